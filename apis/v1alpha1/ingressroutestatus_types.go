@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RenderedRoute is a redacted pomerium route generated for an ingress.
+// it only holds the route matching and behavior flags, and never any secret material,
+// such as TLS client keys, custom CAs, header values or service account tokens.
+type RenderedRoute struct {
+	// Name is the pomerium route name
+	Name string `json:"name,omitempty"`
+	// From is the external URL the route is published at
+	From string `json:"from"`
+	// To are the upstream URLs
+	To []string `json:"to,omitempty"`
+	// Prefix, Path and Regex are the route path matchers
+	Prefix string `json:"prefix,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	// PrefixRewrite replaces the matched prefix before the request is sent upstream
+	PrefixRewrite string `json:"prefixRewrite,omitempty"`
+	// Timeout is the upstream request timeout
+	Timeout string `json:"timeout,omitempty"`
+
+	AllowPublicUnauthenticatedAccess bool   `json:"allowPublicUnauthenticatedAccess,omitempty"`
+	AllowAnyAuthenticatedUser        bool   `json:"allowAnyAuthenticatedUser,omitempty"`
+	AllowWebsockets                  bool   `json:"allowWebsockets,omitempty"`
+	PreserveHostHeader               bool   `json:"preserveHostHeader,omitempty"`
+	PassIdentityHeaders              bool   `json:"passIdentityHeaders,omitempty"`
+	TLSSkipVerify                    bool   `json:"tlsSkipVerify,omitempty"`
+	TLSServerName                    string `json:"tlsServerName,omitempty"`
+
+	// SetRequestHeaders lists the names of the headers set on the upstream requests, values are omitted
+	SetRequestHeaders []string `json:"setRequestHeaders,omitempty"`
+	// Policies is the number of authorization policies attached to the route
+	Policies int `json:"policies,omitempty"`
+}
+
+// IngressRouteStatusStatus is the pomerium configuration rendered for an ingress
+type IngressRouteStatusStatus struct {
+	// Routes are the pomerium routes generated for the ingress by the last successful sync
+	Routes []RenderedRoute `json:"routes,omitempty"`
+	// LastSyncTime is when the routes or the error were last changed
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LastError is the error of the last sync, empty if it was successful
+	LastError string `json:"lastError,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName=irs
+//+kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+//+kubebuilder:printcolumn:name="Error",type=string,JSONPath=`.status.lastError`
+
+// IngressRouteStatus mirrors the pomerium routes generated for the ingress of the same name,
+// so that they may be inspected with kubectl. It is owned by the ingress and removed along with it.
+type IngressRouteStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status IngressRouteStatusStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IngressRouteStatusList contains a list of IngressRouteStatus
+type IngressRouteStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IngressRouteStatus `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IngressRouteStatus{}, &IngressRouteStatusList{})
+}
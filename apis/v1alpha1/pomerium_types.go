@@ -0,0 +1,82 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IdentityProvider configures the identity provider the users sign in with
+type IdentityProvider struct {
+	// Provider is the identity provider name, i.e. google, okta or oidc
+	Provider string `json:"provider"`
+	// URL is the identity provider URL, that some of the providers require
+	URL string `json:"url,omitempty"`
+	// Secret is the name of the secret, in namespace/name format,
+	// holding the client_id and client_secret keys
+	Secret string `json:"secret"`
+	// Scopes are the OAuth scopes requested, the provider defaults are used if empty
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// PomeriumSpec are the global pomerium settings, applied along with the routes of the ingresses
+type PomeriumSpec struct {
+	// AuthenticateURL is the external URL of the authenticate service
+	AuthenticateURL string `json:"authenticateURL,omitempty"`
+	// IdentityProvider configures the identity provider the users sign in with
+	IdentityProvider *IdentityProvider `json:"identityProvider,omitempty"`
+	// Certificates are the names of the kubernetes.io/tls secrets, in namespace/name format,
+	// served in addition to the ingress certificates, i.e. for the authenticate service
+	Certificates []string `json:"certificates,omitempty"`
+}
+
+// PomeriumStatus reports whether the settings were applied
+type PomeriumStatus struct {
+	// ObservedGeneration is the generation of the spec last applied to the databroker
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastError is the reason the current spec could not be applied, empty if it was
+	LastError string `json:"lastError,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Observed Generation",type=integer,JSONPath=`.status.observedGeneration`
+//+kubebuilder:printcolumn:name="Error",type=string,JSONPath=`.status.lastError`
+
+// Pomerium holds the global pomerium settings. the ingress controller only applies the one object
+// it is configured with, and re-applies it once any of the secrets it references change.
+type Pomerium struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PomeriumSpec   `json:"spec,omitempty"`
+	Status PomeriumStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PomeriumList contains a list of Pomerium
+type PomeriumList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Pomerium `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Pomerium{}, &PomeriumList{})
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PomeriumIngressParametersSpec are the defaults applied to all ingresses of the IngressClass referencing them.
+// the ingress annotations take precedence over these defaults
+type PomeriumIngressParametersSpec struct {
+	// DefaultCertificateSecret is the name of the secret, in the namespace of the parameters object,
+	// holding the TLS certificate for the ingress hosts that spec.tls does not cover.
+	// it takes precedence over the default-cert-secret annotation of the IngressClass
+	DefaultCertificateSecret string `json:"defaultCertificateSecret,omitempty"`
+	// Timeout is the default upstream request timeout, as the timeout annotation
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// IdleTimeout is the default upstream idle timeout, as the idle_timeout annotation
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+	// PassIdentityHeaders is the default of the pass_identity_headers annotation
+	PassIdentityHeaders *bool `json:"passIdentityHeaders,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:shortName=pip
+
+// PomeriumIngressParameters holds the settings of an IngressClass, that references it via spec.parameters
+// with apiGroup ingress.pomerium.io, kind PomeriumIngressParameters, scope Namespace and its namespace.
+type PomeriumIngressParameters struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PomeriumIngressParametersSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PomeriumIngressParametersList contains a list of PomeriumIngressParameters
+type PomeriumIngressParametersList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PomeriumIngressParameters `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PomeriumIngressParameters{}, &PomeriumIngressParametersList{})
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+
+	"github.com/pomerium/ingress-controller/pomerium"
+)
+
+const (
+	cleanupConfirm                = "confirm"
+	cleanupLegacyRouteNamePattern = "legacy-route-name-pattern"
+)
+
+// cleanupCmd reports, and optionally deletes, the databroker routes whose ingresses no longer exist.
+// it shares the databroker and cluster flags with the serve command
+type cleanupCmd struct {
+	serve *serveCmd
+
+	confirm                bool
+	legacyRouteNamePattern string
+
+	cobra.Command
+}
+
+func newCleanupCommand(s *serveCmd) *cobra.Command {
+	cmd := &cleanupCmd{
+		serve: s,
+		Command: cobra.Command{
+			Use:   "cleanup",
+			Short: "report the databroker routes of the ingresses that no longer exist, and delete them with --" + cleanupConfirm,
+			Args:  cobra.NoArgs,
+		},
+	}
+	cmd.RunE = cmd.exec
+
+	flags := cmd.Flags()
+	flags.BoolVar(&cmd.confirm, cleanupConfirm, false,
+		"delete the orphan routes, rather than only reporting them. requires the controller to be stopped, as it holds the databroker lease")
+	flags.StringVar(&cmd.legacyRouteNamePattern, cleanupLegacyRouteNamePattern, "",
+		"also report the routes without the ownership marker, whose name matches this regular expression")
+	return &cmd.Command
+}
+
+func (c *cleanupCmd) exec(*cobra.Command, []string) error {
+	var legacy *regexp.Regexp
+	if c.legacyRouteNamePattern != "" {
+		var err error
+		if legacy, err = regexp.Compile(c.legacyRouteNamePattern); err != nil {
+			return fmt.Errorf("--%s: %w", cleanupLegacyRouteNamePattern, err)
+		}
+	}
+
+	c.serve.setupLogger()
+	ctx := ctrl.SetupSignalHandler()
+	live, err := listIngresses(ctx)
+	if err != nil {
+		return err
+	}
+
+	dbc, err := c.serve.getDataBrokerConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("databroker connection: %w", err)
+	}
+	client := databroker.NewDataBrokerServiceClient(dbc)
+	r := &pomerium.ConfigReconciler{
+		DataBrokerServiceClient: client,
+		Cluster:                 c.serve.clusterName,
+		ClusterPriority:         c.serve.clusterPriority,
+	}
+
+	orphans, err := r.FindOrphanRoutes(ctx, func(name types.NamespacedName) bool { return live[name] }, legacy)
+	if err != nil {
+		return err
+	}
+	out := c.OutOrStdout()
+	if err = writeOrphanReport(out, orphans); err != nil {
+		return err
+	}
+	if !c.confirm || len(orphans) == 0 {
+		if len(orphans) > 0 {
+			fmt.Fprintf(out, "dry run, set --%s to delete %d orphan routes\n", cleanupConfirm, len(orphans))
+		}
+		return nil
+	}
+
+	release, err := acquireLease(ctx, client, c.serve.leaseName())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	deleted, err := r.DeleteOrphanRoutes(ctx, orphans)
+	fmt.Fprintf(out, "deleted %d orphan routes\n", deleted)
+	return err
+}
+
+// listIngresses returns the names of all ingresses in the cluster
+func listIngresses(ctx context.Context) (map[types.NamespacedName]bool, error) {
+	c, err := newK8sClient()
+	if err != nil {
+		return nil, err
+	}
+	il := new(networkingv1.IngressList)
+	if err = c.List(ctx, il); err != nil {
+		return nil, fmt.Errorf("list ingresses: %w", err)
+	}
+	names := make(map[types.NamespacedName]bool, len(il.Items))
+	for i := range il.Items {
+		names[types.NamespacedName{Namespace: il.Items[i].Namespace, Name: il.Items[i].Name}] = true
+	}
+	return names, nil
+}
+
+// newK8sClient creates the client of the cluster the kubeconfig points to, without the caches of the manager
+func newK8sClient() (client.Client, error) {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("get k8s api config: %w", err)
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("k8s client: %w", err)
+	}
+	return c, nil
+}
+
+// acquireLease takes the lease the controller runs under, so that it does not update the config concurrently
+func acquireLease(ctx context.Context, client databroker.DataBrokerServiceClient, name string) (func(), error) {
+	resp, err := client.AcquireLease(ctx, &databroker.AcquireLeaseRequest{
+		Name:     name,
+		Duration: durationpb.New(leaseDuration),
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return nil, fmt.Errorf("lease %s is held by a running controller, stop it before deleting the orphan routes", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+	return func() {
+		_, _ = client.ReleaseLease(context.Background(), &databroker.ReleaseLeaseRequest{Name: name, Id: resp.GetId()})
+	}, nil
+}
+
+func writeOrphanReport(w io.Writer, orphans []pomerium.OrphanRoute) error {
+	if len(orphans) == 0 {
+		_, err := fmt.Fprintln(w, "no orphan routes found")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RECORD\tINGRESS\tROUTE\tFROM\tPATH")
+	for _, o := range orphans {
+		ingress := "<legacy>"
+		if o.Ingress.Name != "" {
+			ingress = o.Ingress.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", o.RecordID, ingress, o.Route.GetName(), o.Route.GetFrom(), routePath(o.Route))
+	}
+	return tw.Flush()
+}
+
+func routePath(r *pb.Route) string {
+	switch {
+	case r.GetPath() != "":
+		return r.GetPath()
+	case r.GetPrefix() != "":
+		return r.GetPrefix()
+	default:
+		return r.GetRegex()
+	}
+}
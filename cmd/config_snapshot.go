@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	runtimedebug "runtime/debug"
+
+	"github.com/spf13/pflag"
+
+	"github.com/pomerium/ingress-controller/controllers"
+)
+
+const (
+	// flag value sources, in the order of precedence
+	flagSourceCommandLine = "flag"
+	flagSourceEnv         = "env"
+	flagSourceDefault     = "default"
+
+	maskedValue = "<redacted>"
+)
+
+// sensitiveFlags have their values masked in the config snapshot
+var sensitiveFlags = map[string]bool{
+	sharedSecret: true,
+}
+
+// configSnapshot is the effective serve configuration, shared in support bundles
+type configSnapshot struct {
+	Flags   map[string]flagSnapshot      `json:"flags"`
+	Options controllers.EffectiveOptions `json:"options"`
+	// AllNamespaces is set if the ingresses are watched in all namespaces, rather than in Options.Namespaces
+	AllNamespaces bool      `json:"allNamespaces"`
+	Build         buildInfo `json:"build"`
+}
+
+type flagSnapshot struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+type buildInfo struct {
+	GoVersion string `json:"goVersion"`
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Sum       string `json:"sum,omitempty"`
+}
+
+// getConfigSnapshot returns the flags merged with the environment, with the secrets masked,
+// along with the controller options they result in
+func (s *serveCmd) getConfigSnapshot(opts []controllers.Option) *configSnapshot {
+	snap := &configSnapshot{
+		Flags:   make(map[string]flagSnapshot),
+		Options: controllers.ResolveOptions(opts...),
+		Build:   getBuildInfo(),
+	}
+	snap.AllNamespaces = len(snap.Options.Namespaces) == 0
+	s.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		snap.Flags[f.Name] = flagSnapshot{
+			Value:  maskFlagValue(f),
+			Source: s.flagSource(f),
+		}
+	})
+	return snap
+}
+
+func (s *serveCmd) flagSource(f *pflag.Flag) string {
+	if !f.Changed {
+		return flagSourceDefault
+	}
+	// the environment is applied before the command line is parsed, that may override it
+	if val, ok := s.envFlags[f.Name]; ok && val == f.Value.String() {
+		return flagSourceEnv
+	}
+	return flagSourceCommandLine
+}
+
+func maskFlagValue(f *pflag.Flag) string {
+	val := f.Value.String()
+	if val == "" {
+		return val
+	}
+	if sensitiveFlags[f.Name] {
+		return maskedValue
+	}
+	if f.Name == databrokerServiceURL {
+		if u, err := url.Parse(val); err == nil {
+			return u.Redacted()
+		}
+	}
+	return val
+}
+
+func getBuildInfo() buildInfo {
+	bi := buildInfo{GoVersion: runtime.Version()}
+	if info, ok := runtimedebug.ReadBuildInfo(); ok {
+		bi.Path = info.Main.Path
+		bi.Version = info.Main.Version
+		bi.Sum = info.Main.Sum
+	}
+	return bi
+}
+
+func (s *serveCmd) writeConfigSnapshot(w io.Writer, opts []controllers.Option) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.getConfigSnapshot(opts))
+}
+
+// configHandler returns the effective serve configuration
+func (s *serveCmd) configHandler(opts []controllers.Option) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = s.writeConfigSnapshot(w, opts)
+	}
+}
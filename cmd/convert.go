@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gopkg.in/yaml.v3"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/controllers"
+	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/pomerium"
+)
+
+const (
+	convertFilename    = "filename"
+	convertOutput      = "output"
+	convertShowSecrets = "show-secrets"
+
+	outputYAML = "yaml"
+	outputJSON = "json"
+)
+
+// convertCmd prints the pomerium routes the ingresses would be translated to, without touching the databroker.
+// the ingresses, along with the services and secrets they refer to, are read from files, or from the cluster.
+// it shares the controller flags with the serve command, so that the translation matches the running controller
+type convertCmd struct {
+	serve *serveCmd
+
+	filenames   []string
+	output      string
+	showSecrets bool
+
+	cobra.Command
+}
+
+func newConvertCommand(s *serveCmd) *cobra.Command {
+	cmd := &convertCmd{
+		serve: s,
+		Command: cobra.Command{
+			Use:          "convert [namespace/name...]",
+			Short:        "print the pomerium routes the ingresses would produce, read from --" + convertFilename + " or from the cluster",
+			SilenceUsage: true,
+		},
+	}
+	cmd.RunE = cmd.exec
+
+	flags := cmd.Flags()
+	flags.StringSliceVarP(&cmd.filenames, convertFilename, "f", nil,
+		"files with the ingresses, services, endpoints and secrets to convert, - for stdin. all ingresses are converted unless named as arguments")
+	flags.StringVarP(&cmd.output, convertOutput, "o", outputYAML, "output format, yaml or json")
+	flags.BoolVar(&cmd.showSecrets, convertShowSecrets, false, "print the private keys and secrets, rather than redacting them")
+	return &cmd.Command
+}
+
+func (c *convertCmd) exec(cmd *cobra.Command, args []string) error {
+	if c.output != outputYAML && c.output != outputJSON {
+		return fmt.Errorf("--%s: unsupported format %q", convertOutput, c.output)
+	}
+	if len(c.filenames) == 0 && len(args) == 0 {
+		return fmt.Errorf("either --%s or the ingress namespace/name is required", convertFilename)
+	}
+	names, err := parseIngressNames(args)
+	if err != nil {
+		return err
+	}
+	opts, err := c.serve.getOptions()
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var (
+		k8s       client.Client
+		ingresses []*networkingv1.Ingress
+	)
+	if len(c.filenames) > 0 {
+		k8s, ingresses, err = c.readFiles(names)
+	} else {
+		k8s, ingresses, err = getIngresses(ctx, names)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	ics := make([]*model.IngressConfig, 0, len(ingresses))
+	for _, ingress := range ingresses {
+		ic, err := controllers.FetchIngressConfig(ctx, k8s, ingress, opts...)
+		if err != nil {
+			fmt.Fprintf(c.ErrOrStderr(), "ingress %s/%s: %v\n", ingress.Namespace, ingress.Name, err)
+			failed = true
+			continue
+		}
+		ics = append(ics, ic)
+	}
+
+	cfg, err := pomerium.BuildConfig(ctx, ics)
+	if err != nil {
+		fmt.Fprintln(c.ErrOrStderr(), err)
+		failed = true
+	}
+	if !c.showSecrets {
+		redactConfig(cfg)
+	}
+	if err = writeConfig(c.OutOrStdout(), cfg, c.output); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some of the ingresses could not be converted")
+	}
+	return nil
+}
+
+func parseIngressNames(args []string) ([]types.NamespacedName, error) {
+	names := make([]types.NamespacedName, 0, len(args))
+	for _, arg := range args {
+		parts := strings.Split(arg, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s: expected namespace/name", arg)
+		}
+		names = append(names, types.NamespacedName{Namespace: parts[0], Name: parts[1]})
+	}
+	return names, nil
+}
+
+// readFiles loads the objects from the files into the fake client the ingresses are fetched from,
+// and returns the ingresses to convert
+func (c *convertCmd) readFiles(names []types.NamespacedName) (client.Client, []*networkingv1.Ingress, error) {
+	var objs []client.Object
+	for _, filename := range c.filenames {
+		fileObjs, err := c.readFile(filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		objs = append(objs, fileObjs...)
+	}
+
+	// the mapper is only used to discover the optional kinds, i.e. PomeriumIngressParameters
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for gvk := range scheme.AllKnownTypes() {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(objs...).Build()
+
+	byName := make(map[types.NamespacedName]*networkingv1.Ingress)
+	var ingresses []*networkingv1.Ingress
+	for _, obj := range objs {
+		if ingress, ok := obj.(*networkingv1.Ingress); ok {
+			byName[types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}] = ingress
+			ingresses = append(ingresses, ingress)
+		}
+	}
+	if len(names) == 0 {
+		return k8s, ingresses, nil
+	}
+	ingresses = ingresses[:0]
+	for _, name := range names {
+		ingress, ok := byName[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("ingress %s not found in --%s", name, convertFilename)
+		}
+		ingresses = append(ingresses, ingress)
+	}
+	return k8s, ingresses, nil
+}
+
+func (c *convertCmd) readFile(filename string) ([]client.Object, error) {
+	var r io.Reader
+	if filename == "-" {
+		r = c.InOrStdin()
+	} else {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	var objs []client.Object
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return objs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(string(doc))) == 0 {
+			continue
+		}
+		obj, gvk, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		cobj, ok := obj.(client.Object)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported object", gvk)
+		}
+		if namespaced(gvk.Kind) && cobj.GetNamespace() == "" {
+			cobj.SetNamespace("default")
+		}
+		objs = append(objs, cobj)
+	}
+}
+
+// namespaced checks whether the objects of the kind belong to a namespace, and are placed into the default one if not set
+func namespaced(kind string) bool {
+	switch kind {
+	case "IngressClass", "Namespace", "Pomerium":
+		return false
+	}
+	return true
+}
+
+// getIngresses reads the named ingresses from the cluster
+func getIngresses(ctx context.Context, names []types.NamespacedName) (client.Client, []*networkingv1.Ingress, error) {
+	k8s, err := newK8sClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	ingresses := make([]*networkingv1.Ingress, 0, len(names))
+	for _, name := range names {
+		ingress := new(networkingv1.Ingress)
+		if err := k8s.Get(ctx, name, ingress); err != nil {
+			return nil, nil, fmt.Errorf("get ingress %s: %w", name, err)
+		}
+		ingresses = append(ingresses, ingress)
+	}
+	return k8s, ingresses, nil
+}
+
+// redactConfig masks the private keys and secrets the config carries
+func redactConfig(cfg *pb.Config) {
+	for _, cert := range cfg.GetSettings().GetCertificates() {
+		cert.KeyBytes = nil
+	}
+	for _, route := range cfg.GetRoutes() {
+		if route.TlsClientKey != "" {
+			route.TlsClientKey = maskedValue
+		}
+		if route.KubernetesServiceAccountToken != "" {
+			route.KubernetesServiceAccountToken = maskedValue
+		}
+		for k := range route.SetRequestHeaders {
+			route.SetRequestHeaders[k] = maskedValue
+		}
+	}
+}
+
+// writeConfig prints the config in the requested format. protojson output is deliberately unstable,
+// hence it is re-indented
+func writeConfig(w io.Writer, cfg *pb.Config, format string) error {
+	data, err := protojson.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if format == outputJSON {
+		var buf bytes.Buffer
+		if err = json.Indent(&buf, data, "", "  "); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, buf.String())
+		return err
+	}
+
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err = enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
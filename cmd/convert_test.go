@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConvertObjects = `
+apiVersion: networking.k8s.io/v1
+kind: IngressClass
+metadata:
+  name: pomerium
+  annotations:
+    ingressclass.kubernetes.io/is-default-class: "true"
+spec:
+  controller: pomerium.io/ingress-controller
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: service
+spec:
+  ports:
+  - name: http
+    port: 80
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: ingress
+  annotations:
+    ingress.pomerium.io/pass_identity_headers: "true"
+    ingress.pomerium.io/allow_public_unauthenticated_access: "true"
+spec:
+  rules:
+  - host: service.localhost.pomerium.io
+    http:
+      paths:
+      - path: /
+        pathType: Prefix
+        backend:
+          service:
+            name: service
+            port:
+              name: http
+`
+
+func runConvert(t *testing.T, objects string, args ...string) (string, string, error) {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "objects.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(objects), 0o600))
+
+	cmd, err := ServeCommand()
+	require.NoError(t, err)
+	var stdout, stderr bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(append([]string{"convert", "-f", filename, "--" + disableCertCheck}, args...))
+	err = cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestConvert(t *testing.T) {
+	stdout, stderr, err := runConvert(t, testConvertObjects)
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stdout, "from: https://service.localhost.pomerium.io")
+	assert.Contains(t, stdout, "http://service.default.svc.cluster.local:80")
+
+	stdout, _, err = runConvert(t, testConvertObjects, "-o", "json", "default/ingress")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, `"from": "https://service.localhost.pomerium.io"`)
+
+	_, _, err = runConvert(t, testConvertObjects, "default/missing")
+	assert.Error(t, err)
+}
+
+func TestConvertInvalidAnnotation(t *testing.T) {
+	objects := strings.Replace(testConvertObjects, "  annotations:\n    ingress.pomerium.io/pass", "  annotations:\n    ingress.pomerium.io/no_such_option: value\n    ingress.pomerium.io/pass", 1)
+	_, stderr, err := runConvert(t, objects)
+	assert.Error(t, err)
+	assert.Contains(t, stderr, "ingress.pomerium.io/no_such_option")
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/pomerium/pomerium/pkg/cryptutil"
+	"github.com/pomerium/pomerium/pkg/grpcutil"
+)
+
+const defaultGRPCSecurePort = "443"
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSMinVersion parses TLS version in the 1.x format
+func parseTLSMinVersion(txt string) (uint16, error) {
+	if v, ok := tlsVersions[txt]; ok {
+		return v, nil
+	}
+	supported := make([]string, 0, len(tlsVersions))
+	for name := range tlsVersions {
+		supported = append(supported, name)
+	}
+	sort.Strings(supported)
+	return 0, fmt.Errorf("unsupported TLS version %q, supported values are: %s", txt, strings.Join(supported, ", "))
+}
+
+// tls12CipherSuites returns the secure cipher suites that may be configured,
+// as TLS 1.3 cipher suites are not configurable
+func tls12CipherSuites() map[string]uint16 {
+	suites := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		for _, v := range cs.SupportedVersions {
+			if v == tls.VersionTLS12 {
+				suites[cs.Name] = cs.ID
+			}
+		}
+	}
+	return suites
+}
+
+// parseCipherSuites parses cipher suite names, or returns nil for the default cipher suites
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	supported := tls12CipherSuites()
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := supported[name]
+		if !ok {
+			list := make([]string, 0, len(supported))
+			for name := range supported {
+				list = append(list, name)
+			}
+			sort.Strings(list)
+			return nil, fmt.Errorf("unsupported cipher suite %q, supported values are: %s", name, strings.Join(list, ", "))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func tlsVersionName(v uint16) string {
+	for name, id := range tlsVersions {
+		if id == v {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func (s *serveCmd) getDataBrokerTLSConfig() (*tls.Config, error) {
+	minVersion, err := parseTLSMinVersion(s.tlsMinVersion)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tlsMinVersion, err)
+	}
+	cipherSuites, err := parseCipherSuites(s.tlsCipherSuites)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tlsCipherSuites, err)
+	}
+	rootCAs, err := cryptutil.GetCertPool(base64.StdEncoding.EncodeToString(s.tlsCA), s.tlsCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("databroker CA: %w", err)
+	}
+
+	return &tls.Config{
+		// nolint: gosec
+		InsecureSkipVerify: s.tlsInsecureSkipVerify,
+		RootCAs:            rootCAs,
+		ServerName:         s.tlsOverrideCertificateName,
+		MinVersion:         minVersion,
+		CipherSuites:       cipherSuites,
+	}, nil
+}
+
+// dialDataBrokerTLS establishes a databroker connection over TLS,
+// similar to grpcutil.NewGRPCClientConn, but using the TLS policy set via command line
+func (s *serveCmd) dialDataBrokerTLS(ctx context.Context, u *url.URL, sharedSecret []byte) (*grpc.ClientConn, error) {
+	cfg, err := s.getDataBrokerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	hostport := u.Host
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(hostport, defaultGRPCSecurePort)
+	}
+
+	unary := []grpc.UnaryClientInterceptor{grpcTimeoutInterceptor(defaultGRPCTimeout)}
+	var stream []grpc.StreamClientInterceptor
+	if sharedSecret != nil {
+		unary = append(unary, grpcutil.WithUnarySignedJWT(sharedSecret))
+		stream = append(stream, grpcutil.WithStreamSignedJWT(sharedSecret))
+	}
+
+	return grpc.DialContext(ctx, hostport,
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+		grpc.WithDefaultCallOptions(grpc.WaitForReady(true)),
+		grpc.WithDisableServiceConfig(),
+		grpc.WithTransportCredentials(&handshakeLogger{TransportCredentials: credentials.NewTLS(cfg), once: new(sync.Once)}),
+	)
+}
+
+func grpcTimeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// handshakeLogger logs the negotiated TLS parameters of the first established connection
+type handshakeLogger struct {
+	credentials.TransportCredentials
+	once *sync.Once
+}
+
+// ClientHandshake implements credentials.TransportCredentials
+func (h *handshakeLogger) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := h.TransportCredentials.ClientHandshake(ctx, authority, conn)
+	if err != nil {
+		return conn, info, err
+	}
+	if tlsInfo, ok := info.(credentials.TLSInfo); ok {
+		h.once.Do(func() {
+			ctrl.Log.WithName("databroker").Info("connected",
+				"address", authority,
+				"tls-version", tlsVersionName(tlsInfo.State.Version),
+				"cipher-suite", tls.CipherSuiteName(tlsInfo.State.CipherSuite))
+		})
+	}
+	return conn, info, nil
+}
+
+// Clone implements credentials.TransportCredentials
+func (h *handshakeLogger) Clone() credentials.TransportCredentials {
+	return &handshakeLogger{TransportCredentials: h.TransportCredentials.Clone(), once: h.once}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pomerium/ingress-controller/controllers"
+)
+
+// runDebug serves the debug endpoints, that expose the internal controller state
+func (s *serveCmd) runDebug(ctx context.Context, c *leadController) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/dependencies", c.dependenciesHandler)
+	mux.HandleFunc("/debug/config", s.configHandler(c.CtrlOpts))
+	if s.faultInjector != nil {
+		mux.Handle("/debug/faults", s.faultInjector)
+	}
+
+	srv := http.Server{
+		Addr:    s.debugAddr,
+		Handler: mux,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	return srv.ListenAndServe()
+}
+
+// dependenciesHandler returns the dependency graph of the managed ingresses,
+// as JSON or in the graphviz DOT format if ?format=dot is requested
+func (c *leadController) dependenciesHandler(w http.ResponseWriter, r *http.Request) {
+	state := c.getState()
+	if state == nil {
+		http.Error(w, errWaitingForLease.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	g, err := state.Dependencies(r.Context())
+	if errors.Is(err, controllers.ErrNotReady) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(g)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		_ = g.WriteDOT(w)
+	default:
+		http.Error(w, fmt.Sprintf("unsupported format %q, expected json or dot", format), http.StatusBadRequest)
+	}
+}
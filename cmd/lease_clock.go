@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	// clockJumpThreshold is the difference between the wall clock and the monotonic clock elapsed time,
+	// or between the observed and the expected lease renewal interval, that is reported.
+	// smaller jumps, i.e. NTP corrections, are tolerated silently
+	clockJumpThreshold = time.Second * 5
+)
+
+// processStart anchors the monotonic clock readings
+var processStart = time.Now()
+
+// readClock returns the monotonic clock reading, and the wall clock time
+type readClock func() (time.Duration, time.Time)
+
+// systemClock reads the process clocks. time.Now carries both readings, and Round(0) strips the monotonic one
+func systemClock() (time.Duration, time.Time) {
+	now := time.Now()
+	return now.Sub(processStart), now.Round(0)
+}
+
+// leaseClockMonitor observes the databroker lease renewals, and warns if the wall clock jumped in between,
+// or the renewal was late, i.e. the process was paused, as the lease may expire then.
+// the lease itself is not affected by the local wall clock, as the renewals are scheduled by the monotonic clock,
+// and the lease duration is relative to the databroker clock
+type leaseClockMonitor struct {
+	databroker.DataBrokerServiceClient
+
+	// expected is the lease renewal interval
+	expected time.Duration
+	clock    readClock
+	log      logr.Logger
+
+	mu       sync.Mutex
+	seen     bool
+	lastMono time.Duration
+	lastWall time.Time
+}
+
+func newLeaseClockMonitor(client databroker.DataBrokerServiceClient, ttl time.Duration, log logr.Logger) *leaseClockMonitor {
+	return &leaseClockMonitor{
+		DataBrokerServiceClient: client,
+		// databroker.Leaser renews the lease each half of its duration
+		expected: ttl / 2,
+		clock:    systemClock,
+		log:      log,
+	}
+}
+
+// AcquireLease implements databroker.DataBrokerServiceClient, and starts observing the renewals of the new lease
+func (m *leaseClockMonitor) AcquireLease(ctx context.Context, req *databroker.AcquireLeaseRequest, opts ...grpc.CallOption) (*databroker.AcquireLeaseResponse, error) {
+	m.reset()
+	return m.DataBrokerServiceClient.AcquireLease(ctx, req, opts...)
+}
+
+// RenewLease implements databroker.DataBrokerServiceClient
+func (m *leaseClockMonitor) RenewLease(ctx context.Context, req *databroker.RenewLeaseRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	for _, warning := range m.observe() {
+		m.log.Info("WARNING: "+warning, "lease", req.GetName())
+	}
+	return m.DataBrokerServiceClient.RenewLease(ctx, req, opts...)
+}
+
+func (m *leaseClockMonitor) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastMono, m.lastWall = m.clock()
+	m.seen = true
+}
+
+// observe records a lease renewal, and returns the clock discontinuities since the previous one
+func (m *leaseClockMonitor) observe() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mono, wall := m.clock()
+	prevMono, prevWall, seen := m.lastMono, m.lastWall, m.seen
+	m.lastMono, m.lastWall, m.seen = mono, wall, true
+	if !seen {
+		return nil
+	}
+
+	var warnings []string
+	elapsed := mono - prevMono
+	if jump := wall.Sub(prevWall) - elapsed; jump > clockJumpThreshold || jump < -clockJumpThreshold {
+		warnings = append(warnings, fmt.Sprintf("wall clock jumped by %s between lease renewals, check the NTP synchronization", jump))
+	}
+	if late := elapsed - m.expected; late > clockJumpThreshold {
+		warnings = append(warnings, fmt.Sprintf("lease renewed after %s, expected every %s, the lease may expire if the process is paused", elapsed, m.expected))
+	}
+	return warnings
+}
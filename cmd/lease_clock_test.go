@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseClockMonitor(t *testing.T) {
+	var mono time.Duration
+	wall := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := newLeaseClockMonitor(nil, time.Second*30, logr.Discard())
+	m.clock = func() (time.Duration, time.Time) { return mono, wall }
+	tick := func(monoStep, wallStep time.Duration) []string {
+		mono += monoStep
+		wall = wall.Add(wallStep)
+		return m.observe()
+	}
+
+	m.reset()
+	assert.Empty(t, tick(time.Second*15, time.Second*15))
+	assert.Empty(t, tick(time.Second*15, time.Second*14), "small backwards jumps should be tolerated")
+	assert.Empty(t, tick(time.Second*16, time.Second*19), "small forward jumps should be tolerated")
+
+	if warnings := tick(time.Second*15, -time.Minute); assert.Len(t, warnings, 1) {
+		assert.Contains(t, warnings[0], "wall clock jumped by -1m15s")
+	}
+	if warnings := tick(time.Second*15, time.Hour); assert.Len(t, warnings, 1) {
+		assert.Contains(t, warnings[0], "wall clock jumped by 59m45s")
+	}
+	if warnings := tick(time.Second*40, time.Second*40); assert.Len(t, warnings, 1) {
+		assert.Contains(t, warnings[0], "lease renewed after 40s, expected every 15s")
+	}
+
+	mono += time.Hour
+	m.reset()
+	assert.Empty(t, tick(time.Second*15, time.Second*15), "a new lease should not be compared with the previous one")
+}
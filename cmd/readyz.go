@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+const (
+	defaultReadyzMaxUnsyncedIngresses = 20
+	unsyncedIngressesCheckName        = "managed ingresses synced"
+)
+
+// installReadyzHandler serves the readiness checks at /readyz, and each of them at /readyz/<name>,
+// in the same format as healthz.InstallReadyzHandler. unlike it, the ?verbose form reports why the checks have failed,
+// so that the deployment tooling may tell what blocks the readiness. ?exclude=<name> skips the check.
+func installReadyzHandler(mux *http.ServeMux, checks ...healthz.HealthChecker) {
+	mux.HandleFunc("/readyz", readyzHandler(checks...))
+	for _, check := range checks {
+		mux.HandleFunc("/readyz/"+check.Name(), readyzHandler(check))
+	}
+}
+
+func readyzHandler(checks ...healthz.HealthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, verbose := query["verbose"]
+		excluded := make(map[string]bool)
+		for _, name := range query["exclude"] {
+			excluded[name] = true
+		}
+
+		var out bytes.Buffer
+		failed := false
+		for _, check := range checks {
+			if excluded[check.Name()] {
+				fmt.Fprintf(&out, "[+]%s excluded: ok\n", check.Name())
+				continue
+			}
+			err := check.Check(r)
+			switch {
+			case err == nil:
+				fmt.Fprintf(&out, "[+]%s ok\n", check.Name())
+			case verbose:
+				failed = true
+				// the details may span multiple lines, i.e. list the unsynced ingresses
+				fmt.Fprintf(&out, "[-]%s failed: %s\n", check.Name(), strings.ReplaceAll(err.Error(), "\n", "\n    "))
+			default:
+				failed = true
+				fmt.Fprintf(&out, "[-]%s failed: reason withheld\n", check.Name())
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(&out, "readyz check failed\n")
+			_, _ = out.WriteTo(w)
+			return
+		}
+		if !verbose {
+			fmt.Fprint(w, "ok")
+			return
+		}
+		fmt.Fprintf(&out, "readyz check passed\n")
+		_, _ = out.WriteTo(w)
+	}
+}
+
+// UnsyncedIngressesCheck fails while any of the managed ingresses is not synced to pomerium,
+// listing up to limit of them, the longest unsynced first, along with their phase reasons
+func (c *leadController) UnsyncedIngressesCheck(limit int) func(*http.Request) error {
+	return func(_ *http.Request) error {
+		state := c.getState()
+		if state == nil {
+			return errWaitingForLease
+		}
+		unsynced, total, err := state.UnsyncedIngresses(limit)
+		if err != nil {
+			return err
+		}
+		if total == 0 {
+			return nil
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%d managed ingresses are not synced", total)
+		for _, u := range unsynced {
+			fmt.Fprintf(&b, "\n%s: %s %s", u.NamespacedName, u.Phase, u.Reason)
+			if u.Message != "" {
+				fmt.Fprintf(&b, ": %s", strings.ReplaceAll(u.Message, "\n", " "))
+			}
+		}
+		if more := total - len(unsynced); more > 0 {
+			fmt.Fprintf(&b, "\nand %d more", more)
+		}
+		return errors.New(b.String())
+	}
+}
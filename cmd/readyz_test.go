@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+func TestReadyzHandler(t *testing.T) {
+	var unsynced error
+	mux := http.NewServeMux()
+	installReadyzHandler(mux,
+		healthz.NamedCheck("lease", func(*http.Request) error { return nil }),
+		healthz.NamedCheck("synced", func(*http.Request) error { return unsynced }),
+	)
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body)
+	code, body = get("/readyz?verbose")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[+]lease ok\n[+]synced ok\nreadyz check passed\n", body)
+
+	unsynced = errors.New("2 managed ingresses are not synced\ndefault/a: Error UpdateError: failed\ndefault/b: Pending AwaitingReconcile")
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "[+]lease ok\n[-]synced failed: reason withheld\nreadyz check failed\n", body)
+	code, body = get("/readyz?verbose")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "[+]lease ok\n[-]synced failed: 2 managed ingresses are not synced\n"+
+		"    default/a: Error UpdateError: failed\n    default/b: Pending AwaitingReconcile\nreadyz check failed\n", body)
+	code, body = get("/readyz?verbose&exclude=synced")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[+]lease ok\n[+]synced excluded: ok\nreadyz check passed\n", body)
+
+	code, _ = get("/readyz/lease")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get("/readyz/synced")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,18 +20,23 @@ import (
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apiserver/pkg/server/healthz"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/pomerium/pomerium/pkg/grpc/databroker"
 	"github.com/pomerium/pomerium/pkg/grpcutil"
 
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
 	"github.com/pomerium/ingress-controller/controllers"
+	"github.com/pomerium/ingress-controller/internal/faults"
+	"github.com/pomerium/ingress-controller/model"
 	"github.com/pomerium/ingress-controller/pomerium"
 )
 
@@ -42,35 +48,112 @@ const (
 var (
 	scheme = runtime.NewScheme()
 
-	errWaitingForLease = errors.New("waiting for databroker lease")
+	errWaitingForLease          = errors.New("waiting for databroker lease")
+	errWaitingForLeaderElection = errors.New("waiting for kubernetes leader election")
+	errWaitingForStart          = errors.New("waiting for the controller to start")
 )
 
+const (
+	// leaderElectionKube elects the leader via the kubernetes Lease object, managed by the controller-runtime
+	leaderElectionKube = "kube"
+	// leaderElectionDatabroker elects the leader via the databroker lease
+	leaderElectionDatabroker = "databroker"
+	// leaderElectionNone runs the controller unconditionally, and should only be used with a single replica
+	leaderElectionNone = "none"
+)
+
+var leaderElectionModes = []string{leaderElectionKube, leaderElectionDatabroker, leaderElectionNone}
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 type serveCmd struct {
-	metricsAddr      string
-	webhookPort      int
-	probeAddr        string
-	className        string
-	annotationPrefix string
-	namespaces       []string
+	metricsAddr             string
+	webhookPort             int
+	probeAddr               string
+	className               string
+	annotationPrefix        string
+	serviceAnnotationPrefix string
+	namespaces              []string
+	excludeNamespaces       []string
+	namespaceLabelSelector  string
+	requiredLabels          map[string]string
+	ingressLabelSelector    string
+	watchWithoutClass       bool
+	optionsConfigMap        string
 
 	databrokerServiceURL       string
 	tlsCAFile                  string
 	tlsCA                      []byte
 	tlsInsecureSkipVerify      bool
 	tlsOverrideCertificateName string
+	tlsMinVersion              string
+	tlsCipherSuites            []string
 
 	sharedSecret string
 
-	disableCertCheck bool
+	disableCertCheck        bool
+	skipCertificates        bool
+	strictIngressValidation bool
+
+	clusterName     string
+	clusterPriority int
+
+	listenerPorts []int32
+
+	certCacheSize int
+
+	syncStateWriter string
+
+	hostConflictPolicy string
+
+	warmStandby bool
+
+	leaderElection          string
+	leaderElectionNamespace string
+
+	writeRouteStatusCRs bool
+
+	defaultSecurityHeaders bool
+
+	useLegacyEndpoints bool
+	useServiceProxy    bool
+	wildcardHosts      bool
+
+	faultInjectionFile string
+	faultInjector      *faults.Injector
+
+	backpressure controllers.BackpressureConfig
+
+	dependencyReconcileWindow  int
+	dependencyReconcileMaxWait time.Duration
+
+	reconcileConcurrency int
+	resyncPeriod         time.Duration
+
+	globalSettings string
+
+	databrokerDebounce time.Duration
+
+	readyzMaxUnsyncedIngresses int
+
+	maxRouteDeletionPercent      int
+	allowMassRouteDeletion       bool
+	massRouteDeletionConfirmFile string
 
 	updateStatusFromService string
+	allowedStatusServices   []string
+	statusUpdaterHealth     *controllers.StatusUpdaterHealth
+
+	debug     bool
+	debugAddr string
 
-	debug bool
+	dumpConfig bool
+	// envFlags are the values of the flags that were set from the environment
+	envFlags map[string]string
 
 	cobra.Command
 	controllers.PomeriumReconciler
@@ -87,25 +170,70 @@ func ServeCommand() (*cobra.Command, error) {
 	if err := cmd.setupFlags(); err != nil {
 		return nil, err
 	}
+	cmd.AddCommand(newCleanupCommand(&cmd))
+	cmd.AddCommand(newConvertCommand(&cmd))
 	return &cmd.Command, nil
 }
 
 const (
-	webhookPort                = "webhook-port"
-	metricsBindAddress         = "metrics-bind-address"
-	healthProbeBindAddress     = "health-probe-bind-address"
-	className                  = "name"
-	annotationPrefix           = "prefix"
-	databrokerServiceURL       = "databroker-service-url"
-	databrokerTLSCAFile        = "databroker-tls-ca-file"
-	databrokerTLSCA            = "databroker-tls-ca"
-	tlsInsecureSkipVerify      = "databroker-tls-insecure-skip-verify"
-	tlsOverrideCertificateName = "databroker-tls-override-certificate-name"
-	namespaces                 = "namespaces"
-	sharedSecret               = "shared-secret"
-	debug                      = "debug"
-	updateStatusFromService    = "update-status-from-service"
-	disableCertCheck           = "disable-cert-check"
+	webhookPort                  = "webhook-port"
+	metricsBindAddress           = "metrics-bind-address"
+	healthProbeBindAddress       = "health-probe-bind-address"
+	className                    = "name"
+	annotationPrefix             = "prefix"
+	serviceAnnotationPrefix      = "service-prefix"
+	databrokerServiceURL         = "databroker-service-url"
+	databrokerTLSCAFile          = "databroker-tls-ca-file"
+	databrokerTLSCA              = "databroker-tls-ca"
+	tlsInsecureSkipVerify        = "databroker-tls-insecure-skip-verify"
+	tlsOverrideCertificateName   = "databroker-tls-override-certificate-name"
+	tlsMinVersion                = "databroker-tls-min-version"
+	tlsCipherSuites              = "databroker-tls-cipher-suites"
+	namespaces                   = "namespaces"
+	excludeNamespaces            = "exclude-namespaces"
+	namespaceLabelSelector       = "namespace-label-selector"
+	requiredLabels               = "required-labels"
+	ingressLabelSelector         = "ingress-label-selector"
+	watchWithoutClass            = "watch-ingress-without-class"
+	optionsConfigMap             = "options-configmap"
+	sharedSecret                 = "shared-secret"
+	debug                        = "debug"
+	debugBindAddress             = "debug-bind-address"
+	updateStatusFromService      = "update-status-from-service"
+	allowedStatusServices        = "allowed-status-services"
+	disableCertCheck             = "disable-cert-check"
+	skipCertificates             = "disable-cert-check-skip-certificates"
+	strictIngressValidation      = "strict-ingress-validation"
+	clusterName                  = "cluster-name"
+	clusterPriority              = "cluster-priority"
+	listenerPorts                = "listener-ports"
+	certCacheSize                = "cert-cache-size"
+	syncStateWriter              = "sync-state-writer"
+	hostConflictPolicy           = "host-conflict-policy"
+	warmStandby                  = "warm-standby"
+	leaderElection               = "leader-election"
+	leaderElectionNamespace      = "leader-election-namespace"
+	writeRouteStatusCRs          = "write-route-status-crs"
+	defaultSecurityHeaders       = "default-security-headers"
+	useLegacyEndpoints           = "use-legacy-endpoints"
+	useServiceProxy              = "use-service-proxy"
+	wildcardHosts                = "wildcard-hosts"
+	faultInjectionFile           = "databroker-fault-injection-file"
+	maxRouteDeletionPercent      = "max-route-deletion-percent"
+	allowMassRouteDeletion       = "allow-mass-route-deletion"
+	massRouteDeletionConfirmFile = "mass-route-deletion-confirm-file"
+	dumpConfig                   = "dump-config"
+	backpressureMaxFailures      = "backpressure-max-failures"
+	backpressureMaxLatency       = "backpressure-max-latency"
+	backpressureRequeueDelay     = "backpressure-requeue-delay"
+	backpressureRecoveryRamp     = "backpressure-recovery-ramp"
+	dependencyReconcileWindow    = "dependency-reconcile-window"
+	dependencyReconcileMaxWait   = "dependency-reconcile-max-wait"
+	readyzMaxUnsyncedIngresses   = "readyz-max-unsynced-ingresses"
+	reconcileConcurrency         = "reconcile-concurrency"
+	resyncPeriod                 = "resync-period"
+	globalSettings               = "global-settings"
+	databrokerDebounce           = "databroker-debounce"
 )
 
 func envName(name string) string {
@@ -119,6 +247,8 @@ func (s *serveCmd) setupFlags() error {
 	flags.StringVar(&s.probeAddr, healthProbeBindAddress, ":8081", "The address the probe endpoint binds to.")
 	flags.StringVar(&s.className, className, controllers.DefaultClassControllerName, "IngressClass controller name")
 	flags.StringVar(&s.annotationPrefix, annotationPrefix, controllers.DefaultAnnotationPrefix, "Ingress annotation prefix")
+	flags.StringVar(&s.serviceAnnotationPrefix, serviceAnnotationPrefix, "",
+		"backend Service annotation prefix, ingress annotations take precedence. empty to ignore Service annotations")
 	flags.StringVar(&s.databrokerServiceURL, databrokerServiceURL, "http://localhost:5443",
 		"the databroker service url")
 	flags.StringVar(&s.tlsCAFile, databrokerTLSCAFile, "", "tls CA file path")
@@ -127,19 +257,139 @@ func (s *serveCmd) setupFlags() error {
 		"disable remote hosts TLS certificate chain and hostname check for the databroker connection")
 	flags.StringVar(&s.tlsOverrideCertificateName, tlsOverrideCertificateName, "",
 		"override the certificate name used for the databroker connection")
+	flags.StringVar(&s.tlsMinVersion, tlsMinVersion, "1.2", "minimum TLS version for the databroker connection, 1.2 or 1.3")
+	flags.StringSliceVar(&s.tlsCipherSuites, tlsCipherSuites, nil,
+		"TLS 1.2 cipher suites allowed for the databroker connection, or empty for the Go defaults. TLS 1.3 cipher suites are not configurable")
 
 	flags.StringSliceVar(&s.namespaces, namespaces, nil, "namespaces to watch, or none to watch all namespaces")
+	flags.StringSliceVar(&s.excludeNamespaces, excludeNamespaces, nil,
+		"namespaces to never watch, even if listed in --"+namespaces+". glob patterns, i.e. cattle-*, are supported")
+	flags.StringVar(&s.namespaceLabelSelector, namespaceLabelSelector, "",
+		"only watch the namespaces matching the label selector, i.e. pomerium-enabled=true, in addition to --"+namespaces+". "+
+			"the namespace label changes are applied without a restart")
+	flags.StringToStringVar(&s.requiredLabels, requiredLabels, nil,
+		"only manage ingresses that have all of the labels, in key=value format, in addition to matching the ingress class")
+	flags.StringVar(&s.ingressLabelSelector, ingressLabelSelector, "",
+		"only manage ingresses matching the label selector, i.e. env=staging or env in (staging,qa), in addition to --"+requiredLabels+". "+
+			"unlike --"+requiredLabels+", it is not overridden by --"+optionsConfigMap)
+	flags.BoolVar(&s.watchWithoutClass, watchWithoutClass, false,
+		"also manage the ingresses that specify no ingress class, even if no ingress class of the controller is marked as default")
+	flags.StringVar(&s.optionsConfigMap, optionsConfigMap, "",
+		fmt.Sprintf("namespace/name of a config map, whose %q and %q keys replace the respective flags while it exists, "+
+			"and are applied without a restart", controllers.OptionsConfigMapNamespaces, controllers.OptionsConfigMapRequiredLabels))
 	flags.StringVar(&s.sharedSecret, sharedSecret, "",
 		"base64-encoded shared secret for signing JWTs")
 	flags.BoolVar(&s.debug, debug, false, "enable debug logging")
 	if err := flags.MarkHidden("debug"); err != nil {
 		return err
 	}
+	flags.StringVar(&s.debugAddr, debugBindAddress, "",
+		"the address the debug endpoints bind to, empty to disable. exposes internal state and should not be publicly reachable")
 	flags.StringVar(&s.updateStatusFromService, updateStatusFromService, "", "update ingress status from given service status (pomerium-proxy)")
+	flags.StringSliceVar(&s.allowedStatusServices, allowedStatusServices, nil,
+		"services, in namespace/name format, ingresses may update their status from via status_from_service annotation instead")
 	flags.BoolVar(&s.disableCertCheck, disableCertCheck, false, "this flag should only be set if pomerium is configured with insecure_server option")
+	flags.BoolVar(&s.skipCertificates, skipCertificates, false,
+		"do not forward the ingress TLS certificates and tls_downstream_client_ca_secret to pomerium, that would not use them. requires --"+disableCertCheck)
+	flags.BoolVar(&s.strictIngressValidation, strictIngressValidation, false,
+		"reject the entire ingress if any of its paths is invalid, instead of applying the valid ones")
+	flags.StringVar(&s.clusterName, clusterName, "",
+		"cluster name, must be set if multiple clusters share the same databroker")
+	flags.IntVar(&s.clusterPriority, clusterPriority, 0,
+		"when multiple clusters publish the same route, the cluster with the highest priority owns it. requires --"+clusterName)
+
+	flags.Int32SliceVar(&s.listenerPorts, listenerPorts, nil,
+		"non-default proxy listener ports ingresses may attach their routes to via listener_port annotation")
+
+	flags.IntVar(&s.certCacheSize, certCacheSize, controllers.DefaultCertCacheSize,
+		"number of parsed TLS secrets to keep in cache, 0 to disable caching")
+
+	flags.StringVar(&s.syncStateWriter, syncStateWriter, controllers.SyncStateWriterNone,
+		fmt.Sprintf("record the ingress sync state on the ingress objects, one of %v", controllers.SyncStateWriters))
+	flags.StringVar(&s.hostConflictPolicy, hostConflictPolicy, controllers.HostConflictOldestWins,
+		fmt.Sprintf("which of the ingresses in different namespaces claiming the same host and path is published, one of %v",
+			controllers.HostConflictPolicies))
+
+	flags.BoolVar(&s.warmStandby, warmStandby, false,
+		"run the ingress controller while waiting for the databroker lease, keeping the ingress configs up to date "+
+			"without applying them, so that they are applied at once when the lease is acquired. requires --"+leaderElection+"="+leaderElectionDatabroker)
+	flags.StringVar(&s.leaderElection, leaderElection, leaderElectionDatabroker,
+		fmt.Sprintf("how the controller replicas elect the leader that reconciles the ingresses, one of %v. "+
+			"%s uses the kubernetes Lease object and does not depend on the databroker availability, "+
+			"%s should only be used with a single replica", leaderElectionModes, leaderElectionKube, leaderElectionNone))
+	flags.StringVar(&s.leaderElectionNamespace, leaderElectionNamespace, "",
+		fmt.Sprintf("namespace of the Lease object used with --%s=%s, defaults to the controller namespace when running in cluster",
+			leaderElection, leaderElectionKube))
+	flags.BoolVar(&s.writeRouteStatusCRs, writeRouteStatusCRs, false,
+		"mirror the redacted routes generated for each managed ingress into an IngressRouteStatus object owned by it, "+
+			"requires the IngressRouteStatus CRD to be installed")
+
+	flags.BoolVar(&s.defaultSecurityHeaders, defaultSecurityHeaders, false,
+		fmt.Sprintf("set Strict-Transport-Security, X-Content-Type-Options and X-Frame-Options response headers on all routes, "+
+			"unless set via set_response_headers annotation or disabled with %s annotation", model.DisableDefaultHeaders))
+
+	flags.BoolVar(&s.useLegacyEndpoints, useLegacyEndpoints, false,
+		"resolve the service endpoints from the legacy Endpoints objects instead of discovery.k8s.io/v1 EndpointSlices, "+
+			"that are used unless the cluster does not serve them")
+
+	flags.BoolVar(&s.useServiceProxy, useServiceProxy, false,
+		fmt.Sprintf("use the k8s service proxy as upstream instead of individual endpoints, that are then not watched, "+
+			"unless the ingress sets %s annotation to false", model.UseServiceProxy))
+	flags.BoolVar(&s.wildcardHosts, wildcardHosts, false,
+		"translate the ingress rules with a wildcard host, i.e. *.apps.example.com, that are rejected otherwise, "+
+			"as Pomerium v0.17.x only authorizes the requests by the route whose host equals the request host")
+
+	flags.StringVar(&s.faultInjectionFile, faultInjectionFile, "",
+		"for testing only: inject the databroker call failures described in this file, that is reloaded on change, "+
+			"and may also be updated via /debug/faults endpoint")
+	if err := flags.MarkHidden(faultInjectionFile); err != nil {
+		return err
+	}
+
+	flags.IntVar(&s.maxRouteDeletionPercent, maxRouteDeletionPercent, pomerium.DefaultMaxRouteDeletionPercent,
+		"refuse a full sync that would delete more than this percentage of the existing routes")
+	flags.BoolVar(&s.allowMassRouteDeletion, allowMassRouteDeletion, false,
+		fmt.Sprintf("allow a full sync to delete any number of the existing routes, overriding --%s", maxRouteDeletionPercent))
+	flags.StringVar(&s.massRouteDeletionConfirmFile, massRouteDeletionConfirmFile, "",
+		fmt.Sprintf("if this file exists, a full sync is allowed to delete more than --%s of the existing routes", maxRouteDeletionPercent))
+
+	flags.IntVar(&s.backpressure.MaxConsecutiveFailures, backpressureMaxFailures, controllers.DefaultBackpressureMaxFailures,
+		"consider the databroker degraded after this many consecutive failed or slow writes, "+
+			"and postpone the reconciles until it recovers. 0 to disable")
+	flags.DurationVar(&s.backpressure.MaxLatency, backpressureMaxLatency, controllers.DefaultBackpressureMaxLatency,
+		"a databroker write taking longer than this counts as a failure, 0 to ignore the latency")
+	flags.DurationVar(&s.backpressure.RequeueDelay, backpressureRequeueDelay, controllers.DefaultBackpressureRequeueDelay,
+		"delay of the reconciles postponed while the databroker is degraded")
+	flags.DurationVar(&s.backpressure.RecoveryRamp, backpressureRecoveryRamp, controllers.DefaultBackpressureRecoveryRamp,
+		"period over which the reconcile rate ramps up once the databroker recovers")
+
+	flags.IntVar(&s.dependencyReconcileWindow, dependencyReconcileWindow, controllers.DefaultDependencyReconcileWindow,
+		"number of the reconciles triggered by the service, endpoints, secret or config map updates "+
+			"that may be queued at a time, so that the new ingresses and deletes are processed ahead of them. 0 to disable")
+	flags.DurationVar(&s.dependencyReconcileMaxWait, dependencyReconcileMaxWait, controllers.DefaultDependencyReconcileMaxWait,
+		fmt.Sprintf("a reconcile triggered by a dependency update waiting longer than this is queued regardless of --%s", dependencyReconcileWindow))
+
+	flags.IntVar(&s.reconcileConcurrency, reconcileConcurrency, 1,
+		"number of the ingresses reconciled at a time. the databroker config updates are still applied one at a time")
+	flags.DurationVar(&s.resyncPeriod, resyncPeriod, 0,
+		"reconcile all managed ingresses this often regardless of the updates, so that the pomerium config "+
+			"edited in the databroker directly or not written due to a failure is restored. 0 to disable")
+	flags.StringVar(&s.globalSettings, globalSettings, "",
+		"name of the cluster-scoped Pomerium object, whose global settings are applied to the databroker along with the routes")
+	flags.DurationVar(&s.databrokerDebounce, databrokerDebounce, 0,
+		fmt.Sprintf("coalesce the ingress updates made within this window, i.e. 500ms, into a single databroker update. "+
+			"only the ingresses reconciled concurrently are coalesced, hence it requires --%s greater than 1. 0 to disable", reconcileConcurrency))
+
+	flags.IntVar(&s.readyzMaxUnsyncedIngresses, readyzMaxUnsyncedIngresses, defaultReadyzMaxUnsyncedIngresses,
+		"the readiness check fails while any managed ingress is not synced, "+
+			"and /readyz?verbose lists up to this many of them with their reasons. 0 to list all")
+
+	flags.BoolVar(&s.dumpConfig, dumpConfig, false,
+		"print the effective configuration as JSON, with the secrets masked, and exit")
 
 	v := viper.New()
 	var err error
+	s.envFlags = make(map[string]string)
 	flags.VisitAll(func(f *pflag.Flag) {
 		if err = v.BindEnv(f.Name, envName(f.Name)); err != nil {
 			return
@@ -150,12 +400,25 @@ func (s *serveCmd) setupFlags() error {
 			if err = flags.Set(f.Name, fmt.Sprintf("%v", val)); err != nil {
 				return
 			}
+			s.envFlags[f.Name] = f.Value.String()
 		}
 	})
 	return err
 }
 
 func (s *serveCmd) exec(*cobra.Command, []string) error {
+	opts, err := s.getOptions()
+	if err != nil {
+		return err
+	}
+	if s.dumpConfig {
+		if s.warmStandby {
+			// set by runController
+			opts = append(opts, controllers.WithWarmStandby(controllers.NewWarmStandby()))
+		}
+		return s.writeConfigSnapshot(s.OutOrStdout(), opts)
+	}
+
 	s.setupLogger()
 	ctx := ctrl.SetupSignalHandler()
 	dbc, err := s.getDataBrokerConnection(ctx)
@@ -163,30 +426,161 @@ func (s *serveCmd) exec(*cobra.Command, []string) error {
 		return fmt.Errorf("databroker connection: %w", err)
 	}
 
-	opts, err := s.getOptions()
+	client, err := s.getDataBrokerClient(ctx, dbc)
 	if err != nil {
 		return err
 	}
 
-	return s.runController(ctx,
-		databroker.NewDataBrokerServiceClient(dbc),
-		ctrl.Options{
-			Scheme:             scheme,
-			MetricsBindAddress: s.metricsAddr,
-			Port:               s.webhookPort,
-			LeaderElection:     false,
-		}, opts...)
+	return s.runController(ctx, client, s.getManagerOptions(), opts...)
+}
+
+// getManagerOptions returns the controller manager options,
+// that only enable the leader election if it is delegated to the controller-runtime
+func (s *serveCmd) getManagerOptions() ctrl.Options {
+	opts := ctrl.Options{
+		Scheme:             scheme,
+		MetricsBindAddress: s.metricsAddr,
+		Port:               s.webhookPort,
+		LeaderElection:     false,
+	}
+	if s.leaderElection == leaderElectionKube {
+		opts.LeaderElection = true
+		opts.LeaderElectionResourceLock = resourcelock.LeasesResourceLock
+		opts.LeaderElectionID = fmt.Sprintf("pomerium-%s", s.leaseName())
+		opts.LeaderElectionNamespace = s.leaderElectionNamespace
+	}
+	return opts
+}
+
+// getDataBrokerClient returns the databroker client, that injects failures if fault injection is enabled
+func (s *serveCmd) getDataBrokerClient(ctx context.Context, dbc *grpc.ClientConn) (databroker.DataBrokerServiceClient, error) {
+	client := databroker.NewDataBrokerServiceClient(dbc)
+	if s.faultInjectionFile == "" {
+		return client, nil
+	}
+
+	s.faultInjector = faults.NewInjector()
+	if err := s.faultInjector.LoadFile(s.faultInjectionFile); err != nil {
+		return nil, fmt.Errorf("--%s: %w", faultInjectionFile, err)
+	}
+	go s.faultInjector.WatchFile(ctx, s.faultInjectionFile)
+	ctrl.Log.WithName("databroker").Info("fault injection is enabled, not for production use", "file", s.faultInjectionFile)
+	return faults.Wrap(client, s.faultInjector), nil
 }
 
 func (s *serveCmd) getOptions() ([]controllers.Option, error) {
+	if _, err := labels.ValidatedSelectorFromSet(s.requiredLabels); err != nil {
+		return nil, fmt.Errorf("--%s: %w", requiredLabels, err)
+	}
+	if err := controllers.ValidateNamespacePatterns(s.excludeNamespaces); err != nil {
+		return nil, fmt.Errorf("--%s: %w", excludeNamespaces, err)
+	}
+	if _, err := labels.Parse(s.namespaceLabelSelector); err != nil {
+		return nil, fmt.Errorf("--%s: %w", namespaceLabelSelector, err)
+	}
+	if _, err := labels.Parse(s.ingressLabelSelector); err != nil {
+		return nil, fmt.Errorf("--%s: %w", ingressLabelSelector, err)
+	}
 	opts := []controllers.Option{
 		controllers.WithNamespaces(s.namespaces),
+		controllers.WithExcludedNamespaces(s.excludeNamespaces),
+		controllers.WithNamespaceSelector(s.namespaceLabelSelector),
+		controllers.WithRequiredLabels(s.requiredLabels),
+		controllers.WithIngressLabelSelector(s.ingressLabelSelector),
 		controllers.WithAnnotationPrefix(s.annotationPrefix),
+		controllers.WithServiceAnnotationPrefix(s.serviceAnnotationPrefix),
 		controllers.WithControllerName(s.className),
+		controllers.WithAllowedListenerPorts(s.listenerPorts),
+		controllers.WithCertCacheSize(s.certCacheSize),
+		controllers.WithSyncStateWriter(s.syncStateWriter),
+		controllers.WithHostConflictPolicy(s.hostConflictPolicy),
+	}
+	if s.maxRouteDeletionPercent < 0 || s.maxRouteDeletionPercent > 100 {
+		return nil, fmt.Errorf("--%s must be within 0-100", maxRouteDeletionPercent)
+	}
+	if s.clusterPriority != 0 && s.clusterName == "" {
+		return nil, fmt.Errorf("--%s requires --%s to be set", clusterPriority, clusterName)
+	}
+	switch s.leaderElection {
+	case leaderElectionKube, leaderElectionDatabroker, leaderElectionNone:
+	default:
+		return nil, fmt.Errorf("--%s must be one of %v", leaderElection, leaderElectionModes)
+	}
+	if s.warmStandby && s.leaderElection != leaderElectionDatabroker {
+		return nil, fmt.Errorf("--%s requires --%s=%s", warmStandby, leaderElection, leaderElectionDatabroker)
+	}
+	if s.skipCertificates && !s.disableCertCheck {
+		return nil, fmt.Errorf("--%s requires --%s to be set", skipCertificates, disableCertCheck)
+	}
+	if s.watchWithoutClass {
+		opts = append(opts, controllers.WithWatchIngressWithoutClass())
 	}
 	if s.disableCertCheck {
 		opts = append(opts, controllers.WithDisableCertCheck())
 	}
+	if s.skipCertificates {
+		opts = append(opts, controllers.WithSkipCertificates())
+	}
+	if s.backpressure.MaxConsecutiveFailures > 0 {
+		if s.backpressure.RequeueDelay <= 0 || s.backpressure.RecoveryRamp <= 0 {
+			return nil, fmt.Errorf("--%s and --%s must be positive", backpressureRequeueDelay, backpressureRecoveryRamp)
+		}
+		opts = append(opts, controllers.WithBackpressure(controllers.NewBackpressure(s.backpressure)))
+	}
+	if s.dependencyReconcileWindow < 0 {
+		return nil, fmt.Errorf("--%s must not be negative", dependencyReconcileWindow)
+	}
+	if s.dependencyReconcileWindow > 0 && s.dependencyReconcileMaxWait <= 0 {
+		return nil, fmt.Errorf("--%s must be positive", dependencyReconcileMaxWait)
+	}
+	opts = append(opts, controllers.WithDependencyReconcileWindow(s.dependencyReconcileWindow, s.dependencyReconcileMaxWait))
+	if s.reconcileConcurrency < 1 {
+		return nil, fmt.Errorf("--%s must be at least 1", reconcileConcurrency)
+	}
+	opts = append(opts, controllers.WithMaxConcurrentReconciles(s.reconcileConcurrency))
+	if s.resyncPeriod < 0 {
+		return nil, fmt.Errorf("--%s must not be negative", resyncPeriod)
+	}
+	if s.resyncPeriod > 0 {
+		opts = append(opts, controllers.WithResyncPeriod(s.resyncPeriod))
+	}
+	if s.globalSettings != "" {
+		opts = append(opts, controllers.WithGlobalSettings(s.globalSettings))
+	}
+	if s.databrokerDebounce < 0 {
+		return nil, fmt.Errorf("--%s must not be negative", databrokerDebounce)
+	}
+	if s.databrokerDebounce > 0 {
+		if s.reconcileConcurrency < 2 {
+			return nil, fmt.Errorf("--%s requires --%s greater than 1", databrokerDebounce, reconcileConcurrency)
+		}
+		if s.warmStandby {
+			return nil, fmt.Errorf("--%s is not supported along with --%s", databrokerDebounce, warmStandby)
+		}
+		opts = append(opts, controllers.WithDatabrokerDebounce(s.databrokerDebounce))
+	}
+	if s.defaultSecurityHeaders {
+		opts = append(opts, controllers.WithDefaultResponseHeaders(controllers.DefaultSecurityHeaders))
+	}
+	if s.useLegacyEndpoints {
+		opts = append(opts, controllers.WithLegacyEndpoints())
+	}
+	if s.useServiceProxy {
+		opts = append(opts, controllers.WithServiceProxyUpstreams())
+	}
+	if s.wildcardHosts {
+		opts = append(opts, controllers.WithWildcardHosts())
+	}
+	if s.writeRouteStatusCRs {
+		opts = append(opts, controllers.WithRouteStatusCRs(pomerium.RenderRoutes))
+	}
+	if s.optionsConfigMap != "" {
+		parts := strings.Split(s.optionsConfigMap, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("--%s must be in namespace/name format", optionsConfigMap)
+		}
+		opts = append(opts, controllers.WithOptionsConfigMap(types.NamespacedName{Namespace: parts[0], Name: parts[1]}))
+	}
 	if s.updateStatusFromService != "" {
 		parts := strings.Split(s.updateStatusFromService, "/")
 		if len(parts) != 2 {
@@ -195,6 +589,21 @@ func (s *serveCmd) getOptions() ([]controllers.Option, error) {
 		opts = append(opts,
 			controllers.WithUpdateIngressStatusFromService(types.NamespacedName{Namespace: parts[0], Name: parts[1]}))
 	}
+	if len(s.allowedStatusServices) > 0 {
+		names := make([]types.NamespacedName, 0, len(s.allowedStatusServices))
+		for _, name := range s.allowedStatusServices {
+			parts := strings.Split(name, "/")
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("--%s: %q must be in namespace/name format", allowedStatusServices, name)
+			}
+			names = append(names, types.NamespacedName{Namespace: parts[0], Name: parts[1]})
+		}
+		opts = append(opts, controllers.WithAllowedStatusServices(names))
+	}
+	if s.updateStatusFromService != "" || len(s.allowedStatusServices) > 0 {
+		s.statusUpdaterHealth = controllers.NewStatusUpdaterHealth()
+		opts = append(opts, controllers.WithStatusUpdaterHealth(s.statusUpdaterHealth))
+	}
 	return opts, nil
 }
 
@@ -218,15 +627,14 @@ func (s *serveCmd) getDataBrokerConnection(ctx context.Context) (*grpc.ClientCon
 	}
 
 	sharedSecret, _ := base64.StdEncoding.DecodeString(s.sharedSecret)
+	if dataBrokerServiceURL.Scheme != "http" {
+		return s.dialDataBrokerTLS(ctx, dataBrokerServiceURL, sharedSecret)
+	}
 	return grpcutil.NewGRPCClientConn(ctx, &grpcutil.Options{
-		Address:                 dataBrokerServiceURL,
-		ServiceName:             "databroker",
-		SignedJWTKey:            sharedSecret,
-		RequestTimeout:          defaultGRPCTimeout,
-		CA:                      base64.StdEncoding.EncodeToString(s.tlsCA),
-		CAFile:                  s.tlsCAFile,
-		OverrideCertificateName: s.tlsOverrideCertificateName,
-		InsecureSkipVerify:      s.tlsInsecureSkipVerify,
+		Address:        dataBrokerServiceURL,
+		ServiceName:    "databroker",
+		SignedJWTKey:   sharedSecret,
+		RequestTimeout: defaultGRPCTimeout,
 	})
 }
 
@@ -239,6 +647,16 @@ type leadController struct {
 	annotationPrefix string
 	className        string
 	running          int32
+
+	// leaderElection mode, the readiness check reports what the controller is waiting for
+	leaderElection string
+
+	// warmStandby if set, the controller runs regardless of the lease and is only activated once the lease is acquired
+	warmStandby *controllers.WarmStandby
+
+	mu sync.RWMutex
+	// state of the currently running controller, nil while waiting for the lease
+	state *controllers.State
 }
 
 func (c *leadController) GetDataBrokerServiceClient() databroker.DataBrokerServiceClient {
@@ -253,26 +671,122 @@ func (c *leadController) setRunning(running bool) {
 	}
 }
 
+func (c *leadController) setState(state *controllers.State) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state = state
+}
+
+func (c *leadController) getState() *controllers.State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.state
+}
+
 func (c *leadController) ReadyzCheck(r *http.Request) error {
 	val := atomic.LoadInt32(&c.running)
-	if val == 0 {
-		return errWaitingForLease
+	if val != 0 {
+		return nil
 	}
-	return nil
+	switch c.leaderElection {
+	case leaderElectionKube:
+		return errWaitingForLeaderElection
+	case leaderElectionNone:
+		return errWaitingForStart
+	}
+	return errWaitingForLease
+}
+
+func (c *leadController) newController() (ctrl.Manager, error) {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("get k8s api config: %w", err)
+	}
+	mgr, state, err := controllers.NewIngressControllerWithState(cfg, c.MgrOpts, c.PomeriumReconciler, c.CtrlOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating controller: %w", err)
+	}
+	c.setState(state)
+	return mgr, nil
 }
 
 func (c *leadController) RunLeased(ctx context.Context) error {
 	defer c.setRunning(false)
 
-	cfg, err := ctrl.GetConfig()
+	if c.warmStandby != nil {
+		return c.runWarmLeased(ctx)
+	}
+
+	mgr, err := c.newController()
+	if err != nil {
+		return err
+	}
+	defer c.setState(nil)
+	c.setRunning(true)
+	if err = mgr.Start(ctx); err != nil {
+		return fmt.Errorf("running controller: %w", err)
+	}
+	return nil
+}
+
+// RunElected runs the controller without the databroker lease, and marks it running once the manager is elected,
+// that is immediately unless the manager performs the kubernetes leader election
+func (c *leadController) RunElected(ctx context.Context) error {
+	defer c.setRunning(false)
+
+	mgr, err := c.newController()
 	if err != nil {
-		return fmt.Errorf("get k8s api config: %w", err)
+		return err
 	}
-	mgr, err := controllers.NewIngressController(cfg, c.MgrOpts, c.PomeriumReconciler, c.CtrlOpts...)
+	defer c.setState(nil)
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.trackElected(ctx, mgr.Elected())
+	}()
+	err = mgr.Start(ctx)
+	cancel()
+	<-done
 	if err != nil {
-		return fmt.Errorf("creating controller: %w", err)
+		return fmt.Errorf("running controller: %w", err)
+	}
+	return nil
+}
+
+// trackElected marks the controller running once elected is closed
+func (c *leadController) trackElected(ctx context.Context, elected <-chan struct{}) {
+	select {
+	case <-elected:
+		c.setRunning(true)
+	case <-ctx.Done():
+	}
+}
+
+// runWarmLeased activates the warm standby controller for as long as the lease is held
+func (c *leadController) runWarmLeased(ctx context.Context) error {
+	if err := c.warmStandby.Activate(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("activating warm standby: %w", err)
 	}
+	defer c.warmStandby.Deactivate()
+
 	c.setRunning(true)
+	<-ctx.Done()
+	return nil
+}
+
+// runStandby runs the controller regardless of the lease, that is only activated once the lease is acquired
+func (c *leadController) runStandby(ctx context.Context) error {
+	mgr, err := c.newController()
+	if err != nil {
+		return err
+	}
 	if err = mgr.Start(ctx); err != nil {
 		return fmt.Errorf("running controller: %w", err)
 	}
@@ -281,33 +795,87 @@ func (c *leadController) RunLeased(ctx context.Context) error {
 
 func (s *serveCmd) runController(ctx context.Context, client databroker.DataBrokerServiceClient, opts ctrl.Options, cOpts ...controllers.Option) error {
 	c := &leadController{
-		PomeriumReconciler:      &pomerium.ConfigReconciler{DataBrokerServiceClient: client, DebugDumpConfigDiff: s.debug},
-		DataBrokerServiceClient: client,
+		PomeriumReconciler: &pomerium.ConfigReconciler{
+			DataBrokerServiceClient: client,
+			DebugDumpConfigDiff:     s.debug,
+			StrictIngressValidation: s.strictIngressValidation,
+			Cluster:                 s.clusterName,
+			ClusterPriority:         s.clusterPriority,
+			DeletionGuard: &pomerium.DeletionGuard{
+				MaxPercent:  s.maxRouteDeletionPercent,
+				Allow:       s.allowMassRouteDeletion,
+				ConfirmFile: s.massRouteDeletionConfirmFile,
+			},
+		},
+		DataBrokerServiceClient: newLeaseClockMonitor(client, leaseDuration, ctrl.Log.WithName("lease")),
 		MgrOpts:                 opts,
 		CtrlOpts:                cOpts,
 		namespaces:              s.namespaces,
 		className:               s.className,
 		annotationPrefix:        s.annotationPrefix,
+		leaderElection:          s.leaderElection,
 	}
 
 	eg, ctx := errgroup.WithContext(ctx)
+	if s.warmStandby {
+		c.warmStandby = controllers.NewWarmStandby()
+		c.CtrlOpts = append(c.CtrlOpts, controllers.WithWarmStandby(c.warmStandby))
+		eg.Go(func() error {
+			return c.runStandby(ctx)
+		})
+	}
+	leaderCheck := "acquire databroker lease"
+	switch s.leaderElection {
+	case leaderElectionDatabroker:
+		eg.Go(func() error {
+			leaser := databroker.NewLeaser(s.leaseName(), leaseDuration, c)
+			return leaser.Run(ctx)
+		})
+	case leaderElectionKube:
+		leaderCheck = "kubernetes leader election"
+		eg.Go(func() error {
+			return c.RunElected(ctx)
+		})
+	default:
+		leaderCheck = "controller running"
+		eg.Go(func() error {
+			return c.RunElected(ctx)
+		})
+	}
 	eg.Go(func() error {
-		leaser := databroker.NewLeaser("ingress-controller", leaseDuration, c)
-		return leaser.Run(ctx)
-	})
-	eg.Go(func() error {
-		return s.runHealthz(ctx, healthz.NamedCheck("acquire databroker lease", c.ReadyzCheck))
+		return s.runHealthz(ctx,
+			healthz.NamedCheck(leaderCheck, c.ReadyzCheck),
+			healthz.NamedCheck(unsyncedIngressesCheckName, c.UnsyncedIngressesCheck(s.readyzMaxUnsyncedIngresses)),
+		)
 	})
+	if s.debugAddr != "" {
+		eg.Go(func() error {
+			return s.runDebug(ctx, c)
+		})
+	}
 	return eg.Wait()
 }
 
+// leaseName is unique per cluster, as each cluster maintains its own config record
+func (s *serveCmd) leaseName() string {
+	if s.clusterName == "" {
+		return "ingress-controller"
+	}
+	return fmt.Sprintf("ingress-controller-%s", s.clusterName)
+}
+
 func (s *serveCmd) runHealthz(ctx context.Context, readyChecks ...healthz.HealthChecker) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	mux := http.NewServeMux()
 	healthz.InstallHandler(mux)
-	healthz.InstallReadyzHandler(mux, readyChecks...)
+	installReadyzHandler(mux, readyChecks...)
+	if s.statusUpdaterHealth != nil {
+		// warning level checks do not affect readiness, and are reported separately
+		healthz.InstallPathHandler(mux, "/readyz/warnings",
+			healthz.NamedCheck("ingress-status-updater", s.statusUpdaterHealth.Check))
+	}
 
 	srv := http.Server{
 		Addr:    s.probeAddr,
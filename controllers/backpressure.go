@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// BackpressureState describes the databroker health as observed by the ingress controller
+type BackpressureState int
+
+const (
+	// BackpressureHealthy admits all reconciles
+	BackpressureHealthy BackpressureState = iota
+	// BackpressureDegraded postpones the reconciles, admitting one probe per BackpressureConfig.RequeueDelay,
+	// and pauses the ingress object write-back
+	BackpressureDegraded
+	// BackpressureRecovering admits the reconciles at a rate ramping up over BackpressureConfig.RecoveryRamp
+	BackpressureRecovering
+)
+
+const (
+	// DefaultBackpressureMaxFailures is the default number of consecutive databroker write failures
+	// after which the databroker is considered degraded
+	DefaultBackpressureMaxFailures = 5
+	// DefaultBackpressureMaxLatency is the default databroker write latency that counts as a failure
+	DefaultBackpressureMaxLatency = time.Second * 10
+	// DefaultBackpressureRequeueDelay is the default delay of the reconciles postponed while degraded
+	DefaultBackpressureRequeueDelay = time.Second * 30
+	// DefaultBackpressureRecoveryRamp is the default period over which the reconcile rate ramps up after recovery
+	DefaultBackpressureRecoveryRamp = time.Minute
+
+	// recoveryRampDoublings is how many times the admission rate doubles during the recovery ramp,
+	// starting with one reconcile per second
+	recoveryRampDoublings = 10
+)
+
+func (s BackpressureState) String() string {
+	switch s {
+	case BackpressureHealthy:
+		return "healthy"
+	case BackpressureDegraded:
+		return "degraded"
+	case BackpressureRecovering:
+		return "recovering"
+	}
+	return "unknown"
+}
+
+// BackpressureConfig defines when the databroker is considered degraded, and how the reconciles are slowed down
+type BackpressureConfig struct {
+	// MaxConsecutiveFailures of the databroker writes after which the databroker is considered degraded, 0 to disable
+	MaxConsecutiveFailures int `json:"maxConsecutiveFailures"`
+	// MaxLatency of a databroker write, that counts as a failure if exceeded, 0 to ignore the latency
+	MaxLatency time.Duration `json:"maxLatency"`
+	// RequeueDelay of the reconciles postponed while the databroker is degraded
+	RequeueDelay time.Duration `json:"requeueDelay"`
+	// RecoveryRamp is the period over which the reconcile rate ramps up once the databroker recovers
+	RecoveryRamp time.Duration `json:"recoveryRamp"`
+}
+
+// Backpressure couples the reconciliation rate to the databroker health, so that the reconciles do not pile up
+// while the databroker is degraded, and do not all hit it at once when it recovers. It is safe for concurrent use.
+type Backpressure struct {
+	mu  sync.Mutex
+	cfg BackpressureConfig
+	now func() time.Time
+
+	state    BackpressureState
+	failures int
+	// nextAdmit is when the next reconcile may be admitted while degraded or recovering
+	nextAdmit time.Time
+	// recoveredAt is when the recovery ramp started
+	recoveredAt time.Time
+}
+
+// NewBackpressure creates a healthy back-pressure tracker
+func NewBackpressure(cfg BackpressureConfig) *Backpressure {
+	backpressureState.Set(float64(BackpressureHealthy))
+	return &Backpressure{cfg: cfg, now: time.Now}
+}
+
+// State returns the current back-pressure state
+func (b *Backpressure) State() BackpressureState {
+	if b == nil {
+		return BackpressureHealthy
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// IsDegraded checks whether the non-critical work should be paused
+func (b *Backpressure) IsDegraded() bool {
+	return b.State() == BackpressureDegraded
+}
+
+// Admit checks whether a reconcile may proceed, and otherwise returns the delay it should be requeued after
+func (b *Backpressure) Admit() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case BackpressureDegraded:
+		if now.Before(b.nextAdmit) {
+			return b.postpone(b.cfg.RequeueDelay)
+		}
+		// a probe, that would tell whether the databroker has recovered
+		b.nextAdmit = now.Add(b.cfg.RequeueDelay)
+	case BackpressureRecovering:
+		elapsed := now.Sub(b.recoveredAt)
+		if elapsed >= b.cfg.RecoveryRamp {
+			b.setState(BackpressureHealthy)
+			return 0
+		}
+		if now.Before(b.nextAdmit) {
+			return b.postpone(b.nextAdmit.Sub(now))
+		}
+		rate := math.Exp2(recoveryRampDoublings * float64(elapsed) / float64(b.cfg.RecoveryRamp))
+		b.nextAdmit = now.Add(time.Duration(float64(time.Second) / rate))
+	}
+	return 0
+}
+
+func (b *Backpressure) postpone(d time.Duration) time.Duration {
+	backpressureDeferred.Inc()
+	return d
+}
+
+// Observe records the outcome of a databroker write that started at the provided time
+func (b *Backpressure) Observe(start time.Time, err error) {
+	if b == nil || b.cfg.MaxConsecutiveFailures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	failed := isDataBrokerFailure(err) || (b.cfg.MaxLatency > 0 && now.Sub(start) > b.cfg.MaxLatency)
+	if !failed {
+		b.failures = 0
+		backpressureFailures.Set(0)
+		if b.state == BackpressureDegraded {
+			b.recoveredAt = now
+			b.nextAdmit = now
+			b.setState(BackpressureRecovering)
+		}
+		return
+	}
+
+	b.failures++
+	backpressureFailures.Set(float64(b.failures))
+	if b.failures >= b.cfg.MaxConsecutiveFailures && b.state != BackpressureDegraded {
+		b.nextAdmit = now.Add(b.cfg.RequeueDelay)
+		b.setState(BackpressureDegraded)
+	}
+}
+
+func (b *Backpressure) setState(state BackpressureState) {
+	b.state = state
+	backpressureState.Set(float64(state))
+}
+
+// isDataBrokerFailure checks whether the error is caused by the databroker, rather than by the ingress itself
+func isDataBrokerFailure(err error) bool {
+	if err == nil || model.IsPermanentError(err) {
+		return false
+	}
+	var routeErrs model.RouteErrors
+	return !errors.As(err, &routeErrs)
+}
+
+// skipWriteBack checks whether the non-critical ingress object updates should not be performed
+func (r *ingressController) skipWriteBack() bool {
+	return r.isStandby() || r.backpressure.IsDegraded()
+}
@@ -0,0 +1,90 @@
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/pomerium/ingress-controller/controllers"
+	"github.com/pomerium/ingress-controller/internal/faults"
+	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/pomerium"
+	"github.com/pomerium/ingress-controller/pomeriumtest"
+)
+
+// TestBackpressureOutage runs a reconcile loop gated by the back-pressure against a databroker outage,
+// and checks that the databroker is only probed during the outage, and the routes converge once it recovers
+func TestBackpressureOutage(t *testing.T) {
+	const ingresses = 20
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	injector := faults.NewInjector()
+	db := pomeriumtest.NewDataBroker()
+	pcr := &pomerium.ConfigReconciler{DataBrokerServiceClient: faults.Wrap(db, injector)}
+	bp := controllers.NewBackpressure(controllers.BackpressureConfig{
+		MaxConsecutiveFailures: 3,
+		RequeueDelay:           time.Millisecond * 50,
+		RecoveryRamp:           time.Millisecond * 500,
+	})
+
+	var attempts int64
+	reconcile := func(name string) time.Duration {
+		if delay := bp.Admit(); delay > 0 {
+			return delay
+		}
+		atomic.AddInt64(&attempts, 1)
+		start := time.Now()
+		_, err := pcr.Upsert(ctx, controllers.NewTestIngressConfig(name, map[string]string{fmt.Sprintf("a/%s", model.UseServiceProxy): "true"}))
+		bp.Observe(start, err)
+		if err != nil {
+			// immediate retry, as the controller rate limiter would do for the first failures
+			return time.Millisecond
+		}
+		return 0
+	}
+
+	queue := workqueue.NewDelayingQueue()
+	defer queue.ShutDown()
+	go func() {
+		for {
+			item, shutdown := queue.Get()
+			if shutdown {
+				return
+			}
+			if delay := reconcile(item.(string)); delay > 0 {
+				queue.AddAfter(item, delay)
+			}
+			queue.Done(item)
+		}
+	}()
+
+	require.NoError(t, injector.SetRules([]faults.Rule{{Method: faults.MatchAll, ErrorRate: 1}}))
+	for i := 0; i < ingresses; i++ {
+		queue.Add(fmt.Sprintf("ingress-%d", i))
+	}
+	require.Eventually(t, bp.IsDegraded, time.Second*5, time.Millisecond*10)
+
+	start := atomic.LoadInt64(&attempts)
+	for i := 0; i < 10; i++ {
+		time.Sleep(time.Millisecond * 50)
+		assert.LessOrEqual(t, queue.Len(), ingresses, "each ingress should be queued at most once")
+	}
+	assert.LessOrEqual(t, atomic.LoadInt64(&attempts)-start, int64(15),
+		"the databroker should only be probed once per requeue delay during the outage")
+
+	require.NoError(t, injector.SetRules(nil))
+	assert.Eventually(t, func() bool {
+		routes, err := db.Routes()
+		return err == nil && len(routes) == ingresses
+	}, time.Second*10, time.Millisecond*10, "routes should converge once the databroker recovers")
+	assert.Eventually(t, func() bool {
+		return bp.Admit() == 0 && bp.State() == controllers.BackpressureHealthy
+	}, time.Second*5, time.Millisecond*10)
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func TestBackpressure(t *testing.T) {
+	now := time.Now()
+	b := NewBackpressure(BackpressureConfig{
+		MaxConsecutiveFailures: 2,
+		MaxLatency:             time.Second,
+		RequeueDelay:           time.Second * 30,
+		RecoveryRamp:           time.Minute,
+	})
+	b.now = func() time.Time { return now }
+	failure := errors.New("unavailable")
+
+	// ingress errors are not databroker failures
+	b.Observe(now, model.NewPermanentError(failure))
+	b.Observe(now, model.RouteErrors{})
+	assert.Equal(t, BackpressureHealthy, b.State())
+
+	b.Observe(now, failure)
+	assert.Equal(t, BackpressureHealthy, b.State())
+	assert.Zero(t, b.Admit())
+	// slow writes count as failures
+	b.Observe(now.Add(-time.Minute), nil)
+	assert.Equal(t, BackpressureDegraded, b.State())
+	assert.True(t, b.IsDegraded())
+
+	assert.Equal(t, time.Second*30, b.Admit(), "reconciles should be postponed while degraded")
+	now = now.Add(time.Second * 30)
+	assert.Zero(t, b.Admit(), "one probe should be admitted per requeue delay")
+	assert.Equal(t, time.Second*30, b.Admit())
+	b.Observe(now, failure)
+	assert.Equal(t, BackpressureDegraded, b.State())
+
+	now = now.Add(time.Second * 30)
+	assert.Zero(t, b.Admit())
+	b.Observe(now, nil)
+	assert.Equal(t, BackpressureRecovering, b.State())
+	assert.False(t, b.IsDegraded())
+
+	// the admission rate ramps up from one reconcile per second
+	assert.Zero(t, b.Admit())
+	assert.Equal(t, time.Second, b.Admit())
+	now = now.Add(time.Second * 30)
+	admitted := 0
+	for b.Admit() == 0 {
+		admitted++
+	}
+	assert.Equal(t, 1, admitted)
+	now = now.Add(time.Second)
+	assert.Zero(t, b.Admit())
+	assert.Less(t, b.Admit(), time.Second/10)
+
+	now = now.Add(time.Minute)
+	assert.Zero(t, b.Admit())
+	assert.Equal(t, BackpressureHealthy, b.State())
+	assert.Zero(t, b.Admit())
+
+	var disabled *Backpressure
+	assert.Zero(t, disabled.Admit())
+	assert.False(t, disabled.IsDegraded())
+}
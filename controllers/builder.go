@@ -2,10 +2,12 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/pomerium/ingress-controller/model"
 )
@@ -24,30 +26,124 @@ func NewIngressController(
 	pcr PomeriumReconciler,
 	opts ...Option,
 ) (ctrl.Manager, error) {
+	mgr, _, err := NewIngressControllerWithState(cfg, crOpts, pcr, opts...)
+	return mgr, err
+}
+
+// NewIngressControllerWithState creates new controller runtime,
+// and also returns State that provides read access to the controller caches and managed ingresses
+func NewIngressControllerWithState(
+	cfg *rest.Config,
+	crOpts ctrl.Options,
+	pcr PomeriumReconciler,
+	opts ...Option,
+) (ctrl.Manager, *State, error) {
 	mgr, err := ctrl.NewManager(cfg, crOpts)
 	if err != nil {
-		return nil, fmt.Errorf("unable to start manager: %w", err)
+		return nil, nil, fmt.Errorf("unable to start manager: %w", err)
 	}
 
 	registry := model.NewRegistry()
-	ic := &ingressController{
-		annotationPrefix:   DefaultAnnotationPrefix,
-		controllerName:     DefaultClassControllerName,
-		PomeriumReconciler: pcr,
-		Client:             mgr.GetClient(),
-		Registry:           registry,
-		EventRecorder:      mgr.GetEventRecorderFor("pomerium-ingress"),
+	ic := newIngressController(opts...)
+	if ic.globalSettings != "" {
+		if err = setupSettingsController(mgr, pcr, ic.globalSettings, ic.warmStandby); err != nil {
+			return nil, nil, fmt.Errorf("unable to create settings controller: %w", err)
+		}
+	}
+	if cache, ok := pcr.(AppliedConfigCache); ok {
+		ic.forgetApplied = cache.ForgetApplied
 	}
+	if ic.databrokerDebounce > 0 {
+		if ic.warmStandby != nil {
+			return nil, nil, fmt.Errorf("databroker debounce is not supported along with warm standby")
+		}
+		if pcr, err = newDebouncedReconciler(pcr, ic.databrokerDebounce); err != nil {
+			return nil, nil, err
+		}
+	}
+	pcr = &instrumentedReconciler{PomeriumReconciler: pcr, events: ic.eventTimes}
+	ic.PomeriumReconciler = pcr
+	ic.Client = mgr.GetClient()
+	ingressV1, err := hasIngressV1(mgr.GetRESTMapper())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ingressV1 {
+		log.FromContext(context.Background()).Info("networking.k8s.io/v1 Ingress is not served, using v1beta1")
+		ic.ingressV1beta1 = true
+		ic.Client = &ingressV1beta1Client{Client: ic.Client}
+	}
+	ic.Registry = registry
+	ic.EventRecorder = mgr.GetEventRecorderFor("pomerium-ingress")
+	ic.syncStates = newSyncStates()
 	ic.initComplete = newOnce(ic.reconcileInitial)
-	for _, opt := range opts {
-		opt(ic)
+	if err = validateHostConflictPolicy(ic.hostConflictPolicy); err != nil {
+		return nil, nil, err
+	}
+	ic.hostConflicts = newHostConflicts(ic.hostConflictPolicy)
+	if err = ValidateNamespacePatterns(ic.excludedNamespaces); err != nil {
+		return nil, nil, err
+	}
+	if ic.namespaceSelector, err = parseNamespaceSelector(ic.namespaceLabelSelector); err != nil {
+		return nil, nil, err
+	}
+	if ic.labelSelector, err = parseIngressLabelSelector(ic.ingressLabelSelector); err != nil {
+		return nil, nil, err
+	}
+	if ic.dependencyReconcileWindow > 0 {
+		ic.dependencyQueue = newDependencyQueue(ic.dependencyReconcileWindow, ic.dependencyReconcileMaxWait)
+	}
+	if ic.warmStandby != nil {
+		ic.warmStandby.setTarget(pcr)
+		ic.PomeriumReconciler = ic.warmStandby
+		ic.EventRecorder = &standbyEventRecorder{EventRecorder: ic.EventRecorder, standby: ic.warmStandby}
+	}
+	if ic.certCache, err = newCertCache(ic.certCacheSize); err != nil {
+		return nil, nil, err
+	}
+	if ic.syncStateWriter, err = newSyncStateWriter(ic.syncStateWriterKind, ic.Client, ic.annotationPrefix); err != nil {
+		return nil, nil, err
 	}
 
 	if err = ic.SetupWithManager(mgr); err != nil {
-		return nil, fmt.Errorf("unable to create controller: %w", err)
+		return nil, nil, fmt.Errorf("unable to create controller: %w", err)
+	}
+
+	state := &State{
+		reader:      mgr.GetCache(),
+		scheme:      mgr.GetScheme(),
+		registry:    registry,
+		ingressKind: ic.ingressKind,
+		states:      ic.syncStates,
+	}
+	if err = mgr.Add(&waitForCacheSync{mgr: mgr, State: state}); err != nil {
+		return nil, nil, fmt.Errorf("unable to add cache sync watcher: %w", err)
 	}
 
-	return mgr, nil
+	return mgr, state, nil
+}
+
+// newIngressController returns the ingress controller with the defaults and the options applied
+func newIngressController(opts ...Option) *ingressController {
+	ic := &ingressController{
+		annotationPrefix:        DefaultAnnotationPrefix,
+		controllerName:          DefaultClassControllerName,
+		statusUpdaterHealth:     NewStatusUpdaterHealth(),
+		certCacheSize:           DefaultCertCacheSize,
+		hostConflictPolicy:      HostConflictOldestWins,
+		routeTTLs:               newRouteTTLs(),
+		eventTimes:              newEventTimes(),
+		maxConcurrentReconciles: 1,
+		endpointSlices:          true,
+
+		dependencyReconcileWindow:  DefaultDependencyReconcileWindow,
+		dependencyReconcileMaxWait: DefaultDependencyReconcileMaxWait,
+	}
+	for _, opt := range opts {
+		opt(ic)
+	}
+	ic.flagScope = scope{namespaces: ic.namespaces, requiredLabels: ic.requiredLabels}
+	return ic
 }
 
 func arrayToMap(in []string) map[string]bool {
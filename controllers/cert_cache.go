@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	lru "github.com/hashicorp/golang-lru"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultCertCacheSize is the default number of parsed TLS secrets kept in cache
+const DefaultCertCacheSize = 1024
+
+// certCache keeps parsed TLS secrets, as the same secret (i.e. a wildcard cert)
+// is often shared by many ingresses and would be parsed on every reconciliation otherwise.
+// secrets are keyed by their UID and resource version, hence any update of the secret results in a cache miss.
+// a nil cache parses secrets every time.
+type certCache struct {
+	cache *lru.Cache
+}
+
+type certCacheKey struct {
+	uid             types.UID
+	resourceVersion string
+}
+
+type parsedTLSSecret struct {
+	cert *x509.Certificate
+	err  error
+}
+
+// newCertCache creates a new cache holding up to size parsed secrets, or nil if size is 0
+func newCertCache(size int) (*certCache, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("cert cache: %w", err)
+	}
+	return &certCache{cache: cache}, nil
+}
+
+// parseTLSSecret returns the parsed certificate, and an error if the secret does not hold
+// a valid certificate and private key pair
+func (c *certCache) parseTLSSecret(secret *corev1.Secret) (*x509.Certificate, error) {
+	// objects that were not persisted do not have the UID and resource version set
+	if c == nil || secret.UID == "" || secret.ResourceVersion == "" {
+		p := parseTLSSecret(secret)
+		return p.cert, p.err
+	}
+
+	key := certCacheKey{uid: secret.UID, resourceVersion: secret.ResourceVersion}
+	if v, ok := c.cache.Get(key); ok {
+		p := v.(*parsedTLSSecret)
+		return p.cert, p.err
+	}
+	p := parseTLSSecret(secret)
+	c.cache.Add(key, p)
+	return p.cert, p.err
+}
+
+func parseTLSSecret(secret *corev1.Secret) *parsedTLSSecret {
+	cert, key := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	if len(cert) == 0 {
+		return &parsedTLSSecret{err: fmt.Errorf("%s is missing", corev1.TLSCertKey)}
+	}
+	if len(key) == 0 {
+		return &parsedTLSSecret{err: fmt.Errorf("%s is missing", corev1.TLSPrivateKeyKey)}
+	}
+
+	block, _ := pem.Decode(cert)
+	if block == nil {
+		return &parsedTLSSecret{err: fmt.Errorf("%s does not contain a PEM encoded certificate", corev1.TLSCertKey)}
+	}
+	x509Cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return &parsedTLSSecret{err: fmt.Errorf("%s: %w", corev1.TLSCertKey, err)}
+	}
+	if _, err := tls.X509KeyPair(cert, key); err != nil {
+		return &parsedTLSSecret{cert: x509Cert, err: fmt.Errorf("%s and %s: %w", corev1.TLSCertKey, corev1.TLSPrivateKeyKey, err)}
+	}
+	return &parsedTLSSecret{cert: x509Cert}
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCertCacheRotation(t *testing.T) {
+	cache, err := newCertCache(10)
+	require.NoError(t, err)
+
+	secret := testTLSSecret(t, "wildcard")
+	secret.UID = types.UID("uid")
+	_, err = cache.parseTLSSecret(secret)
+	require.NoError(t, err)
+
+	// rotated to a mismatching key under the same name
+	secret = secret.DeepCopy()
+	secret.ResourceVersion = "2"
+	secret.Data[corev1.TLSPrivateKeyKey] = testTLSSecret(t, "wildcard").Data[corev1.TLSPrivateKeyKey]
+	_, err = cache.parseTLSSecret(secret)
+	assert.Error(t, err, "rotated secret should not be served from cache")
+
+	// rotated to a new valid cert
+	rotated := testTLSSecret(t, "wildcard")
+	secret = secret.DeepCopy()
+	secret.ResourceVersion = "3"
+	secret.Data = rotated.Data
+	cert, err := cache.parseTLSSecret(secret)
+	require.NoError(t, err)
+	expect := parseTLSSecret(rotated)
+	require.NoError(t, expect.err)
+	assert.Equal(t, expect.cert.Raw, cert.Raw, "should return the new certificate")
+
+	// same version is served from cache
+	assert.Equal(t, 3, cache.cache.Len())
+	cached, err := cache.parseTLSSecret(secret)
+	require.NoError(t, err)
+	assert.Same(t, cert, cached)
+
+	// disabled cache
+	cache, err = newCertCache(0)
+	require.NoError(t, err)
+	assert.Nil(t, cache)
+	_, err = cache.parseTLSSecret(secret)
+	assert.NoError(t, err)
+}
+
+func BenchmarkCertCache(b *testing.B) {
+	secret := testTLSSecret(b, "wildcard")
+	secret.UID = types.UID("uid")
+
+	for _, size := range []int{0, DefaultCertCacheSize} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			cache, err := newCertCache(size)
+			require.NoError(b, err)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := cache.parseTLSSecret(secret); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
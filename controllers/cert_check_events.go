@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// certCheckEvents keeps track of the ingresses that were already told their certificate material is not used,
+// so that an ingress only gets an event again once the certificates it references change
+type certCheckEvents struct {
+	sync.Mutex
+	// reported holds the certificate material last reported for each ingress
+	reported map[types.NamespacedName]string
+}
+
+// shouldReport returns true if the certificate material referenced by the ingress changed since it was last reported
+func (s *certCheckEvents) shouldReport(name types.NamespacedName, material string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if material == "" {
+		delete(s.reported, name)
+		return false
+	}
+	if s.reported[name] == material {
+		return false
+	}
+	if s.reported == nil {
+		s.reported = make(map[types.NamespacedName]string)
+	}
+	s.reported[name] = material
+	return true
+}
+
+// forget removes the ingress that is no longer managed
+func (s *certCheckEvents) forget(name types.NamespacedName) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.reported, name)
+}
+
+// reportDisabledCertCheck emits an informational event on the ingress that references downstream certificates,
+// while Pomerium runs with insecure_server and would neither serve nor validate them.
+// the upstream TLS annotations, i.e. tls_client_secret, are still in effect and are not reported
+func (r *ingressController) reportDisabledCertCheck(ctx context.Context, ic *model.IngressConfig) {
+	if !r.disableCertCheck || r.isStandby() {
+		return
+	}
+
+	material := strings.Join(r.downstreamCertMaterial(ic.Ingress), ", ")
+	if !r.certCheckEvents.shouldReport(ic.GetIngressNamespacedName(), material) {
+		return
+	}
+
+	msg := fmt.Sprintf("%s: forwarded to Pomerium, but not validated, as it runs with insecure_server", material)
+	if ic.SkipCertificates {
+		msg = fmt.Sprintf("%s: not forwarded to Pomerium, as it runs with insecure_server", material)
+	}
+	log.FromContext(ctx).Info("certificate check is disabled", "message", msg)
+	r.EventRecorder.Event(ic.Ingress, corev1.EventTypeNormal, reasonCertCheckDisabled, msg)
+}
+
+// downstreamCertMaterial lists the TLS secrets and annotations of the ingress that Pomerium would use to serve it
+func (r *ingressController) downstreamCertMaterial(ingress *networkingv1.Ingress) []string {
+	var material []string
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName != "" {
+			material = append(material, fmt.Sprintf("tls secret %s", tls.SecretName))
+		}
+	}
+	if name, ok := ingress.Annotations[fmt.Sprintf("%s/%s", r.annotationPrefix, model.TLSDownstreamClientCASecret)]; ok {
+		material = append(material, fmt.Sprintf("%s %s", model.TLSDownstreamClientCASecret, name))
+	}
+	return material
+}
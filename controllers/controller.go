@@ -1,25 +1,34 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
 	"github.com/pomerium/ingress-controller/model"
 )
 
-//go:generate go run github.com/golang/mock/mockgen -package controllers -destination client_mock.go sigs.k8s.io/controller-runtime/pkg/client Client
+//go:generate go run github.com/golang/mock/mockgen -package controllers -destination client_mock.go sigs.k8s.io/controller-runtime/pkg/client Client,StatusWriter
 
 const (
 	// IngressClassAnnotationKey although deprecated, still may be used by the HTTP solvers even for v1 Ingress resources
@@ -30,15 +39,32 @@ const (
 
 	reasonPomeriumConfigUpdated     = "Updated"
 	reasonPomeriumConfigUpdateError = "UpdateError"
-	msgPomeriumConfigUpdated        = "updated pomerium configuration"
+	// reasonPomeriumConfigPartialUpdate is used if only the valid routes of an ingress were applied
+	reasonPomeriumConfigPartialUpdate = "PartialUpdate"
+	msgPomeriumConfigUpdated          = "updated pomerium configuration"
+	// reasonFetchError is used if the ingress dependencies could not be obtained
+	reasonFetchError = "FetchError"
+	// reasonInvalidSecret is reported on the secret object referenced by ingresses
+	reasonInvalidSecret = "InvalidSecret"
+	// reasonCertCheckDisabled is reported on ingresses referencing certificates that Pomerium would not use
+	reasonCertCheckDisabled = "CertCheckDisabled"
 )
 
+// DefaultSecurityHeaders are the standard security response headers that may be set on all routes by default
+var DefaultSecurityHeaders = map[string]string{
+	"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+	"X-Content-Type-Options":    "nosniff",
+	"X-Frame-Options":           "SAMEORIGIN",
+}
+
 // ingressController watches ingress and related resources for updates and reconciles with pomerium
 type ingressController struct {
 	// controllerName to watch in the IngressClass.spec.controller
 	controllerName string
 	// annotationPrefix is a prefix (without /) for Ingress annotations
 	annotationPrefix string
+	// serviceAnnotationPrefix is a prefix (without /) for backend Service annotations, empty to ignore them
+	serviceAnnotationPrefix string
 
 	// Scheme keeps track between objects and their group/version/kinds
 	*runtime.Scheme
@@ -53,25 +79,141 @@ type ingressController struct {
 	// EventRecorder provides means to add events to Ingress objects, that are visible via kubectl describe
 	record.EventRecorder
 
+	// scopeMu guards namespaces and requiredLabels, that may be changed at runtime via optionsConfigMap
+	scopeMu sync.RWMutex
 	// Namespaces to listen to, nil/empty to listen to all
 	namespaces map[string]bool
+	// requiredLabels the ingresses must have in order to be managed, nil to manage regardless of labels
+	requiredLabels labels.Selector
+	// excludedNamespaces are the glob patterns of the namespaces whose ingresses are never managed,
+	// even if listed in namespaces. unlike those, they are not changed at runtime
+	excludedNamespaces []string
+	// namespaceSelector if set, is the label selector the namespaces of the managed ingresses must match,
+	// parsed from namespaceLabelSelector once the controller is built.
+	// namespaceSelection tracks the namespace label changes, so that their ingresses are reconciled
+	namespaceLabelSelector string
+	namespaceSelector      labels.Selector
+	namespaceSelection     namespaceSelection
+	// ingressLabelSelector the ingresses must match in order to be managed, in addition to requiredLabels,
+	// is parsed into labelSelector once the controller is built, and is not changed at runtime
+	ingressLabelSelector string
+	labelSelector        labels.Selector
+	// watchWithoutClass makes the ingresses that specify no ingress class managed,
+	// even if none of the ingress classes of the controller is marked as default
+	watchWithoutClass bool
+	// optionsConfigMap if set, holds the namespaces and required labels that override the options at runtime
+	optionsConfigMap *types.NamespacedName
+	// flagScope is the scope set by the options, that is restored once optionsConfigMap is deleted
+	flagScope scope
 
 	// updateStatusFromService defines a pomerium-proxy service name that should be watched for changes in the status field
 	// and all dependent ingresses should be updated accordingly
 	updateStatusFromService *types.NamespacedName
+	// allowedStatusServices are the proxy services ingresses may take their status from via status_from_service annotation,
+	// instead of updateStatusFromService
+	allowedStatusServices []types.NamespacedName
+	// statusUpdaterHealth tracks outcome of the ingress status updates
+	statusUpdaterHealth *StatusUpdaterHealth
 
 	// object Kinds are frequently used, do not change and are cached
-	endpointsKind    string
-	ingressKind      string
-	ingressClassKind string
-	secretKind       string
-	serviceKind      string
+	configMapKind     string
+	endpointsKind     string
+	endpointSliceKind string
+	ingressKind       string
+	ingressClassKind  string
+	namespaceKind     string
+	secretKind        string
+	serviceKind       string
+	// ingressParametersKind is only set along with ingressParameters
+	ingressParametersKind string
+
+	// endpointSlices if set, the service endpoints are resolved from discovery.k8s.io/v1 EndpointSlices,
+	// rather than the legacy Endpoints that are truncated for services with more than 1000 endpoints
+	endpointSlices bool
+	// ingressParameters is set if the PomeriumIngressParameters CRD is installed,
+	// and the IngressClass spec.parameters referring to it are applied
+	ingressParameters bool
+	// ingressV1beta1 is set if the cluster does not serve networking.k8s.io/v1 Ingress, and the v1beta1 is watched instead,
+	// the Client converts the objects to v1 the rest of the controller operates on
+	ingressV1beta1 bool
+	// serviceProxyUpstreams if set, the k8s service proxy is used as upstream, unless the ingress opts out,
+	// and the endpoints of the services are neither fetched nor watched
+	serviceProxyUpstreams bool
+	// allowWildcardHosts if set, the ingress rules with a wildcard host are translated, rather than rejected
+	allowWildcardHosts bool
+
+	// allowedListenerPorts are the non-default proxy listener ports ingresses may attach their routes to
+	allowedListenerPorts []int32
+	// defaultResponseHeaders are set on the responses of all routes, unless overridden or disabled per ingress
+	defaultResponseHeaders map[string]string
 
 	// disableCertCheck indicates that pomerium is deployed with insecure_server option
 	// no checks should be applied for the cert check
 	disableCertCheck bool
+	// skipCertificates if set along with disableCertCheck, the downstream certificate material is not forwarded
+	skipCertificates bool
+	// certCheckEvents deduplicates the events reported on ingresses referencing certificates under disableCertCheck
+	certCheckEvents certCheckEvents
 
 	initComplete *once
+
+	// syncStates tracks managed ingresses and their reconciliation state
+	syncStates *syncStates
+	// eventTimes tracks the ingress events not yet written to the databroker, for the sync latency metric
+	eventTimes *eventTimes
+
+	// certCacheSize is the number of parsed TLS secrets to keep in certCache, 0 to disable caching
+	certCacheSize int
+	certCache     *certCache
+
+	// syncStateWriterKind selects how the sync state is recorded on the ingress objects
+	syncStateWriterKind string
+	syncStateWriter     syncStateWriter
+
+	// hostConflictPolicy selects which of the ingresses in different namespaces claiming the same host and path
+	// is published, one of HostConflictPolicies
+	hostConflictPolicy string
+	hostConflicts      *hostConflicts
+
+	// secretEvents deduplicates the events reported on invalid secrets
+	secretEvents secretEvents
+	// ingressEvents limits the rate of the repeated warning events reported on ingresses
+	ingressEvents ingressEvents
+
+	// warmStandby if set, wraps the PomeriumReconciler and gates the ingress object updates until active
+	warmStandby *WarmStandby
+
+	// routeRenderer if set, makes the controller mirror the routes generated for each ingress into IngressRouteStatus
+	routeRenderer RouteRenderer
+
+	// routeTTLs tracks the expiration of the routes of the ingresses with route_ttl annotation
+	routeTTLs *routeTTLs
+
+	// backpressure if set, slows down the reconciles while the databroker is degraded
+	backpressure *Backpressure
+
+	// dependencyReconcileWindow is the number of the reconciles triggered by the dependency updates
+	// that may be waiting in the controller queue at a time, 0 to queue them along with the rest
+	dependencyReconcileWindow  int
+	dependencyReconcileMaxWait time.Duration
+	dependencyQueue            *dependencyQueue
+
+	// maxConcurrentReconciles is the number of the ingresses reconciled at a time
+	maxConcurrentReconciles int
+
+	// resyncPeriod if set, is how often all managed ingresses are reconciled again regardless of the updates
+	resyncPeriod time.Duration
+	// forgetApplied if set, makes the PomeriumReconciler write the unchanged ingress configs on resync
+	forgetApplied func()
+
+	// globalSettings if set, is the name of the Pomerium object whose global settings are applied
+	globalSettings string
+
+	// databrokerDebounce if set, is the window the pomerium config updates are coalesced within
+	databrokerDebounce time.Duration
+
+	// revision is the last assigned model.IngressConfig revision, must be accessed atomically
+	revision uint64
 }
 
 // Option customizes ingress controller
@@ -91,6 +233,14 @@ func WithAnnotationPrefix(prefix string) Option {
 	}
 }
 
+// WithServiceAnnotationPrefix makes ingress controller also apply annotations with given prefix
+// from the backend Service objects, with the Ingress annotations taking precedence
+func WithServiceAnnotationPrefix(prefix string) Option {
+	return func(ic *ingressController) {
+		ic.serviceAnnotationPrefix = prefix
+	}
+}
+
 // WithNamespaces requires ingress controller to only monitor specific namespaces
 func WithNamespaces(ns []string) Option {
 	return func(ic *ingressController) {
@@ -98,6 +248,60 @@ func WithNamespaces(ns []string) Option {
 	}
 }
 
+// WithExcludedNamespaces makes ingress controller ignore the namespaces matching any of the glob patterns,
+// i.e. kube-system or cattle-*, in addition to WithNamespaces
+func WithExcludedNamespaces(patterns []string) Option {
+	return func(ic *ingressController) {
+		ic.excludedNamespaces = patterns
+	}
+}
+
+// WithNamespaceSelector requires ingress controller to only manage the ingresses in the namespaces
+// matching the label selector, i.e. pomerium-enabled=true, in addition to WithNamespaces.
+// the namespace label changes are applied at runtime
+func WithNamespaceSelector(selector string) Option {
+	return func(ic *ingressController) {
+		ic.namespaceLabelSelector = selector
+	}
+}
+
+// WithRequiredLabels requires ingress controller to only manage the ingresses bearing all of the provided labels,
+// in addition to matching the ingress class. empty to manage ingresses regardless of their labels
+func WithRequiredLabels(l map[string]string) Option {
+	return func(ic *ingressController) {
+		if len(l) == 0 {
+			ic.requiredLabels = nil
+			return
+		}
+		ic.requiredLabels = labels.SelectorFromSet(l)
+	}
+}
+
+// WithIngressLabelSelector requires ingress controller to only manage the ingresses matching the label selector,
+// i.e. env=staging,tier!=canary, in addition to WithRequiredLabels. empty to manage ingresses regardless of their labels
+func WithIngressLabelSelector(selector string) Option {
+	return func(ic *ingressController) {
+		ic.ingressLabelSelector = selector
+	}
+}
+
+// WithWatchIngressWithoutClass makes ingress controller manage the ingresses that specify neither
+// spec.ingressClassName nor the deprecated annotation, in addition to the ones of the default ingress class.
+// the ingresses assigned to other ingress classes are not affected
+func WithWatchIngressWithoutClass() Option {
+	return func(ic *ingressController) {
+		ic.watchWithoutClass = true
+	}
+}
+
+// WithOptionsConfigMap makes ingress controller watch the config map, whose namespaces and required-labels keys
+// replace WithNamespaces and WithRequiredLabels at runtime, until the config map is deleted
+func WithOptionsConfigMap(name types.NamespacedName) Option {
+	return func(ic *ingressController) {
+		ic.optionsConfigMap = &name
+	}
+}
+
 // WithUpdateIngressStatusFromService configures ingress controller to watch a designated service (pomerium proxy)
 // for its load balancer status, and update all managed ingresses accordingly
 func WithUpdateIngressStatusFromService(name types.NamespacedName) Option {
@@ -106,6 +310,22 @@ func WithUpdateIngressStatusFromService(name types.NamespacedName) Option {
 	}
 }
 
+// WithAllowedStatusServices sets the proxy services the ingresses may take their load balancer status from
+// via status_from_service annotation, overriding WithUpdateIngressStatusFromService
+func WithAllowedStatusServices(names []types.NamespacedName) Option {
+	return func(ic *ingressController) {
+		ic.allowedStatusServices = names
+	}
+}
+
+// WithStatusUpdaterHealth makes ingress controller report the outcome of the ingress status updates
+// to the provided health tracker, that may outlive the controller
+func WithStatusUpdaterHealth(h *StatusUpdaterHealth) Option {
+	return func(ic *ingressController) {
+		ic.statusUpdaterHealth = h
+	}
+}
+
 // WithDisableCertCheck indicates that Pomerium this ingress controller is communicating with
 // is currently configured with insecure_server option
 // that would disable certificate checks
@@ -115,26 +335,193 @@ func WithDisableCertCheck() Option {
 	}
 }
 
+// WithSkipCertificates makes ingress controller not forward the ingress TLS certificates
+// and tls_downstream_client_ca_secret to Pomerium, only effective along with WithDisableCertCheck
+func WithSkipCertificates() Option {
+	return func(ic *ingressController) {
+		ic.skipCertificates = true
+	}
+}
+
+// WithServiceProxyUpstreams makes ingress controller use the k8s service proxy as upstream for all ingresses,
+// opposed to individual endpoints, unless an ingress sets the service_proxy_upstream annotation to false
+func WithServiceProxyUpstreams() Option {
+	return func(ic *ingressController) {
+		ic.serviceProxyUpstreams = true
+	}
+}
+
+// WithWildcardHosts makes ingress controller translate the ingress rules with a wildcard host,
+// that are rejected otherwise, as Pomerium v0.17.x does not authorize the requests to them
+func WithWildcardHosts() Option {
+	return func(ic *ingressController) {
+		ic.allowWildcardHosts = true
+	}
+}
+
+// WithAllowedListenerPorts sets the non-default proxy listener ports
+// the ingresses may attach their routes to via listener_port annotation
+func WithAllowedListenerPorts(ports []int32) Option {
+	return func(ic *ingressController) {
+		ic.allowedListenerPorts = ports
+	}
+}
+
+// WithDefaultResponseHeaders sets response headers on all routes, i.e. DefaultSecurityHeaders.
+// the headers set via ingress annotations take precedence, and ingresses may opt out entirely
+// via disable_default_headers annotation
+func WithDefaultResponseHeaders(headers map[string]string) Option {
+	return func(ic *ingressController) {
+		ic.defaultResponseHeaders = make(map[string]string, len(headers))
+		for k, v := range headers {
+			ic.defaultResponseHeaders[k] = v
+		}
+	}
+}
+
+// WithCertCacheSize sets the number of parsed TLS secrets kept in cache, 0 disables caching
+func WithCertCacheSize(size int) Option {
+	return func(ic *ingressController) {
+		ic.certCacheSize = size
+	}
+}
+
+// WithSyncStateWriter selects how the ingress sync state is recorded on the ingress objects,
+// one of SyncStateWriters
+func WithSyncStateWriter(kind string) Option {
+	return func(ic *ingressController) {
+		ic.syncStateWriterKind = kind
+	}
+}
+
+// WithHostConflictPolicy selects which of the ingresses in different namespaces claiming the same host and path
+// is published, one of HostConflictPolicies
+func WithHostConflictPolicy(policy string) Option {
+	return func(ic *ingressController) {
+		ic.hostConflictPolicy = policy
+	}
+}
+
+// WithWarmStandby makes ingress controller keep the ingress configs in the warm standby,
+// and only update pomerium configuration and the ingress objects once the standby is activated
+func WithWarmStandby(w *WarmStandby) Option {
+	return func(ic *ingressController) {
+		ic.warmStandby = w
+	}
+}
+
+// WithRouteStatusCRs makes ingress controller mirror the redacted routes generated for each managed ingress,
+// along with the last sync outcome, into an IngressRouteStatus object owned by the ingress
+func WithRouteStatusCRs(render RouteRenderer) Option {
+	return func(ic *ingressController) {
+		ic.routeRenderer = render
+	}
+}
+
+// WithBackpressure makes ingress controller postpone the reconciles while the databroker is degraded,
+// and ramp them up once it recovers
+func WithBackpressure(b *Backpressure) Option {
+	return func(ic *ingressController) {
+		ic.backpressure = b
+	}
+}
+
+// WithDependencyReconcileWindow limits the number of the reconciles triggered by the dependency updates,
+// that may be waiting in the controller queue at a time, so that the new ingresses and deletes are processed
+// ahead of them. the reconciles waiting longer than maxWait are queued regardless. 0 window disables the limit
+func WithDependencyReconcileWindow(window int, maxWait time.Duration) Option {
+	return func(ic *ingressController) {
+		ic.dependencyReconcileWindow = window
+		ic.dependencyReconcileMaxWait = maxWait
+	}
+}
+
+// WithLegacyEndpoints makes ingress controller resolve the service endpoints from the legacy Endpoints objects,
+// instead of the discovery.k8s.io/v1 EndpointSlices, that the clusters prior to kubernetes v1.21 do not serve
+func WithLegacyEndpoints() Option {
+	return func(ic *ingressController) {
+		ic.endpointSlices = false
+	}
+}
+
+// WithMaxConcurrentReconciles sets the number of the ingresses reconciled at a time,
+// that requires the PomeriumReconciler to be safe for concurrent use if above 1
+func WithMaxConcurrentReconciles(n int) Option {
+	return func(ic *ingressController) {
+		ic.maxConcurrentReconciles = n
+	}
+}
+
+// WithResyncPeriod makes ingress controller reconcile all managed ingresses every period,
+// so that the pomerium configuration that drifted from them is restored. 0 disables the periodic resync
+func WithResyncPeriod(period time.Duration) Option {
+	return func(ic *ingressController) {
+		ic.resyncPeriod = period
+	}
+}
+
+// WithGlobalSettings makes ingress controller apply the global settings of the named cluster-scoped Pomerium object,
+// re-applying them once any of the secrets it references change. the PomeriumReconciler should implement SettingsReconciler
+func WithGlobalSettings(name string) Option {
+	return func(ic *ingressController) {
+		ic.globalSettings = name
+	}
+}
+
+// WithDatabrokerDebounce makes ingress controller coalesce the ingress updates made within the window
+// into a single pomerium config update. as each reconcile waits for its update to be applied,
+// only the ingresses reconciled concurrently are coalesced. the PomeriumReconciler should implement BatchReconciler
+func WithDatabrokerDebounce(window time.Duration) Option {
+	return func(ic *ingressController) {
+		ic.databrokerDebounce = window
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager
 func (r *ingressController) SetupWithManager(mgr ctrl.Manager) error {
+	var ingress, ingressClass client.Object = &networkingv1.Ingress{}, &networkingv1.IngressClass{}
+	ingressClassFn := r.watchIngressClass
+	if r.ingressV1beta1 {
+		ingress, ingressClass = &networkingv1beta1.Ingress{}, &networkingv1beta1.IngressClass{}
+		ingressClassFn = r.watchIngressClassV1beta1
+	}
 	c, err := ctrl.NewControllerManagedBy(mgr).
-		For(&networkingv1.Ingress{}).
+		For(ingress, builder.WithPredicates(ignoreControllerAnnotationUpdates(r.annotationPrefix), r.eventTimes.predicate())).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.maxConcurrentReconciles}).
 		Build(r)
 	if err != nil {
 		return err
 	}
 
 	r.Scheme = mgr.GetScheme()
+	if r.endpointSlices {
+		if r.endpointSlices, err = hasEndpointSlices(mgr.GetRESTMapper()); err != nil {
+			return err
+		}
+		if !r.endpointSlices {
+			log.FromContext(context.Background()).Info("discovery.k8s.io/v1 EndpointSlices are not served, using legacy Endpoints")
+		}
+	}
+	endpointsFn, endpointSlicesFn := r.getDependantIngressFn, r.watchEndpointSlice
+	if r.endpointSlices {
+		endpointsFn = nil
+	} else {
+		endpointSlicesFn = nil
+	}
+
 	for _, o := range []struct {
 		client.Object
 		kind  *string
 		mapFn func(string) func(client.Object) []reconcile.Request
 	}{
-		{&networkingv1.Ingress{}, &r.ingressKind, nil},
-		{&networkingv1.IngressClass{}, &r.ingressClassKind, r.watchIngressClass},
+		{ingress, &r.ingressKind, nil},
+		{ingressClass, &r.ingressClassKind, ingressClassFn},
+		{&corev1.Namespace{}, &r.namespaceKind, r.watchNamespace},
 		{&corev1.Secret{}, &r.secretKind, r.getDependantIngressFn},
+		{&corev1.ConfigMap{}, &r.configMapKind, r.getDependantIngressFn},
 		{&corev1.Service{}, &r.serviceKind, r.getDependantIngressFn},
-		{&corev1.Endpoints{}, &r.endpointsKind, r.getDependantIngressFn},
+		{&corev1.Endpoints{}, &r.endpointsKind, endpointsFn},
+		{&discoveryv1.EndpointSlice{}, &r.endpointSliceKind, endpointSlicesFn},
 	} {
 		gvk, err := apiutil.GVKForObject(o.Object, r.Scheme)
 		if err != nil {
@@ -148,23 +535,95 @@ func (r *ingressController) SetupWithManager(mgr ctrl.Manager) error {
 
 		if err := c.Watch(
 			&source.Kind{Type: o.Object},
-			handler.EnqueueRequestsFromMapFunc(o.mapFn(gvk.Kind))); err != nil {
+			handler.EnqueueRequestsFromMapFunc(r.eventTimes.mapFunc(o.mapFn(gvk.Kind)))); err != nil {
 			return fmt.Errorf("watching %s: %w", gvk.String(), err)
 		}
 	}
 
+	// ingresses of the classes whose parameters have changed
+	if r.ingressParameters, err = hasIngressParameters(r.Scheme, mgr.GetRESTMapper()); err != nil {
+		return err
+	}
+	if r.ingressParameters {
+		r.ingressParametersKind = ingressParametersKind
+		if err := c.Watch(
+			&source.Kind{Type: &v1alpha1.PomeriumIngressParameters{}},
+			handler.EnqueueRequestsFromMapFunc(r.eventTimes.mapFunc(r.watchIngressParameters(r.ingressParametersKind)))); err != nil {
+			return fmt.Errorf("watching ingress parameters: %w", err)
+		}
+	} else {
+		log.FromContext(context.Background()).Info(ingressParametersKind + " CRD is not installed, the IngressClass parameters are ignored")
+	}
+
+	// ingresses that entered or left the scope, as the options config map has changed
+	if r.optionsConfigMap != nil {
+		if err := c.Watch(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.eventTimes.mapFunc(r.watchOptionsConfigMap))); err != nil {
+			return fmt.Errorf("watching options config map: %w", err)
+		}
+	}
+
+	// ingresses whose host conflicts have changed due to another ingress update
+	if err := c.Watch(
+		&source.Channel{Source: r.hostConflicts.requeue},
+		&handler.EnqueueRequestForObject{}); err != nil {
+		return fmt.Errorf("watching host conflicts: %w", err)
+	}
+
+	if r.resyncPeriod > 0 {
+		resync := newPeriodicResync(r.resyncPeriod, r.listResyncIngresses)
+		if err := c.Watch(
+			&source.Channel{Source: resync.out},
+			&handler.EnqueueRequestForObject{}); err != nil {
+			return fmt.Errorf("watching periodic resync: %w", err)
+		}
+		if err := mgr.Add(resync); err != nil {
+			return fmt.Errorf("adding periodic resync: %w", err)
+		}
+	}
+
+	if r.dependencyQueue == nil {
+		return nil
+	}
+	// ingresses released from the lower priority dependency updates queue
+	if err := c.Watch(
+		&source.Channel{Source: r.dependencyQueue.out},
+		&handler.EnqueueRequestForObject{}); err != nil {
+		return fmt.Errorf("watching dependency updates: %w", err)
+	}
+	if err := mgr.Add(r.dependencyQueue); err != nil {
+		return fmt.Errorf("adding dependency updates queue: %w", err)
+	}
+
 	return nil
 }
 
 func (r *ingressController) isWatching(obj client.Object) bool {
-	if len(r.namespaces) == 0 {
+	if r.isWatchingNamespace(obj.GetNamespace()) {
 		return true
 	}
 
-	if (r.updateStatusFromService != nil) &&
-		(*r.updateStatusFromService == types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}) {
+	name := types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}
+	if r.updateStatusFromService != nil && *r.updateStatusFromService == name {
 		return true
 	}
+	for _, allowed := range r.allowedStatusServices {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isWatchingNamespace checks whether the namespace is within the set of namespaces this controller manages
+func (r *ingressController) isWatchingNamespace(name string) bool {
+	if isExcludedNamespace(r.excludedNamespaces, name) {
+		return false
+	}
+
+	r.scopeMu.RLock()
+	defer r.scopeMu.RUnlock()
 
-	return r.namespaces[obj.GetNamespace()]
+	return len(r.namespaces) == 0 || r.namespaces[name]
 }
@@ -2,34 +2,37 @@ package controllers_test
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"reflect"
-	"sync"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-logr/zapr"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap/zaptest"
+	"google.golang.org/protobuf/proto"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
-	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
 	"github.com/pomerium/ingress-controller/controllers"
+	"github.com/pomerium/ingress-controller/internal/faults"
 	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/pomerium"
+	"github.com/pomerium/ingress-controller/pomeriumtest"
+	"github.com/pomerium/ingress-controller/translate"
 )
 
 var (
@@ -46,93 +49,27 @@ var (
 
 type ControllerTestSuite struct {
 	suite.Suite
-	client.Client
-	*envtest.Environment
+	*pomeriumtest.Harness
 
 	// created per test
-	mgrCtxCancel context.CancelFunc
-	mgrDone      chan error
-	*mockPomeriumReconciler
+	*pomeriumtest.Controller
 
 	controllerName string
 }
 
-type mockPomeriumReconciler struct {
-	sync.RWMutex
-	lastUpsert *model.IngressConfig
-	lastDelete *types.NamespacedName
-}
-
-func (m *mockPomeriumReconciler) Upsert(ctx context.Context, ic *model.IngressConfig) (bool, error) {
-	m.Lock()
-	defer m.Unlock()
-
-	m.lastUpsert = ic.Clone()
-	m.lastDelete = nil
-	return true, nil
-}
-
-func (m *mockPomeriumReconciler) Delete(ctx context.Context, name types.NamespacedName) error {
-	m.Lock()
-	defer m.Unlock()
-
-	m.lastDelete = &name
-	m.lastUpsert = nil
-	return nil
-}
-
-func (m *mockPomeriumReconciler) Set(ctx context.Context, ics []*model.IngressConfig) (bool, error) {
-	if len(ics) != 0 {
-		return false, errors.New("unexpected ingresses")
-	}
-	return false, nil
-}
-
 func (s *ControllerTestSuite) EventuallyDeleted(name types.NamespacedName) {
 	s.T().Helper()
-	require.Eventually(s.T(), func() bool {
-		s.mockPomeriumReconciler.Lock()
-		defer s.mockPomeriumReconciler.Unlock()
-
-		if s.mockPomeriumReconciler.lastDelete == nil {
-			return false
-		}
-		val := *s.mockPomeriumReconciler.lastDelete == name
-		s.mockPomeriumReconciler.lastDelete = nil
-		return val
-	}, time.Second, time.Millisecond*50, "lastDeleted != %s", name)
-}
-
-func (s *ControllerTestSuite) diffFn(diffFn func(current *model.IngressConfig) string, diff *string) func() bool {
-	return func() bool {
-		s.mockPomeriumReconciler.RLock()
-		defer s.mockPomeriumReconciler.RUnlock()
-
-		if s.lastUpsert == nil {
-			*diff = "lastUpsert == nil"
-			return false
-		}
-		if s.lastDelete != nil {
-			*diff = fmt.Sprintf("lastDelete = %s", *s.lastDelete)
-		}
-		*diff = diffFn(s.lastUpsert)
-		return *diff == ""
-	}
+	s.Controller.EventuallyDeleted(s.T(), name)
 }
 
 func (s *ControllerTestSuite) EventuallyUpsert(diffFn func(current *model.IngressConfig) string, msg string) {
 	s.T().Helper()
-	var diff string
-
-	if !assert.Eventually(s.T(), s.diffFn(diffFn, &diff), time.Second*30, time.Millisecond*50) {
-		s.T().Fatalf("condition %q never satisfied: %s", msg, diff)
-	}
+	s.Controller.EventuallyUpsert(s.T(), diffFn, msg)
 }
 
 func (s *ControllerTestSuite) NeverEqual(diffFn func(current *model.IngressConfig) string) {
 	s.T().Helper()
-	var diff string
-	require.Never(s.T(), s.diffFn(diffFn, &diff), time.Second, time.Millisecond*50)
+	s.Controller.NeverUpsert(s.T(), diffFn)
 }
 
 func (s *ControllerTestSuite) NoError(err error, msgAndArgs ...interface{}) {
@@ -143,23 +80,10 @@ func (s *ControllerTestSuite) NoError(err error, msgAndArgs ...interface{}) {
 func (s *ControllerTestSuite) SetupSuite() {
 	s.controllerName = controllers.DefaultClassControllerName
 
-	scheme := runtime.NewScheme()
-	s.NoError(clientgoscheme.AddToScheme(scheme))
-
-	useExistingCluster := false
-	s.Environment = &envtest.Environment{
-		Scheme:             scheme,
-		UseExistingCluster: &useExistingCluster,
-	}
-	cfg, err := s.Environment.Start()
-	s.NoError(err)
-	require.NotNil(s.T(), cfg)
-	s.T().Logf("API Host: %s", cfg.Host)
-
-	k8sClient, err := client.New(cfg, client.Options{Scheme: scheme})
+	h, err := pomeriumtest.StartHarness()
 	s.NoError(err)
-	require.NotNil(s.T(), k8sClient)
-	s.Client = k8sClient
+	s.T().Logf("API Host: %s", h.Environment.Config.Host)
+	s.Harness = h
 }
 
 func (s *ControllerTestSuite) SetupTest() {
@@ -193,120 +117,38 @@ func (s *ControllerTestSuite) deleteAll() {
 	for i := range secrets.Items {
 		s.NoError(s.Client.Delete(ctx, &secrets.Items[i]))
 	}
+
+	// there are no controllers in the test environment that would remove the endpoints along with the services
+	endpoints := new(corev1.EndpointsList)
+	s.NoError(s.Client.List(ctx, endpoints))
+	for i := range endpoints.Items {
+		s.NoError(s.Client.Delete(ctx, &endpoints.Items[i]))
+	}
+
+	slices := new(discoveryv1.EndpointSliceList)
+	s.NoError(s.Client.List(ctx, slices))
+	for i := range slices.Items {
+		s.NoError(s.Client.Delete(ctx, &slices.Items[i]))
+	}
 }
 
 func (s *ControllerTestSuite) TearDownTest() {
-	s.mgrCtxCancel()
-	<-s.mgrDone
+	s.NoError(s.Controller.Stop())
 	s.deleteAll()
 }
 
 func (s *ControllerTestSuite) TearDownSuite() {
-	s.NoError(s.Environment.Stop())
+	s.NoError(s.Harness.Stop())
 }
 
 func (s *ControllerTestSuite) createTestController(ctx context.Context, opts ...controllers.Option) {
-	s.mockPomeriumReconciler = &mockPomeriumReconciler{}
-	mgr, err := controllers.NewIngressController(s.Environment.Config,
-		ctrl.Options{
-			Scheme: s.Environment.Scheme,
-		},
-		s.mockPomeriumReconciler,
-		opts...)
+	c, err := s.Harness.StartController(opts...)
 	s.NoError(err)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	s.mgrCtxCancel = cancel
-	s.mgrDone = make(chan error)
-
-	go func() {
-		s.mgrDone <- mgr.Start(ctx)
-	}()
+	s.Controller = c
 }
 
-type testObjs struct {
-	*networkingv1.IngressClass
-	*networkingv1.Ingress
-	*corev1.Endpoints
-	*corev1.Service
-	*corev1.Secret
-}
-
-func (s *ControllerTestSuite) initialTestObjects(namespace string) *testObjs {
-	typePrefix := networkingv1.PathTypePrefix
-	icsName := "pomerium"
-	return &testObjs{
-		&networkingv1.IngressClass{
-			ObjectMeta: metav1.ObjectMeta{Name: icsName, Namespace: namespace},
-			Spec: networkingv1.IngressClassSpec{
-				Controller: s.controllerName,
-			},
-		},
-		&networkingv1.Ingress{
-			ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: namespace},
-			Spec: networkingv1.IngressSpec{
-				IngressClassName: &icsName,
-				TLS: []networkingv1.IngressTLS{{
-					Hosts:      []string{"service.localhost.pomerium.io"},
-					SecretName: "secret",
-				}},
-				Rules: []networkingv1.IngressRule{{
-					Host: "service.localhost.pomerium.io",
-					IngressRuleValue: networkingv1.IngressRuleValue{
-						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{{
-								Path:     "/",
-								PathType: &typePrefix,
-								Backend: networkingv1.IngressBackend{
-									Service: &networkingv1.IngressServiceBackend{
-										Name: "service",
-										Port: networkingv1.ServiceBackendPort{
-											Name: "http",
-										},
-									},
-								},
-							}},
-						},
-					},
-				}},
-			},
-		},
-		&corev1.Endpoints{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "service",
-				Namespace: namespace,
-			},
-			Subsets: []corev1.EndpointSubset{{
-				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
-			}},
-		},
-		&corev1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "service",
-				Namespace: namespace,
-			},
-			Spec: corev1.ServiceSpec{
-				Ports: []corev1.ServicePort{{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.IntOrString{IntVal: 80},
-				}},
-			},
-			Status: corev1.ServiceStatus{},
-		},
-		&corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "secret",
-				Namespace: namespace,
-			},
-			Data: map[string][]byte{
-				corev1.TLSPrivateKeyKey: []byte("A"),
-				corev1.TLSCertKey:       []byte("A"),
-			},
-			Type: corev1.SecretTypeTLS,
-		},
-	}
+func (s *ControllerTestSuite) initialTestObjects(namespace string) *pomeriumtest.Objects {
+	return pomeriumtest.InitialObjects(namespace, s.controllerName)
 }
 
 func (s *ControllerTestSuite) TestIngressClass() {
@@ -390,6 +232,86 @@ func (s *ControllerTestSuite) TestDefaultCert() {
 	}, "set default cert")
 }
 
+// TestWildcardHostDefaultCert checks the ingress with a wildcard host is handled the same way,
+// and is picked up once the ingress class provides a default certificate
+func (s *ControllerTestSuite) TestWildcardHostDefaultCert() {
+	ctx := context.Background()
+	s.createTestController(ctx)
+
+	to := s.initialTestObjects("default")
+	to.Ingress.Spec.Rules[0].Host = "*.apps.example.com"
+	to.Ingress.Spec.TLS[0].Hosts = []string{"*.apps.example.com"}
+	to.Ingress.Spec.TLS[0].SecretName = ""
+	s.NoError(s.Client.Create(ctx, to.Secret))
+	s.NoError(s.Client.Create(ctx, to.Ingress))
+	s.NoError(s.Client.Create(ctx, to.Endpoints))
+	s.NoError(s.Client.Create(ctx, to.Service))
+	s.NoError(s.Client.Create(ctx, to.IngressClass))
+	s.NeverEqual(func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...)
+	})
+
+	to.IngressClass.Annotations = map[string]string{
+		fmt.Sprintf("%s/%s", controllers.DefaultAnnotationPrefix, controllers.DefaultCertSecretKey): fmt.Sprintf("%s/%s", to.Secret.Namespace, to.Secret.Name),
+	}
+	s.NoError(s.Client.Update(ctx, to.IngressClass))
+	secretName := types.NamespacedName{Name: to.Secret.Name, Namespace: to.Secret.Namespace}
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...) +
+			cmp.Diff(to.Secret, ic.Secrets[secretName], cmpOpts...)
+	}, "set default cert for the wildcard host")
+}
+
+// TestDefaultCertRotation checks the ingresses relying on the default certificate are reconciled
+// once the secret is updated, i.e. renewed, or the ingress class refers to another one,
+// while the secret is kept outside of the watched namespaces
+func (s *ControllerTestSuite) TestDefaultCertRotation() {
+	ctx := context.Background()
+	s.createTestController(ctx, controllers.WithNamespaces([]string{"default"}))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default-cert"}}
+	s.NoError(s.Client.Create(ctx, ns))
+	to := s.initialTestObjects("default")
+	to.Ingress.Spec.TLS[0].SecretName = ""
+	to.Secret.Namespace = ns.Name
+	other := to.Secret.DeepCopy()
+	other.Name = "other-cert"
+	to.IngressClass.Annotations = map[string]string{
+		fmt.Sprintf("%s/%s", controllers.DefaultAnnotationPrefix, controllers.DefaultCertSecretKey): fmt.Sprintf("%s/%s", to.Secret.Namespace, to.Secret.Name),
+	}
+	for _, obj := range []client.Object{to.Secret, other, to.IngressClass, to.Ingress, to.Endpoints, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	secretName := types.NamespacedName{Name: to.Secret.Name, Namespace: to.Secret.Namespace}
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...) +
+			cmp.Diff(to.Secret, ic.Secrets[secretName], cmpOpts...)
+	}, "initial default cert")
+
+	to.Secret.Data[corev1.TLSCertKey] = []byte("renewed")
+	s.NoError(s.Client.Update(ctx, to.Secret))
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Secret, ic.Secrets[secretName], cmpOpts...)
+	}, "renewed default cert")
+
+	to.IngressClass.Annotations[fmt.Sprintf("%s/%s", controllers.DefaultAnnotationPrefix, controllers.DefaultCertSecretKey)] =
+		fmt.Sprintf("%s/%s", other.Namespace, other.Name)
+	s.NoError(s.Client.Update(ctx, to.IngressClass))
+	otherName := types.NamespacedName{Name: other.Name, Namespace: other.Namespace}
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		if _, ok := ic.Secrets[secretName]; ok {
+			return "previous default cert is still referenced"
+		}
+		return cmp.Diff(other, ic.Secrets[otherName], cmpOpts...)
+	}, "default cert changed")
+
+	other.Data[corev1.TLSCertKey] = []byte("renewed")
+	s.NoError(s.Client.Update(ctx, other))
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(other, ic.Secrets[otherName], cmpOpts...)
+	}, "renewed the other default cert")
+}
+
 func (s *ControllerTestSuite) TestSkipCertCheck() {
 	ctx := context.Background()
 	s.createTestController(ctx, controllers.WithDisableCertCheck())
@@ -448,6 +370,147 @@ func (s *ControllerTestSuite) TestDependencies() {
 	}, "updated secret")
 }
 
+// TestMissingSecretEvents checks the ingress gets a warning event naming the missing secret,
+// and an update event once the secret is created
+func (s *ControllerTestSuite) TestMissingSecretEvents() {
+	ctx := context.Background()
+	s.createTestController(ctx)
+
+	to := s.initialTestObjects("default")
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	hasEvent := func(reason, msg string) func() bool {
+		return func() bool {
+			events := new(corev1.EventList)
+			if err := s.Client.List(ctx, events, client.InNamespace(to.Ingress.Namespace)); err != nil {
+				return false
+			}
+			for _, evt := range events.Items {
+				if evt.InvolvedObject.Kind == "Ingress" && evt.InvolvedObject.Name == to.Ingress.Name &&
+					evt.Reason == reason && strings.Contains(evt.Message, msg) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	s.Eventually(hasEvent("FetchError", fmt.Sprintf("get secret %s/%s", to.Secret.Namespace, to.Secret.Name)),
+		time.Second*30, time.Millisecond*100, "missing secret")
+
+	s.NoError(s.Client.Create(ctx, to.Secret))
+	s.Eventually(hasEvent("Updated", "routes"), time.Second*30, time.Millisecond*100, "updated once the secret exists")
+}
+
+// TestIngressClassParameters checks the PomeriumIngressParameters the IngressClass refers to are applied
+// to its ingresses, and their changes are reconciled, while an unsupported reference is reported on the class
+func (s *ControllerTestSuite) TestIngressClassParameters() {
+	ctx := context.Background()
+	s.createTestController(ctx)
+
+	params := &v1alpha1.PomeriumIngressParameters{
+		ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "default"},
+		Spec: v1alpha1.PomeriumIngressParametersSpec{
+			Timeout:             &metav1.Duration{Duration: time.Second * 30},
+			PassIdentityHeaders: proto.Bool(true),
+		},
+	}
+	s.NoError(s.Client.Create(ctx, params))
+
+	to := s.initialTestObjects("default")
+	to.IngressClass.Spec.Parameters = &networkingv1.IngressClassParametersReference{
+		APIGroup:  proto.String(v1alpha1.GroupVersion.Group),
+		Kind:      "PomeriumIngressParameters",
+		Name:      params.Name,
+		Scope:     proto.String(networkingv1.IngressClassParametersReferenceScopeNamespace),
+		Namespace: proto.String(params.Namespace),
+	}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service, to.Secret} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(map[string]string{"timeout": "30s", "pass_identity_headers": "true"}, ic.ClassDefaults)
+	}, "parameters applied")
+
+	params.Spec.Timeout.Duration = time.Minute
+	s.NoError(s.Client.Update(ctx, params))
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(map[string]string{"timeout": "60s", "pass_identity_headers": "true"}, ic.ClassDefaults)
+	}, "parameters updated")
+
+	to.IngressClass.Spec.Parameters.APIGroup = proto.String("example.com")
+	to.IngressClass.Spec.Parameters.Kind = "Parameters"
+	s.NoError(s.Client.Update(ctx, to.IngressClass))
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(map[string]string(nil), ic.ClassDefaults)
+	}, "unsupported parameters ignored")
+	s.Eventually(func() bool {
+		events := new(corev1.EventList)
+		if err := s.Client.List(ctx, events); err != nil {
+			return false
+		}
+		for _, evt := range events.Items {
+			if evt.InvolvedObject.Kind == "IngressClass" && evt.InvolvedObject.Name == to.IngressClass.Name &&
+				evt.Reason == "UnsupportedParameters" {
+				return true
+			}
+		}
+		return false
+	}, time.Second*30, time.Millisecond*100, "unsupported parameters reported")
+}
+
+// TestGlobalSettings checks the Pomerium object settings are applied along with the secrets it references,
+// and are re-applied once the identity provider secret is rotated
+func (s *ControllerTestSuite) TestGlobalSettings() {
+	ctx := context.Background()
+	s.createTestController(ctx, controllers.WithGlobalSettings("global"))
+
+	obj := &v1alpha1.Pomerium{
+		ObjectMeta: metav1.ObjectMeta{Name: "global"},
+		Spec: v1alpha1.PomeriumSpec{
+			AuthenticateURL: "https://authenticate.localhost.pomerium.io",
+			IdentityProvider: &v1alpha1.IdentityProvider{
+				Provider: "oidc",
+				URL:      "https://idp.localhost.pomerium.io",
+				Secret:   "default/idp",
+			},
+		},
+	}
+	s.NoError(s.Client.Create(ctx, obj))
+	s.Eventually(func() bool {
+		cur := new(v1alpha1.Pomerium)
+		return s.Client.Get(ctx, types.NamespacedName{Name: obj.Name}, cur) == nil &&
+			strings.Contains(cur.Status.LastError, "default/idp")
+	}, time.Second*30, time.Millisecond*100, "missing secret reported")
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "idp", Namespace: "default"},
+		Data:       map[string][]byte{"client_id": []byte("id"), "client_secret": []byte("secret-1")},
+	}
+	s.NoError(s.Client.Create(ctx, secret))
+	clientSecret := func() string {
+		gs := s.Controller.LastSettings()
+		if gs == nil || gs.IdentityProviderSecret == nil {
+			return ""
+		}
+		return string(gs.IdentityProviderSecret.Data["client_secret"])
+	}
+	s.Eventually(func() bool { return clientSecret() == "secret-1" }, time.Second*30, time.Millisecond*100, "settings applied")
+	s.Eventually(func() bool {
+		cur := new(v1alpha1.Pomerium)
+		return s.Client.Get(ctx, types.NamespacedName{Name: obj.Name}, cur) == nil &&
+			cur.Status.ObservedGeneration == cur.Generation && cur.Status.LastError == ""
+	}, time.Second*30, time.Millisecond*100, "observed generation reported")
+
+	secret.Data["client_secret"] = []byte("secret-2")
+	s.NoError(s.Client.Update(ctx, secret))
+	s.Eventually(func() bool { return clientSecret() == "secret-2" }, time.Second*30, time.Millisecond*100, "secret rotated")
+
+	s.NoError(s.Client.Delete(ctx, obj))
+	s.Eventually(func() bool { return s.Controller.LastSettings() == nil }, time.Second*30, time.Millisecond*100, "settings removed")
+}
+
 func (s *ControllerTestSuite) TestAnnotationDependencies() {
 	ctx := context.Background()
 	s.createTestController(ctx)
@@ -505,6 +568,29 @@ func (s *ControllerTestSuite) TestAnnotationDependencies() {
 	}, "secret, service, ingress up to date")
 }
 
+// TestAnnotationUpdate checks that a change to only the ingress annotations is applied
+func (s *ControllerTestSuite) TestAnnotationUpdate() {
+	ctx := context.Background()
+	s.createTestController(ctx)
+
+	to := s.initialTestObjects("default")
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service, to.Secret} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...)
+	}, "ingress up to date")
+
+	ingress := to.Ingress
+	ingress.Annotations = map[string]string{
+		fmt.Sprintf("%s/allowed_users", controllers.DefaultAnnotationPrefix): "alice@example.com, bob@example.com",
+	}
+	s.NoError(s.Client.Update(ctx, ingress))
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(ingress.Annotations, ic.Ingress.Annotations)
+	}, "annotation update applied")
+}
+
 // TestNamespaces checks that controller would only
 func (s *ControllerTestSuite) TestNamespaces() {
 	namespaces := map[string]bool{"a": true, "b": false, "c": true, "d": false}
@@ -539,6 +625,145 @@ func (s *ControllerTestSuite) TestNamespaces() {
 	}
 }
 
+// TestExcludedNamespaces checks the ingresses in the excluded namespaces are never upserted,
+// and the ingress recreated in an allowed namespace is
+func (s *ControllerTestSuite) TestExcludedNamespaces() {
+	ctx := context.Background()
+	s.createTestController(ctx, controllers.WithExcludedNamespaces([]string{"excluded", "cattle-*"}))
+
+	s.NoError(s.Client.Create(ctx, s.initialTestObjects("").IngressClass))
+	for _, ns := range []string{"excluded", "cattle-system", "allowed"} {
+		s.NoError(s.Client.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}))
+	}
+
+	for _, ns := range []string{"excluded", "cattle-system"} {
+		to := s.initialTestObjects(ns)
+		for _, obj := range []client.Object{to.Ingress, to.Endpoints, to.Service, to.Secret} {
+			s.NoError(s.Client.Create(ctx, obj))
+		}
+		s.NeverEqual(func(ic *model.IngressConfig) string {
+			return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...)
+		})
+		if ns == "excluded" {
+			s.NoError(s.Client.Delete(ctx, to.Ingress))
+		}
+	}
+
+	// move the ingress from the excluded namespace
+	to := s.initialTestObjects("allowed")
+	for _, obj := range []client.Object{to.Ingress, to.Endpoints, to.Service, to.Secret} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...)
+	}, "ingress in the allowed namespace")
+}
+
+// TestNamespaceSelector checks the ingresses are managed once their namespace labels match the selector,
+// and deleted once they no longer do
+func (s *ControllerTestSuite) TestNamespaceSelector() {
+	ctx := context.Background()
+	s.createTestController(ctx, controllers.WithNamespaceSelector("pomerium-enabled=true"))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+	s.NoError(s.Client.Create(ctx, ns))
+	to := s.initialTestObjects(ns.Name)
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service, to.Secret} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	diffFn := func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...)
+	}
+	s.NeverEqual(diffFn)
+
+	ns.Labels = map[string]string{"pomerium-enabled": "true"}
+	s.NoError(s.Client.Update(ctx, ns))
+	s.EventuallyUpsert(diffFn, "namespace labeled")
+
+	ns.Labels = nil
+	s.NoError(s.Client.Update(ctx, ns))
+	s.EventuallyDeleted(types.NamespacedName{Name: to.Ingress.Name, Namespace: ns.Name})
+}
+
+// TestIngressLabelSelector checks only the ingresses matching the label selector are managed,
+// and the ingress is deleted once its labels no longer match
+func (s *ControllerTestSuite) TestIngressLabelSelector() {
+	ctx := context.Background()
+	s.createTestController(ctx, controllers.WithIngressLabelSelector("env in (staging,qa)"))
+
+	to := s.initialTestObjects("default")
+	ingress := to.Ingress
+	for _, obj := range []client.Object{to.IngressClass, ingress, to.Endpoints, to.Service, to.Secret} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	diffFn := func(ic *model.IngressConfig) string {
+		return cmp.Diff(ingress, ic.Ingress, cmpOpts...)
+	}
+	s.NeverEqual(diffFn)
+
+	// add the matching label
+	ingress.Labels = map[string]string{"env": "staging"}
+	s.NoError(s.Client.Update(ctx, ingress))
+	s.EventuallyUpsert(diffFn, "ingress labeled")
+
+	// change the label so that it no longer matches
+	ingress.Labels["env"] = "prod"
+	s.NoError(s.Client.Update(ctx, ingress))
+	s.EventuallyDeleted(types.NamespacedName{Name: ingress.Name, Namespace: ingress.Namespace})
+
+	// match again, then remove the label
+	ingress.Labels["env"] = "qa"
+	s.NoError(s.Client.Update(ctx, ingress))
+	s.EventuallyUpsert(diffFn, "ingress relabeled")
+
+	ingress.Labels = nil
+	s.NoError(s.Client.Update(ctx, ingress))
+	s.EventuallyDeleted(types.NamespacedName{Name: ingress.Name, Namespace: ingress.Namespace})
+}
+
+func (s *ControllerTestSuite) TestNamespaceDeletion() {
+	ctx := context.Background()
+	s.createTestController(ctx)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "deleted"}}
+	s.NoError(s.Client.Create(ctx, ns))
+	to := s.initialTestObjects(ns.Name)
+	for _, obj := range []client.Object{to.IngressClass, to.Endpoints, to.Service, to.Secret} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	var names []types.NamespacedName
+	for _, name := range []string{"a", "b", "c"} {
+		ingress := to.Ingress.DeepCopy()
+		ingress.Name = name
+		s.NoError(s.Client.Create(ctx, ingress))
+		names = append(names, types.NamespacedName{Name: name, Namespace: ns.Name})
+	}
+	require.Eventually(s.T(), func() bool {
+		ingresses, err := s.State.ManagedIngresses()
+		if err != nil {
+			return false
+		}
+		for _, name := range names {
+			if ingresses[name].Phase != controllers.SyncPhaseSynced {
+				return false
+			}
+		}
+		return true
+	}, time.Second*30, time.Millisecond*50)
+
+	// test environment has no namespace controller, so the namespace would remain terminating
+	// and the ingresses are never garbage collected
+	s.NoError(s.Client.Delete(ctx, ns))
+	s.Controller.EventuallyAllDeleted(s.T(), names...)
+
+	ingresses, err := s.State.ManagedIngresses()
+	s.NoError(err)
+	for _, name := range names {
+		s.NotContains(ingresses, name)
+	}
+}
+
 func (s *ControllerTestSuite) TestIngressStatus() {
 	ctx := context.Background()
 
@@ -592,6 +817,45 @@ func (s *ControllerTestSuite) TestIngressStatus() {
 	}, time.Minute, time.Second)
 }
 
+func (s *ControllerTestSuite) TestState() {
+	ctx := context.Background()
+	c, err := s.Harness.NewController()
+	s.NoError(err)
+	s.Controller = c
+	state := c.State
+
+	_, err = state.Cache()
+	s.ErrorIs(err, controllers.ErrNotReady)
+	_, err = state.ManagedIngresses()
+	s.ErrorIs(err, controllers.ErrNotReady)
+
+	c.Start()
+
+	to := s.initialTestObjects("default")
+	for _, obj := range to.All() {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...)
+	}, "ingress created")
+
+	name := types.NamespacedName{Name: to.Ingress.Name, Namespace: to.Ingress.Namespace}
+	require.Eventually(s.T(), func() bool {
+		ingresses, err := state.ManagedIngresses()
+		return err == nil && ingresses[name].Phase == controllers.SyncPhaseSynced
+	}, time.Second*10, time.Millisecond*50)
+
+	reader, err := state.Cache()
+	s.NoError(err)
+	s.NoError(reader.Get(ctx, types.NamespacedName{Name: to.Secret.Name, Namespace: to.Secret.Namespace}, new(corev1.Secret)))
+
+	s.NoError(s.Client.Delete(ctx, to.Ingress))
+	s.EventuallyDeleted(name)
+	ingresses, err := state.ManagedIngresses()
+	s.NoError(err)
+	s.NotContains(ingresses, name)
+}
+
 func (s *ControllerTestSuite) TestHttp01Solver() {
 	ctx := context.Background()
 	s.createTestController(ctx)
@@ -663,6 +927,430 @@ func (s *ControllerTestSuite) TestHttp01Solver() {
 	}, "http01 solver ingress")
 }
 
+// TestDataBrokerOutage checks the controller converges once the databroker is available again
+func (s *ControllerTestSuite) TestDataBrokerOutage() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	injector := faults.NewInjector()
+	s.NoError(injector.SetRules([]faults.Rule{{Method: faults.MatchAll, Disconnect: true}}))
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{
+		DataBrokerServiceClient: faults.Wrap(db, injector),
+	})
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	routes := func() []string {
+		from, err := db.Routes()
+		s.NoError(err)
+		return from
+	}
+	s.Never(func() bool { return len(routes()) > 0 }, time.Second, time.Millisecond*50, "databroker is unavailable")
+
+	s.NoError(injector.SetRules(nil))
+	s.Eventually(func() bool {
+		return reflect.DeepEqual([]string{"https://service.localhost.pomerium.io"}, routes())
+	}, time.Second*30, time.Millisecond*50, "routes should be applied once the databroker is available")
+}
+
+// TestInitialSyncRemovesStaleRoutes checks the routes of the ingresses deleted while the controller was down
+// are removed by the startup sync, while the routes added to the config record manually are kept
+func (s *ControllerTestSuite) TestInitialSyncRemovesStaleRoutes() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	staleID, err := (&translate.RouteID{Name: "stale", Namespace: "default", Host: "stale.localhost.pomerium.io", Path: "/"}).Marshal()
+	s.NoError(err)
+	s.NoError(db.PutConfig("ingress-controller", &pb.Config{Routes: []*pb.Route{
+		{Id: staleID, From: "https://stale.localhost.pomerium.io", To: []string{"http://stale.default.svc.cluster.local"}, Prefix: "/"},
+		{Name: "manual", From: "https://manual.localhost.pomerium.io", To: []string{"http://manual.example.com"}},
+	}}))
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db})
+	s.NoError(err)
+	s.Controller = c
+
+	s.Eventually(func() bool {
+		from, err := db.Routes()
+		s.NoError(err)
+		return reflect.DeepEqual([]string{"https://manual.localhost.pomerium.io", "https://service.localhost.pomerium.io"}, from)
+	}, time.Second*30, time.Millisecond*50, "stale route should be removed and the manual one kept")
+}
+
+// TestSecureUpstream checks the route destination scheme follows the secure_upstream annotation,
+// while the address is still resolved from the endpoints of the named service port
+func (s *ControllerTestSuite) TestSecureUpstream() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db})
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	to.Ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port = networkingv1.ServiceBackendPort{Name: "https"}
+	to.Service.Spec.Ports = []corev1.ServicePort{{Name: "https", Protocol: "TCP", Port: 443, TargetPort: intstr.FromString("https")}}
+	to.EndpointSlice.Ports = []discoveryv1.EndpointPort{{Name: proto.String("https"), Port: proto.Int32(8443)}}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.EndpointSlice, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	destinations := func(expect string) func() bool {
+		return func() bool {
+			to, err := db.Destinations()
+			s.NoError(err)
+			return reflect.DeepEqual([]string{expect}, to)
+		}
+	}
+	s.Eventually(destinations("http://1.2.3.4:8443"), time.Second*30, time.Millisecond*50, "plain upstream")
+
+	to.Ingress.Annotations = map[string]string{
+		fmt.Sprintf("%s/%s", controllers.DefaultAnnotationPrefix, model.SecureUpstream): "true",
+		fmt.Sprintf("%s/%s", controllers.DefaultAnnotationPrefix, model.TLSServerName):  "upstream.example.com",
+	}
+	s.NoError(s.Client.Update(ctx, to.Ingress))
+	s.Eventually(destinations("https://1.2.3.4:8443"), time.Second*30, time.Millisecond*50, "secure upstream")
+
+	to.Ingress.Annotations = nil
+	s.NoError(s.Client.Update(ctx, to.Ingress))
+	s.Eventually(destinations("http://1.2.3.4:8443"), time.Second*30, time.Millisecond*50, "annotation removed")
+}
+
+// TestConcurrentReconciles checks the ingresses created at once are all applied, while reconciled concurrently
+func (s *ControllerTestSuite) TestConcurrentReconciles() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db},
+		controllers.WithMaxConcurrentReconciles(4))
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	for _, obj := range []client.Object{to.IngressClass, to.EndpointSlice, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	var expect []string
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		ingress := to.Ingress.DeepCopy()
+		ingress.Name = name
+		// no TLS, as the test secret does not hold a valid certificate
+		ingress.Spec.TLS = nil
+		ingress.Spec.Rules[0].Host = fmt.Sprintf("%s.localhost.pomerium.io", name)
+		s.NoError(s.Client.Create(ctx, ingress))
+		expect = append(expect, fmt.Sprintf("https://%s.localhost.pomerium.io", name))
+	}
+
+	s.Eventually(func() bool {
+		routes, err := db.Routes()
+		s.NoError(err)
+		return reflect.DeepEqual(expect, routes)
+	}, time.Second*30, time.Millisecond*50, "routes of all ingresses")
+}
+
+// TestPeriodicResync checks the ingress is upserted again with no object changes once the resync period passes
+func (s *ControllerTestSuite) TestPeriodicResync() {
+	ctx := context.Background()
+	s.createTestController(ctx, controllers.WithResyncPeriod(time.Second))
+
+	to := s.initialTestObjects("default")
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service, to.Secret} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+	s.EventuallyUpsert(func(ic *model.IngressConfig) string {
+		return cmp.Diff(to.Ingress, ic.Ingress, cmpOpts...)
+	}, "initial upsert")
+
+	revision := s.Controller.LastUpsert().Revision
+	s.Eventually(func() bool {
+		ic := s.Controller.LastUpsert()
+		return ic != nil && ic.Revision > revision
+	}, time.Second*10, time.Millisecond*50, "resync upsert")
+}
+
+// TestHostRewrite checks an annotation-only update of the upstream Host header options is applied
+func (s *ControllerTestSuite) TestHostRewrite() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db})
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	to.Ingress.Annotations = map[string]string{
+		fmt.Sprintf("%s/host_rewrite", controllers.DefaultAnnotationPrefix): "upstream.example.com",
+	}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	hostRewrite := func(literal, header string) func() bool {
+		return func() bool {
+			routes, err := db.RouteConfigs()
+			s.NoError(err)
+			return len(routes) == 1 &&
+				routes[0].GetHostRewrite() == literal &&
+				routes[0].GetHostRewriteHeader() == header
+		}
+	}
+	s.Eventually(hostRewrite("upstream.example.com", ""), time.Second*30, time.Millisecond*50, "literal host")
+
+	to.Ingress.Annotations = map[string]string{
+		fmt.Sprintf("%s/host_rewrite_header", controllers.DefaultAnnotationPrefix): "X-Upstream-Host",
+	}
+	s.NoError(s.Client.Update(ctx, to.Ingress))
+	s.Eventually(hostRewrite("", "X-Upstream-Host"), time.Second*30, time.Millisecond*50, "host from header")
+}
+
+// TestAllowSpdy checks SPDY upgrades are allowed on the upserted routes along with websockets
+func (s *ControllerTestSuite) TestAllowSpdy() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db})
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	to.Ingress.Annotations = map[string]string{
+		fmt.Sprintf("%s/allow_spdy", controllers.DefaultAnnotationPrefix):       "true",
+		fmt.Sprintf("%s/allow_websockets", controllers.DefaultAnnotationPrefix): "true",
+	}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	upgrades := func(spdy, websockets bool) func() bool {
+		return func() bool {
+			routes, err := db.RouteConfigs()
+			s.NoError(err)
+			return len(routes) == 1 &&
+				routes[0].GetAllowSpdy() == spdy &&
+				routes[0].GetAllowWebsockets() == websockets
+		}
+	}
+	s.Eventually(upgrades(true, true), time.Second*30, time.Millisecond*50, "spdy and websockets")
+
+	delete(to.Ingress.Annotations, fmt.Sprintf("%s/allow_websockets", controllers.DefaultAnnotationPrefix))
+	s.NoError(s.Client.Update(ctx, to.Ingress))
+	s.Eventually(upgrades(true, false), time.Second*30, time.Millisecond*50, "spdy only")
+}
+
+// TestTCPUpstream checks a TCP ingress is upserted as a tcp+https route to the service endpoints
+func (s *ControllerTestSuite) TestTCPUpstream() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db})
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	to.Ingress.Annotations = map[string]string{
+		fmt.Sprintf("%s/%s", controllers.DefaultAnnotationPrefix, model.TCPUpstream): "true",
+	}
+	to.Service.Spec.Ports = []corev1.ServicePort{{Name: "http", Protocol: "TCP", Port: 5432, TargetPort: intstr.FromInt(5432)}}
+	to.EndpointSlice.Ports = []discoveryv1.EndpointPort{{Name: proto.String("http"), Port: proto.Int32(5432)}}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.EndpointSlice, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	s.Eventually(func() bool {
+		routes, err := db.RouteConfigs()
+		s.NoError(err)
+		return len(routes) == 1 &&
+			routes[0].GetFrom() == "tcp+https://service.localhost.pomerium.io:5432" &&
+			cmp.Equal(routes[0].GetTo(), []string{"tcp://1.2.3.4:5432"}) &&
+			routes[0].GetPrefix() == ""
+	}, time.Second*30, time.Millisecond*50, "tcp route")
+}
+
+// TestCanary checks the canary service endpoints are tracked like the primary service ones
+func (s *ControllerTestSuite) TestCanary() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db})
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	to.Ingress.Annotations = map[string]string{
+		fmt.Sprintf("%s/%s", controllers.DefaultAnnotationPrefix, model.Canary): "{serviceName: canary, servicePort: http, weight: 10}",
+	}
+	to.EndpointSlice.Ports = []discoveryv1.EndpointPort{{Name: proto.String("http"), Port: proto.Int32(80)}}
+	canaryService := to.Service.DeepCopy()
+	canaryService.Name = "canary"
+	canarySlice := to.EndpointSlice.DeepCopy()
+	canarySlice.Name = "canary-1"
+	canarySlice.Labels = map[string]string{discoveryv1.LabelServiceName: "canary"}
+	canarySlice.Endpoints = []discoveryv1.Endpoint{{Addresses: []string{"1.2.3.5"}}}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.EndpointSlice, to.Service, canarySlice, canaryService} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	destinations := func(expect ...string) func() bool {
+		return func() bool {
+			routes, err := db.RouteConfigs()
+			s.NoError(err)
+			return len(routes) == 1 && cmp.Equal(routes[0].GetTo(), expect)
+		}
+	}
+	s.Eventually(destinations("http://1.2.3.4:80,9", "http://1.2.3.5:80,1"),
+		time.Second*30, time.Millisecond*50, "weighted destinations")
+
+	canarySlice.Endpoints = []discoveryv1.Endpoint{{Addresses: []string{"1.2.3.6"}}}
+	s.NoError(s.Client.Update(ctx, canarySlice))
+	s.Eventually(destinations("http://1.2.3.4:80,9", "http://1.2.3.6:80,1"),
+		time.Second*30, time.Millisecond*50, "canary endpoints change")
+
+	to.Ingress.Annotations = nil
+	s.NoError(s.Client.Update(ctx, to.Ingress))
+	s.Eventually(destinations("http://1.2.3.4:80"), time.Second*30, time.Millisecond*50, "canary removed")
+}
+
+// TestServiceAccountTokenSecret checks the token secret blocks the upsert until it exists, and its updates are applied
+func (s *ControllerTestSuite) TestServiceAccountTokenSecret() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db})
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	to.Ingress.Annotations = map[string]string{
+		fmt.Sprintf("%s/%s", controllers.DefaultAnnotationPrefix, model.KubernetesServiceAccountTokenSecret): "k8s-token",
+	}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	token := func(expect string) func() bool {
+		return func() bool {
+			routes, err := db.RouteConfigs()
+			s.NoError(err)
+			return len(routes) == 1 && routes[0].GetKubernetesServiceAccountToken() == expect
+		}
+	}
+	s.Never(func() bool {
+		routes, err := db.RouteConfigs()
+		s.NoError(err)
+		return len(routes) > 0
+	}, time.Second*3, time.Millisecond*50, "missing secret should block the upsert")
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-token", Namespace: "default"},
+		Data:       map[string][]byte{model.KubernetesServiceAccountTokenSecretKey: []byte("token-a")},
+	}
+	s.NoError(s.Client.Create(ctx, secret))
+	s.Eventually(token("token-a"), time.Second*30, time.Millisecond*50, "secret created")
+
+	secret.Data[model.KubernetesServiceAccountTokenSecretKey] = []byte("token-b")
+	s.NoError(s.Client.Update(ctx, secret))
+	s.Eventually(token("token-b"), time.Second*30, time.Millisecond*50, "secret updated")
+}
+
+// TestEndpointSlices checks the endpoints are aggregated across the service slices,
+// and a slice being added or removed updates the route destinations
+func (s *ControllerTestSuite) TestEndpointSlices() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db})
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	to.EndpointSlice.Ports = []discoveryv1.EndpointPort{{Name: proto.String("http"), Port: proto.Int32(80)}}
+	second := to.EndpointSlice.DeepCopy()
+	second.Name = "service-2"
+	second.Endpoints = []discoveryv1.Endpoint{{Addresses: []string{"1.2.3.5"}}}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.EndpointSlice, second, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	destinations := func(expect ...string) func() bool {
+		return func() bool {
+			to, err := db.Destinations()
+			s.NoError(err)
+			return reflect.DeepEqual(expect, to)
+		}
+	}
+	s.Eventually(destinations("http://1.2.3.4:80", "http://1.2.3.5:80"), time.Second*30, time.Millisecond*50, "both slices")
+
+	third := to.EndpointSlice.DeepCopy()
+	third.Name = "service-3"
+	third.Endpoints = []discoveryv1.Endpoint{{Addresses: []string{"1.2.3.6"}}}
+	s.NoError(s.Client.Create(ctx, third))
+	s.Eventually(destinations("http://1.2.3.4:80", "http://1.2.3.5:80", "http://1.2.3.6:80"),
+		time.Second*30, time.Millisecond*50, "slice added")
+
+	s.NoError(s.Client.Delete(ctx, second))
+	s.Eventually(destinations("http://1.2.3.4:80", "http://1.2.3.6:80"), time.Second*30, time.Millisecond*50, "slice removed")
+}
+
+// TestLegacyEndpoints checks the endpoints are resolved from the Endpoints objects once the slices are disabled
+func (s *ControllerTestSuite) TestLegacyEndpoints() {
+	ctx := context.Background()
+
+	db := pomeriumtest.NewDataBroker()
+	c, err := s.Harness.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: db},
+		controllers.WithLegacyEndpoints())
+	s.NoError(err)
+	s.Controller = c
+
+	to := s.initialTestObjects("default")
+	// no TLS, as the test secret does not hold a valid certificate
+	to.Ingress.Spec.TLS = nil
+	to.Endpoints.Subsets[0].Ports = []corev1.EndpointPort{{Name: "http", Port: 80}}
+	for _, obj := range []client.Object{to.IngressClass, to.Ingress, to.Endpoints, to.Service} {
+		s.NoError(s.Client.Create(ctx, obj))
+	}
+
+	destinations := func(expect ...string) func() bool {
+		return func() bool {
+			to, err := db.Destinations()
+			s.NoError(err)
+			return reflect.DeepEqual(expect, to)
+		}
+	}
+	s.Eventually(destinations("http://1.2.3.4:80"), time.Second*30, time.Millisecond*50, "legacy endpoints")
+
+	to.Endpoints.Subsets[0].Addresses = append(to.Endpoints.Subsets[0].Addresses, corev1.EndpointAddress{IP: "1.2.3.5"})
+	s.NoError(s.Client.Update(ctx, to.Endpoints))
+	s.Eventually(destinations("http://1.2.3.4:80", "http://1.2.3.5:80"), time.Second*30, time.Millisecond*50, "endpoints updated")
+}
+
 func TestIngressController(t *testing.T) {
 	suite.Run(t, &ControllerTestSuite{})
 }
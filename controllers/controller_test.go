@@ -2,13 +2,34 @@ package controllers
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/translate"
 )
 
 func TestManagingIngressClass(t *testing.T) {
@@ -118,3 +139,824 @@ func TestManagingIngressClass(t *testing.T) {
 		}
 	}
 }
+
+func TestRequiredLabels(t *testing.T) {
+	className := "pomerium"
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		controllerName: DefaultClassControllerName,
+		Client:         mc,
+	}
+	WithRequiredLabels(map[string]string{"pomerium.io/managed": "true"})(&ctrl)
+
+	mc.EXPECT().List(ctx, gomock.AssignableToTypeOf(&networkingv1.IngressClassList{})).
+		Do(func(_ context.Context, dst *networkingv1.IngressClassList, _ ...client.ListOption) {
+			dst.Items = []networkingv1.IngressClass{{
+				ObjectMeta: metav1.ObjectMeta{Name: className},
+				Spec:       networkingv1.IngressClassSpec{Controller: DefaultClassControllerName},
+			}}
+		}).
+		Return(nil).
+		AnyTimes()
+
+	for _, tc := range []struct {
+		title  string
+		labels map[string]string
+		result bool
+	}{
+		{"labeled", map[string]string{"pomerium.io/managed": "true", "app": "x"}, true},
+		{"unlabeled", nil, false},
+		{"label value mismatch", map[string]string{"pomerium.io/managed": "false"}, false},
+	} {
+		ing := &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Labels: tc.labels},
+			Spec:       networkingv1.IngressSpec{IngressClassName: &className},
+		}
+		ok, err := ctrl.isManaging(ctx, ing)
+		if assert.NoError(t, err, tc.title) {
+			assert.Equal(t, tc.result, ok, tc.title)
+		}
+	}
+
+	otherClass := "other"
+	ok, err := ctrl.isManaging(ctx, &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"pomerium.io/managed": "true"}},
+		Spec:       networkingv1.IngressSpec{IngressClassName: &otherClass},
+	})
+	require.NoError(t, err)
+	assert.False(t, ok, "labels should not override ingress class matching")
+}
+
+func TestWatchIngressWithoutClass(t *testing.T) {
+	className := "pomerium"
+	otherClass := "other"
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		controllerName: DefaultClassControllerName,
+		Client:         mc,
+	}
+
+	mc.EXPECT().List(ctx, gomock.AssignableToTypeOf(&networkingv1.IngressClassList{})).
+		Do(func(_ context.Context, dst *networkingv1.IngressClassList, _ ...client.ListOption) {
+			dst.Items = []networkingv1.IngressClass{{
+				ObjectMeta: metav1.ObjectMeta{Name: className},
+				Spec:       networkingv1.IngressClassSpec{Controller: DefaultClassControllerName},
+			}}
+		}).
+		Return(nil).
+		AnyTimes()
+
+	classless := new(networkingv1.Ingress)
+	ok, err := ctrl.isManaging(ctx, classless)
+	require.NoError(t, err)
+	assert.False(t, ok, "no default ingress class")
+
+	WithWatchIngressWithoutClass()(&ctrl)
+	ok, err = ctrl.isManaging(ctx, classless)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	for _, ing := range []*networkingv1.Ingress{
+		{Spec: networkingv1.IngressSpec{IngressClassName: &otherClass}},
+		{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{IngressClassAnnotationKey: otherClass}}},
+	} {
+		ok, err = ctrl.isManaging(ctx, ing)
+		require.NoError(t, err)
+		assert.False(t, ok, "ingresses of other classes should not be affected")
+	}
+
+	ctrl.ingressParameters = true
+	params, err := ctrl.fetchIngressParameters(ctx, classless)
+	require.NoError(t, err)
+	assert.Nil(t, params)
+}
+
+func TestIngressLabelSelector(t *testing.T) {
+	_, err := parseIngressLabelSelector("env in staging")
+	assert.Error(t, err)
+	sel, err := parseIngressLabelSelector("")
+	require.NoError(t, err)
+	assert.Nil(t, sel)
+
+	ctrl := ingressController{}
+	WithRequiredLabels(map[string]string{"team": "a"})(&ctrl)
+	ctrl.labelSelector, err = parseIngressLabelSelector("env in (staging,qa),!canary")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		title  string
+		labels map[string]string
+		result bool
+	}{
+		{"matching", map[string]string{"team": "a", "env": "qa"}, true},
+		{"excluded label", map[string]string{"team": "a", "env": "qa", "canary": "true"}, false},
+		{"selector mismatch", map[string]string{"team": "a", "env": "prod"}, false},
+		{"required labels mismatch", map[string]string{"env": "staging"}, false},
+	} {
+		ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}}
+		assert.Equal(t, tc.result, ctrl.hasRequiredLabels(ing), tc.title)
+	}
+}
+
+func TestStatusUpdaterHealth(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	recorder := record.NewFakeRecorder(10)
+	svcName := types.NamespacedName{Name: "pomerium-proxy", Namespace: "pomerium"}
+	ctrl := ingressController{
+		Client:                  mc,
+		EventRecorder:           recorder,
+		updateStatusFromService: &svcName,
+		statusUpdaterHealth:     NewStatusUpdaterHealth(),
+	}
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "services"}, svcName.Name, errors.New("rbac"))
+
+	mc.EXPECT().Get(ctx, svcName, gomock.AssignableToTypeOf(&corev1.Service{})).Return(forbidden).Times(3)
+	for _, name := range []string{"a", "b", "c"} {
+		assert.Error(t, ctrl.updateIngressStatus(ctx, &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+	if assert.Len(t, recorder.Events, 1, "only one event should be emitted for the same error") {
+		assert.Contains(t, <-recorder.Events, msgServiceForbidden)
+	}
+	err := ctrl.statusUpdaterHealth.Check(nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), msgServiceForbidden)
+	}
+
+	sw := NewMockStatusWriter(gomock.NewController(t))
+	mc.EXPECT().Get(ctx, svcName, gomock.AssignableToTypeOf(&corev1.Service{})).Return(nil)
+	mc.EXPECT().Status().Return(sw)
+	sw.EXPECT().Update(ctx, gomock.AssignableToTypeOf(&networkingv1.Ingress{})).Return(nil)
+	assert.NoError(t, ctrl.updateIngressStatus(ctx, new(networkingv1.Ingress)))
+	assert.NoError(t, ctrl.statusUpdaterHealth.Check(nil))
+	assert.Empty(t, recorder.Events)
+}
+
+func TestStatusFromService(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	proxy := types.NamespacedName{Name: "pomerium-proxy", Namespace: "pomerium"}
+	internal := types.NamespacedName{Name: "internal-proxy", Namespace: "pomerium"}
+	ctrl := newIngressController(
+		WithNamespaces([]string{"default"}),
+		WithUpdateIngressStatusFromService(proxy),
+		WithAllowedStatusServices([]types.NamespacedName{internal}),
+	)
+	ctrl.Client = mc
+	ctrl.EventRecorder = record.NewFakeRecorder(10)
+	ctrl.Scheme = clientgoscheme.Scheme
+	ctrl.Registry = model.NewRegistry()
+	ctrl.ingressKind, ctrl.serviceKind, ctrl.endpointsKind = "Ingress", "Service", "Endpoints"
+
+	ingress := func(name, statusFrom string) *networkingv1.Ingress {
+		ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{}}}
+		if statusFrom != "" {
+			ing.Annotations[DefaultAnnotationPrefix+"/"+model.StatusFromService] = statusFrom
+		}
+		return ing
+	}
+	main, other := ingress("main", ""), ingress("other", internal.String())
+	assert.Equal(t, &proxy, ctrl.statusService(main))
+	assert.Equal(t, &internal, ctrl.statusService(other))
+	assert.Nil(t, ctrl.statusService(ingress("invalid", "pomerium/other-proxy")), "services that are not allowed should be ignored")
+
+	assert.True(t, ctrl.isWatching(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: internal.Name, Namespace: internal.Namespace}}))
+	assert.False(t, ctrl.isWatching(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "other-proxy", Namespace: "pomerium"}}))
+
+	// only the overriding ingress should be reconciled once the internal proxy load balancer changes
+	ctrl.updateDependencies(&model.IngressConfig{Ingress: main})
+	ctrl.updateDependencies(&model.IngressConfig{Ingress: other})
+	deps := ctrl.getDependantIngressFn(ctrl.serviceKind)
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "other", Namespace: "default"}}},
+		deps(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: internal.Name, Namespace: internal.Namespace}}))
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "main", Namespace: "default"}}},
+		deps(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: proxy.Name, Namespace: proxy.Namespace}}))
+
+	sw := NewMockStatusWriter(gomock.NewController(t))
+	mc.EXPECT().Get(ctx, internal, gomock.AssignableToTypeOf(&corev1.Service{})).DoAndReturn(
+		func(_ context.Context, _ types.NamespacedName, obj client.Object) error {
+			obj.(*corev1.Service).Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+			return nil
+		})
+	mc.EXPECT().Status().Return(sw)
+	sw.EXPECT().Update(ctx, gomock.AssignableToTypeOf(&networkingv1.Ingress{})).Return(nil)
+	require.NoError(t, ctrl.updateIngressStatus(ctx, other))
+	assert.Equal(t, []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}, other.Status.LoadBalancer.Ingress)
+}
+
+// TestDefaultCertSecretDependency checks the ingresses relying on the default certificate are reconciled once it is updated,
+// even though the secret is kept outside of the watched namespaces
+func TestDefaultCertSecretDependency(t *testing.T) {
+	ctrl := newIngressController(WithNamespaces([]string{"default"}))
+	ctrl.Scheme = clientgoscheme.Scheme
+	ctrl.Registry = model.NewRegistry()
+	ctrl.ingressKind, ctrl.secretKind = "Ingress", "Secret"
+
+	defaultCert := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "default-cert", Namespace: "pomerium"}}
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"}}
+	ctrl.updateDependencies(&model.IngressConfig{
+		Ingress: ingress,
+		Secrets: map[types.NamespacedName]*corev1.Secret{{Name: "default-cert", Namespace: "pomerium"}: defaultCert},
+	})
+
+	deps := ctrl.getDependantIngressFn(ctrl.secretKind)
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "ingress", Namespace: "default"}}}, deps(defaultCert))
+	assert.Empty(t, deps(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "pomerium"}}),
+		"secrets outside of the watched namespaces that no ingress relies on should be ignored")
+
+	// i.e. the ingress has got its own TLS secret, or the ingress class refers to another default cert
+	ctrl.updateDependencies(&model.IngressConfig{Ingress: ingress})
+	assert.Empty(t, deps(defaultCert))
+}
+
+func TestFetchErrorClassification(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		annotationPrefix: DefaultAnnotationPrefix,
+		Client:           mc,
+		Scheme:           clientgoscheme.Scheme,
+		Registry:         model.NewRegistry(),
+		disableCertCheck: true,
+		ingressKind:      "Ingress",
+		secretKind:       "Secret",
+		serviceKind:      "Service",
+	}
+	ingress := func(backend networkingv1.IngressBackend) *networkingv1.Ingress {
+		typePrefix := networkingv1.PathTypePrefix
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+			Spec: networkingv1.IngressSpec{
+				TLS: []networkingv1.IngressTLS{{Hosts: []string{"a.localhost.pomerium.io"}, SecretName: "secret"}},
+				Rules: []networkingv1.IngressRule{{
+					Host: "a.localhost.pomerium.io",
+					IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{Path: "/", PathType: &typePrefix, Backend: backend}},
+					}},
+				}},
+			},
+		}
+	}
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "secret")
+
+	mc.EXPECT().Get(ctx, types.NamespacedName{Name: "secret", Namespace: "default"}, gomock.Any()).Return(notFound)
+	_, err := ctrl.fetchIngress(ctx, ingress(networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{Name: "service", Port: networkingv1.ServiceBackendPort{Number: 80}},
+	}))
+	if assert.Error(t, err) {
+		assert.False(t, model.IsPermanentError(err), "missing secret may be created later")
+	}
+	res, err := requeueTransient(ctx, err)
+	assert.Error(t, err)
+	assert.True(t, res.Requeue)
+
+	mc.EXPECT().Get(ctx, types.NamespacedName{Name: "secret", Namespace: "default"}, gomock.Any()).Return(nil)
+	_, err = ctrl.fetchIngress(ctx, ingress(networkingv1.IngressBackend{}))
+	if assert.Error(t, err) {
+		assert.True(t, model.IsPermanentError(err), "path without backend service is a spec error")
+	}
+	res, err = requeueTransient(ctx, err)
+	assert.NoError(t, err)
+	assert.False(t, res.Requeue)
+}
+
+func TestFetchRedirectIngress(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		annotationPrefix: DefaultAnnotationPrefix,
+		Client:           mc,
+		Registry:         model.NewRegistry(),
+		disableCertCheck: true,
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", Annotations: map[string]string{
+			DefaultAnnotationPrefix + "/" + model.Redirect: "{host_redirect: example.com}",
+		}},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{Host: "a.localhost.pomerium.io"}},
+			DefaultBackend: &networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{Name: "missing", Port: networkingv1.ServiceBackendPort{Number: 80}},
+			},
+		},
+	}
+
+	// the mock fails on any service or endpoints Get
+	ic, err := ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.Empty(t, ic.Services)
+	assert.Empty(t, ic.Endpoints)
+}
+
+func TestFetchCanaryService(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		annotationPrefix: DefaultAnnotationPrefix,
+		Client:           mc,
+		Scheme:           clientgoscheme.Scheme,
+		Registry:         model.NewRegistry(),
+		disableCertCheck: true,
+		ingressKind:      "Ingress",
+		serviceKind:      "Service",
+		endpointsKind:    "Endpoints",
+	}
+	typePrefix := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", Annotations: map[string]string{
+			DefaultAnnotationPrefix + "/" + model.Canary: "{serviceName: app-canary, servicePort: 80, weight: 10}",
+		}},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: "a.localhost.pomerium.io",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{Path: "/", PathType: &typePrefix, Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{Name: "app", Port: networkingv1.ServiceBackendPort{Number: 80}},
+					}}},
+				}},
+			}},
+		},
+	}
+	mc.EXPECT().Get(ctx, gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, name types.NamespacedName, obj client.Object) error {
+			obj.SetName(name.Name)
+			obj.SetNamespace(name.Namespace)
+			return nil
+		})
+
+	ic, err := ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	canary := types.NamespacedName{Name: "app-canary", Namespace: "default"}
+	assert.Contains(t, ic.Services, canary)
+	assert.Contains(t, ic.Endpoints, canary)
+
+	ctrl.updateDependencies(ic)
+	for _, kind := range []string{"Service", "Endpoints"} {
+		assert.Equal(t, []model.Key{{Kind: "Ingress", NamespacedName: types.NamespacedName{Name: "ingress", Namespace: "default"}}},
+			ctrl.DepsOfKind(model.Key{Kind: kind, NamespacedName: canary}, "Ingress"), "canary %s changes should reconcile the ingress", kind)
+	}
+
+	ingress.Annotations[DefaultAnnotationPrefix+"/"+model.Canary] = "{serviceName: app-canary}"
+	_, err = ctrl.fetchIngress(ctx, ingress)
+	if assert.Error(t, err) {
+		assert.True(t, model.IsPermanentError(err))
+	}
+}
+
+func TestFetchDoublyReferencedSecret(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		annotationPrefix: DefaultAnnotationPrefix,
+		Client:           mc,
+		Scheme:           clientgoscheme.Scheme,
+		Registry:         model.NewRegistry(),
+		ingressKind:      "Ingress",
+		secretKind:       "Secret",
+		serviceKind:      "Service",
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", Annotations: map[string]string{
+			DefaultAnnotationPrefix + "/" + model.TLSClientSecret: "secret",
+		}},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{"service.localhost.pomerium.io"}, SecretName: "secret"}},
+		},
+	}
+	name := types.NamespacedName{Name: "secret", Namespace: "default"}
+
+	for _, secret := range []*corev1.Secret{testTLSSecret(t, "secret"), testTLSSecret(t, "secret")} {
+		secret := secret
+		// the secret referenced in both roles should only be fetched once
+		mc.EXPECT().Get(ctx, name, gomock.Any()).Times(1).DoAndReturn(
+			func(_ context.Context, _ types.NamespacedName, obj client.Object) error {
+				secret.DeepCopyInto(obj.(*corev1.Secret))
+				return nil
+			})
+		ic, err := ctrl.fetchIngress(ctx, ingress)
+		require.NoError(t, err)
+		require.Len(t, ic.Secrets, 1)
+		assert.Equal(t, secret.Data, ic.Secrets[name].Data)
+
+		certs, err := ic.ParseTLSCerts(ctx)
+		require.NoError(t, err)
+		if assert.Len(t, certs, 1) {
+			assert.Equal(t, secret.Data[corev1.TLSCertKey], certs[0].Cert, "TLS role should observe the latest data")
+		}
+	}
+}
+
+// TestFetchTLSHosts checks the secrets of the spec.tls entries covering each of the rule hosts are fetched,
+// and a rule host that none of them covers requires the default certificate
+func TestFetchTLSHosts(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		annotationPrefix: DefaultAnnotationPrefix,
+		controllerName:   "pomerium.io/ingress-controller",
+		Client:           mc,
+		Scheme:           clientgoscheme.Scheme,
+		Registry:         model.NewRegistry(),
+		ingressKind:      "Ingress",
+		secretKind:       "Secret",
+		serviceKind:      "Service",
+	}
+	className := "pomerium"
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &className,
+			TLS: []networkingv1.IngressTLS{
+				{Hosts: []string{"a.localhost.pomerium.io"}, SecretName: "a"},
+				{Hosts: []string{"*.apps.localhost.pomerium.io", "unused.localhost.pomerium.io"}, SecretName: "apps"},
+			},
+			Rules: []networkingv1.IngressRule{
+				{Host: "a.localhost.pomerium.io"},
+				{Host: "b.apps.localhost.pomerium.io"},
+			},
+		},
+	}
+	mc.EXPECT().Get(ctx, gomock.Any(), gomock.AssignableToTypeOf(&corev1.Secret{})).AnyTimes().DoAndReturn(
+		func(_ context.Context, name types.NamespacedName, obj client.Object) error {
+			testTLSSecret(t, name.Name).DeepCopyInto(obj.(*corev1.Secret))
+			return nil
+		})
+
+	ic, err := ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.Len(t, ic.Secrets, 2)
+	assert.Contains(t, ic.Secrets, types.NamespacedName{Name: "a", Namespace: "default"})
+	assert.Contains(t, ic.Secrets, types.NamespacedName{Name: "apps", Namespace: "default"})
+	assert.Equal(t, []model.Warning{{
+		Reason:  warningUnusedTLSHost,
+		Message: "spec.tls host unused.localhost.pomerium.io does not match any of the ingress rules",
+	}}, ic.Warnings.List())
+
+	// the host not covered by spec.tls requires the default cert
+	ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{Host: "c.localhost.pomerium.io"})
+	class := networkingv1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{Name: className},
+		Spec:       networkingv1.IngressClassSpec{Controller: ctrl.controllerName},
+	}
+	mc.EXPECT().List(ctx, gomock.AssignableToTypeOf(&networkingv1.IngressClassList{})).AnyTimes().DoAndReturn(
+		func(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+			list.(*networkingv1.IngressClassList).Items = []networkingv1.IngressClass{class}
+			return nil
+		})
+	_, err = ctrl.fetchIngress(ctx, ingress)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "hosts c.localhost.pomerium.io are not covered by spec.tls")
+		assert.True(t, model.IsPermanentError(err), "ingress class without the default cert")
+	}
+
+	class.Annotations = map[string]string{DefaultAnnotationPrefix + "/" + DefaultCertSecretKey: "default/default-cert"}
+	ic, err = ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.Len(t, ic.Secrets, 3)
+	assert.Contains(t, ic.Secrets, types.NamespacedName{Name: "default-cert", Namespace: "default"})
+}
+
+func testTLSSecret(t testing.TB, name string) *corev1.Secret {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"service.localhost.pomerium.io"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: "1"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		},
+	}
+}
+
+func TestInvalidSecretEvents(t *testing.T) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	ctrl := ingressController{
+		EventRecorder: recorder,
+		Registry:      model.NewRegistry(),
+		Scheme:        clientgoscheme.Scheme,
+		ingressKind:   "Ingress",
+		secretKind:    "Secret",
+	}
+
+	secret := testTLSSecret(t, "secret")
+	ingressConfig := func(name string) *model.IngressConfig {
+		ic := &model.IngressConfig{
+			Ingress: &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}},
+			Secrets: map[types.NamespacedName]*corev1.Secret{{Name: secret.Name, Namespace: secret.Namespace}: secret},
+		}
+		ctrl.updateDependencies(ic)
+		return ic
+	}
+	ics := []*model.IngressConfig{ingressConfig("a"), ingressConfig("b"), ingressConfig("c")}
+
+	for _, ic := range ics {
+		ctrl.reportInvalidSecrets(ctx, ic)
+	}
+	assert.Empty(t, recorder.Events, "valid secret")
+
+	secret.ResourceVersion = "2"
+	secret.Data[corev1.TLSCertKey] = []byte("not a cert")
+	for _, ic := range ics {
+		ctrl.reportInvalidSecrets(ctx, ic)
+	}
+	if assert.Len(t, recorder.Events, 1, "one event per secret") {
+		evt := <-recorder.Events
+		assert.Contains(t, evt, reasonInvalidSecret)
+		assert.Contains(t, evt, "does not contain a PEM encoded certificate")
+		assert.Contains(t, evt, "default/a, default/b, default/c")
+	}
+
+	secret.ResourceVersion = "3"
+	secret.Data[corev1.TLSCertKey] = testTLSSecret(t, "other").Data[corev1.TLSCertKey]
+	ctrl.reportInvalidSecrets(ctx, ics[0])
+	if assert.Len(t, recorder.Events, 1, "key mismatch") {
+		assert.Contains(t, <-recorder.Events, "private key does not match public key")
+	}
+}
+
+func TestDisabledCertCheckEvents(t *testing.T) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	ctrl := ingressController{
+		EventRecorder:    recorder,
+		annotationPrefix: DefaultAnnotationPrefix,
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", Annotations: map[string]string{
+			DefaultAnnotationPrefix + "/" + model.TLSClientSecret: "client",
+		}},
+	}
+	ic := &model.IngressConfig{Ingress: ingress}
+
+	ctrl.reportDisabledCertCheck(ctx, ic)
+	assert.Empty(t, recorder.Events, "cert check is enabled")
+
+	ctrl.disableCertCheck = true
+	ctrl.reportDisabledCertCheck(ctx, ic)
+	assert.Empty(t, recorder.Events, "upstream TLS annotations are in effect")
+
+	ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{"a.localhost.pomerium.io"}, SecretName: "cert"}}
+	for i := 0; i < 3; i++ {
+		ctrl.reportDisabledCertCheck(ctx, ic)
+	}
+	if assert.Len(t, recorder.Events, 1, "should be deduplicated") {
+		evt := <-recorder.Events
+		assert.Contains(t, evt, reasonCertCheckDisabled)
+		assert.Contains(t, evt, "tls secret cert: forwarded to Pomerium, but not validated")
+	}
+
+	ingress.Annotations[DefaultAnnotationPrefix+"/"+model.TLSDownstreamClientCASecret] = "ca"
+	ic.SkipCertificates = true
+	ctrl.reportDisabledCertCheck(ctx, ic)
+	if assert.Len(t, recorder.Events, 1, "certificate material changed") {
+		assert.Contains(t, <-recorder.Events, "tls secret cert, tls_downstream_client_ca_secret ca: not forwarded to Pomerium")
+	}
+
+	ctrl.certCheckEvents.forget(ic.GetIngressNamespacedName())
+	ctrl.reportDisabledCertCheck(ctx, ic)
+	assert.Len(t, recorder.Events, 1, "should be reported again once the ingress is managed again")
+}
+
+func TestFetchSkipCertificates(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		annotationPrefix: DefaultAnnotationPrefix,
+		Client:           mc,
+		Scheme:           clientgoscheme.Scheme,
+		Registry:         model.NewRegistry(),
+		disableCertCheck: true,
+		secretKind:       "Secret",
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{"service.localhost.pomerium.io"}, SecretName: "secret"}},
+		},
+	}
+	secret := testTLSSecret(t, "secret")
+	mc.EXPECT().Get(ctx, types.NamespacedName{Name: "secret", Namespace: "default"}, gomock.Any()).Times(2).DoAndReturn(
+		func(_ context.Context, _ types.NamespacedName, obj client.Object) error {
+			secret.DeepCopyInto(obj.(*corev1.Secret))
+			return nil
+		})
+
+	// the ingress certificates are still forwarded by default, as they were before the option was introduced
+	ic, err := ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.False(t, ic.SkipCertificates)
+	assert.Len(t, ic.Secrets, 1)
+
+	ctrl.skipCertificates = true
+	ic, err = ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.True(t, ic.SkipCertificates)
+	assert.Len(t, ic.Secrets, 1, "secrets are fetched regardless, as upstream TLS annotations may reference them")
+}
+
+// translatingReconciler only translates the ingress, so that the translation errors are observed without a databroker
+type translatingReconciler struct {
+	PomeriumReconciler
+}
+
+func (translatingReconciler) Upsert(ctx context.Context, ic *model.IngressConfig) (bool, error) {
+	_, err := translate.Ingress(ctx, ic)
+	return true, err
+}
+
+func TestServicePortRenamed(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	recorder := record.NewFakeRecorder(10)
+	ctrl := ingressController{
+		annotationPrefix:   DefaultAnnotationPrefix,
+		Client:             mc,
+		Scheme:             clientgoscheme.Scheme,
+		Registry:           model.NewRegistry(),
+		EventRecorder:      recorder,
+		PomeriumReconciler: translatingReconciler{},
+		disableCertCheck:   true,
+		syncStates:         newSyncStates(),
+		endpointsKind:      "Endpoints",
+		ingressKind:        "Ingress",
+		serviceKind:        "Service",
+	}
+	typePrefix := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: "service.localhost.pomerium.io",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{Path: "/", PathType: &typePrefix, Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{Name: "foo", Port: networkingv1.ServiceBackendPort{Name: "http"}},
+					}}},
+				}},
+			}},
+		},
+	}
+	name := types.NamespacedName{Name: "foo", Namespace: "default"}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	mc.EXPECT().Get(ctx, name, gomock.AssignableToTypeOf(service)).Times(2).DoAndReturn(
+		func(_ context.Context, _ types.NamespacedName, obj client.Object) error {
+			service.DeepCopyInto(obj.(*corev1.Service))
+			return nil
+		})
+	mc.EXPECT().Get(ctx, name, gomock.AssignableToTypeOf(&corev1.Endpoints{})).Times(2).Return(nil)
+
+	upsert := func() {
+		t.Helper()
+		ic, err := ctrl.fetchIngress(ctx, ingress)
+		require.NoError(t, err)
+		_, err = ctrl.upsertIngress(ctx, ic)
+		require.NoError(t, err)
+	}
+	upsert()
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, reasonPomeriumConfigUpdated)
+	}
+
+	service.Spec.Ports[0].Name = "web"
+	reqs := ctrl.getDependantIngressFn(ctrl.serviceKind)(service)
+	if assert.Len(t, reqs, 1, "service update should trigger the ingress reconcile") {
+		assert.Equal(t, types.NamespacedName{Name: "ingress", Namespace: "default"}, reqs[0].NamespacedName)
+	}
+	upsert()
+	if assert.Len(t, recorder.Events, 1) {
+		evt := <-recorder.Events
+		assert.Contains(t, evt, reasonPomeriumConfigPartialUpdate)
+		assert.Contains(t, evt, "port http not found on service default/foo; available: web")
+	}
+	unsynced, _, err := (&State{states: ctrl.syncStates, synced: 1}).UnsyncedIngresses(0)
+	require.NoError(t, err)
+	if assert.Len(t, unsynced, 1) {
+		assert.Equal(t, SyncPhaseError, unsynced[0].Phase)
+	}
+}
+
+// TestPermanentUpsertErrorDependencies checks the ingress that failed with a permanent error,
+// that is not retried, is reconciled again once the resources it depends on are fixed
+func TestPermanentUpsertErrorDependencies(t *testing.T) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	ctrl := ingressController{
+		Client:             NewMockClient(gomock.NewController(t)),
+		Scheme:             clientgoscheme.Scheme,
+		Registry:           model.NewRegistry(),
+		EventRecorder:      recorder,
+		PomeriumReconciler: &failingReconciler{err: model.NewPermanentError(errors.New("tls.crt: invalid certificate"))},
+		syncStates:         newSyncStates(),
+		ingressKind:        "Ingress",
+		secretKind:         "Secret",
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"}}
+	ic := NewTestIngressConfig("ingress", nil)
+	ic.Secrets = map[types.NamespacedName]*corev1.Secret{{Name: "secret", Namespace: "default"}: secret}
+
+	res, err := ctrl.upsertIngress(ctx, ic)
+	require.NoError(t, err, "permanent errors are not retried")
+	assert.Equal(t, reconcile.Result{}, res)
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, reasonPomeriumConfigUpdateError)
+	}
+
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "ingress", Namespace: "default"}}},
+		ctrl.getDependantIngressFn(ctrl.secretKind)(secret), "secret update should trigger the ingress reconcile")
+}
+
+func TestAnnotationSyncStateWriter(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	w, err := newSyncStateWriter(SyncStateWriterAnnotation, mc, DefaultAnnotationPrefix)
+	require.NoError(t, err)
+	key := DefaultAnnotationPrefix + "/" + model.SyncStateAnnotation
+	generationKey := DefaultAnnotationPrefix + "/" + model.LastAppliedGeneration
+	errorKey := DefaultAnnotationPrefix + "/" + model.ReconcileError
+
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"}}
+	state := IngressSyncState{Phase: SyncPhaseError, Message: "failed", LastTransitionTime: time.Unix(1600000000, 0)}
+
+	var patched *networkingv1.Ingress
+	mc.EXPECT().Patch(ctx, gomock.AssignableToTypeOf(ingress), gomock.Any()).
+		DoAndReturn(func(_ context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+			patched = obj.(*networkingv1.Ingress)
+			return nil
+		}).Times(3)
+
+	require.NoError(t, w.Write(ctx, ingress, state))
+	if assert.NotNil(t, patched) {
+		assert.JSONEq(t, `{"phase":"Error","lastTransitionTime":"2020-09-13T12:26:40Z","message":"failed"}`,
+			patched.Annotations[key])
+		assert.Equal(t, "failed", patched.Annotations[errorKey])
+		assert.NotContains(t, patched.Annotations, generationKey)
+	}
+	assert.Equal(t, patched.Annotations, ingress.Annotations, "ingress should be updated after patch")
+
+	// no changes
+	require.NoError(t, w.Write(ctx, ingress, state))
+
+	ingress.Generation = 3
+	state = IngressSyncState{Phase: SyncPhaseSynced, LastTransitionTime: time.Unix(1600000000, 0)}
+	require.NoError(t, w.Write(ctx, ingress, state))
+	assert.Equal(t, "3", patched.Annotations[generationKey])
+	assert.NotContains(t, patched.Annotations, errorKey, "error should be cleared once synced")
+
+	require.NoError(t, w.Remove(ctx, ingress))
+	assert.NotContains(t, patched.Annotations, key)
+	assert.NotContains(t, patched.Annotations, generationKey)
+	require.NoError(t, w.Remove(ctx, ingress), "already removed")
+
+	_, err = newSyncStateWriter("conditions", mc, DefaultAnnotationPrefix)
+	assert.Error(t, err)
+}
+
+func TestIgnoreControllerAnnotationUpdates(t *testing.T) {
+	p := ignoreControllerAnnotationUpdates(DefaultAnnotationPrefix)
+	prev := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{
+		Name: "ingress", Namespace: "default", ResourceVersion: "1", Generation: 1,
+	}}
+	update := func(fn func(*networkingv1.Ingress)) bool {
+		next := prev.DeepCopy()
+		next.ResourceVersion = "2"
+		fn(next)
+		return p.Update(event.UpdateEvent{ObjectOld: prev, ObjectNew: next})
+	}
+
+	assert.False(t, update(func(ingress *networkingv1.Ingress) {
+		ingress.Annotations = map[string]string{
+			DefaultAnnotationPrefix + "/" + model.SyncStateAnnotation:   "{}",
+			DefaultAnnotationPrefix + "/" + model.LastAppliedGeneration: "1",
+		}
+	}), "sync state recorded")
+	assert.True(t, update(func(ingress *networkingv1.Ingress) {
+		ingress.Annotations = map[string]string{DefaultAnnotationPrefix + "/" + model.SecureUpstream: "true"}
+	}), "annotation")
+	assert.True(t, update(func(ingress *networkingv1.Ingress) {
+		ingress.Generation = 2
+		ingress.Spec.IngressClassName = proto.String("pomerium")
+	}), "spec")
+	assert.True(t, update(func(ingress *networkingv1.Ingress) {
+		ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	}), "status")
+	assert.True(t, p.Create(event.CreateEvent{Object: prev}))
+}
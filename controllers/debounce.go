@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// BatchReconciler applies the updates of several ingresses in a single pomerium config update
+type BatchReconciler interface {
+	// Batch applies the deletes and then the upserts. the ingresses that could not be applied are skipped,
+	// and their errors returned by name, along with model.RouteErrors of the ingresses applied partially.
+	// err is returned if the config could not be updated, in which case none of them were applied
+	Batch(ctx context.Context, upserts []*model.IngressConfig, deletes []types.NamespacedName) (
+		changed bool, ingressErrs map[types.NamespacedName]error, err error)
+}
+
+// debouncedReconciler coalesces the ingress upserts and deletes made within the window into a single batch,
+// so that a burst of ingress changes results in a single databroker update.
+// each call waits for its batch to be applied and returns the result for its ingress,
+// hence only the ingresses reconciled concurrently are coalesced
+type debouncedReconciler struct {
+	PomeriumReconciler
+	target BatchReconciler
+	window time.Duration
+
+	mu      sync.Mutex
+	pending *debounceBatch
+	// applyMu serializes the batches and Set
+	applyMu sync.Mutex
+}
+
+// debounceBatch keeps the last operation of each ingress, so that a delete coalesced with an upsert
+// of the same ingress is applied in the order the calls were made
+type debounceBatch struct {
+	upserts map[types.NamespacedName]*model.IngressConfig
+	deletes map[types.NamespacedName]bool
+	// logCtx carries the logger of the first call
+	logCtx context.Context
+
+	once        sync.Once
+	done        chan struct{}
+	changed     bool
+	ingressErrs map[types.NamespacedName]error
+	err         error
+}
+
+func newDebouncedReconciler(pcr PomeriumReconciler, window time.Duration) (*debouncedReconciler, error) {
+	target, ok := pcr.(BatchReconciler)
+	if !ok {
+		return nil, fmt.Errorf("databroker debounce: %T does not implement BatchReconciler", pcr)
+	}
+	return &debouncedReconciler{PomeriumReconciler: pcr, target: target, window: window}, nil
+}
+
+// Upsert implements PomeriumReconciler
+func (r *debouncedReconciler) Upsert(ctx context.Context, ic *model.IngressConfig) (bool, error) {
+	name := ic.GetIngressNamespacedName()
+	b := r.enqueue(ctx, func(b *debounceBatch) {
+		delete(b.deletes, name)
+		b.upserts[name] = ic
+	})
+	if err := r.wait(ctx, b); err != nil {
+		return false, err
+	}
+	if err := b.ingressErrs[name]; err != nil {
+		var routeErrs model.RouteErrors
+		if errors.As(err, &routeErrs) {
+			return b.changed, err
+		}
+		return false, err
+	}
+	return b.changed, nil
+}
+
+// Delete implements PomeriumReconciler
+func (r *debouncedReconciler) Delete(ctx context.Context, name types.NamespacedName) error {
+	b := r.enqueue(ctx, func(b *debounceBatch) {
+		delete(b.upserts, name)
+		b.deletes[name] = true
+	})
+	if err := r.wait(ctx, b); err != nil {
+		return err
+	}
+	return b.ingressErrs[name]
+}
+
+// Set implements PomeriumReconciler. the pending batch is applied first, so that it does not override the full sync
+func (r *debouncedReconciler) Set(ctx context.Context, ics []*model.IngressConfig) (bool, error) {
+	r.mu.Lock()
+	b := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	if b != nil {
+		r.apply(b)
+	}
+
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+
+	return r.PomeriumReconciler.Set(ctx, ics)
+}
+
+// enqueue adds the operation to the pending batch, that is started if there is none
+func (r *debouncedReconciler) enqueue(ctx context.Context, fn func(*debounceBatch)) *debounceBatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b := r.pending
+	if b == nil {
+		b = &debounceBatch{
+			upserts: make(map[types.NamespacedName]*model.IngressConfig),
+			deletes: make(map[types.NamespacedName]bool),
+			logCtx:  log.IntoContext(context.Background(), log.FromContext(ctx)),
+			done:    make(chan struct{}),
+		}
+		r.pending = b
+		time.AfterFunc(r.window, func() {
+			r.mu.Lock()
+			if r.pending == b {
+				r.pending = nil
+			}
+			r.mu.Unlock()
+			r.apply(b)
+		})
+	}
+	fn(b)
+	return b
+}
+
+// wait returns once the batch is applied, or the error the batch as a whole failed with.
+// the batch is still applied if the context is canceled meanwhile
+func (r *debouncedReconciler) wait(ctx context.Context, b *debounceBatch) error {
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *debouncedReconciler) apply(b *debounceBatch) {
+	b.once.Do(func() {
+		defer close(b.done)
+
+		r.applyMu.Lock()
+		defer r.applyMu.Unlock()
+
+		upserts := make([]*model.IngressConfig, 0, len(b.upserts))
+		for _, ic := range b.upserts {
+			upserts = append(upserts, ic)
+		}
+		// so that the ingresses are applied in the same order the initial sync would
+		sort.Slice(upserts, func(i, j int) bool {
+			return upserts[i].GetIngressNamespacedName().String() < upserts[j].GetIngressNamespacedName().String()
+		})
+		deletes := make([]types.NamespacedName, 0, len(b.deletes))
+		for name := range b.deletes {
+			deletes = append(deletes, name)
+		}
+
+		log.FromContext(b.logCtx).V(1).Info("applying debounced updates", "upserts", len(upserts), "deletes", len(deletes))
+		b.changed, b.ingressErrs, b.err = r.target.Batch(b.logCtx, upserts, deletes)
+	})
+}
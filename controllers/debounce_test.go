@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// batchReconciler keeps the revisions of the applied ingresses, and counts the batches
+type batchReconciler struct {
+	PomeriumReconciler
+
+	mu      sync.Mutex
+	batches int
+	applied map[types.NamespacedName]uint64
+	// fail if set, is the ingress the batches fail to apply
+	fail types.NamespacedName
+}
+
+func (r *batchReconciler) Batch(_ context.Context, upserts []*model.IngressConfig, deletes []types.NamespacedName) (
+	bool, map[types.NamespacedName]error, error,
+) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches++
+	ingressErrs := make(map[types.NamespacedName]error)
+	for _, name := range deletes {
+		delete(r.applied, name)
+	}
+	for _, ic := range upserts {
+		name := ic.GetIngressNamespacedName()
+		if name == r.fail {
+			ingressErrs[name] = model.NewPermanentError(fmt.Errorf("invalid"))
+			continue
+		}
+		r.applied[name] = ic.Revision
+	}
+	return true, ingressErrs, nil
+}
+
+func (r *batchReconciler) snapshot() (int, map[types.NamespacedName]uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	applied := make(map[types.NamespacedName]uint64, len(r.applied))
+	for k, v := range r.applied {
+		applied[k] = v
+	}
+	return r.batches, applied
+}
+
+func TestDebouncedReconciler(t *testing.T) {
+	ctx := context.Background()
+	target := &batchReconciler{applied: map[types.NamespacedName]uint64{
+		{Namespace: "default", Name: "deleted-0"}: 1,
+		{Namespace: "default", Name: "deleted-1"}: 1,
+	}}
+	r, err := newDebouncedReconciler(target, time.Millisecond*100)
+	require.NoError(t, err)
+	upsert := func(name string, revision uint64) (bool, error) {
+		ic := NewTestIngressConfig(name, nil)
+		ic.Revision = revision
+		return r.Upsert(ctx, ic)
+	}
+
+	// a burst of concurrent reconciles, i.e. a release touching many ingresses
+	var eg errgroup.Group
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("ingress-%d", i)
+		eg.Go(func() error {
+			changed, err := upsert(name, 1)
+			if err == nil && !changed {
+				err = fmt.Errorf("%s: expected changes", name)
+			}
+			return err
+		})
+	}
+	for i := 0; i < 2; i++ {
+		name := types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("deleted-%d", i)}
+		eg.Go(func() error { return r.Delete(ctx, name) })
+	}
+	require.NoError(t, eg.Wait())
+
+	batches, applied := target.snapshot()
+	assert.Less(t, batches, 5, "updates should be coalesced")
+	assert.Len(t, applied, 50)
+	for i := 0; i < 50; i++ {
+		assert.Contains(t, applied, types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("ingress-%d", i)})
+	}
+
+	// the last operation of the ingress within the window takes effect
+	eg = errgroup.Group{}
+	name := types.NamespacedName{Namespace: "default", Name: "ingress-0"}
+	eg.Go(func() error {
+		_, err := upsert("ingress-0", 2)
+		return err
+	})
+	time.Sleep(time.Millisecond * 10)
+	eg.Go(func() error { return r.Delete(ctx, name) })
+	time.Sleep(time.Millisecond * 10)
+	eg.Go(func() error {
+		_, err := upsert("ingress-1", 2)
+		return err
+	})
+	require.NoError(t, eg.Wait())
+	_, applied = target.snapshot()
+	assert.NotContains(t, applied, name, "delete should not be lost")
+	assert.Equal(t, uint64(2), applied[types.NamespacedName{Namespace: "default", Name: "ingress-1"}])
+
+	// the ingress errors are returned to their callers only
+	target.fail = types.NamespacedName{Namespace: "default", Name: "invalid"}
+	eg = errgroup.Group{}
+	var invalidErr error
+	eg.Go(func() error {
+		_, invalidErr = upsert("invalid", 3)
+		return nil
+	})
+	eg.Go(func() error {
+		_, err := upsert("ingress-2", 3)
+		return err
+	})
+	require.NoError(t, eg.Wait())
+	assert.True(t, model.IsPermanentError(invalidErr), invalidErr)
+}
+
+func TestDebouncedReconcilerRequiresBatch(t *testing.T) {
+	_, err := newDebouncedReconciler(new(WarmStandby), time.Second)
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// DependencyState describes whether an ingress dependency could be found
+type DependencyState string
+
+const (
+	// DependencyResolved indicates the dependency exists
+	DependencyResolved DependencyState = "Resolved"
+	// DependencyMissing indicates the dependency was not found
+	DependencyMissing DependencyState = "Missing"
+	// DependencyError indicates the dependency could not be looked up
+	DependencyError DependencyState = "Error"
+)
+
+// DependencyGraph is a snapshot of the dependencies between the managed ingresses
+// and the objects they reference
+type DependencyGraph struct {
+	// Objects lists the referenced objects along with the ingresses that reference them
+	Objects []ObjectDependants `json:"objects"`
+	// Ingresses lists the managed ingresses along with their dependencies
+	Ingresses []IngressDependencies `json:"ingresses"`
+}
+
+// ObjectDependants is an object referenced by the managed ingresses
+type ObjectDependants struct {
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name"`
+	Ingresses []string `json:"ingresses"`
+}
+
+// IngressDependencies is a managed ingress and the objects it references
+type IngressDependencies struct {
+	Name         string       `json:"name"`
+	Dependencies []Dependency `json:"dependencies"`
+	// Warnings found by the last translation of the ingress
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Dependency is an object referenced by an ingress, and its resolution state
+type Dependency struct {
+	Kind    string          `json:"kind"`
+	Name    string          `json:"name"`
+	State   DependencyState `json:"state"`
+	Message string          `json:"message,omitempty"`
+}
+
+// Dependencies returns the current dependency graph of the managed ingresses,
+// with each dependency resolved against the controller cache
+func (s *State) Dependencies(ctx context.Context) (*DependencyGraph, error) {
+	if !s.isSynced() {
+		return nil, ErrNotReady
+	}
+	return buildDependencyGraph(ctx, s.reader, s.scheme, s.registry, s.ingressKind, s.states.snapshot()), nil
+}
+
+func buildDependencyGraph(
+	ctx context.Context,
+	reader client.Reader,
+	scheme *runtime.Scheme,
+	registry model.Registry,
+	ingressKind string,
+	managed map[types.NamespacedName]IngressSyncState,
+) *DependencyGraph {
+	g := &DependencyGraph{
+		Objects:   []ObjectDependants{},
+		Ingresses: []IngressDependencies{},
+	}
+	states := make(map[model.Key]Dependency)
+
+	for _, key := range registry.Keys() {
+		if key.Kind == ingressKind {
+			continue
+		}
+		var ingresses []string
+		for _, k := range registry.DepsOfKind(key, ingressKind) {
+			ingresses = append(ingresses, k.NamespacedName.String())
+		}
+		sort.Strings(ingresses)
+		g.Objects = append(g.Objects, ObjectDependants{
+			Kind:      key.Kind,
+			Name:      key.NamespacedName.String(),
+			Ingresses: ingresses,
+		})
+		states[key] = resolveDependency(ctx, reader, scheme, key)
+	}
+
+	for name, state := range managed {
+		deps := []Dependency{}
+		for _, k := range registry.Deps(model.Key{Kind: ingressKind, NamespacedName: name}) {
+			deps = append(deps, states[k])
+		}
+		sort.Slice(deps, func(i, j int) bool { return lessDependency(deps[i].Kind, deps[i].Name, deps[j].Kind, deps[j].Name) })
+		g.Ingresses = append(g.Ingresses, IngressDependencies{Name: name.String(), Dependencies: deps, Warnings: state.Warnings})
+	}
+
+	sort.Slice(g.Objects, func(i, j int) bool {
+		return lessDependency(g.Objects[i].Kind, g.Objects[i].Name, g.Objects[j].Kind, g.Objects[j].Name)
+	})
+	sort.Slice(g.Ingresses, func(i, j int) bool { return g.Ingresses[i].Name < g.Ingresses[j].Name })
+	return g
+}
+
+func lessDependency(kindA, nameA, kindB, nameB string) bool {
+	if kindA != kindB {
+		return kindA < kindB
+	}
+	return nameA < nameB
+}
+
+// resolveDependency looks up the object in the cache.
+// all dependency kinds tracked by the controller are core v1 objects
+func resolveDependency(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, key model.Key) Dependency {
+	dep := Dependency{Kind: key.Kind, Name: key.NamespacedName.String()}
+
+	gv := corev1.SchemeGroupVersion
+	if key.Kind == ingressParametersKind {
+		gv = v1alpha1.GroupVersion
+	}
+	ro, err := scheme.New(gv.WithKind(key.Kind))
+	if err != nil {
+		dep.State, dep.Message = DependencyError, err.Error()
+		return dep
+	}
+	obj, ok := ro.(client.Object)
+	if !ok {
+		dep.State, dep.Message = DependencyError, fmt.Sprintf("%T is not an object", ro)
+		return dep
+	}
+
+	err = reader.Get(ctx, key.NamespacedName, obj)
+	switch {
+	case err == nil:
+		dep.State = DependencyResolved
+	case apierrors.IsNotFound(err):
+		dep.State = DependencyMissing
+	default:
+		dep.State, dep.Message = DependencyError, err.Error()
+	}
+	return dep
+}
+
+// WriteDOT writes the graph in the graphviz DOT format, with the unresolved dependencies highlighted
+func (g *DependencyGraph) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph dependencies {"); err != nil {
+		return err
+	}
+	for _, ing := range g.Ingresses {
+		ingNode := fmt.Sprintf("Ingress %s", ing.Name)
+		if _, err := fmt.Fprintf(w, "\t%q [shape=box];\n", ingNode); err != nil {
+			return err
+		}
+		for _, dep := range ing.Dependencies {
+			depNode := fmt.Sprintf("%s %s", dep.Kind, dep.Name)
+			if _, err := fmt.Fprintf(w, "\t%q -> %q;\n", ingNode, depNode); err != nil {
+				return err
+			}
+			if dep.State == DependencyResolved {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "\t%q [color=red, tooltip=%q];\n", depNode, dep.State); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
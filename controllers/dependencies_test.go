@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"}},
+	).Build()
+
+	ingA := types.NamespacedName{Name: "a", Namespace: "default"}
+	ingB := types.NamespacedName{Name: "b", Namespace: "default"}
+	secret := model.Key{Kind: "Secret", NamespacedName: types.NamespacedName{Name: "secret", Namespace: "default"}}
+	service := model.Key{Kind: "Service", NamespacedName: types.NamespacedName{Name: "service", Namespace: "default"}}
+	configMap := model.Key{Kind: "ConfigMap", NamespacedName: types.NamespacedName{Name: "policy", Namespace: "default"}}
+
+	registry := model.NewRegistry()
+	registry.Add(model.Key{Kind: "Ingress", NamespacedName: ingA}, secret)
+	registry.Add(model.Key{Kind: "Ingress", NamespacedName: ingA}, service)
+	registry.Add(model.Key{Kind: "Ingress", NamespacedName: ingB}, secret)
+	registry.Add(model.Key{Kind: "Ingress", NamespacedName: ingB}, configMap)
+
+	g := buildDependencyGraph(context.Background(), reader, scheme, registry, "Ingress",
+		map[types.NamespacedName]IngressSyncState{ingA: {}, ingB: {Warnings: []string{"Reason: message"}}})
+
+	assert.Equal(t, []ObjectDependants{
+		{Kind: "ConfigMap", Name: "default/policy", Ingresses: []string{"default/b"}},
+		{Kind: "Secret", Name: "default/secret", Ingresses: []string{"default/a", "default/b"}},
+		{Kind: "Service", Name: "default/service", Ingresses: []string{"default/a"}},
+	}, g.Objects)
+	assert.Equal(t, []IngressDependencies{
+		{Name: "default/a", Dependencies: []Dependency{
+			{Kind: "Secret", Name: "default/secret", State: DependencyResolved},
+			{Kind: "Service", Name: "default/service", State: DependencyResolved},
+		}},
+		{Name: "default/b", Dependencies: []Dependency{
+			{Kind: "ConfigMap", Name: "default/policy", State: DependencyMissing},
+			{Kind: "Secret", Name: "default/secret", State: DependencyResolved},
+		}, Warnings: []string{"Reason: message"}},
+	}, g.Ingresses)
+
+	var buf bytes.Buffer
+	require.NoError(t, g.WriteDOT(&buf))
+	assert.Contains(t, buf.String(), `"Ingress default/a" -> "Secret default/secret";`)
+	assert.Contains(t, buf.String(), `"ConfigMap default/policy" [color=red, tooltip="Missing"];`)
+}
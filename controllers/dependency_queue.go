@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	// DefaultDependencyReconcileWindow is the default number of the reconciles triggered by the dependency updates,
+	// that may be waiting in the controller queue at a time
+	DefaultDependencyReconcileWindow = 10
+	// DefaultDependencyReconcileMaxWait is the default time after which a reconcile triggered by a dependency update
+	// is queued regardless of the window
+	DefaultDependencyReconcileMaxWait = time.Second * 30
+)
+
+// dependencyQueue is the lower priority tier of the reconcile queue, that holds the reconciles triggered by
+// the ingress dependency updates, i.e. endpoints churn. It only releases a window of them into the controller queue
+// at a time, so that the new ingresses and deletes, queued directly, are not stuck behind hundreds of no-op updates.
+// the reconciles waiting longer than maxWait are released regardless of the window, so that they are never starved.
+// It is safe for concurrent use.
+type dependencyQueue struct {
+	mu      sync.Mutex
+	window  int
+	maxWait time.Duration
+	now     func() time.Time
+
+	// pending are the ingresses waiting to be released, in the order they were added
+	pending []types.NamespacedName
+	added   map[types.NamespacedName]time.Time
+	// released are the ingresses sent to the controller queue, whose reconcile has not started yet
+	released map[types.NamespacedName]bool
+
+	wake chan struct{}
+	out  chan event.GenericEvent
+}
+
+func newDependencyQueue(window int, maxWait time.Duration) *dependencyQueue {
+	return &dependencyQueue{
+		window:   window,
+		maxWait:  maxWait,
+		now:      time.Now,
+		added:    make(map[types.NamespacedName]time.Time),
+		released: make(map[types.NamespacedName]bool),
+		wake:     make(chan struct{}, 1),
+		out:      make(chan event.GenericEvent),
+	}
+}
+
+// add queues the ingresses for reconciliation. the ingresses already queued are skipped,
+// as their reconcile has not started yet and would see the update anyway
+func (q *dependencyQueue) add(names []types.NamespacedName) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	for _, name := range names {
+		if q.released[name] {
+			continue
+		}
+		if _, ok := q.added[name]; ok {
+			continue
+		}
+		q.pending = append(q.pending, name)
+		q.added[name] = now
+	}
+	dependencyReconcilesPending.Set(float64(len(q.pending)))
+	q.signal()
+}
+
+// started frees the window slot of the ingress, once its reconcile starts
+func (q *dependencyQueue) started(name types.NamespacedName) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.released[name] {
+		return
+	}
+	delete(q.released, name)
+	if len(q.pending) > 0 {
+		q.signal()
+	}
+}
+
+func (q *dependencyQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the ingresses that may be released now, and how long until the oldest remaining one ages
+func (q *dependencyQueue) next() ([]types.NamespacedName, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	var names []types.NamespacedName
+	for len(q.pending) > 0 {
+		name := q.pending[0]
+		// the pending ingresses are ordered by age, so if the first is not aged, neither are the rest
+		aged := now.Sub(q.added[name]) >= q.maxWait
+		if len(q.released) >= q.window && !aged {
+			break
+		}
+		q.pending = q.pending[1:]
+		delete(q.added, name)
+		q.released[name] = true
+		names = append(names, name)
+	}
+	dependencyReconcilesPending.Set(float64(len(q.pending)))
+
+	if len(q.pending) == 0 {
+		return names, q.maxWait
+	}
+	return names, q.maxWait - now.Sub(q.added[q.pending[0]])
+}
+
+// Start implements manager.Runnable, releasing the queued ingresses into the controller queue
+func (q *dependencyQueue) Start(ctx context.Context) error {
+	for {
+		names, wait := q.next()
+		for _, name := range names {
+			select {
+			case q.out <- event.GenericEvent{Object: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace},
+			}}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+		timer.Stop()
+	}
+}
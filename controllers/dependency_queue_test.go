@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDependencyQueue(t *testing.T) {
+	now := time.Now()
+	q := newDependencyQueue(2, time.Minute)
+	q.now = func() time.Time { return now }
+	a, b, c := types.NamespacedName{Name: "a"}, types.NamespacedName{Name: "b"}, types.NamespacedName{Name: "c"}
+
+	q.add([]types.NamespacedName{a, b, c, a})
+	names, wait := q.next()
+	assert.Equal(t, []types.NamespacedName{a, b}, names, "only the window should be released")
+	assert.Equal(t, time.Minute, wait)
+
+	q.add([]types.NamespacedName{b})
+	names, _ = q.next()
+	assert.Empty(t, names, "released ingresses should not be queued again before their reconcile starts")
+
+	q.started(a)
+	names, _ = q.next()
+	assert.Equal(t, []types.NamespacedName{c}, names)
+
+	q.started(b)
+	q.add([]types.NamespacedName{b, a})
+	names, _ = q.next()
+	assert.Equal(t, []types.NamespacedName{b}, names)
+
+	now = now.Add(time.Second * 50)
+	names, wait = q.next()
+	assert.Empty(t, names)
+	assert.Equal(t, time.Second*10, wait, "wait should be until the oldest pending ingress ages")
+
+	now = now.Add(time.Second * 10)
+	names, _ = q.next()
+	assert.Equal(t, []types.NamespacedName{a}, names, "aged ingresses should be released regardless of the window")
+}
+
+// TestDependencyQueueNewIngressLatency checks a new ingress is reconciled quickly,
+// while the queue is saturated with the reconciles triggered by the endpoints updates
+func TestDependencyQueueNewIngressLatency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const window, churn = 5, 200
+	q := newDependencyQueue(window, time.Minute)
+	wq := workqueue.New()
+	defer wq.ShutDown()
+
+	go func() { _ = q.Start(ctx) }()
+	go func() {
+		for evt := range q.out {
+			wq.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+				Name: evt.Object.GetName(), Namespace: evt.Object.GetNamespace(),
+			}})
+		}
+	}()
+
+	newIngress := types.NamespacedName{Namespace: "default", Name: "new"}
+	var churned []types.NamespacedName
+	for i := 0; i < churn; i++ {
+		churned = append(churned, types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("ingress-%d", i)})
+	}
+	q.add(churned)
+
+	processed := make(chan types.NamespacedName)
+	go func() {
+		for {
+			item, shutdown := wq.Get()
+			if shutdown {
+				return
+			}
+			name := item.(reconcile.Request).NamespacedName
+			q.started(name)
+			time.Sleep(time.Millisecond)
+			processed <- name
+			wq.Done(item)
+		}
+	}()
+
+	before := 0
+	for i := 0; i < window; i++ {
+		<-processed
+		before++
+	}
+	// a new ingress is queued directly by the ingress watch
+	wq.Add(reconcile.Request{NamespacedName: newIngress})
+	ahead := 0
+	for name := range processed {
+		before++
+		if name == newIngress {
+			break
+		}
+		ahead++
+	}
+	assert.LessOrEqual(t, ahead, window+1, "new ingress should only wait for the released window")
+
+	// the dependency updates are not starved
+	timeout := time.After(time.Second * 10)
+	for ; before <= churn; before++ {
+		select {
+		case <-processed:
+		case <-timeout:
+			require.FailNow(t, "dependency updates were not processed", "processed %d of %d", before-1, churn)
+		}
+	}
+}
@@ -37,15 +37,21 @@ func (r *ingressController) updateDependencies(ic *model.IngressConfig) {
 	for _, s := range ic.Secrets {
 		r.Add(ingKey, r.objectKey(s))
 	}
+	for _, cm := range ic.ConfigMaps {
+		r.Add(ingKey, r.objectKey(cm))
+	}
 	for _, s := range ic.Services {
-		k := r.objectKey(s)
-		r.Add(ingKey, k)
-		k.Kind = r.endpointsKind
-		r.Add(ingKey, k)
+		r.Add(ingKey, r.objectKey(s))
+	}
+	for name := range ic.Endpoints {
+		r.Add(ingKey, model.Key{Kind: r.endpointsDependencyKind(), NamespacedName: name})
 	}
 
-	if r.updateStatusFromService != nil {
-		r.Add(ingKey, model.Key{NamespacedName: *r.updateStatusFromService, Kind: r.serviceKind})
+	if name := r.statusService(ic.Ingress); name != nil {
+		r.Add(ingKey, model.Key{NamespacedName: *name, Kind: r.serviceKind})
+	}
+	if ic.ClassParameters != nil {
+		r.Add(ingKey, model.Key{NamespacedName: *ic.ClassParameters, Kind: r.ingressParametersKind})
 	}
 }
 
@@ -55,17 +61,28 @@ func (r *ingressController) getDependantIngressFn(kind string) func(a client.Obj
 	logger := log.FromContext(context.Background()).WithValues("kind", kind)
 
 	return func(a client.Object) []reconcile.Request {
-		if !r.isWatching(a) {
-			return nil
-		}
-
 		name := types.NamespacedName{Name: a.GetName(), Namespace: a.GetNamespace()}
 		deps := r.DepsOfKind(model.Key{Kind: kind, NamespacedName: name}, r.ingressKind)
+		// the default certificate secret the ingress class refers to is often kept outside of the watched namespaces,
+		// i.e. along with pomerium, and should still be tracked for the ingresses that rely on it
+		if !r.isWatching(a) && (kind != r.secretKind || len(deps) == 0) {
+			return nil
+		}
 		reqs := make([]reconcile.Request, 0, len(deps))
 		for _, k := range deps {
 			reqs = append(reqs, reconcile.Request{NamespacedName: k.NamespacedName})
 		}
 		logger.V(1).Info("watch", "name", fmt.Sprintf("%s/%s", a.GetNamespace(), a.GetName()), "deps", reqs)
+		if r.dependencyQueue != nil {
+			names := make([]types.NamespacedName, 0, len(reqs))
+			for _, req := range reqs {
+				names = append(names, req.NamespacedName)
+				// the event is recorded as it arrives, rather than once released from the queue
+				r.eventTimes.observe(req.NamespacedName)
+			}
+			r.dependencyQueue.add(names)
+			return nil
+		}
 		return reqs
 	}
 }
@@ -87,6 +104,7 @@ func (r *ingressController) watchIngressClass(string) func(a client.Object) []re
 		if ic.Spec.Controller != r.controllerName {
 			return nil
 		}
+		r.reportClassParameters(ic)
 		il := new(networkingv1.IngressList)
 		err := r.Client.List(ctx, il)
 		if err != nil {
@@ -106,3 +124,29 @@ func (r *ingressController) watchIngressClass(string) func(a client.Object) []re
 		return deps
 	}
 }
+
+// watchNamespace returns the managed ingresses of a namespace that is being deleted,
+// so that they are removed from pomerium without waiting for the ingresses to be garbage collected,
+// or the ingresses of a namespace whose labels started or stopped matching the namespace selector
+func (r *ingressController) watchNamespace(string) func(a client.Object) []reconcile.Request {
+	ctx := context.Background()
+	logger := log.FromContext(ctx)
+
+	return func(a client.Object) []reconcile.Request {
+		if a.GetDeletionTimestamp() == nil {
+			return r.watchNamespaceSelector(ctx, a)
+		}
+		if !r.isWatchingNamespace(a.GetName()) {
+			return nil
+		}
+
+		var deps []reconcile.Request
+		for name := range r.syncStates.snapshot() {
+			if name.Namespace == a.GetName() {
+				deps = append(deps, reconcile.Request{NamespacedName: name})
+			}
+		}
+		logger.Info("namespace deleted", "namespace", a.GetName(), "deps", deps)
+		return deps
+	}
+}
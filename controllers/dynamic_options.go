@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// OptionsConfigMapNamespaces is the options config map key holding a comma separated list of namespaces to watch,
+	// empty to watch all namespaces
+	OptionsConfigMapNamespaces = "namespaces"
+	// OptionsConfigMapRequiredLabels is the options config map key holding the labels, in key=value,key=value format,
+	// the ingresses must have in order to be managed
+	OptionsConfigMapRequiredLabels = "required-labels"
+
+	// reasonInvalidOptions is reported on the options config map that could not be applied
+	reasonInvalidOptions = "InvalidOptions"
+	// reasonOptionIgnored is reported on the options config map holding the options that may not change at runtime
+	reasonOptionIgnored = "OptionIgnored"
+)
+
+// scope selects the ingresses the controller manages in addition to the ingress class
+type scope struct {
+	// namespaces to watch, nil to watch all namespaces
+	namespaces map[string]bool
+	// requiredLabels the ingresses must have, nil to manage regardless of labels
+	requiredLabels labels.Selector
+}
+
+// includes checks the ingress is within the scope
+func (s scope) includes(ing *networkingv1.Ingress) bool {
+	if len(s.namespaces) > 0 && !s.namespaces[ing.Namespace] {
+		return false
+	}
+	return s.requiredLabels == nil || s.requiredLabels.Matches(labels.Set(ing.Labels))
+}
+
+// parseScope parses the options config map data, and returns the keys that are not supported at runtime
+func parseScope(data map[string]string) (scope, []string, error) {
+	var s scope
+	var ignored []string
+	for key, val := range data {
+		switch key {
+		case OptionsConfigMapNamespaces:
+			for _, ns := range strings.Split(val, ",") {
+				if ns = strings.TrimSpace(ns); ns == "" {
+					continue
+				}
+				if s.namespaces == nil {
+					s.namespaces = make(map[string]bool)
+				}
+				s.namespaces[ns] = true
+			}
+		case OptionsConfigMapRequiredLabels:
+			if strings.TrimSpace(val) == "" {
+				continue
+			}
+			set, err := labels.ConvertSelectorToLabelsMap(val)
+			if err != nil {
+				return scope{}, nil, fmt.Errorf("%s: %w", key, err)
+			}
+			if s.requiredLabels, err = labels.ValidatedSelectorFromSet(set); err != nil {
+				return scope{}, nil, fmt.Errorf("%s: %w", key, err)
+			}
+		default:
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+	return s, ignored, nil
+}
+
+// setScope replaces the scope, and returns the previous one
+func (r *ingressController) setScope(next scope) scope {
+	r.scopeMu.Lock()
+	defer r.scopeMu.Unlock()
+
+	prev := scope{namespaces: r.namespaces, requiredLabels: r.requiredLabels}
+	r.namespaces, r.requiredLabels = next.namespaces, next.requiredLabels
+	return prev
+}
+
+// watchOptionsConfigMap applies the options config map, and returns the ingresses that entered or left the scope,
+// so that the newly included ingresses are reconciled, and the routes of the excluded ones are deleted
+func (r *ingressController) watchOptionsConfigMap(a client.Object) []reconcile.Request {
+	if (types.NamespacedName{Namespace: a.GetNamespace(), Name: a.GetName()}) != *r.optionsConfigMap {
+		return nil
+	}
+	ctx := context.Background()
+	reqs, err := r.applyOptionsConfigMap(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "applying options config map", "configmap", r.optionsConfigMap.String())
+	}
+	return reqs
+}
+
+// applyOptionsConfigMap applies the scope from the options config map, or restores the scope set by the options
+// if the config map does not exist. an invalid config map is reported and leaves the scope unchanged
+func (r *ingressController) applyOptionsConfigMap(ctx context.Context) ([]reconcile.Request, error) {
+	if r.optionsConfigMap == nil {
+		return nil, nil
+	}
+	logger := log.FromContext(ctx).WithValues("configmap", r.optionsConfigMap.String())
+
+	next := r.flagScope
+	cm := new(corev1.ConfigMap)
+	if err := r.Client.Get(ctx, *r.optionsConfigMap, cm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		logger.V(1).Info("options config map not found, using the command line options")
+	} else {
+		var ignored []string
+		if next, ignored, err = parseScope(cm.Data); err != nil {
+			logger.Error(err, "invalid options, keeping the current ones")
+			r.EventRecorder.Event(cm, corev1.EventTypeWarning, reasonInvalidOptions, err.Error())
+			return nil, nil
+		}
+		if len(ignored) > 0 {
+			msg := fmt.Sprintf("%s may not be changed at runtime, restart the controller with the command line options instead. "+
+				"only %s and %s are applied", strings.Join(ignored, ", "), OptionsConfigMapNamespaces, OptionsConfigMapRequiredLabels)
+			logger.Info(msg)
+			r.EventRecorder.Event(cm, corev1.EventTypeWarning, reasonOptionIgnored, msg)
+		}
+	}
+
+	prev := r.setScope(next)
+	ingresses := new(networkingv1.IngressList)
+	if err := r.Client.List(ctx, ingresses); err != nil {
+		return nil, fmt.Errorf("list ingresses: %w", err)
+	}
+	var reqs []reconcile.Request
+	for i := range ingresses.Items {
+		ing := &ingresses.Items[i]
+		if prev.includes(ing) != next.includes(ing) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}})
+		}
+	}
+	if len(reqs) > 0 {
+		logger.Info("options changed", "ingresses", len(reqs))
+	}
+	return reqs, nil
+}
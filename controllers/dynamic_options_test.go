@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestParseScope(t *testing.T) {
+	s, ignored, err := parseScope(map[string]string{
+		OptionsConfigMapNamespaces:     "a, b,",
+		OptionsConfigMapRequiredLabels: "team=a,env=prod",
+		"class-name":                   "other",
+		"databroker-service-url":       "http://other",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, s.namespaces)
+	assert.Equal(t, "env=prod,team=a", s.requiredLabels.String())
+	assert.Equal(t, []string{"class-name", "databroker-service-url"}, ignored)
+
+	s, _, err = parseScope(map[string]string{OptionsConfigMapNamespaces: "", OptionsConfigMapRequiredLabels: ""})
+	require.NoError(t, err)
+	assert.Nil(t, s.namespaces, "all namespaces")
+	assert.Nil(t, s.requiredLabels)
+
+	_, _, err = parseScope(map[string]string{OptionsConfigMapRequiredLabels: "team"})
+	assert.Error(t, err)
+	_, _, err = parseScope(map[string]string{OptionsConfigMapRequiredLabels: "team=a b"})
+	assert.Error(t, err)
+}
+
+func TestOptionsConfigMap(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	recorder := record.NewFakeRecorder(10)
+	name := types.NamespacedName{Namespace: "pomerium", Name: "options"}
+	ctrl := newIngressController(WithNamespaces([]string{"a"}), WithOptionsConfigMap(name))
+	ctrl.Client = mc
+	ctrl.EventRecorder = recorder
+
+	ingress := func(ns string, l map[string]string) networkingv1.Ingress {
+		return networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "ingress", Labels: l}}
+	}
+	ingresses := []networkingv1.Ingress{
+		ingress("a", nil),
+		ingress("b", nil),
+		ingress("b", map[string]string{"team": "b"}),
+		ingress("c", nil),
+	}
+	ingresses[2].Name = "labeled"
+	mc.EXPECT().List(ctx, gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+			list.(*networkingv1.IngressList).Items = ingresses
+			return nil
+		})
+	var cm *corev1.ConfigMap
+	mc.EXPECT().Get(ctx, name, gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, _ types.NamespacedName, obj client.Object) error {
+			if cm == nil {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name.Name)
+			}
+			cm.DeepCopyInto(obj.(*corev1.ConfigMap))
+			return nil
+		})
+	apply := func() []reconcile.Request {
+		t.Helper()
+		reqs, err := ctrl.applyOptionsConfigMap(ctx)
+		require.NoError(t, err)
+		return reqs
+	}
+	req := func(ns, name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: ns, Name: name}}
+	}
+
+	assert.Empty(t, apply(), "no config map, the options apply")
+	assert.True(t, ctrl.isWatchingNamespace("a"))
+	assert.False(t, ctrl.isWatchingNamespace("b"))
+
+	cm = &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name},
+		Data:       map[string]string{OptionsConfigMapNamespaces: "b"},
+	}
+	assert.Equal(t, []reconcile.Request{req("a", "ingress"), req("b", "ingress"), req("b", "labeled")}, apply(),
+		"a left the scope, and b entered it")
+	assert.False(t, ctrl.isWatchingNamespace("a"))
+	assert.True(t, ctrl.isWatchingNamespace("b"))
+	assert.Empty(t, apply(), "unchanged")
+
+	cm.Data[OptionsConfigMapRequiredLabels] = "team=b"
+	cm.Data["class-name"] = "other"
+	assert.Equal(t, []reconcile.Request{req("b", "ingress")}, apply())
+	assert.True(t, ctrl.hasRequiredLabels(&ingresses[2]))
+	if assert.Len(t, recorder.Events, 1) {
+		evt := <-recorder.Events
+		assert.Contains(t, evt, reasonOptionIgnored)
+		assert.Contains(t, evt, "class-name may not be changed at runtime")
+	}
+
+	cm.Data[OptionsConfigMapRequiredLabels] = "team"
+	assert.Empty(t, apply())
+	assert.True(t, ctrl.hasRequiredLabels(&ingresses[2]), "invalid options should be ignored")
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, reasonInvalidOptions)
+	}
+
+	cm = nil
+	assert.Equal(t, []reconcile.Request{req("a", "ingress"), req("b", "labeled")}, apply(),
+		"the options should be restored once the config map is deleted")
+	assert.True(t, ctrl.isWatchingNamespace("a"))
+	assert.True(t, ctrl.hasRequiredLabels(&ingresses[0]))
+
+	assert.Nil(t, ctrl.watchOptionsConfigMap(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "other"}}),
+		"other config maps should be ignored")
+}
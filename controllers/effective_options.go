@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"sort"
+	"time"
+)
+
+// EffectiveOptions describes the ingress controller configuration resulting from the defaults and the options,
+// for troubleshooting purposes
+type EffectiveOptions struct {
+	ControllerName          string `json:"controllerName"`
+	AnnotationPrefix        string `json:"annotationPrefix"`
+	ServiceAnnotationPrefix string `json:"serviceAnnotationPrefix,omitempty"`
+	// Namespaces being watched, empty if all namespaces are watched
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ExcludedNamespaces are the glob patterns of the namespaces that are never watched
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// NamespaceSelector is the label selector the namespaces of the managed ingresses must match
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// RequiredLabels is the label selector the managed ingresses must match
+	RequiredLabels string `json:"requiredLabels,omitempty"`
+	// IngressLabelSelector the managed ingresses must match in addition to RequiredLabels
+	IngressLabelSelector string `json:"ingressLabelSelector,omitempty"`
+	// WatchIngressWithoutClass is set if the ingresses without ingress class are managed
+	WatchIngressWithoutClass bool `json:"watchIngressWithoutClass,omitempty"`
+	// OptionsConfigMap overrides Namespaces and RequiredLabels at runtime, if set
+	OptionsConfigMap        string `json:"optionsConfigMap,omitempty"`
+	UpdateStatusFromService string `json:"updateStatusFromService,omitempty"`
+	// AllowedStatusServices may override UpdateStatusFromService per ingress via status_from_service annotation
+	AllowedStatusServices  []string          `json:"allowedStatusServices,omitempty"`
+	AllowedListenerPorts   []int32           `json:"allowedListenerPorts,omitempty"`
+	DefaultResponseHeaders map[string]string `json:"defaultResponseHeaders,omitempty"`
+	DisableCertCheck       bool              `json:"disableCertCheck"`
+	SkipCertificates       bool              `json:"skipCertificates,omitempty"`
+	ServiceProxyUpstreams  bool              `json:"serviceProxyUpstreams,omitempty"`
+	WildcardHosts          bool              `json:"wildcardHosts,omitempty"`
+	CertCacheSize          int               `json:"certCacheSize"`
+	SyncStateWriter        string            `json:"syncStateWriter"`
+	HostConflictPolicy     string            `json:"hostConflictPolicy"`
+	WarmStandby            bool              `json:"warmStandby"`
+	RouteStatusCRs         bool              `json:"routeStatusCRs"`
+	// Backpressure is set if the reconciles are slowed down while the databroker is degraded
+	Backpressure *BackpressureConfig `json:"backpressure,omitempty"`
+	// DependencyReconcileWindow limits the reconciles triggered by the dependency updates, 0 if not limited
+	DependencyReconcileWindow  int           `json:"dependencyReconcileWindow"`
+	DependencyReconcileMaxWait time.Duration `json:"dependencyReconcileMaxWait,omitempty"`
+	// MaxConcurrentReconciles is the number of the ingresses reconciled at a time
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles"`
+	// ResyncPeriod is how often all managed ingresses are reconciled again, 0 if disabled
+	ResyncPeriod time.Duration `json:"resyncPeriod,omitempty"`
+	// DatabrokerDebounce is the window the pomerium config updates are coalesced within, 0 if disabled
+	DatabrokerDebounce time.Duration `json:"databrokerDebounce,omitempty"`
+	// GlobalSettings is the name of the Pomerium object whose global settings are applied, if set
+	GlobalSettings string `json:"globalSettings,omitempty"`
+}
+
+// ResolveOptions returns the ingress controller configuration the options would result in
+func ResolveOptions(opts ...Option) EffectiveOptions {
+	ic := newIngressController(opts...)
+	eo := EffectiveOptions{
+		ControllerName:           ic.controllerName,
+		AnnotationPrefix:         ic.annotationPrefix,
+		ServiceAnnotationPrefix:  ic.serviceAnnotationPrefix,
+		AllowedListenerPorts:     ic.allowedListenerPorts,
+		DefaultResponseHeaders:   ic.defaultResponseHeaders,
+		DisableCertCheck:         ic.disableCertCheck,
+		SkipCertificates:         ic.disableCertCheck && ic.skipCertificates,
+		ServiceProxyUpstreams:    ic.serviceProxyUpstreams,
+		WildcardHosts:            ic.allowWildcardHosts,
+		CertCacheSize:            ic.certCacheSize,
+		SyncStateWriter:          ic.syncStateWriterKind,
+		HostConflictPolicy:       ic.hostConflictPolicy,
+		WarmStandby:              ic.warmStandby != nil,
+		RouteStatusCRs:           ic.routeRenderer != nil,
+		MaxConcurrentReconciles:  ic.maxConcurrentReconciles,
+		ResyncPeriod:             ic.resyncPeriod,
+		IngressLabelSelector:     ic.ingressLabelSelector,
+		WatchIngressWithoutClass: ic.watchWithoutClass,
+		ExcludedNamespaces:       ic.excludedNamespaces,
+		NamespaceSelector:        ic.namespaceLabelSelector,
+		GlobalSettings:           ic.globalSettings,
+		DatabrokerDebounce:       ic.databrokerDebounce,
+	}
+	if ic.dependencyReconcileWindow > 0 {
+		eo.DependencyReconcileWindow = ic.dependencyReconcileWindow
+		eo.DependencyReconcileMaxWait = ic.dependencyReconcileMaxWait
+	}
+	if eo.SyncStateWriter == "" {
+		eo.SyncStateWriter = SyncStateWriterNone
+	}
+	for ns := range ic.namespaces {
+		eo.Namespaces = append(eo.Namespaces, ns)
+	}
+	sort.Strings(eo.Namespaces)
+	if ic.backpressure != nil {
+		cfg := ic.backpressure.cfg
+		eo.Backpressure = &cfg
+	}
+	if ic.requiredLabels != nil {
+		eo.RequiredLabels = ic.requiredLabels.String()
+	}
+	if ic.optionsConfigMap != nil {
+		eo.OptionsConfigMap = ic.optionsConfigMap.String()
+	}
+	if ic.updateStatusFromService != nil {
+		eo.UpdateStatusFromService = ic.updateStatusFromService.String()
+	}
+	for _, name := range ic.allowedStatusServices {
+		eo.AllowedStatusServices = append(eo.AllowedStatusServices, name.String())
+	}
+	return eo
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// hasEndpointSlices checks whether the cluster serves discovery.k8s.io/v1 EndpointSlices
+func hasEndpointSlices(mapper meta.RESTMapper) (bool, error) {
+	gvk := discoveryv1.SchemeGroupVersion.WithKind("EndpointSlice")
+	if _, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); meta.IsNoMatchError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("endpoint slices: %w", err)
+	}
+	return true, nil
+}
+
+// endpointsDependencyKind returns the kind of objects the service endpoints are resolved from
+func (r *ingressController) endpointsDependencyKind() string {
+	if r.endpointSlices {
+		return r.endpointSliceKind
+	}
+	return r.endpointsKind
+}
+
+// fetchServiceEndpoints returns the endpoints of the service, either aggregated from its EndpointSlices,
+// or from the legacy Endpoints object that kubernetes truncates to 1000 addresses
+func (r *ingressController) fetchServiceEndpoints(
+	ctx context.Context,
+	ingressKey model.Key,
+	name types.NamespacedName,
+) (*corev1.Endpoints, error) {
+	if !r.endpointSlices {
+		endpoints := new(corev1.Endpoints)
+		if err := r.Client.Get(ctx, name, endpoints); err != nil {
+			if apierrors.IsNotFound(err) {
+				r.Registry.Add(ingressKey, model.Key{Kind: r.endpointsKind, NamespacedName: name})
+			}
+			return nil, model.NewTransientError(err)
+		}
+		return endpoints, nil
+	}
+
+	slices := new(discoveryv1.EndpointSliceList)
+	if err := r.Client.List(ctx, slices,
+		client.InNamespace(name.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: name.Name},
+	); err != nil {
+		return nil, model.NewTransientError(fmt.Errorf("list endpoint slices: %w", err))
+	}
+	// a service without slices has no endpoints yet, and the ingress should be reconciled once they are added
+	if len(slices.Items) == 0 {
+		r.Registry.Add(ingressKey, model.Key{Kind: r.endpointSliceKind, NamespacedName: name})
+	}
+	return endpointsFromSlices(name, slices.Items), nil
+}
+
+// endpointsFromSlices aggregates the addresses of all service EndpointSlices into a single Endpoints object,
+// with a subset per distinct set of ports. an address listed by more than one slice, as it may be
+// while the endpoint is moved between the slices, is only included once
+func endpointsFromSlices(name types.NamespacedName, slices []discoveryv1.EndpointSlice) *corev1.Endpoints {
+	sort.Slice(slices, func(i, j int) bool { return slices[i].Name < slices[j].Name })
+
+	endpoints := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace}}
+	subsets := make(map[string]int)
+	seen := make(map[string]map[string]bool)
+	for _, slice := range slices {
+		// the FQDN address type is deprecated, and was never mirrored to Endpoints
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+
+		ports := endpointPorts(slice.Ports)
+		key := endpointPortsKey(ports)
+		idx, ok := subsets[key]
+		if !ok {
+			idx = len(endpoints.Subsets)
+			endpoints.Subsets = append(endpoints.Subsets, corev1.EndpointSubset{Ports: ports})
+			subsets[key] = idx
+			seen[key] = make(map[string]bool)
+		}
+		subset := &endpoints.Subsets[idx]
+
+		for _, ep := range slice.Endpoints {
+			// ready is unset if it is unknown, that should be interpreted as ready
+			ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+			for _, ip := range ep.Addresses {
+				if seen[key][ip] {
+					continue
+				}
+				seen[key][ip] = true
+
+				addr := corev1.EndpointAddress{IP: ip, TargetRef: ep.TargetRef, NodeName: ep.NodeName}
+				if ep.Hostname != nil {
+					addr.Hostname = *ep.Hostname
+				}
+				if ready {
+					subset.Addresses = append(subset.Addresses, addr)
+				} else {
+					subset.NotReadyAddresses = append(subset.NotReadyAddresses, addr)
+				}
+			}
+		}
+	}
+	return endpoints
+}
+
+func endpointPorts(src []discoveryv1.EndpointPort) []corev1.EndpointPort {
+	var ports []corev1.EndpointPort
+	for _, p := range src {
+		var port corev1.EndpointPort
+		if p.Name != nil {
+			port.Name = *p.Name
+		}
+		if p.Port != nil {
+			port.Port = *p.Port
+		}
+		if p.Protocol != nil {
+			port.Protocol = *p.Protocol
+		}
+		port.AppProtocol = p.AppProtocol
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Name != ports[j].Name {
+			return ports[i].Name < ports[j].Name
+		}
+		return ports[i].Port < ports[j].Port
+	})
+	return ports
+}
+
+func endpointPortsKey(ports []corev1.EndpointPort) string {
+	var key string
+	for _, p := range ports {
+		appProtocol := ""
+		if p.AppProtocol != nil {
+			appProtocol = *p.AppProtocol
+		}
+		key += fmt.Sprintf("%s/%d/%s/%s;", p.Name, p.Port, p.Protocol, appProtocol)
+	}
+	return key
+}
+
+// watchEndpointSlice returns a function that maps the EndpointSlice to the ingresses depending on its service,
+// as the slices are tracked by the name of the service they belong to
+func (r *ingressController) watchEndpointSlice(kind string) func(a client.Object) []reconcile.Request {
+	deps := r.getDependantIngressFn(kind)
+
+	return func(a client.Object) []reconcile.Request {
+		svc := a.GetLabels()[discoveryv1.LabelServiceName]
+		if svc == "" {
+			return nil
+		}
+		return deps(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: svc, Namespace: a.GetNamespace()}})
+	}
+}
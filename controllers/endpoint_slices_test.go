@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func testEndpointSlice(name string, ports []discoveryv1.EndpointPort, endpoints ...discoveryv1.Endpoint) discoveryv1.EndpointSlice {
+	return discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "service"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       ports,
+		Endpoints:   endpoints,
+	}
+}
+
+func TestEndpointsFromSlices(t *testing.T) {
+	name := types.NamespacedName{Name: "service", Namespace: "default"}
+	http := []discoveryv1.EndpointPort{{Name: proto.String("http"), Port: proto.Int32(8080)}}
+	metrics := []discoveryv1.EndpointPort{{Name: proto.String("metrics"), Port: proto.Int32(9090)}}
+	ready := func(ips ...string) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{Addresses: ips, Conditions: discoveryv1.EndpointConditions{Ready: proto.Bool(true)}}
+	}
+
+	endpoints := endpointsFromSlices(name, []discoveryv1.EndpointSlice{
+		testEndpointSlice("service-b", http, ready("10.0.0.3"), ready("10.0.0.2")),
+		testEndpointSlice("service-a", http, ready("10.0.0.1"), ready("10.0.0.2"),
+			discoveryv1.Endpoint{Addresses: []string{"10.0.0.4"}, Conditions: discoveryv1.EndpointConditions{Ready: proto.Bool(false)}},
+			discoveryv1.Endpoint{Addresses: []string{"10.0.0.5"}},
+		),
+		testEndpointSlice("service-c", metrics, ready("10.0.0.1")),
+		func() discoveryv1.EndpointSlice {
+			s := testEndpointSlice("service-d", http, ready("example.com"))
+			s.AddressType = discoveryv1.AddressTypeFQDN
+			return s
+		}(),
+	})
+
+	assert.Equal(t, &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{
+				{IP: "10.0.0.1"}, {IP: "10.0.0.2"}, {IP: "10.0.0.5"}, {IP: "10.0.0.3"},
+			},
+			NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.4"}},
+			Ports:             []corev1.EndpointPort{{Name: "http", Port: 8080}},
+		}, {
+			Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+			Ports:     []corev1.EndpointPort{{Name: "metrics", Port: 9090}},
+		}},
+	}, endpoints)
+
+	assert.Equal(t, &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"}},
+		endpointsFromSlices(name, nil), "service without slices has no endpoints")
+
+	// the pods exposing a named target port on different numbers are placed in different slices
+	web := []discoveryv1.EndpointPort{{Name: proto.String("http"), Port: proto.Int32(9090)}}
+	endpoints = endpointsFromSlices(name, []discoveryv1.EndpointSlice{
+		testEndpointSlice("service-a", http, ready("10.0.0.1")),
+		testEndpointSlice("service-b", web, ready("10.0.0.2")),
+	})
+	assert.Equal(t, []corev1.EndpointSubset{{
+		Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+		Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+	}, {
+		Addresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}},
+		Ports:     []corev1.EndpointPort{{Name: "http", Port: 9090}},
+	}}, endpoints.Subsets, "addresses keep their own port numbers")
+
+	// the dual stack service has a slice per address family
+	v6 := testEndpointSlice("service-v6", http, ready("fd00::1"))
+	v6.AddressType = discoveryv1.AddressTypeIPv6
+	endpoints = endpointsFromSlices(name, []discoveryv1.EndpointSlice{
+		testEndpointSlice("service-v4", http, ready("10.0.0.1")),
+		v6,
+	})
+	assert.Equal(t, []corev1.EndpointSubset{{
+		Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "fd00::1"}},
+		Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+	}}, endpoints.Subsets, "both address families should be used")
+}
+
+// TestFetchEndpointSlices checks the endpoints are aggregated across the slices of the service,
+// and a slice being added or removed reconciles the ingresses that depend on the service
+func TestFetchEndpointSlices(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		annotationPrefix:  DefaultAnnotationPrefix,
+		Client:            mc,
+		Scheme:            clientgoscheme.Scheme,
+		Registry:          model.NewRegistry(),
+		disableCertCheck:  true,
+		ingressKind:       "Ingress",
+		serviceKind:       "Service",
+		endpointsKind:     "Endpoints",
+		endpointSliceKind: "EndpointSlice",
+		endpointSlices:    true,
+	}
+	typePrefix := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: "a.localhost.pomerium.io",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{Path: "/", PathType: &typePrefix, Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{Name: "service", Port: networkingv1.ServiceBackendPort{Name: "http"}},
+					}}},
+				}},
+			}},
+		},
+	}
+	name := types.NamespacedName{Name: "service", Namespace: "default"}
+	http := []discoveryv1.EndpointPort{{Name: proto.String("http"), Port: proto.Int32(8080)}}
+	slices := []discoveryv1.EndpointSlice{
+		testEndpointSlice("service-a", http, discoveryv1.Endpoint{Addresses: []string{"10.0.0.1"}}),
+		testEndpointSlice("service-b", http, discoveryv1.Endpoint{Addresses: []string{"10.0.0.2"}}),
+	}
+	mc.EXPECT().Get(ctx, name, gomock.AssignableToTypeOf(&corev1.Service{})).AnyTimes().DoAndReturn(
+		func(_ context.Context, name types.NamespacedName, obj client.Object) error {
+			obj.SetName(name.Name)
+			obj.SetNamespace(name.Namespace)
+			return nil
+		})
+	mc.EXPECT().List(ctx, gomock.AssignableToTypeOf(&discoveryv1.EndpointSliceList{}), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+			lo := new(client.ListOptions)
+			lo.ApplyOptions(opts)
+			assert.Equal(t, "default", lo.Namespace)
+			assert.Equal(t, discoveryv1.LabelServiceName+"=service", lo.LabelSelector.String())
+			list.(*discoveryv1.EndpointSliceList).Items = append([]discoveryv1.EndpointSlice(nil), slices...)
+			return nil
+		})
+
+	ic, err := ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	if assert.Contains(t, ic.Endpoints, name) && assert.Len(t, ic.Endpoints[name].Subsets, 1) {
+		assert.Equal(t, []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}, ic.Endpoints[name].Subsets[0].Addresses)
+	}
+
+	ctrl.updateDependencies(ic)
+	reqs := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "ingress", Namespace: "default"}}}
+	added := testEndpointSlice("service-c", http, discoveryv1.Endpoint{Addresses: []string{"10.0.0.3"}})
+	// the same mapping is applied to the slice create, update and delete events
+	assert.Equal(t, reqs, ctrl.watchEndpointSlice(ctrl.endpointSliceKind)(&added))
+	assert.Equal(t, reqs, ctrl.watchEndpointSlice(ctrl.endpointSliceKind)(&slices[0]))
+
+	other := testEndpointSlice("other-a", http)
+	other.Labels[discoveryv1.LabelServiceName] = "other"
+	assert.Empty(t, ctrl.watchEndpointSlice(ctrl.endpointSliceKind)(&other))
+	other.Labels = nil
+	assert.Empty(t, ctrl.watchEndpointSlice(ctrl.endpointSliceKind)(&other), "slices not owned by a service are ignored")
+
+	// the ingress still depends on the service without slices, and is reconciled once the first one is added
+	slices = nil
+	ic, err = ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.Empty(t, ic.Endpoints[name].Subsets)
+	ctrl.updateDependencies(ic)
+	assert.Equal(t, reqs, ctrl.watchEndpointSlice(ctrl.endpointSliceKind)(&added))
+}
+
+// TestFetchServiceProxy checks the endpoints of the services used via the k8s service proxy are neither fetched nor watched,
+// so that the endpoint changes do not reconcile the ingress, while the service changes still do
+func TestFetchServiceProxy(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMockClient(gomock.NewController(t))
+	ctrl := ingressController{
+		annotationPrefix:      DefaultAnnotationPrefix,
+		Client:                mc,
+		Scheme:                clientgoscheme.Scheme,
+		Registry:              model.NewRegistry(),
+		disableCertCheck:      true,
+		ingressKind:           "Ingress",
+		serviceKind:           "Service",
+		endpointsKind:         "Endpoints",
+		endpointSliceKind:     "EndpointSlice",
+		endpointSlices:        true,
+		serviceProxyUpstreams: true,
+	}
+	typePrefix := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: "a.localhost.pomerium.io",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{Path: "/", PathType: &typePrefix, Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{Name: "service", Port: networkingv1.ServiceBackendPort{Number: 80}},
+					}}},
+				}},
+			}},
+		},
+	}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"}}
+	mc.EXPECT().Get(ctx, types.NamespacedName{Name: "service", Namespace: "default"}, gomock.AssignableToTypeOf(&corev1.Service{})).
+		AnyTimes().DoAndReturn(func(_ context.Context, _ types.NamespacedName, obj client.Object) error {
+		service.DeepCopyInto(obj.(*corev1.Service))
+		return nil
+	})
+
+	ic, err := ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.Contains(t, ic.Services, types.NamespacedName{Name: "service", Namespace: "default"})
+	assert.Empty(t, ic.Endpoints)
+	assert.True(t, ic.UseServiceProxy())
+
+	ctrl.updateDependencies(ic)
+	reqs := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "ingress", Namespace: "default"}}}
+	slice := testEndpointSlice("service-a", nil, discoveryv1.Endpoint{Addresses: []string{"10.0.0.1"}})
+	assert.Empty(t, ctrl.watchEndpointSlice(ctrl.endpointSliceKind)(&slice), "endpoint changes should not reconcile the ingress")
+	assert.Equal(t, reqs, ctrl.getDependantIngressFn(ctrl.serviceKind)(service), "service changes should reconcile the ingress")
+
+	// the ingress opting out of the service proxy depends on the endpoints
+	ingress.Annotations = map[string]string{DefaultAnnotationPrefix + "/" + model.UseServiceProxy: "false"}
+	mc.EXPECT().List(ctx, gomock.AssignableToTypeOf(&discoveryv1.EndpointSliceList{}), gomock.Any(), gomock.Any()).Return(nil)
+	ic, err = ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.Contains(t, ic.Endpoints, types.NamespacedName{Name: "service", Namespace: "default"})
+	ctrl.updateDependencies(ic)
+	assert.Equal(t, reqs, ctrl.watchEndpointSlice(ctrl.endpointSliceKind)(&slice))
+}
@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"fmt"
+	"path"
+)
+
+// ValidateNamespacePatterns checks the excluded namespace glob patterns are well formed
+func ValidateNamespacePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("namespace pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// isExcludedNamespace checks whether the namespace matches any of the glob patterns.
+// the patterns are expected to be validated, and the malformed ones never match
+func isExcludedNamespace(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
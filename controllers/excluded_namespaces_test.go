@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExcludedNamespaces(t *testing.T) {
+	patterns := []string{"kube-system", "cattle-*"}
+	assert.NoError(t, ValidateNamespacePatterns(patterns))
+	assert.Error(t, ValidateNamespacePatterns([]string{"cattle-[*"}))
+
+	ctrl := newIngressController(WithExcludedNamespaces(patterns))
+	for ns, watching := range map[string]bool{
+		"default":            true,
+		"kube-system":        false,
+		"kube-public":        true,
+		"cattle-system":      false,
+		"cattle-fleet-local": false,
+		"my-cattle-app":      true,
+	} {
+		assert.Equal(t, watching, ctrl.isWatchingNamespace(ns), ns)
+	}
+
+	// the exclusions apply to the namespaces explicitly listed, too
+	ctrl = newIngressController(WithNamespaces([]string{"a", "cattle-a"}), WithExcludedNamespaces(patterns))
+	assert.True(t, ctrl.isWatchingNamespace("a"))
+	assert.False(t, ctrl.isWatchingNamespace("cattle-a"))
+	assert.False(t, ctrl.isWatchingNamespace("b"))
+}
@@ -2,22 +2,50 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
 	"github.com/pomerium/ingress-controller/model"
 )
 
+// FetchIngressConfig resolves the objects the ingress refers to via the client, as the controller would
+// before applying it, only without a manager, caches and dependency tracking.
+// it is used to preview the pomerium configuration of an ingress, i.e. from the objects read from files
+func FetchIngressConfig(ctx context.Context, c client.Client, ingress *networkingv1.Ingress, opts ...Option) (*model.IngressConfig, error) {
+	r := newIngressController(opts...)
+	r.Client = c
+	r.Scheme = c.Scheme()
+	r.Registry = model.NewRegistry()
+	var err error
+	if r.ingressParameters, err = hasIngressParameters(r.Scheme, c.RESTMapper()); err != nil {
+		return nil, err
+	}
+	if r.ingressParameters {
+		r.ingressParametersKind = ingressParametersKind
+	}
+	return r.fetchIngress(ctx, ingress)
+}
+
 func (r *ingressController) fetchIngress(
 	ctx context.Context,
 	ingress *networkingv1.Ingress,
 ) (*model.IngressConfig, error) {
-	secrets, err := r.fetchIngressSecrets(ctx, ingress)
+	params, err := r.fetchIngressParameters(ctx, ingress)
+	if err != nil {
+		return nil, fmt.Errorf("ingress class parameters: %w", err)
+	}
+
+	secrets, err := r.fetchIngressSecrets(ctx, ingress, params)
 	if err != nil {
 		return nil, fmt.Errorf("tls: %w", err)
 	}
@@ -27,16 +55,61 @@ func (r *ingressController) fetchIngress(
 		return nil, fmt.Errorf("services: %w", err)
 	}
 
-	return &model.IngressConfig{
-		AnnotationPrefix: r.annotationPrefix,
-		Ingress:          ingress,
-		Endpoints:        endpoints,
-		Secrets:          secrets,
-		Services:         services,
-	}, nil
+	configMaps, err := r.fetchIngressConfigMaps(ctx, ingress)
+	if err != nil {
+		return nil, fmt.Errorf("config maps: %w", err)
+	}
+
+	ic := &model.IngressConfig{
+		AnnotationPrefix:        r.annotationPrefix,
+		ServiceAnnotationPrefix: r.serviceAnnotationPrefix,
+		Revision:                atomic.AddUint64(&r.revision, 1),
+		AllowedListenerPorts:    r.allowedListenerPorts,
+		AllowedStatusServices:   r.allowedStatusServices,
+		DefaultResponseHeaders:  r.defaultResponseHeaders,
+		SkipCertificates:        r.disableCertCheck && r.skipCertificates,
+		ServiceProxyUpstreams:   r.serviceProxyUpstreams,
+		AllowWildcardHosts:      r.allowWildcardHosts,
+		Ingress:                 ingress,
+		Endpoints:               endpoints,
+		Secrets:                 secrets,
+		Services:                services,
+		ConfigMaps:              configMaps,
+		ClassDefaults:           classDefaults(params),
+		Warnings:                new(model.Warnings),
+	}
+	if params != nil {
+		ic.ClassParameters = &types.NamespacedName{Namespace: params.Namespace, Name: params.Name}
+	}
+	warnUnusedTLSHosts(ic)
+	return ic, nil
 }
 
-// fetchIngressServices returns list of services referred from named port in the ingress path backend spec
+// fetchIngressConfigMaps returns config maps referenced by the ingress annotations
+func (r *ingressController) fetchIngressConfigMaps(ctx context.Context, ingress *networkingv1.Ingress) (
+	map[types.NamespacedName]*corev1.ConfigMap,
+	error,
+) {
+	configMaps := make(map[types.NamespacedName]*corev1.ConfigMap)
+	name, ok := ingress.Annotations[fmt.Sprintf("%s/%s", r.annotationPrefix, model.PolicyConfigMap)]
+	if !ok {
+		return configMaps, nil
+	}
+
+	key := types.NamespacedName{Name: name, Namespace: ingress.Namespace}
+	cm := new(corev1.ConfigMap)
+	if err := r.Client.Get(ctx, key, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Registry.Add(r.objectKey(ingress), model.Key{Kind: r.configMapKind, NamespacedName: key})
+		}
+		return nil, fmt.Errorf("get config map %s: %w", key.String(), model.NewTransientError(err))
+	}
+	configMaps[key] = cm
+	return configMaps, nil
+}
+
+// fetchIngressServices returns list of services referred from named port in the ingress path backend spec,
+// and their endpoints, unless the ingress uses the k8s service proxy as upstream
 func (r *ingressController) fetchIngressServices(ctx context.Context, ingress *networkingv1.Ingress) (
 	map[types.NamespacedName]*corev1.Service,
 	map[types.NamespacedName]*corev1.Endpoints,
@@ -44,7 +117,12 @@ func (r *ingressController) fetchIngressServices(ctx context.Context, ingress *n
 ) {
 	sm := make(map[types.NamespacedName]*corev1.Service)
 	em := make(map[types.NamespacedName]*corev1.Endpoints)
+	// redirect routes have no upstream, hence the backends, if any, are not resolved
+	if _, ok := ingress.Annotations[fmt.Sprintf("%s/%s", r.annotationPrefix, model.Redirect)]; ok {
+		return sm, em, nil
+	}
 	ingressKey := r.objectKey(ingress)
+	serviceProxy := model.IsServiceProxyUpstream(ingress, r.annotationPrefix, r.serviceProxyUpstreams)
 	for _, rule := range ingress.Spec.Rules {
 		if rule.HTTP == nil {
 			continue
@@ -52,26 +130,37 @@ func (r *ingressController) fetchIngressServices(ctx context.Context, ingress *n
 		for _, p := range rule.HTTP.Paths {
 			svc := p.Backend.Service
 			if svc == nil {
-				return nil, nil, fmt.Errorf("rule host=%s path=%s has no backend service defined", rule.Host, p.Path)
+				return nil, nil, model.NewPermanentError(
+					fmt.Errorf("rule host=%s path=%s has no backend service defined", rule.Host, p.Path))
 			}
 			svcName := types.NamespacedName{Name: svc.Name, Namespace: ingress.Namespace}
-			if err := r.fetchIngressService(ctx, ingressKey, sm, em, svcName); err != nil {
+			if err := r.fetchIngressService(ctx, ingressKey, serviceProxy, sm, em, svcName); err != nil {
 				return nil, nil, fmt.Errorf("rule host=%s path=%s refers to service %s port=%s, failed to get service information: %w",
 					rule.Host, p.Path, svcName.String(), svc.Port.String(), err)
 			}
 		}
 	}
 
-	if ingress.Spec.DefaultBackend == nil {
-		return sm, em, nil
+	if ingress.Spec.DefaultBackend != nil {
+		if err := r.fetchIngressService(ctx, ingressKey, serviceProxy, sm, em,
+			types.NamespacedName{
+				Name:      ingress.Spec.DefaultBackend.Service.Name,
+				Namespace: ingress.Namespace,
+			}); err != nil {
+			return nil, nil, fmt.Errorf("defaultBackend: %w", err)
+		}
 	}
 
-	if err := r.fetchIngressService(ctx, ingressKey, sm, em,
-		types.NamespacedName{
-			Name:      ingress.Spec.DefaultBackend.Service.Name,
-			Namespace: ingress.Namespace,
-		}); err != nil {
-		return nil, nil, fmt.Errorf("defaultBackend: %w", err)
+	// the canary service is tracked as a dependency along with the backend services
+	canary, err := model.GetCanary(ingress, r.annotationPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if canary != nil {
+		if err := r.fetchIngressService(ctx, ingressKey, serviceProxy, sm, em,
+			types.NamespacedName{Name: canary.ServiceName, Namespace: ingress.Namespace}); err != nil {
+			return nil, nil, fmt.Errorf("%s: service %s: %w", model.Canary, canary.ServiceName, err)
+		}
 	}
 
 	return sm, em, nil
@@ -80,6 +169,7 @@ func (r *ingressController) fetchIngressServices(ctx context.Context, ingress *n
 func (r *ingressController) fetchIngressService(
 	ctx context.Context,
 	ingressKey model.Key,
+	serviceProxy bool,
 	servicesDst map[types.NamespacedName]*corev1.Service,
 	endpointsDst map[types.NamespacedName]*corev1.Endpoints,
 	name types.NamespacedName,
@@ -89,27 +179,29 @@ func (r *ingressController) fetchIngressService(
 		if apierrors.IsNotFound(err) {
 			r.Registry.Add(ingressKey, model.Key{Kind: r.serviceKind, NamespacedName: name})
 		}
-		return err
+		return model.NewTransientError(err)
 	}
 	servicesDst[name] = service
 
-	if service.Spec.Type == corev1.ServiceTypeExternalName {
+	// neither is needed, nor watched, the endpoints of a service that is not routed to directly
+	if service.Spec.Type == corev1.ServiceTypeExternalName || serviceProxy {
 		return nil
 	}
 
-	endpoint := new(corev1.Endpoints)
-	if err := r.Client.Get(ctx, name, endpoint); err != nil {
-		if apierrors.IsNotFound(err) {
-			r.Registry.Add(ingressKey, model.Key{Kind: r.endpointsKind, NamespacedName: name})
-		}
+	endpoints, err := r.fetchServiceEndpoints(ctx, ingressKey, name)
+	if err != nil {
 		return err
 	}
-	endpointsDst[name] = endpoint
+	endpointsDst[name] = endpoints
 
 	return nil
 }
 
-func (r *ingressController) fetchIngressSecrets(ctx context.Context, ingress *networkingv1.Ingress) (
+func (r *ingressController) fetchIngressSecrets(
+	ctx context.Context,
+	ingress *networkingv1.Ingress,
+	params *v1alpha1.PomeriumIngressParameters,
+) (
 	map[types.NamespacedName]*corev1.Secret,
 	error,
 ) {
@@ -121,7 +213,7 @@ func (r *ingressController) fetchIngressSecrets(ctx context.Context, ingress *ne
 			if apierrors.IsNotFound(err) {
 				r.Registry.Add(r.objectKey(ingress), model.Key{Kind: r.secretKind, NamespacedName: name})
 			}
-			return nil, fmt.Errorf("get secret %s: %w", name.String(), err)
+			return nil, fmt.Errorf("get secret %s: %w", name.String(), model.NewTransientError(err))
 		}
 		secrets[name] = secret
 	}
@@ -130,8 +222,11 @@ func (r *ingressController) fetchIngressSecrets(ctx context.Context, ingress *ne
 		return secrets, nil
 	}
 
-	defaultCertSecret, err := r.fetchDefaultCert(ctx, ingress)
-	if err != nil {
+	defaultCertSecret, err := r.fetchDefaultCert(ctx, ingress, params)
+	if uncovered := uncoveredHosts(ingress); err != nil && len(ingress.Spec.TLS) > 0 && len(uncovered) > 0 {
+		return nil, fmt.Errorf("hosts %s are not covered by spec.tls, could not get default cert from ingressClass: %w",
+			strings.Join(uncovered, ", "), err)
+	} else if err != nil {
 		return nil, fmt.Errorf("spec.TLS.secretName was empty, could not get default cert from ingressClass: %w", err)
 	}
 	name := types.NamespacedName{
@@ -144,33 +239,61 @@ func (r *ingressController) fetchIngressSecrets(ctx context.Context, ingress *ne
 	return secrets, nil
 }
 
+// allIngressSecrets returns the secrets referenced by the ingress, each listed once regardless of how many roles
+// (i.e. TLS certificate and tls_client_secret annotation) it is referenced by, so that all roles observe the same data.
+// the default certificate is expected for the rule hosts that no spec.tls entry referencing a secret covers
 func (r *ingressController) allIngressSecrets(ingress *networkingv1.Ingress) ([]types.NamespacedName, bool) {
-	expectsDefault := len(ingress.Spec.TLS) == 0
+	expectsDefault := len(ingress.Spec.TLS) == 0 || len(uncoveredHosts(ingress)) > 0
 	var names []types.NamespacedName
+	seen := make(map[types.NamespacedName]bool)
+	add := func(name string) {
+		key := types.NamespacedName{Name: name, Namespace: ingress.Namespace}
+		if !seen[key] {
+			seen[key] = true
+			names = append(names, key)
+		}
+	}
 	for _, tls := range ingress.Spec.TLS {
 		if tls.SecretName == "" {
 			expectsDefault = true
 			continue
 		}
-		names = append(names, types.NamespacedName{Name: tls.SecretName, Namespace: ingress.Namespace})
+		add(tls.SecretName)
 	}
+	var annotated []string
 	for key, secret := range ingress.Annotations {
 		if strings.HasPrefix(key, r.annotationPrefix) && strings.HasSuffix(key, "_secret") {
-			names = append(names, types.NamespacedName{Name: secret, Namespace: ingress.Namespace})
+			annotated = append(annotated, secret)
 		}
 	}
+	sort.Strings(annotated)
+	for _, name := range annotated {
+		add(name)
+	}
 	return names, expectsDefault
 }
 
-func (r *ingressController) fetchDefaultCert(ctx context.Context, ingress *networkingv1.Ingress) (*corev1.Secret, error) {
-	class, err := r.getManagingClass(ctx, ingress)
-	if err != nil {
-		return nil, fmt.Errorf("could not find a matching ingressClass: %w", err)
-	}
-
-	name, err := getDefaultCertSecretName(class, r.annotationPrefix)
-	if err != nil {
-		return nil, fmt.Errorf("default cert secret name: %w", err)
+// fetchDefaultCert returns the default certificate secret set by the ingress class parameters,
+// or otherwise by the ingress class annotation
+func (r *ingressController) fetchDefaultCert(
+	ctx context.Context,
+	ingress *networkingv1.Ingress,
+	params *v1alpha1.PomeriumIngressParameters,
+) (*corev1.Secret, error) {
+	var name *types.NamespacedName
+	if params != nil && params.Spec.DefaultCertificateSecret != "" {
+		name = &types.NamespacedName{Namespace: params.Namespace, Name: params.Spec.DefaultCertificateSecret}
+	} else {
+		class, err := r.getManagingClass(ctx, ingress)
+		if err != nil {
+			return nil, fmt.Errorf("could not find a matching ingressClass: %w", err)
+		}
+		if class == nil {
+			return nil, model.NewPermanentError(errors.New("the ingress has no ingressClass to take the default cert from"))
+		}
+		if name, err = getDefaultCertSecretName(class, r.annotationPrefix); err != nil {
+			return nil, fmt.Errorf("default cert secret name: %w", model.NewPermanentError(err))
+		}
 	}
 
 	var secret corev1.Secret
@@ -178,7 +301,7 @@ func (r *ingressController) fetchDefaultCert(ctx context.Context, ingress *netwo
 		if apierrors.IsNotFound(err) {
 			r.Registry.Add(r.objectKey(ingress), model.Key{Kind: r.secretKind, NamespacedName: *name})
 		}
-		return nil, err
+		return nil, model.NewTransientError(err)
 	}
 
 	return &secret, nil
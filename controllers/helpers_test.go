@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// NewTestIngressConfig returns the config of an ingress in the default namespace, routing name.localhost.pomerium.io
+// to port 80 of the service, that the pomerium reconcilers accept. it is exported for the controllers_test package
+func NewTestIngressConfig(name string, annotations map[string]string) *model.IngressConfig {
+	prefix := networkingv1.PathTypePrefix
+	return &model.IngressConfig{
+		AnnotationPrefix: "a",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+			Spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{
+					Host: fmt.Sprintf("%s.localhost.pomerium.io", name),
+					IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{
+							Path:     "/",
+							PathType: &prefix,
+							Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+								Name: "service",
+								Port: networkingv1.ServiceBackendPort{Number: 80},
+							}},
+						}},
+					}},
+				}},
+			},
+		},
+		Services: map[types.NamespacedName]*corev1.Service{
+			{Name: "service", Namespace: "default"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+			},
+		},
+	}
+}
@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// HostConflictOldestWins publishes the host and path claimed by ingresses in different namespaces
+	// only for the oldest of them
+	HostConflictOldestWins = "oldest-wins"
+	// HostConflictDenyBoth does not publish the host and path claimed by ingresses in different namespaces
+	// for any of them
+	HostConflictDenyBoth = "deny-both"
+
+	// reasonHostConflict is reported on both ingresses claiming the same host and path
+	reasonHostConflict = "HostConflict"
+)
+
+// HostConflictPolicies lists supported policies for the host and path claimed by ingresses in different namespaces
+var HostConflictPolicies = []string{HostConflictOldestWins, HostConflictDenyBoth}
+
+func validateHostConflictPolicy(policy string) error {
+	for _, p := range HostConflictPolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown host conflict policy %q, supported values are %v", policy, HostConflictPolicies)
+}
+
+// hostClaim is a host and path an ingress publishes
+type hostClaim struct {
+	host, path, pathType string
+}
+
+func (c hostClaim) String() string {
+	return fmt.Sprintf("%s%s (%s)", c.host, c.path, c.pathType)
+}
+
+// hostConflict is a host and path that is also claimed by an ingress in another namespace
+type hostConflict struct {
+	hostClaim
+	peer types.NamespacedName
+	// published is set if the host and path is kept by this ingress
+	published bool
+}
+
+type claimant struct {
+	created metav1.Time
+	claims  map[hostClaim]bool
+}
+
+// conflictKey identifies a host and path contested with a peer ingress
+type conflictKey struct {
+	hostClaim
+	peer types.NamespacedName
+}
+
+// hostConflicts tracks the hosts and paths claimed by the managed ingresses,
+// so that ingresses in different namespaces never publish the same host and path.
+// it is safe for concurrent use
+type hostConflicts struct {
+	sync.Mutex
+	policy    string
+	claimants map[types.NamespacedName]*claimant
+	// conflicts of each ingress, symmetric for both sides of a conflict
+	conflicts map[types.NamespacedName]map[conflictKey]bool
+	// requeue receives ingresses that should be reconciled again, as their conflicts have changed
+	requeue chan event.GenericEvent
+}
+
+func newHostConflicts(policy string) *hostConflicts {
+	return &hostConflicts{
+		policy:    policy,
+		claimants: make(map[types.NamespacedName]*claimant),
+		conflicts: make(map[types.NamespacedName]map[conflictKey]bool),
+		requeue:   make(chan event.GenericEvent, 1024),
+	}
+}
+
+func getHostClaims(ing *networkingv1.Ingress) map[hostClaim]bool {
+	claims := make(map[hostClaim]bool)
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host == "" || rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			claims[getHostClaim(rule.Host, p)] = true
+		}
+	}
+	return claims
+}
+
+func getHostClaim(host string, p networkingv1.HTTPIngressPath) hostClaim {
+	c := hostClaim{host: host, path: p.Path, pathType: string(networkingv1.PathTypeImplementationSpecific)}
+	if c.path == "" {
+		c.path = "/"
+	}
+	if p.PathType != nil {
+		c.pathType = string(*p.PathType)
+	}
+	return c
+}
+
+// set records the hosts and paths claimed by the ingress,
+// and returns the other ingresses whose conflicts have changed as a result
+func (h *hostConflicts) set(ing *networkingv1.Ingress) []types.NamespacedName {
+	h.Lock()
+	defer h.Unlock()
+
+	name := types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}
+	cur := &claimant{created: ing.CreationTimestamp, claims: getHostClaims(ing)}
+	h.claimants[name] = cur
+
+	next := make(map[conflictKey]bool)
+	for peer, other := range h.claimants {
+		if peer.Namespace == name.Namespace {
+			continue
+		}
+		for c := range cur.claims {
+			if other.claims[c] {
+				next[conflictKey{c, peer}] = true
+			}
+		}
+	}
+	return h.replace(name, next)
+}
+
+// delete removes the ingress claims, and returns the other ingresses whose conflicts have changed as a result
+func (h *hostConflicts) delete(name types.NamespacedName) []types.NamespacedName {
+	h.Lock()
+	defer h.Unlock()
+
+	delete(h.claimants, name)
+	return h.replace(name, nil)
+}
+
+// replace sets the conflicts of the ingress and its peers, and returns the peers whose conflicts have changed
+func (h *hostConflicts) replace(name types.NamespacedName, next map[conflictKey]bool) []types.NamespacedName {
+	prev := h.conflicts[name]
+	changed := make(map[types.NamespacedName]bool)
+	for k := range prev {
+		if next[k] {
+			continue
+		}
+		changed[k.peer] = true
+		h.setPeerConflict(k.peer, conflictKey{k.hostClaim, name}, false)
+	}
+	for k := range next {
+		if prev[k] {
+			continue
+		}
+		changed[k.peer] = true
+		h.setPeerConflict(k.peer, conflictKey{k.hostClaim, name}, true)
+	}
+	if len(next) == 0 {
+		delete(h.conflicts, name)
+	} else {
+		h.conflicts[name] = next
+	}
+	hostConflictsActive.Set(float64(h.countClaims()))
+
+	names := make([]types.NamespacedName, 0, len(changed))
+	for peer := range changed {
+		names = append(names, peer)
+	}
+	return names
+}
+
+func (h *hostConflicts) setPeerConflict(peer types.NamespacedName, k conflictKey, conflicting bool) {
+	dst := h.conflicts[peer]
+	if !conflicting {
+		delete(dst, k)
+		if len(dst) == 0 {
+			delete(h.conflicts, peer)
+		}
+		return
+	}
+	if dst == nil {
+		dst = make(map[conflictKey]bool)
+		h.conflicts[peer] = dst
+	}
+	dst[k] = true
+}
+
+// countClaims returns the number of distinct hosts and paths that are currently contested
+func (h *hostConflicts) countClaims() int {
+	claims := make(map[hostClaim]bool)
+	for _, conflicts := range h.conflicts {
+		for k := range conflicts {
+			claims[k.hostClaim] = true
+		}
+	}
+	return len(claims)
+}
+
+// get returns current conflicts of the ingress, sorted by host and path
+func (h *hostConflicts) get(name types.NamespacedName) []hostConflict {
+	h.Lock()
+	defer h.Unlock()
+
+	cur := h.claimants[name]
+	var out []hostConflict
+	for k := range h.conflicts[name] {
+		out = append(out, hostConflict{
+			hostClaim: k.hostClaim,
+			peer:      k.peer,
+			published: h.isPublished(name, cur, k.hostClaim),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].hostClaim != out[j].hostClaim {
+			return out[i].String() < out[j].String()
+		}
+		return out[i].peer.String() < out[j].peer.String()
+	})
+	return out
+}
+
+// isPublished checks whether the ingress keeps the contested host and path,
+// that is only the case if it is older than all other ingresses claiming it, with the oldest-wins policy.
+// ingresses of the same age are ordered by name, so that the winner is always deterministic
+func (h *hostConflicts) isPublished(name types.NamespacedName, cur *claimant, c hostClaim) bool {
+	if h.policy != HostConflictOldestWins || cur == nil {
+		return false
+	}
+	for k := range h.conflicts[name] {
+		if k.hostClaim != c {
+			continue
+		}
+		other := h.claimants[k.peer]
+		if other == nil {
+			continue
+		}
+		if !isOlder(cur.created.Time, name, other.created.Time, k.peer) {
+			return false
+		}
+	}
+	return true
+}
+
+func isOlder(a time.Time, aName types.NamespacedName, b time.Time, bName types.NamespacedName) bool {
+	if !a.Equal(b) {
+		return a.Before(b)
+	}
+	return aName.String() < bName.String()
+}
+
+// enqueue requests the ingresses to be reconciled again
+func (h *hostConflicts) enqueue(ctx context.Context, names []types.NamespacedName) {
+	for _, name := range names {
+		select {
+		case h.requeue <- event.GenericEvent{Object: &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace},
+		}}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resolveHostConflicts records the hosts and paths claimed by the ingress, and returns the ingress
+// that should be published, without the hosts and paths it lost to ingresses in other namespaces.
+// the conflicts are reported as events, and the other ingresses whose conflicts have changed are reconciled again
+func (r *ingressController) resolveHostConflicts(ctx context.Context, ingress *networkingv1.Ingress) *networkingv1.Ingress {
+	r.hostConflicts.enqueue(ctx, r.hostConflicts.set(ingress))
+	return r.applyHostConflicts(ctx, ingress)
+}
+
+// applyHostConflicts returns the ingress without the hosts and paths it lost to ingresses in other namespaces,
+// and reports the conflicts as events
+func (r *ingressController) applyHostConflicts(ctx context.Context, ingress *networkingv1.Ingress) *networkingv1.Ingress {
+	conflicts := r.hostConflicts.get(types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name})
+	if len(conflicts) == 0 {
+		return ingress
+	}
+
+	lost := make(map[hostClaim]bool)
+	for _, c := range conflicts {
+		if c.published {
+			r.EventRecorder.Event(ingress, corev1.EventTypeWarning, reasonHostConflict,
+				fmt.Sprintf("%s is also claimed by ingress %s, that is not published: %s policy", c.hostClaim, c.peer, r.hostConflicts.policy))
+			continue
+		}
+		lost[c.hostClaim] = true
+		r.EventRecorder.Event(ingress, corev1.EventTypeWarning, reasonHostConflict,
+			fmt.Sprintf("%s is also claimed by ingress %s, not published: %s policy", c.hostClaim, c.peer, r.hostConflicts.policy))
+	}
+	if len(lost) == 0 {
+		return ingress
+	}
+	log.FromContext(ctx).Info("not publishing hosts and paths claimed by ingresses in other namespaces", "conflicts", len(lost))
+	return withoutHostClaims(ingress, lost)
+}
+
+// withoutHostClaims returns a copy of the ingress without the provided hosts and paths
+func withoutHostClaims(ingress *networkingv1.Ingress, claims map[hostClaim]bool) *networkingv1.Ingress {
+	dst := ingress.DeepCopy()
+	rules := dst.Spec.Rules[:0]
+	for _, rule := range dst.Spec.Rules {
+		if rule.Host == "" || rule.HTTP == nil {
+			rules = append(rules, rule)
+			continue
+		}
+		paths := rule.HTTP.Paths[:0]
+		for _, p := range rule.HTTP.Paths {
+			if !claims[getHostClaim(rule.Host, p)] {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		rule.HTTP.Paths = paths
+		rules = append(rules, rule)
+	}
+	dst.Spec.Rules = rules
+	return dst
+}
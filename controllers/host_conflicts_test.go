@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func testHostIngress(namespace, name string, created time.Time, hosts ...string) *networkingv1.Ingress {
+	prefix := networkingv1.PathTypePrefix
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, CreationTimestamp: metav1.NewTime(created)},
+	}
+	for _, host := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{Path: "/", PathType: &prefix}},
+			}},
+		})
+	}
+	return ing
+}
+
+func TestHostConflicts(t *testing.T) {
+	now := time.Now()
+	older := testHostIngress("a", "older", now, "shared.localhost.pomerium.io", "a.localhost.pomerium.io")
+	newer := testHostIngress("b", "newer", now.Add(time.Minute), "shared.localhost.pomerium.io")
+	sameNamespace := testHostIngress("a", "same", now.Add(time.Hour), "a.localhost.pomerium.io")
+	olderName := types.NamespacedName{Namespace: "a", Name: "older"}
+	newerName := types.NamespacedName{Namespace: "b", Name: "newer"}
+
+	h := newHostConflicts(HostConflictOldestWins)
+	assert.Empty(t, h.set(older))
+	assert.Empty(t, h.set(sameNamespace), "same namespace ingresses do not conflict")
+	assert.Equal(t, []types.NamespacedName{olderName}, h.set(newer))
+	assert.Empty(t, h.set(newer), "unchanged conflicts should not requeue peers")
+
+	if conflicts := h.get(olderName); assert.Len(t, conflicts, 1) {
+		assert.Equal(t, newerName, conflicts[0].peer)
+		assert.True(t, conflicts[0].published, "oldest ingress should keep the host")
+	}
+	if conflicts := h.get(newerName); assert.Len(t, conflicts, 1) {
+		assert.Equal(t, olderName, conflicts[0].peer)
+		assert.False(t, conflicts[0].published)
+	}
+	assert.Empty(t, h.get(types.NamespacedName{Namespace: "a", Name: "same"}))
+
+	// conflict clears once the winner is deleted
+	assert.Equal(t, []types.NamespacedName{newerName}, h.delete(olderName))
+	assert.Empty(t, h.get(newerName))
+	assert.Empty(t, h.conflicts)
+
+	h = newHostConflicts(HostConflictDenyBoth)
+	h.set(older)
+	h.set(newer)
+	for _, name := range []types.NamespacedName{olderName, newerName} {
+		if conflicts := h.get(name); assert.Len(t, conflicts, 1) {
+			assert.False(t, conflicts[0].published, "neither ingress should keep the host")
+		}
+	}
+
+	// conflict clears once the ingress no longer claims the host
+	assert.Equal(t, []types.NamespacedName{olderName},
+		h.set(testHostIngress("b", "newer", now.Add(time.Minute), "b.localhost.pomerium.io")))
+	assert.Empty(t, h.get(olderName))
+}
+
+func TestWithoutHostClaims(t *testing.T) {
+	ing := testHostIngress("a", "a", time.Now(), "shared.localhost.pomerium.io", "a.localhost.pomerium.io")
+	dst := withoutHostClaims(ing, map[hostClaim]bool{
+		{host: "shared.localhost.pomerium.io", path: "/", pathType: string(networkingv1.PathTypePrefix)}: true,
+	})
+	require.Len(t, dst.Spec.Rules, 1)
+	assert.Equal(t, "a.localhost.pomerium.io", dst.Spec.Rules[0].Host)
+	assert.Len(t, ing.Spec.Rules, 2, "original ingress should not be modified")
+}
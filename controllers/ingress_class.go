@@ -9,6 +9,7 @@ import (
 
 	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -24,7 +25,10 @@ const (
 func (r *ingressController) isManaging(ctx context.Context, ing *networkingv1.Ingress) (bool, error) {
 	_, err := r.getManagingClass(ctx, ing)
 	if err == nil {
-		return true, nil
+		if !r.hasRequiredLabels(ing) {
+			return false, nil
+		}
+		return r.isSelectedNamespace(ctx, ing.Namespace)
 	}
 
 	if status := apierrors.APIStatus(nil); errors.As(err, &status) {
@@ -34,10 +38,25 @@ func (r *ingressController) isManaging(ctx context.Context, ing *networkingv1.In
 	return false, nil
 }
 
+// hasRequiredLabels checks the ingress bears the labels required for it to be managed
+func (r *ingressController) hasRequiredLabels(ing *networkingv1.Ingress) bool {
+	if r.labelSelector != nil && !r.labelSelector.Matches(labels.Set(ing.Labels)) {
+		return false
+	}
+
+	r.scopeMu.RLock()
+	defer r.scopeMu.RUnlock()
+
+	if r.requiredLabels == nil {
+		return true
+	}
+	return r.requiredLabels.Matches(labels.Set(ing.Labels))
+}
+
 func (r *ingressController) getManagingClass(ctx context.Context, ing *networkingv1.Ingress) (*networkingv1.IngressClass, error) {
 	// if controller is started with explicit list of namespaces to watch,
 	// ignore all ingress resources coming from other namespaces
-	if len(r.namespaces) > 0 && !r.namespaces[ing.Namespace] {
+	if !r.isWatchingNamespace(ing.Namespace) {
 		return nil, fmt.Errorf("ingress %s/%s is not in the namespace list this controller is managing", ing.Namespace, ing.Name)
 	}
 
@@ -63,6 +82,9 @@ func (r *ingressController) getManagingClass(ctx context.Context, ing *networkin
 				return &ic, nil
 			}
 		}
+		if r.watchWithoutClass {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("the ingress did not specify an ingressClass, and no ingressClass managed by controller %s is marked as default", r.controllerName)
 	}
 
@@ -116,3 +138,15 @@ func getDefaultCertSecretName(ic *networkingv1.IngressClass, prefix string) (*ty
 	}
 	return namespacedName(txt)
 }
+
+// parseIngressLabelSelector parses the ingress label selector, nil if empty
+func parseIngressLabelSelector(selector string) (labels.Selector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("ingress label selector: %w", err)
+	}
+	return sel, nil
+}
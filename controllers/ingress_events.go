@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ingressEventRepeatInterval is how long the same warning is not reported again on an ingress
+const ingressEventRepeatInterval = time.Minute * 10
+
+// ingressEvents keeps track of the warning events last reported on each ingress, so that an ingress requeued
+// due to a dependency that keeps failing, i.e. a missing secret, does not flood the API server with events
+type ingressEvents struct {
+	sync.Mutex
+	reported map[types.NamespacedName]reportedIngressEvent
+}
+
+type reportedIngressEvent struct {
+	reason, msg string
+	at          time.Time
+}
+
+// shouldReport returns true if the ingress has not got the same warning within ingressEventRepeatInterval
+func (e *ingressEvents) shouldReport(name types.NamespacedName, reason, msg string, now time.Time) bool {
+	e.Lock()
+	defer e.Unlock()
+
+	prev, ok := e.reported[name]
+	if ok && prev.reason == reason && prev.msg == msg && now.Sub(prev.at) < ingressEventRepeatInterval {
+		return false
+	}
+	if e.reported == nil {
+		e.reported = make(map[types.NamespacedName]reportedIngressEvent)
+	}
+	e.reported[name] = reportedIngressEvent{reason: reason, msg: msg, at: now}
+	return true
+}
+
+// forget removes the ingress that was synced or is no longer managed, so that the next failure is reported at once
+func (e *ingressEvents) forget(name types.NamespacedName) {
+	e.Lock()
+	defer e.Unlock()
+
+	delete(e.reported, name)
+}
+
+// warningEvent reports a warning on the ingress, unless the same one was recently reported
+func (r *ingressController) warningEvent(ingress *networkingv1.Ingress, reason, msg string) {
+	if r.isStandby() {
+		// the events are not emitted, and should not be considered reported
+		return
+	}
+	name := types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}
+	if !r.ingressEvents.shouldReport(name, reason, msg, time.Now()) {
+		return
+	}
+	r.EventRecorder.Event(ingress, corev1.EventTypeWarning, reason, msg)
+}
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestIngressEvents(t *testing.T) {
+	var e ingressEvents
+	name := types.NamespacedName{Namespace: "default", Name: "a"}
+	now := time.Now()
+
+	assert.True(t, e.shouldReport(name, reasonFetchError, "secret not found", now))
+	assert.False(t, e.shouldReport(name, reasonFetchError, "secret not found", now.Add(time.Minute)), "repeated warning")
+	assert.True(t, e.shouldReport(name, reasonFetchError, "service not found", now.Add(time.Minute)), "another warning")
+	assert.True(t, e.shouldReport(name, reasonFetchError, "secret not found", now.Add(time.Minute*2)), "previous warning is replaced")
+	assert.True(t, e.shouldReport(name, reasonFetchError, "secret not found", now.Add(time.Minute*2+ingressEventRepeatInterval)), "interval passed")
+	assert.True(t, e.shouldReport(types.NamespacedName{Namespace: "default", Name: "b"}, reasonFetchError, "secret not found", now),
+		"other ingress")
+
+	e.forget(name)
+	assert.True(t, e.shouldReport(name, reasonFetchError, "secret not found", now.Add(time.Minute*3)), "reported again once synced")
+}
+
+func TestWarningEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &ingressController{EventRecorder: recorder}
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}}
+
+	for i := 0; i < 3; i++ {
+		r.warningEvent(ingress, reasonFetchError, "tls: get secret default/secret: not found")
+	}
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Equal(t, "Warning FetchError tls: get secret default/secret: not found", <-recorder.Events)
+	}
+}
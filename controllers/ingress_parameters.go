@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	// ingressParametersKind is the only IngressClass spec.parameters kind the controller supports
+	ingressParametersKind = "PomeriumIngressParameters"
+	// reasonUnsupportedParameters is reported on the IngressClass whose spec.parameters could not be applied
+	reasonUnsupportedParameters = "UnsupportedParameters"
+)
+
+// hasIngressParameters checks whether the PomeriumIngressParameters CRD is installed,
+// and the type is registered with the scheme
+func hasIngressParameters(scheme *runtime.Scheme, mapper meta.RESTMapper) (bool, error) {
+	gvk := v1alpha1.GroupVersion.WithKind(ingressParametersKind)
+	if !scheme.Recognizes(gvk) {
+		return false, nil
+	}
+	if _, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); meta.IsNoMatchError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("ingress parameters: %w", err)
+	}
+	return true, nil
+}
+
+// getParametersName returns the name of the PomeriumIngressParameters the IngressClass refers to via spec.parameters,
+// or nil if it has none
+func getParametersName(class *networkingv1.IngressClass) (*types.NamespacedName, error) {
+	p := class.Spec.Parameters
+	if p == nil {
+		return nil, nil
+	}
+	group := ""
+	if p.APIGroup != nil {
+		group = *p.APIGroup
+	}
+	if group != v1alpha1.GroupVersion.Group || p.Kind != ingressParametersKind {
+		return nil, fmt.Errorf("spec.parameters: unsupported kind %q of apiGroup %q, only %s of %s is supported",
+			p.Kind, group, ingressParametersKind, v1alpha1.GroupVersion.Group)
+	}
+	if p.Scope == nil || *p.Scope != networkingv1.IngressClassParametersReferenceScopeNamespace ||
+		p.Namespace == nil || *p.Namespace == "" {
+		return nil, fmt.Errorf("spec.parameters: %s is namespaced, the scope should be %s and the namespace set",
+			ingressParametersKind, networkingv1.IngressClassParametersReferenceScopeNamespace)
+	}
+	return &types.NamespacedName{Namespace: *p.Namespace, Name: p.Name}, nil
+}
+
+// reportClassParameters reports the IngressClass spec.parameters that could not be applied,
+// in which case the ingresses of that class are reconciled without them
+func (r *ingressController) reportClassParameters(class *networkingv1.IngressClass) {
+	name, err := getParametersName(class)
+	if err == nil && name != nil && !r.ingressParameters {
+		err = fmt.Errorf("spec.parameters: %s CRD is not installed", ingressParametersKind)
+	}
+	if err != nil {
+		r.EventRecorder.Event(class, corev1.EventTypeWarning, reasonUnsupportedParameters, err.Error())
+	}
+}
+
+// fetchIngressParameters returns the PomeriumIngressParameters of the ingress class, or nil if it refers to none.
+// the unsupported references are reported on the IngressClass once it is observed, and are ignored here
+func (r *ingressController) fetchIngressParameters(ctx context.Context, ingress *networkingv1.Ingress) (
+	*v1alpha1.PomeriumIngressParameters,
+	error,
+) {
+	if !r.ingressParameters {
+		return nil, nil
+	}
+	class, err := r.getManagingClass(ctx, ingress)
+	if err != nil {
+		return nil, fmt.Errorf("could not find a matching ingressClass: %w", err)
+	} else if class == nil {
+		return nil, nil
+	}
+	name, err := getParametersName(class)
+	if err != nil || name == nil {
+		return nil, nil
+	}
+
+	params := new(v1alpha1.PomeriumIngressParameters)
+	if err := r.Client.Get(ctx, *name, params); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Registry.Add(r.objectKey(ingress), model.Key{Kind: r.ingressParametersKind, NamespacedName: *name})
+		}
+		return nil, fmt.Errorf("get %s %s: %w", ingressParametersKind, name.String(), model.NewTransientError(err))
+	}
+	return params, nil
+}
+
+// classDefaults returns the route annotations, without prefix, the ingress class parameters set by default
+func classDefaults(params *v1alpha1.PomeriumIngressParameters) map[string]string {
+	if params == nil {
+		return nil
+	}
+	defaults := make(map[string]string)
+	if params.Spec.Timeout != nil {
+		defaults["timeout"] = durationSeconds(params.Spec.Timeout.Duration)
+	}
+	if params.Spec.IdleTimeout != nil {
+		defaults["idle_timeout"] = durationSeconds(params.Spec.IdleTimeout.Duration)
+	}
+	if params.Spec.PassIdentityHeaders != nil {
+		defaults["pass_identity_headers"] = strconv.FormatBool(*params.Spec.PassIdentityHeaders)
+	}
+	return defaults
+}
+
+// durationSeconds formats the duration as the route annotations expect, i.e. 90s rather than 1m30s
+func durationSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// watchIngressParameters returns the ingresses of the classes referring to the parameters object.
+// unlike the other dependencies, it is not limited to the watched namespaces, as is the default certificate
+func (r *ingressController) watchIngressParameters(kind string) func(a client.Object) []reconcile.Request {
+	logger := log.FromContext(context.Background()).WithValues("kind", kind)
+
+	return func(a client.Object) []reconcile.Request {
+		name := types.NamespacedName{Name: a.GetName(), Namespace: a.GetNamespace()}
+		deps := r.DepsOfKind(model.Key{Kind: kind, NamespacedName: name}, r.ingressKind)
+		reqs := make([]reconcile.Request, 0, len(deps))
+		for _, k := range deps {
+			reqs = append(reqs, reconcile.Request{NamespacedName: k.NamespacedName})
+		}
+		logger.V(1).Info("watch", "name", name.String(), "deps", reqs)
+		return reqs
+	}
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func parametersRef(group, kind, scope, namespace string) *networkingv1.IngressClassParametersReference {
+	return &networkingv1.IngressClassParametersReference{
+		APIGroup: proto.String(group), Kind: kind, Name: "params",
+		Scope: proto.String(scope), Namespace: proto.String(namespace),
+	}
+}
+
+func TestGetParametersName(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ref    *networkingv1.IngressClassParametersReference
+		expect *types.NamespacedName
+		err    bool
+	}{
+		{"none", nil, nil, false},
+		{"namespaced", parametersRef("ingress.pomerium.io", "PomeriumIngressParameters", "Namespace", "pomerium"),
+			&types.NamespacedName{Namespace: "pomerium", Name: "params"}, false},
+		{"unknown kind", parametersRef("example.com", "Parameters", "Namespace", "pomerium"), nil, true},
+		{"cluster scoped", parametersRef("ingress.pomerium.io", "PomeriumIngressParameters", "Cluster", ""), nil, true},
+	} {
+		name, err := getParametersName(&networkingv1.IngressClass{Spec: networkingv1.IngressClassSpec{Parameters: tc.ref}})
+		if tc.err {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		if assert.NoError(t, err, tc.name) {
+			assert.Equal(t, tc.expect, name, tc.name)
+		}
+	}
+}
+
+func TestFetchIngressParameters(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	className := "pomerium"
+	class := &networkingv1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{Name: className},
+		Spec: networkingv1.IngressClassSpec{
+			Controller: DefaultClassControllerName,
+			Parameters: parametersRef("ingress.pomerium.io", "PomeriumIngressParameters", "Namespace", "pomerium"),
+		},
+	}
+	params := &v1alpha1.PomeriumIngressParameters{
+		ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "pomerium"},
+		Spec: v1alpha1.PomeriumIngressParametersSpec{
+			DefaultCertificateSecret: "wildcard",
+			Timeout:                  &metav1.Duration{Duration: time.Minute + time.Second*30},
+			PassIdentityHeaders:      proto.Bool(true),
+		},
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "wildcard", Namespace: "pomerium"}}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec:       networkingv1.IngressSpec{IngressClassName: &className},
+	}
+
+	ctrl := newIngressController()
+	ctrl.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(class, secret, ingress).Build()
+	ctrl.Registry = model.NewRegistry()
+	ctrl.Scheme = scheme
+	ctrl.ingressKind, ctrl.secretKind = "Ingress", "Secret"
+	ctrl.ingressParameters, ctrl.ingressParametersKind = true, ingressParametersKind
+
+	// missing parameters are tracked as a dependency
+	_, err := ctrl.fetchIngress(ctx, ingress)
+	assert.Error(t, err)
+	paramsKey := model.Key{Kind: ingressParametersKind, NamespacedName: types.NamespacedName{Namespace: "pomerium", Name: "params"}}
+	assert.Equal(t, []model.Key{{Kind: "Ingress", NamespacedName: types.NamespacedName{Namespace: "default", Name: "ingress"}}},
+		ctrl.DepsOfKind(paramsKey, "Ingress"))
+
+	require.NoError(t, ctrl.Client.Create(ctx, params))
+	ic, err := ctrl.fetchIngress(ctx, ingress)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"timeout": "90s", "pass_identity_headers": "true"}, ic.ClassDefaults)
+	assert.Equal(t, &paramsKey.NamespacedName, ic.ClassParameters)
+	assert.Contains(t, ic.Secrets, types.NamespacedName{Namespace: "pomerium", Name: "wildcard"}, "default cert from parameters")
+}
+
+func TestReportClassParameters(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	ctrl := ingressController{EventRecorder: recorder}
+
+	class := &networkingv1.IngressClass{Spec: networkingv1.IngressClassSpec{
+		Parameters: parametersRef("example.com", "Parameters", "Namespace", "pomerium"),
+	}}
+	ctrl.reportClassParameters(class)
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, `UnsupportedParameters spec.parameters: unsupported kind "Parameters"`)
+	}
+
+	class.Spec.Parameters = parametersRef("ingress.pomerium.io", "PomeriumIngressParameters", "Namespace", "pomerium")
+	ctrl.reportClassParameters(class)
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, "CRD is not installed")
+	}
+
+	ctrl.ingressParameters = true
+	ctrl.reportClassParameters(class)
+	assert.Empty(t, recorder.Events)
+}
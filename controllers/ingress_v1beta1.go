@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// hasIngressV1 checks whether the cluster serves networking.k8s.io/v1 Ingress, that is only available since 1.19
+func hasIngressV1(mapper meta.RESTMapper) (bool, error) {
+	gvk := networkingv1.SchemeGroupVersion.WithKind("Ingress")
+	if _, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); meta.IsNoMatchError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("ingress: %w", err)
+	}
+	return true, nil
+}
+
+// ingressV1beta1Client serves the networking.k8s.io/v1 Ingress and IngressClass objects the controller operates on
+// from the v1beta1 API, for the clusters that do not serve v1 yet. all other objects are passed through as is
+type ingressV1beta1Client struct {
+	client.Client
+}
+
+// Get implements client.Client
+func (c *ingressV1beta1Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	switch dst := obj.(type) {
+	case *networkingv1.Ingress:
+		src := new(networkingv1beta1.Ingress)
+		if err := c.Client.Get(ctx, key, src); err != nil {
+			return err
+		}
+		*dst = *ingressFromV1beta1(src)
+		return nil
+	case *networkingv1.IngressClass:
+		src := new(networkingv1beta1.IngressClass)
+		if err := c.Client.Get(ctx, key, src); err != nil {
+			return err
+		}
+		*dst = *ingressClassFromV1beta1(src)
+		return nil
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+// List implements client.Client
+func (c *ingressV1beta1Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	switch dst := list.(type) {
+	case *networkingv1.IngressList:
+		src := new(networkingv1beta1.IngressList)
+		if err := c.Client.List(ctx, src, opts...); err != nil {
+			return err
+		}
+		dst.ListMeta = src.ListMeta
+		dst.Items = make([]networkingv1.Ingress, 0, len(src.Items))
+		for i := range src.Items {
+			dst.Items = append(dst.Items, *ingressFromV1beta1(&src.Items[i]))
+		}
+		return nil
+	case *networkingv1.IngressClassList:
+		src := new(networkingv1beta1.IngressClassList)
+		if err := c.Client.List(ctx, src, opts...); err != nil {
+			return err
+		}
+		dst.ListMeta = src.ListMeta
+		dst.Items = make([]networkingv1.IngressClass, 0, len(src.Items))
+		for i := range src.Items {
+			dst.Items = append(dst.Items, *ingressClassFromV1beta1(&src.Items[i]))
+		}
+		return nil
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+// Update implements client.Client
+func (c *ingressV1beta1Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if dst, ok := obj.(*networkingv1.Ingress); ok {
+		src := ingressToV1beta1(dst)
+		if err := c.Client.Update(ctx, src, opts...); err != nil {
+			return err
+		}
+		*dst = *ingressFromV1beta1(src)
+		return nil
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+// Patch implements client.Client.
+// the patch is computed against the v1 object, as the patches the controller makes do not touch the converted fields
+func (c *ingressV1beta1Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if dst, ok := obj.(*networkingv1.Ingress); ok {
+		data, err := patch.Data(dst)
+		if err != nil {
+			return err
+		}
+		src := ingressToV1beta1(dst)
+		if err := c.Client.Patch(ctx, src, client.RawPatch(patch.Type(), data), opts...); err != nil {
+			return err
+		}
+		*dst = *ingressFromV1beta1(src)
+		return nil
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// Status implements client.Client
+func (c *ingressV1beta1Client) Status() client.StatusWriter {
+	return &ingressV1beta1StatusWriter{StatusWriter: c.Client.Status()}
+}
+
+// ingressV1beta1StatusWriter updates the status of networking.k8s.io/v1 Ingress via the v1beta1 API
+type ingressV1beta1StatusWriter struct {
+	client.StatusWriter
+}
+
+// Update implements client.StatusWriter
+func (w *ingressV1beta1StatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if dst, ok := obj.(*networkingv1.Ingress); ok {
+		src := ingressToV1beta1(dst)
+		if err := w.StatusWriter.Update(ctx, src, opts...); err != nil {
+			return err
+		}
+		*dst = *ingressFromV1beta1(src)
+		return nil
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+// Patch implements client.StatusWriter
+func (w *ingressV1beta1StatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if dst, ok := obj.(*networkingv1.Ingress); ok {
+		data, err := patch.Data(dst)
+		if err != nil {
+			return err
+		}
+		src := ingressToV1beta1(dst)
+		if err := w.StatusWriter.Patch(ctx, src, client.RawPatch(patch.Type(), data), opts...); err != nil {
+			return err
+		}
+		*dst = *ingressFromV1beta1(src)
+		return nil
+	}
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+// watchIngressClassV1beta1 converts the v1beta1 IngressClass to v1 before it is handled by watchIngressClass
+func (r *ingressController) watchIngressClassV1beta1(kind string) func(a client.Object) []reconcile.Request {
+	fn := r.watchIngressClass(kind)
+
+	return func(a client.Object) []reconcile.Request {
+		if class, ok := a.(*networkingv1beta1.IngressClass); ok {
+			a = ingressClassFromV1beta1(class)
+		}
+		return fn(a)
+	}
+}
+
+// ingressFromV1beta1 converts the ingress to v1, where the backend service is a nested object,
+// and the path type is required, that defaults to ImplementationSpecific as the v1beta1 API server would
+func ingressFromV1beta1(src *networkingv1beta1.Ingress) *networkingv1.Ingress {
+	dst := &networkingv1.Ingress{
+		ObjectMeta: *src.ObjectMeta.DeepCopy(),
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: src.Spec.IngressClassName,
+			DefaultBackend:   backendFromV1beta1(src.Spec.Backend),
+		},
+		Status: networkingv1.IngressStatus{LoadBalancer: *src.Status.LoadBalancer.DeepCopy()},
+	}
+	for _, tls := range src.Spec.TLS {
+		dst.Spec.TLS = append(dst.Spec.TLS, networkingv1.IngressTLS{
+			Hosts:      append([]string(nil), tls.Hosts...),
+			SecretName: tls.SecretName,
+		})
+	}
+	for _, rule := range src.Spec.Rules {
+		r := networkingv1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			r.HTTP = new(networkingv1.HTTPIngressRuleValue)
+			for _, p := range rule.HTTP.Paths {
+				pathType := networkingv1.PathTypeImplementationSpecific
+				if p.PathType != nil {
+					pathType = networkingv1.PathType(*p.PathType)
+				}
+				r.HTTP.Paths = append(r.HTTP.Paths, networkingv1.HTTPIngressPath{
+					Path:     p.Path,
+					PathType: &pathType,
+					Backend:  *backendFromV1beta1(&p.Backend),
+				})
+			}
+		}
+		dst.Spec.Rules = append(dst.Spec.Rules, r)
+	}
+	return dst
+}
+
+func backendFromV1beta1(src *networkingv1beta1.IngressBackend) *networkingv1.IngressBackend {
+	if src == nil {
+		return nil
+	}
+	dst := &networkingv1.IngressBackend{Resource: src.Resource.DeepCopy()}
+	if src.ServiceName != "" {
+		dst.Service = &networkingv1.IngressServiceBackend{Name: src.ServiceName}
+		if src.ServicePort.Type == intstr.String {
+			dst.Service.Port.Name = src.ServicePort.StrVal
+		} else {
+			dst.Service.Port.Number = src.ServicePort.IntVal
+		}
+	}
+	return dst
+}
+
+// ingressToV1beta1 converts the ingress back to v1beta1, in order to be updated
+func ingressToV1beta1(src *networkingv1.Ingress) *networkingv1beta1.Ingress {
+	dst := &networkingv1beta1.Ingress{
+		ObjectMeta: *src.ObjectMeta.DeepCopy(),
+		Spec: networkingv1beta1.IngressSpec{
+			IngressClassName: src.Spec.IngressClassName,
+			Backend:          backendToV1beta1(src.Spec.DefaultBackend),
+		},
+		Status: networkingv1beta1.IngressStatus{LoadBalancer: *src.Status.LoadBalancer.DeepCopy()},
+	}
+	for _, tls := range src.Spec.TLS {
+		dst.Spec.TLS = append(dst.Spec.TLS, networkingv1beta1.IngressTLS{
+			Hosts:      append([]string(nil), tls.Hosts...),
+			SecretName: tls.SecretName,
+		})
+	}
+	for _, rule := range src.Spec.Rules {
+		r := networkingv1beta1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			r.HTTP = new(networkingv1beta1.HTTPIngressRuleValue)
+			for _, p := range rule.HTTP.Paths {
+				path := networkingv1beta1.HTTPIngressPath{
+					Path:    p.Path,
+					Backend: *backendToV1beta1(&p.Backend),
+				}
+				if p.PathType != nil {
+					pathType := networkingv1beta1.PathType(*p.PathType)
+					path.PathType = &pathType
+				}
+				r.HTTP.Paths = append(r.HTTP.Paths, path)
+			}
+		}
+		dst.Spec.Rules = append(dst.Spec.Rules, r)
+	}
+	return dst
+}
+
+func backendToV1beta1(src *networkingv1.IngressBackend) *networkingv1beta1.IngressBackend {
+	if src == nil {
+		return nil
+	}
+	dst := &networkingv1beta1.IngressBackend{Resource: src.Resource.DeepCopy()}
+	if src.Service != nil {
+		dst.ServiceName = src.Service.Name
+		if src.Service.Port.Name != "" {
+			dst.ServicePort = intstr.FromString(src.Service.Port.Name)
+		} else {
+			dst.ServicePort = intstr.FromInt(int(src.Service.Port.Number))
+		}
+	}
+	return dst
+}
+
+func ingressClassFromV1beta1(src *networkingv1beta1.IngressClass) *networkingv1.IngressClass {
+	dst := &networkingv1.IngressClass{
+		ObjectMeta: *src.ObjectMeta.DeepCopy(),
+		Spec:       networkingv1.IngressClassSpec{Controller: src.Spec.Controller},
+	}
+	if p := src.Spec.Parameters; p != nil {
+		dst.Spec.Parameters = &networkingv1.IngressClassParametersReference{
+			APIGroup:  p.APIGroup,
+			Kind:      p.Kind,
+			Name:      p.Name,
+			Scope:     p.Scope,
+			Namespace: p.Namespace,
+		}
+	}
+	return dst
+}
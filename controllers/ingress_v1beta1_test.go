@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testIngressV1beta1() *networkingv1beta1.Ingress {
+	className := "pomerium"
+	typeExact := networkingv1beta1.PathTypeExact
+	return &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec: networkingv1beta1.IngressSpec{
+			IngressClassName: &className,
+			Backend:          &networkingv1beta1.IngressBackend{ServiceName: "default", ServicePort: intstr.FromInt(8080)},
+			TLS:              []networkingv1beta1.IngressTLS{{Hosts: []string{"a.localhost.pomerium.io"}, SecretName: "secret"}},
+			Rules: []networkingv1beta1.IngressRule{{
+				Host: "a.localhost.pomerium.io",
+				IngressRuleValue: networkingv1beta1.IngressRuleValue{HTTP: &networkingv1beta1.HTTPIngressRuleValue{
+					Paths: []networkingv1beta1.HTTPIngressPath{{
+						Path:    "/",
+						Backend: networkingv1beta1.IngressBackend{ServiceName: "service", ServicePort: intstr.FromString("http")},
+					}, {
+						Path:     "/exact",
+						PathType: &typeExact,
+						Backend:  networkingv1beta1.IngressBackend{ServiceName: "service", ServicePort: intstr.FromInt(80)},
+					}},
+				}},
+			}},
+		},
+	}
+}
+
+func TestIngressFromV1beta1(t *testing.T) {
+	className := "pomerium"
+	typeExact := networkingv1.PathTypeExact
+	typeImplSpecific := networkingv1.PathTypeImplementationSpecific
+	src := testIngressV1beta1()
+
+	ingress := ingressFromV1beta1(src)
+	assert.Equal(t, &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &className,
+			DefaultBackend: &networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+				Name: "default", Port: networkingv1.ServiceBackendPort{Number: 8080},
+			}},
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{"a.localhost.pomerium.io"}, SecretName: "secret"}},
+			Rules: []networkingv1.IngressRule{{
+				Host: "a.localhost.pomerium.io",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     "/",
+						PathType: &typeImplSpecific,
+						Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+							Name: "service", Port: networkingv1.ServiceBackendPort{Name: "http"},
+						}},
+					}, {
+						Path:     "/exact",
+						PathType: &typeExact,
+						Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+							Name: "service", Port: networkingv1.ServiceBackendPort{Number: 80},
+						}},
+					}},
+				}},
+			}},
+		},
+	}, ingress)
+
+	// the path type defaults to ImplementationSpecific once converted
+	typeBeta := networkingv1beta1.PathTypeImplementationSpecific
+	src.Spec.Rules[0].HTTP.Paths[0].PathType = &typeBeta
+	assert.Equal(t, src, ingressToV1beta1(ingress), "round trip")
+}
+
+// TestIngressV1beta1Client checks the v1 ingress the controller operates on is read and updated via the v1beta1 API
+func TestIngressV1beta1Client(t *testing.T) {
+	ctx := context.Background()
+	class := &networkingv1beta1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "pomerium"},
+		Spec:       networkingv1beta1.IngressClassSpec{Controller: DefaultClassControllerName},
+	}
+	fc := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(testIngressV1beta1(), class).Build()
+	c := &ingressV1beta1Client{Client: fc}
+	name := types.NamespacedName{Name: "ingress", Namespace: "default"}
+
+	ingress := new(networkingv1.Ingress)
+	require.NoError(t, c.Get(ctx, name, ingress))
+	assert.Equal(t, "service", ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name)
+
+	il := new(networkingv1.IngressList)
+	require.NoError(t, c.List(ctx, il))
+	if assert.Len(t, il.Items, 1) {
+		assert.Equal(t, ingress, &il.Items[0])
+	}
+	icl := new(networkingv1.IngressClassList)
+	require.NoError(t, c.List(ctx, icl))
+	if assert.Len(t, icl.Items, 1) {
+		assert.Equal(t, DefaultClassControllerName, icl.Items[0].Spec.Controller)
+	}
+
+	// the sync state annotation is patched
+	next := ingress.DeepCopy()
+	next.Annotations = map[string]string{"ingress.pomerium.io/sync_state": "{}"}
+	require.NoError(t, c.Patch(ctx, next, client.MergeFrom(ingress)))
+	next.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	require.NoError(t, c.Status().Update(ctx, next))
+
+	stored := new(networkingv1beta1.Ingress)
+	require.NoError(t, fc.Get(ctx, name, stored))
+	assert.Equal(t, "{}", stored.Annotations["ingress.pomerium.io/sync_state"])
+	assert.Equal(t, []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}, stored.Status.LoadBalancer.Ingress)
+	assert.Equal(t, "service", stored.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName, "spec should be intact")
+	assert.Equal(t, stored.ResourceVersion, next.ResourceVersion)
+}
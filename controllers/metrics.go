@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricResultSuccess = "success"
+	metricResultError   = "error"
+)
+
+var (
+	statusUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pomerium_ingress_status_updates_total",
+		Help: "Number of ingress status updates from the pomerium proxy service, by result",
+	}, []string{"result"})
+	statusUpdaterHealthy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pomerium_ingress_status_updater_healthy",
+		Help: "Whether the last ingress status update from the pomerium proxy service has succeeded",
+	})
+	hostConflictsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pomerium_ingress_host_conflicts",
+		Help: "Number of hosts and paths currently claimed by managed ingresses in different namespaces",
+	})
+	translationWarnings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pomerium_ingress_translation_warnings",
+		Help: "Number of warnings found by the last translation of the managed ingress",
+	}, []string{"namespace", "name"})
+	backpressureState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pomerium_ingress_backpressure_state",
+		Help: "Databroker back-pressure state: 0 healthy, 1 degraded, 2 recovering",
+	})
+	backpressureFailures = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pomerium_ingress_backpressure_consecutive_failures",
+		Help: "Number of consecutive failed or slow databroker writes",
+	})
+	backpressureDeferred = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pomerium_ingress_backpressure_deferred_reconciles_total",
+		Help: "Total number of reconciles postponed due to the databroker back-pressure",
+	})
+	dependencyReconcilesPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pomerium_ingress_dependency_reconciles_pending",
+		Help: "Number of reconciles triggered by the dependency updates, waiting behind the new ingresses and deletes",
+	})
+	ingressSyncPhases = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pomerium_ingress_sync_phase",
+		Help: "Number of managed ingresses by their sync phase",
+	}, []string{"phase"})
+	reconcileOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pomerium_ingress_reconciles_total",
+		Help: "Number of ingress upserts and deletes applied to the pomerium config, by operation, result and ingress",
+	}, []string{"operation", "result", "namespace", "name"})
+	syncLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pomerium_ingress_sync_latency_seconds",
+		Help:    "Time from the ingress or its dependency update until the ingress is successfully written to the databroker",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	})
+	lastSuccessfulSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pomerium_ingress_last_successful_sync_timestamp_seconds",
+		Help: "Unix timestamp of the last successful pomerium config update",
+	})
+)
+
+func init() {
+	// metrics are served by the controller manager
+	metrics.Registry.MustRegister(statusUpdates, statusUpdaterHealthy, hostConflictsActive, translationWarnings,
+		backpressureState, backpressureFailures, backpressureDeferred, dependencyReconcilesPending, ingressSyncPhases,
+		reconcileOutcomes, syncLatency, lastSuccessfulSync)
+}
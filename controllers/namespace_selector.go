@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// namespaceSelection keeps the namespaces that matched the namespace selector when last seen,
+// so that the ingresses of the namespaces whose labels started or stopped matching are reconciled
+type namespaceSelection struct {
+	sync.Mutex
+	selected map[string]bool
+}
+
+// update records whether the namespace matches the selector, and returns true if that changed.
+// a namespace seen for the first time counts as changed if it matches
+func (s *namespaceSelection) update(name string, selected bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.selected[name] == selected {
+		return false
+	}
+	if s.selected == nil {
+		s.selected = make(map[string]bool)
+	}
+	if selected {
+		s.selected[name] = true
+	} else {
+		delete(s.selected, name)
+	}
+	return true
+}
+
+// parseNamespaceSelector parses the namespace label selector, nil if empty
+func parseNamespaceSelector(selector string) (labels.Selector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("namespace selector: %w", err)
+	}
+	return sel, nil
+}
+
+// isSelectedNamespace checks whether the namespace labels match the namespace selector, if one is set.
+// the namespace is looked up in the cache, that is kept current by the namespace informer
+func (r *ingressController) isSelectedNamespace(ctx context.Context, name string) (bool, error) {
+	if r.namespaceSelector == nil {
+		return true, nil
+	}
+	ns := new(corev1.Namespace)
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: name}, ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get namespace: %w", err)
+	}
+	return r.namespaceSelector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// watchNamespaceSelector returns the ingresses of the namespace that started or stopped matching the namespace selector
+func (r *ingressController) watchNamespaceSelector(ctx context.Context, a client.Object) []reconcile.Request {
+	if r.namespaceSelector == nil || !r.isWatchingNamespace(a.GetName()) {
+		return nil
+	}
+	selected := r.namespaceSelector.Matches(labels.Set(a.GetLabels()))
+	if !r.namespaceSelection.update(a.GetName(), selected) {
+		return nil
+	}
+
+	logger := log.FromContext(ctx).WithValues("namespace", a.GetName(), "selected", selected)
+	il := new(networkingv1.IngressList)
+	if err := r.Client.List(ctx, il, client.InNamespace(a.GetName())); err != nil {
+		logger.Error(err, "list ingresses")
+		return nil
+	}
+	reqs := make([]reconcile.Request, 0, len(il.Items))
+	for i := range il.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: il.Items[i].Namespace,
+			Name:      il.Items[i].Name,
+		}})
+	}
+	logger.Info("namespace selection changed", "ingresses", len(reqs))
+	return reqs
+}
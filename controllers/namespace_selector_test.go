@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestNamespaceSelector(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+	ctrl := newIngressController(WithNamespaceSelector("pomerium-enabled=true"))
+	var err error
+	ctrl.namespaceSelector, err = parseNamespaceSelector(ctrl.namespaceLabelSelector)
+	require.NoError(t, err)
+	ctrl.Client = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(ns,
+		&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "tenant"}},
+		&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "other"}},
+	).Build()
+
+	selected, err := ctrl.isSelectedNamespace(ctx, "tenant")
+	require.NoError(t, err)
+	assert.False(t, selected)
+	selected, err = ctrl.isSelectedNamespace(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(t, selected)
+
+	watch := ctrl.watchNamespace("Namespace")
+	assert.Empty(t, watch(ns), "not matching namespace seen for the first time")
+
+	ns.Labels = map[string]string{"pomerium-enabled": "true"}
+	require.NoError(t, ctrl.Client.Update(ctx, ns))
+	reqs := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "a", Namespace: "tenant"}}}
+	assert.Equal(t, reqs, watch(ns), "namespace started matching")
+	assert.Empty(t, watch(ns), "no label changes")
+	selected, err = ctrl.isSelectedNamespace(ctx, "tenant")
+	require.NoError(t, err)
+	assert.True(t, selected)
+
+	ns.Labels = nil
+	assert.Equal(t, reqs, watch(ns), "namespace stopped matching")
+}
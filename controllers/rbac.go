@@ -6,6 +6,13 @@ package controllers
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses/secrets,verbs=update
 
+//+kubebuilder:rbac:groups=core.k8s.io,resources=configmaps,verbs=get;list;watch
+
+//+kubebuilder:rbac:groups=core.k8s.io,resources=endpoints,verbs=get;list;watch
+//+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+
+//+kubebuilder:rbac:groups=core.k8s.io,resources=namespaces,verbs=get;list;watch
+
 //+kubebuilder:rbac:groups=core.k8s.io,resources=secrets,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core.k8s.io,resources=secrets/status,verbs=get
 //+kubebuilder:rbac:groups=core.k8s.io,resources=secrets/secrets,verbs=update
@@ -13,3 +20,9 @@ package controllers
 //+kubebuilder:rbac:groups=core.k8s.io,resources=services,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core.k8s.io,resources=services/status,verbs=get
 //+kubebuilder:rbac:groups=core.k8s.io,resources=services/secrets,verbs=update
+
+//+kubebuilder:rbac:groups=ingress.pomerium.io,resources=ingressroutestatuses,verbs=get;list;watch;create;delete;patch
+//+kubebuilder:rbac:groups=ingress.pomerium.io,resources=ingressroutestatuses/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=ingress.pomerium.io,resources=pomeriumingressparameters,verbs=get;list;watch
+//+kubebuilder:rbac:groups=ingress.pomerium.io,resources=pomerium,verbs=get;list;watch
+//+kubebuilder:rbac:groups=ingress.pomerium.io,resources=pomerium/status,verbs=get;update;patch
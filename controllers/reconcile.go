@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
@@ -14,10 +16,11 @@ import (
 	"github.com/pomerium/ingress-controller/model"
 )
 
-// PomeriumReconciler updates pomerium configuration based on provided network resources
-// it is not expected to be thread safe
+// PomeriumReconciler updates pomerium configuration based on provided network resources.
+// it must be safe for concurrent use if the controller runs several reconciles at a time, see WithMaxConcurrentReconciles
 type PomeriumReconciler interface {
-	// Upsert should update or create the pomerium routes corresponding to this ingress
+	// Upsert should update or create the pomerium routes corresponding to this ingress.
+	// model.RouteErrors should be returned if only the valid routes of the ingress were applied
 	Upsert(ctx context.Context, ic *model.IngressConfig) (changes bool, err error)
 	// Set configuration to match provided ingresses
 	Set(ctx context.Context, ics []*model.IngressConfig) (changes bool, err error)
@@ -38,12 +41,17 @@ func (r *ingressController) reconcileInitial(ctx context.Context) (err error) {
 		}
 	}()
 
+	// all ingresses are reconciled below, regardless of the scope change
+	if _, err := r.applyOptionsConfigMap(ctx); err != nil {
+		return fmt.Errorf("options config map: %w", err)
+	}
+
 	ingressList := new(networkingv1.IngressList)
 	if err := r.Client.List(ctx, ingressList); err != nil {
 		return fmt.Errorf("list ingresses: %w", err)
 	}
 
-	var ics []*model.IngressConfig
+	var managed []*networkingv1.Ingress
 	for i := range ingressList.Items {
 		ingress := &ingressList.Items[i]
 		managing, err := r.isManaging(ctx, ingress)
@@ -53,14 +61,32 @@ func (r *ingressController) reconcileInitial(ctx context.Context) (err error) {
 		if !managing {
 			continue
 		}
+		// claims of all ingresses should be known before any of them is published
+		_ = r.hostConflicts.set(ingress)
+		managed = append(managed, ingress)
+	}
+
+	var ics []*model.IngressConfig
+	for _, ingress := range managed {
+		ingress = r.applyHostConflicts(ctx, ingress)
 		ic, err := r.fetchIngress(ctx, ingress)
 		if err != nil {
 			return fmt.Errorf("fetch ingress %s/%s: %w", ingress.Namespace, ingress.Name, err)
 		}
 		logger.V(1).Info("fetch", "ingress", ingress.Name, "secrets", len(ic.Secrets), "services", len(ic.Services))
+		r.syncStates.setPending(ic.GetIngressNamespacedName())
 		ics = append(ics, ic)
 	}
 
+	for _, ic := range ics {
+		// so that secrets referenced by multiple ingresses are reported with all of them at once
+		r.updateDependencies(ic)
+	}
+	for _, ic := range ics {
+		r.reportInvalidSecrets(ctx, ic)
+		r.reportDisabledCertCheck(ctx, ic)
+	}
+
 	changed, err := r.PomeriumReconciler.Set(ctx, ics)
 	for i := range ingressList.Items {
 		ingress := &ingressList.Items[i]
@@ -77,9 +103,13 @@ func (r *ingressController) reconcileInitial(ctx context.Context) (err error) {
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 func (r *ingressController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r.dependencyQueue.started(req.NamespacedName)
 	if err := r.initComplete.yield(ctx); err != nil {
 		return ctrl.Result{Requeue: true}, fmt.Errorf("initial reconciliation: %w", err)
 	}
+	if delay := r.backpressure.Admit(); delay > 0 {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
 
 	logger := log.FromContext(ctx)
 	ingress := new(networkingv1.Ingress)
@@ -90,45 +120,105 @@ func (r *ingressController) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return r.deleteIngress(ctx, req.NamespacedName, "Ingress resource was deleted")
 	}
 
+	deleted, err := r.isNamespaceDeleted(ctx, req.Namespace)
+	if err != nil {
+		return ctrl.Result{Requeue: true}, fmt.Errorf("get namespace: %w", err)
+	}
+	if deleted {
+		return r.deleteIngress(ctx, req.NamespacedName, "Namespace is being deleted")
+	}
+
 	managing, err := r.isManaging(ctx, ingress)
 	if err != nil {
 		return ctrl.Result{Requeue: true}, fmt.Errorf("get ingressClass info: %w", err)
 	}
 
 	if !managing {
+		r.removeSyncState(ctx, ingress)
+		r.removeRouteStatus(ctx, ingress)
 		return r.deleteIngress(ctx, req.NamespacedName, "not marked to be managed by this controller")
 	}
 
+	r.syncStates.setPending(req.NamespacedName)
+	ingress = r.resolveHostConflicts(ctx, ingress)
 	ic, err := r.fetchIngress(ctx, ingress)
 	if err != nil {
+		r.warningEvent(ingress, reasonFetchError, err.Error())
+		r.setSyncState(ctx, ingress, SyncPhaseError, reasonFetchError, err.Error())
+		r.setRouteStatus(ctx, ingress, nil, err)
 		logger.Error(err, "obtaining ingress related resources", "deps",
 			r.Registry.Deps(model.Key{Kind: r.ingressKind, NamespacedName: req.NamespacedName}))
-		return ctrl.Result{Requeue: true}, fmt.Errorf("fetch ingress related resources: %w", err)
+		return requeueTransient(ctx, fmt.Errorf("fetch ingress related resources: %w", err))
 	}
 
-	return r.upsertIngress(ctx, ic)
+	return r.upsertIngressWithTTL(ctx, ic)
+}
+
+// isNamespaceDeleted checks whether the namespace is gone or is terminating,
+// in which case its ingresses would soon be deleted and should no longer be served
+func (r *ingressController) isNamespaceDeleted(ctx context.Context, name string) (bool, error) {
+	ns := new(corev1.Namespace)
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: name}, ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return ns.DeletionTimestamp != nil, nil
 }
 
 func (r *ingressController) deleteIngress(ctx context.Context, name types.NamespacedName, reason string) (ctrl.Result, error) {
-	if err := r.PomeriumReconciler.Delete(ctx, name); err != nil {
+	start := time.Now()
+	err := r.PomeriumReconciler.Delete(ctx, name)
+	r.backpressure.Observe(start, err)
+	if err != nil {
 		return ctrl.Result{Requeue: true}, fmt.Errorf("deleting ingress: %w", err)
 	}
 	log.FromContext(ctx).Info("deleted from pomerium", "reason", reason)
 	r.Registry.DeleteCascade(model.Key{Kind: r.ingressKind, NamespacedName: name})
+	r.syncStates.delete(name)
+	r.routeTTLs.forget(name)
+	r.certCheckEvents.forget(name)
+	r.ingressEvents.forget(name)
+	clearWarnings(name)
+	r.hostConflicts.enqueue(ctx, r.hostConflicts.delete(name))
 	return ctrl.Result{}, nil
 }
 
 func (r *ingressController) upsertIngress(ctx context.Context, ic *model.IngressConfig) (ctrl.Result, error) {
+	r.reportInvalidSecrets(ctx, ic)
+	r.reportDisabledCertCheck(ctx, ic)
+	start := time.Now()
 	changed, err := r.PomeriumReconciler.Upsert(ctx, ic)
-	if err != nil {
-		r.EventRecorder.Event(ic.Ingress, corev1.EventTypeWarning, reasonPomeriumConfigUpdateError, err.Error())
-		return ctrl.Result{Requeue: true}, fmt.Errorf("upsert: %w", err)
+	r.backpressure.Observe(start, err)
+	r.reportWarnings(ctx, ic)
+	// the ingress is only reconciled again once the resources it depends on are updated if the error is permanent,
+	// i.e. the TLS secret is malformed, hence they are tracked regardless of the outcome
+	r.updateDependencies(ic)
+	var routeErrs model.RouteErrors
+	if err != nil && !errors.As(err, &routeErrs) {
+		r.warningEvent(ic.Ingress, reasonPomeriumConfigUpdateError, err.Error())
+		r.setSyncState(ctx, ic.Ingress, SyncPhaseError, reasonPomeriumConfigUpdateError, err.Error())
+		r.setRouteStatus(ctx, ic.Ingress, nil, err)
+		return requeueTransient(ctx, fmt.Errorf("upsert: %w", err))
+	}
+	if len(routeErrs) > 0 {
+		// valid routes were applied, and there's no point retrying until the ingress is fixed
+		log.FromContext(ctx).Error(routeErrs, "some ingress routes were skipped")
+		r.warningEvent(ic.Ingress, reasonPomeriumConfigPartialUpdate, routeErrs.Error())
+		r.setSyncState(ctx, ic.Ingress, SyncPhaseError, reasonPomeriumConfigPartialUpdate, routeErrs.Error())
+		r.setRouteStatus(ctx, ic.Ingress, ic, routeErrs)
+		changed = false
+	} else {
+		r.ingressEvents.forget(ic.GetIngressNamespacedName())
+		r.setSyncState(ctx, ic.Ingress, SyncPhaseSynced, reasonPomeriumConfigUpdated, msgPomeriumConfigUpdated)
+		r.setRouteStatus(ctx, ic.Ingress, ic, nil)
 	}
 
-	r.updateDependencies(ic)
 	if changed {
 		log.FromContext(ctx).V(1).Info("ingress updated", "deps", r.Deps(r.objectKey(ic.Ingress)), "spec", ic.Ingress.Spec, "changed", changed)
-		r.EventRecorder.Event(ic.Ingress, corev1.EventTypeNormal, reasonPomeriumConfigUpdated, msgPomeriumConfigUpdated)
+		r.EventRecorder.Event(ic.Ingress, corev1.EventTypeNormal, reasonPomeriumConfigUpdated,
+			fmt.Sprintf("%s, %d routes", msgPomeriumConfigUpdated, ic.RouteCount))
 	}
 
 	if err = r.updateIngressStatus(ctx, ic.Ingress); err != nil {
@@ -138,16 +228,12 @@ func (r *ingressController) upsertIngress(ctx context.Context, ic *model.Ingress
 	return ctrl.Result{}, nil
 }
 
-func (r *ingressController) updateIngressStatus(ctx context.Context, ingress *networkingv1.Ingress) error {
-	if r.updateStatusFromService == nil {
-		return nil
+// requeueTransient requeues transient errors, while permanent errors are only logged,
+// as the ingress would be reconciled again once it, or the resources it depends on, are updated
+func requeueTransient(ctx context.Context, err error) (ctrl.Result, error) {
+	if model.IsPermanentError(err) {
+		log.FromContext(ctx).Error(err, "not retrying until resources are updated")
+		return ctrl.Result{}, nil
 	}
-
-	svc := new(corev1.Service)
-	if err := r.Client.Get(ctx, *r.updateStatusFromService, svc); err != nil {
-		return fmt.Errorf("get pomerium-proxy service %s: %w", r.updateStatusFromService.String(), err)
-	}
-
-	ingress.Status.LoadBalancer = svc.Status.LoadBalancer
-	return r.Client.Status().Update(ctx, ingress)
+	return ctrl.Result{Requeue: true}, err
 }
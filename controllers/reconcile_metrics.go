@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	metricOperationUpsert = "upsert"
+	metricOperationDelete = "delete"
+)
+
+// eventTimes keeps the time of the earliest event of each ingress that was not yet written to the databroker
+type eventTimes struct {
+	mu    sync.Mutex
+	items map[types.NamespacedName]time.Time
+}
+
+func newEventTimes() *eventTimes {
+	return &eventTimes{items: make(map[types.NamespacedName]time.Time)}
+}
+
+// observe records the ingress event, unless an earlier one is still pending
+func (e *eventTimes) observe(name types.NamespacedName) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.items[name]; !ok {
+		e.items[name] = time.Now()
+	}
+}
+
+// take returns and forgets the time of the earliest pending ingress event
+func (e *eventTimes) take(name types.NamespacedName) (time.Time, bool) {
+	if e == nil {
+		return time.Time{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t, ok := e.items[name]
+	delete(e.items, name)
+	return t, ok
+}
+
+// predicate records the ingress events, without filtering any of them
+func (e *eventTimes) predicate() predicate.Predicate {
+	observe := func(obj client.Object) bool {
+		e.observe(types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()})
+		return true
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(evt event.CreateEvent) bool { return observe(evt.Object) },
+		UpdateFunc:  func(evt event.UpdateEvent) bool { return observe(evt.ObjectNew) },
+		DeleteFunc:  func(evt event.DeleteEvent) bool { return observe(evt.Object) },
+		GenericFunc: func(evt event.GenericEvent) bool { return observe(evt.Object) },
+	}
+}
+
+// mapFunc records the events of the ingresses affected by a dependency update
+func (e *eventTimes) mapFunc(fn func(client.Object) []reconcile.Request) func(client.Object) []reconcile.Request {
+	return func(a client.Object) []reconcile.Request {
+		reqs := fn(a)
+		for _, req := range reqs {
+			e.observe(req.NamespacedName)
+		}
+		return reqs
+	}
+}
+
+// instrumentedReconciler counts the outcomes of the pomerium config updates,
+// and measures the time from the ingress event until it is written to the databroker
+type instrumentedReconciler struct {
+	PomeriumReconciler
+	events *eventTimes
+}
+
+// Upsert implements PomeriumReconciler.
+// the ingress applied with some of its routes skipped is counted as a success, as the config was written
+func (r *instrumentedReconciler) Upsert(ctx context.Context, ic *model.IngressConfig) (bool, error) {
+	changed, err := r.PomeriumReconciler.Upsert(ctx, ic)
+	var routeErrs model.RouteErrors
+	if errors.As(err, &routeErrs) {
+		r.observe(metricOperationUpsert, ic.GetIngressNamespacedName(), nil)
+	} else {
+		r.observe(metricOperationUpsert, ic.GetIngressNamespacedName(), err)
+	}
+	return changed, err
+}
+
+// Set implements PomeriumReconciler, each ingress of the full sync is counted as an upsert with its result
+func (r *instrumentedReconciler) Set(ctx context.Context, ics []*model.IngressConfig) (bool, error) {
+	changed, err := r.PomeriumReconciler.Set(ctx, ics)
+	for _, ic := range ics {
+		r.observe(metricOperationUpsert, ic.GetIngressNamespacedName(), err)
+	}
+	if err == nil && len(ics) == 0 {
+		lastSuccessfulSync.SetToCurrentTime()
+	}
+	return changed, err
+}
+
+// Delete implements PomeriumReconciler
+func (r *instrumentedReconciler) Delete(ctx context.Context, name types.NamespacedName) error {
+	err := r.PomeriumReconciler.Delete(ctx, name)
+	r.observe(metricOperationDelete, name, err)
+	return err
+}
+
+func (r *instrumentedReconciler) observe(operation string, name types.NamespacedName, err error) {
+	if err != nil {
+		reconcileOutcomes.WithLabelValues(operation, metricResultError, name.Namespace, name.Name).Inc()
+		return
+	}
+	reconcileOutcomes.WithLabelValues(operation, metricResultSuccess, name.Namespace, name.Name).Inc()
+	lastSuccessfulSync.SetToCurrentTime()
+	if t, ok := r.events.take(name); ok {
+		syncLatency.Observe(time.Since(t).Seconds())
+	}
+}
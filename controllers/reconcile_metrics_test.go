@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// failingReconciler fails all pomerium config updates with err, if set
+type failingReconciler struct {
+	recordingReconciler
+	err error
+}
+
+func (r *failingReconciler) Upsert(ctx context.Context, ic *model.IngressConfig) (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+	return r.recordingReconciler.Upsert(ctx, ic)
+}
+
+func (r *failingReconciler) Delete(ctx context.Context, name types.NamespacedName) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.recordingReconciler.Delete(ctx, name)
+}
+
+func TestReconcileMetrics(t *testing.T) {
+	ctx := context.Background()
+	target := new(failingReconciler)
+	events := newEventTimes()
+	r := &instrumentedReconciler{PomeriumReconciler: target, events: events}
+	name := types.NamespacedName{Namespace: "metrics", Name: "a"}
+	ic := NewTestIngressConfig("a", nil)
+	ic.Namespace = "metrics"
+
+	outcome := func(operation, result string) float64 {
+		return testutil.ToFloat64(reconcileOutcomes.WithLabelValues(operation, result, name.Namespace, name.Name))
+	}
+
+	events.observe(name)
+	first := events.items[name]
+	time.Sleep(time.Millisecond)
+	events.observe(name)
+	assert.Equal(t, first, events.items[name], "the earliest pending event should be kept")
+
+	target.err = errors.New("unavailable")
+	_, err := r.Upsert(ctx, ic)
+	require.Error(t, err)
+	assert.Equal(t, 1.0, outcome(metricOperationUpsert, metricResultError))
+	assert.Contains(t, events.items, name, "the event is pending until written")
+
+	before := time.Now().Unix()
+	target.err = nil
+	_, err = r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, outcome(metricOperationUpsert, metricResultSuccess))
+	assert.NotContains(t, events.items, name)
+	assert.GreaterOrEqual(t, testutil.ToFloat64(lastSuccessfulSync), float64(before))
+
+	target.err = model.RouteErrors{}
+	_, err = r.Upsert(ctx, ic)
+	require.Error(t, err)
+	assert.Equal(t, 2.0, outcome(metricOperationUpsert, metricResultSuccess), "partially applied ingress was written")
+
+	target.err = nil
+	_, err = r.Set(ctx, []*model.IngressConfig{ic})
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, outcome(metricOperationUpsert, metricResultSuccess))
+
+	require.NoError(t, r.Delete(ctx, name))
+	assert.Equal(t, 1.0, outcome(metricOperationDelete, metricResultSuccess))
+	assert.Equal(t, 0.0, outcome(metricOperationDelete, metricResultError))
+
+	// dependency updates record the events of the affected ingresses
+	fn := events.mapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: name}}
+	})
+	fn(nil)
+	assert.Contains(t, events.items, name)
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// AppliedConfigCache is implemented by the PomeriumReconciler that skips the ingress updates
+// whose translated config did not change since applied
+type AppliedConfigCache interface {
+	// ForgetApplied makes the subsequent updates be applied regardless
+	ForgetApplied()
+}
+
+// periodicResync requeues all managed ingresses every period, so that the pomerium config that drifted from them,
+// i.e. was edited in the databroker directly, or was not written due to a silently failed update, is restored.
+// the ingresses are sent to the controller queue, and are reconciled within its concurrency limits
+type periodicResync struct {
+	period time.Duration
+	list   func(ctx context.Context) ([]types.NamespacedName, error)
+	out    chan event.GenericEvent
+}
+
+func newPeriodicResync(period time.Duration, list func(ctx context.Context) ([]types.NamespacedName, error)) *periodicResync {
+	return &periodicResync{
+		period: period,
+		list:   list,
+		out:    make(chan event.GenericEvent),
+	}
+}
+
+// Start implements manager.Runnable, and only runs on the elected leader
+func (p *periodicResync) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		names, err := p.list(ctx)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "periodic resync")
+			continue
+		}
+		log.FromContext(ctx).V(1).Info("periodic resync", "ingresses", len(names))
+		for _, name := range names {
+			select {
+			case p.out <- event.GenericEvent{Object: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace},
+			}}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// listResyncIngresses returns the managed ingresses to reconcile again, forgetting the applied configs,
+// so that the drifted ones are written even though the ingresses did not change
+func (r *ingressController) listResyncIngresses(ctx context.Context) ([]types.NamespacedName, error) {
+	if r.forgetApplied != nil {
+		r.forgetApplied()
+	}
+	return r.listManagedIngresses(ctx)
+}
+
+// listManagedIngresses returns the names of the ingresses this controller manages
+func (r *ingressController) listManagedIngresses(ctx context.Context) ([]types.NamespacedName, error) {
+	ingressList := new(networkingv1.IngressList)
+	if err := r.Client.List(ctx, ingressList); err != nil {
+		return nil, fmt.Errorf("list ingresses: %w", err)
+	}
+
+	var names []types.NamespacedName
+	for i := range ingressList.Items {
+		ingress := &ingressList.Items[i]
+		managing, err := r.isManaging(ctx, ingress)
+		if err != nil {
+			return nil, fmt.Errorf("get ingressClass info: %w", err)
+		}
+		if managing {
+			names = append(names, types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name})
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestPeriodicResync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	names := []types.NamespacedName{{Namespace: "default", Name: "a"}, {Namespace: "other", Name: "b"}}
+	var calls int32
+	p := newPeriodicResync(time.Millisecond*10, func(ctx context.Context) ([]types.NamespacedName, error) {
+		// the list errors are retried on the next tick
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, errors.New("list failed")
+		}
+		return names, nil
+	})
+
+	done := make(chan error)
+	go func() { done <- p.Start(ctx) }()
+
+	for round := 0; round < 2; round++ {
+		for _, name := range names {
+			var evt event.GenericEvent
+			select {
+			case evt = <-p.out:
+			case <-time.After(time.Second * 5):
+				t.Fatal("timed out waiting for resync")
+			}
+			assert.Equal(t, name.Name, evt.Object.GetName(), "round %d", round)
+			assert.Equal(t, name.Namespace, evt.Object.GetNamespace(), "round %d", round)
+		}
+	}
+
+	// the pending resync should not block the shutdown
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// RouteRenderer returns the redacted pomerium routes generated for the ingress
+type RouteRenderer func(ctx context.Context, ic *model.IngressConfig) ([]v1alpha1.RenderedRoute, error)
+
+// setRouteStatus records the routes generated for the ingress and the sync outcome in the IngressRouteStatus
+// of the same name, that is created if missing. ic is nil if the ingress was not applied, in which case
+// the previously recorded routes are kept
+func (r *ingressController) setRouteStatus(ctx context.Context, ingress *networkingv1.Ingress, ic *model.IngressConfig, syncErr error) {
+	if r.routeRenderer == nil || r.skipWriteBack() {
+		return
+	}
+	if err := r.writeRouteStatus(ctx, ingress, ic, syncErr); err != nil {
+		log.FromContext(ctx).Error(err, "recording ingress route status")
+	}
+}
+
+func (r *ingressController) writeRouteStatus(ctx context.Context, ingress *networkingv1.Ingress, ic *model.IngressConfig, syncErr error) error {
+	cur, err := r.getOrCreateRouteStatus(ctx, ingress)
+	if err != nil {
+		return err
+	}
+
+	next := cur.DeepCopy()
+	next.Status.LastError = ""
+	if syncErr != nil {
+		next.Status.LastError = syncErr.Error()
+	}
+	if ic != nil {
+		if next.Status.Routes, err = r.routeRenderer(ctx, ic); err != nil {
+			return fmt.Errorf("render routes: %w", err)
+		}
+	}
+	// patch only on change, as the last sync time would otherwise be updated on every reconciliation
+	if equality.Semantic.DeepEqual(cur.Status, next.Status) {
+		return nil
+	}
+	now := metav1.Now()
+	next.Status.LastSyncTime = &now
+
+	if err := r.Client.Status().Patch(ctx, next, client.MergeFrom(cur)); err != nil {
+		return fmt.Errorf("patch ingress route status %s/%s: %w", cur.Namespace, cur.Name, err)
+	}
+	return nil
+}
+
+// getOrCreateRouteStatus returns the IngressRouteStatus of the ingress, creating it controlled by the ingress if missing,
+// so that it is garbage collected along with the ingress
+func (r *ingressController) getOrCreateRouteStatus(ctx context.Context, ingress *networkingv1.Ingress) (*v1alpha1.IngressRouteStatus, error) {
+	name := types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}
+	obj := new(v1alpha1.IngressRouteStatus)
+	err := r.Client.Get(ctx, name, obj)
+	if err == nil {
+		if !metav1.IsControlledBy(obj, ingress) {
+			// i.e. left over from the deleted ingress of the same name, and not yet garbage collected
+			return nil, fmt.Errorf("ingress route status %s is not controlled by the ingress %s", name, ingress.UID)
+		}
+		return obj, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("get ingress route status %s: %w", name, err)
+	}
+
+	obj = &v1alpha1.IngressRouteStatus{ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace}}
+	if err := controllerutil.SetControllerReference(ingress, obj, r.Scheme); err != nil {
+		return nil, fmt.Errorf("set ingress route status owner: %w", err)
+	}
+	if err := r.Client.Create(ctx, obj); err != nil {
+		return nil, fmt.Errorf("create ingress route status %s: %w", name, err)
+	}
+	return obj, nil
+}
+
+// removeRouteStatus deletes the IngressRouteStatus of the ingress that is no longer managed.
+// once the ingress itself is deleted, it is garbage collected via the owner reference
+func (r *ingressController) removeRouteStatus(ctx context.Context, ingress *networkingv1.Ingress) {
+	if r.routeRenderer == nil || r.skipWriteBack() {
+		return
+	}
+	obj := new(v1alpha1.IngressRouteStatus)
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}, obj)
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	if err == nil && metav1.IsControlledBy(obj, ingress) {
+		err = client.IgnoreNotFound(r.Client.Delete(ctx, obj))
+	}
+	if err != nil {
+		log.FromContext(ctx).Error(err, "removing ingress route status")
+	}
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func TestRouteStatus(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	ingress := &networkingv1.Ingress{
+		TypeMeta:   metav1.TypeMeta{Kind: "Ingress", APIVersion: "networking.k8s.io/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", UID: "ingress-uid"},
+	}
+	name := types.NamespacedName{Name: "ingress", Namespace: "default"}
+	routes := []v1alpha1.RenderedRoute{{Name: "a", From: "https://a.localhost.pomerium.io"}}
+	ctrl := ingressController{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(ingress).Build(),
+		Scheme: scheme,
+		routeRenderer: func(context.Context, *model.IngressConfig) ([]v1alpha1.RenderedRoute, error) {
+			return routes, nil
+		},
+	}
+	ic := &model.IngressConfig{Ingress: ingress}
+	get := func() *v1alpha1.IngressRouteStatus {
+		t.Helper()
+		obj := new(v1alpha1.IngressRouteStatus)
+		require.NoError(t, ctrl.Client.Get(ctx, name, obj))
+		return obj
+	}
+
+	ctrl.setRouteStatus(ctx, ingress, ic, nil)
+	obj := get()
+	assert.True(t, metav1.IsControlledBy(obj, ingress), "should be garbage collected with the ingress")
+	assert.Equal(t, routes, obj.Status.Routes)
+	assert.Empty(t, obj.Status.LastError)
+	require.NotNil(t, obj.Status.LastSyncTime)
+
+	ctrl.setRouteStatus(ctx, ingress, ic, nil)
+	assert.Equal(t, obj.ResourceVersion, get().ResourceVersion, "should not be patched if unchanged")
+
+	ctrl.setRouteStatus(ctx, ingress, nil, errors.New("upsert failed"))
+	obj = get()
+	assert.Equal(t, routes, obj.Status.Routes, "routes of the last successful sync should be kept")
+	assert.Equal(t, "upsert failed", obj.Status.LastError)
+
+	ctrl.removeRouteStatus(ctx, ingress)
+	assert.Error(t, ctrl.Client.Get(ctx, name, new(v1alpha1.IngressRouteStatus)))
+}
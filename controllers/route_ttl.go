@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	// reasonRouteExpired is reported once the ingress routes are removed as their route_ttl has elapsed
+	reasonRouteExpired = "RouteExpired"
+)
+
+// routeExpiry is the expiration of the ingress routes, that is extended each time the ingress is updated
+type routeExpiry struct {
+	// version identifies the ingress spec and annotations the expiration was set for
+	version string
+	expires time.Time
+	// expired is set once the routes were removed
+	expired bool
+}
+
+// routeTTLs tracks the expiration of the routes of the ingresses with the route_ttl annotation.
+// the expiration is kept in memory, so it restarts along with the controller, that never expires the routes early.
+// it is safe for concurrent use
+type routeTTLs struct {
+	sync.Mutex
+	items map[types.NamespacedName]*routeExpiry
+	now   func() time.Time
+}
+
+func newRouteTTLs() *routeTTLs {
+	return &routeTTLs{items: make(map[types.NamespacedName]*routeExpiry), now: time.Now}
+}
+
+// isExpired checks whether the routes of this version of the ingress have expired,
+// and whether it has just happened
+func (t *routeTTLs) isExpired(name types.NamespacedName, version string) (expired, justExpired bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	cur := t.items[name]
+	if cur == nil || cur.version != version || t.now().Before(cur.expires) {
+		return false, false
+	}
+	justExpired = !cur.expired
+	cur.expired = true
+	return true, justExpired
+}
+
+// refresh is called once the ingress is successfully applied, and returns when its routes would expire.
+// the expiration is only extended if the ingress was updated since it was last applied
+func (t *routeTTLs) refresh(name types.NamespacedName, version string, ttl time.Duration) time.Time {
+	t.Lock()
+	defer t.Unlock()
+
+	cur := t.items[name]
+	if cur == nil || cur.version != version {
+		cur = &routeExpiry{version: version, expires: t.now().Add(ttl)}
+		t.items[name] = cur
+	}
+	return cur.expires
+}
+
+// until returns the time remaining before the provided expiration
+func (t *routeTTLs) until(expires time.Time) time.Duration {
+	return expires.Sub(t.now())
+}
+
+func (t *routeTTLs) forget(name types.NamespacedName) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.items, name)
+}
+
+// getRouteTTL returns the route_ttl annotation value, if set
+func getRouteTTL(ic *model.IngressConfig) (time.Duration, bool, error) {
+	key := fmt.Sprintf("%s/%s", ic.AnnotationPrefix, model.RouteTTL)
+	txt, ok := ic.Ingress.Annotations[key]
+	if !ok {
+		return 0, false, nil
+	}
+	ttl, err := time.ParseDuration(txt)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", key, err)
+	}
+	if ttl <= 0 {
+		return 0, false, fmt.Errorf("%s: must be positive", key)
+	}
+	return ttl, true, nil
+}
+
+// ingressVersion identifies the ingress spec and annotations, except the ones written by the controller,
+// as the resource version also changes with the status and the sync state updates
+func ingressVersion(ingress *networkingv1.Ingress, annotationPrefix string) string {
+	annotations := make(map[string]string, len(ingress.Annotations))
+	for k, v := range ingress.Annotations {
+		annotations[k] = v
+	}
+	for _, name := range model.ControllerAnnotations {
+		delete(annotations, fmt.Sprintf("%s/%s", annotationPrefix, name))
+	}
+	data, _ := json.Marshal(struct {
+		Generation  int64
+		Annotations map[string]string
+	}{ingress.Generation, annotations})
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// upsertIngressWithTTL applies the ingress, unless its route_ttl has elapsed since it was last updated,
+// in which case its routes are removed until the ingress is updated again
+func (r *ingressController) upsertIngressWithTTL(ctx context.Context, ic *model.IngressConfig) (ctrl.Result, error) {
+	name := ic.GetIngressNamespacedName()
+	ttl, ok, err := getRouteTTL(ic)
+	if err != nil {
+		r.EventRecorder.Event(ic.Ingress, corev1.EventTypeWarning, reasonPomeriumConfigUpdateError, err.Error())
+		r.setSyncState(ctx, ic.Ingress, SyncPhaseError, reasonPomeriumConfigUpdateError, err.Error())
+		r.setRouteStatus(ctx, ic.Ingress, nil, err)
+		return requeueTransient(ctx, model.NewPermanentError(err))
+	}
+	if !ok {
+		r.routeTTLs.forget(name)
+		return r.upsertIngress(ctx, ic)
+	}
+
+	version := ingressVersion(ic.Ingress, ic.AnnotationPrefix)
+	if expired, justExpired := r.routeTTLs.isExpired(name, version); expired {
+		return r.expireRoutes(ctx, ic, ttl, justExpired)
+	}
+
+	result, err := r.upsertIngress(ctx, ic)
+	if err != nil || result.Requeue || result.RequeueAfter > 0 {
+		return result, err
+	}
+	expires := r.routeTTLs.refresh(name, version, ttl)
+	log.FromContext(ctx).V(1).Info("ingress routes expire unless updated", "expires", expires)
+	return ctrl.Result{RequeueAfter: r.routeTTLs.until(expires)}, nil
+}
+
+// expireRoutes removes the routes of the ingress whose route_ttl has elapsed
+func (r *ingressController) expireRoutes(ctx context.Context, ic *model.IngressConfig, ttl time.Duration, justExpired bool) (ctrl.Result, error) {
+	start := time.Now()
+	err := r.PomeriumReconciler.Delete(ctx, ic.GetIngressNamespacedName())
+	r.backpressure.Observe(start, err)
+	if err != nil {
+		return ctrl.Result{Requeue: true}, fmt.Errorf("deleting expired ingress routes: %w", err)
+	}
+	if !justExpired {
+		return ctrl.Result{}, nil
+	}
+
+	msg := fmt.Sprintf("routes removed as the ingress was not updated within %s=%s, update the ingress to restore them",
+		model.RouteTTL, ttl)
+	log.FromContext(ctx).Info("ingress routes expired", "ttl", ttl)
+	r.EventRecorder.Event(ic.Ingress, corev1.EventTypeWarning, reasonRouteExpired, msg)
+	r.setSyncState(ctx, ic.Ingress, SyncPhaseError, reasonRouteExpired, msg)
+	r.setRouteStatus(ctx, ic.Ingress, nil, fmt.Errorf("%s", msg))
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func TestRouteTTL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	ttls := newRouteTTLs()
+	ttls.now = func() time.Time { return now }
+	recorder := record.NewFakeRecorder(10)
+	target := new(recordingReconciler)
+	ctrl := ingressController{
+		Scheme:             clientgoscheme.Scheme,
+		Registry:           model.NewRegistry(),
+		PomeriumReconciler: target,
+		EventRecorder:      recorder,
+		syncStates:         newSyncStates(),
+		routeTTLs:          ttls,
+	}
+
+	ic := NewTestIngressConfig("preview", nil)
+	ic.AnnotationPrefix = DefaultAnnotationPrefix
+	ic.Ingress.Annotations = map[string]string{
+		DefaultAnnotationPrefix + "/" + model.RouteTTL: "1h",
+	}
+	name := ic.GetIngressNamespacedName()
+
+	result, err := ctrl.upsertIngressWithTTL(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, result.RequeueAfter, "should be reconciled once the routes expire")
+
+	// reconciles without the ingress updates do not extend the ttl
+	now = now.Add(time.Minute * 30)
+	result, err = ctrl.upsertIngressWithTTL(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute*30, result.RequeueAfter)
+	// the sync state written by the controller is not an ingress update
+	ic.Ingress.Annotations[DefaultAnnotationPrefix+"/"+model.SyncStateAnnotation] = "{}"
+	result, err = ctrl.upsertIngressWithTTL(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute*30, result.RequeueAfter)
+
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+	now = now.Add(time.Minute * 30)
+	result, err = ctrl.upsertIngressWithTTL(ctx, ic)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, []types.NamespacedName{name}, target.deletes, "expired routes should be removed")
+	assert.Len(t, target.upserts, 3)
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, reasonRouteExpired)
+	}
+	assert.Equal(t, SyncPhaseError, ctrl.syncStates.snapshot()[name].Phase)
+
+	_, err = ctrl.upsertIngressWithTTL(ctx, ic)
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Events, "expiration should only be reported once")
+	assert.Len(t, target.upserts, 3, "expired routes should not be restored")
+
+	// the ingress update restores the routes, and extends the ttl
+	ic.Ingress.Generation++
+	result, err = ctrl.upsertIngressWithTTL(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, result.RequeueAfter)
+	assert.Len(t, target.upserts, 4)
+	assert.Equal(t, SyncPhaseSynced, ctrl.syncStates.snapshot()[name].Phase)
+
+	ic.Ingress.Annotations[DefaultAnnotationPrefix+"/"+model.RouteTTL] = "-1h"
+	_, err = ctrl.upsertIngressWithTTL(ctx, ic)
+	require.NoError(t, err, "invalid ttl is not retried")
+	assert.Equal(t, SyncPhaseError, ctrl.syncStates.snapshot()[name].Phase)
+}
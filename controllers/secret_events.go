@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// maxReportedIngresses limits the number of ingresses listed in the secret event
+const maxReportedIngresses = 10
+
+// secretEvents keeps track of the invalid secrets that were already reported,
+// so that a secret referenced by many ingresses only gets a single event
+type secretEvents struct {
+	sync.Mutex
+	// reported holds secret resource version and error last reported
+	reported map[types.NamespacedName]string
+}
+
+// shouldReport returns true if the secret state changed since it was last reported
+func (s *secretEvents) shouldReport(secret *corev1.Secret, err error) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	if err == nil {
+		delete(s.reported, name)
+		return false
+	}
+
+	state := fmt.Sprintf("%s/%s", secret.ResourceVersion, err.Error())
+	if s.reported[name] == state {
+		return false
+	}
+	if s.reported == nil {
+		s.reported = make(map[types.NamespacedName]string)
+	}
+	s.reported[name] = state
+	return true
+}
+
+// reportInvalidSecrets emits a warning event on the invalid TLS secrets referenced by the ingress,
+// as secrets are often managed by a different team than the ingress
+func (r *ingressController) reportInvalidSecrets(ctx context.Context, ic *model.IngressConfig) {
+	if r.isStandby() {
+		// so that the secrets are reported once active
+		return
+	}
+	for _, secret := range ic.Secrets {
+		err := r.validateTLSSecret(secret)
+		if !r.secretEvents.shouldReport(secret, err) {
+			continue
+		}
+
+		ingresses := r.secretIngresses(secret, ic.GetIngressNamespacedName())
+		msg := fmt.Sprintf("%s, referenced by ingress %s", err.Error(), ingresses)
+		log.FromContext(ctx).Error(err, "invalid secret", "secret", fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
+		r.EventRecorder.Event(secret, corev1.EventTypeWarning, reasonInvalidSecret, msg)
+	}
+}
+
+// secretIngresses returns a list of ingresses that reference the secret
+func (r *ingressController) secretIngresses(secret *corev1.Secret, current types.NamespacedName) string {
+	names := map[string]bool{current.String(): true}
+	key := model.Key{Kind: r.secretKind, NamespacedName: types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}}
+	for _, k := range r.DepsOfKind(key, r.ingressKind) {
+		names[k.NamespacedName.String()] = true
+	}
+
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	if len(list) > maxReportedIngresses {
+		return fmt.Sprintf("%s and %d more", strings.Join(list[:maxReportedIngresses], ", "), len(list)-maxReportedIngresses)
+	}
+	return strings.Join(list, ", ")
+}
+
+// validateTLSSecret checks that a TLS secret holds a valid certificate and private key pair,
+// other secret types are not checked
+func (r *ingressController) validateTLSSecret(secret *corev1.Secret) error {
+	if secret.Type != corev1.SecretTypeTLS {
+		return nil
+	}
+	_, err := r.certCache.parseTLSSecret(secret)
+	return err
+}
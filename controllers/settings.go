@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	// settingsKind is the kind of the cluster-scoped object holding the global pomerium settings
+	settingsKind = "Pomerium"
+	// standbySettingsRetry is how often the settings are retried while the warm standby is not active
+	standbySettingsRetry = time.Second * 5
+)
+
+// SettingsReconciler applies the global pomerium settings, alongside the routes the PomeriumReconciler applies
+type SettingsReconciler interface {
+	// SetSettings applies the global settings, nil to remove the previously applied ones.
+	// model.PermanentError should be returned if the settings are invalid
+	SetSettings(ctx context.Context, gs *model.GlobalSettings) (changes bool, err error)
+}
+
+// settingsController watches the Pomerium object it is configured with, and the secrets it references,
+// and applies the global settings via SettingsReconciler
+type settingsController struct {
+	// name of the Pomerium object to apply
+	name string
+
+	client.Client
+	SettingsReconciler
+	// Registry keeps track of the secrets the Pomerium object references
+	model.Registry
+	// standby if set, gates the settings updates until active, as it does for the ingress configs
+	standby *WarmStandby
+
+	secretKind string
+}
+
+// hasSettings checks whether the Pomerium CRD is installed, and the type is registered with the scheme
+func hasSettings(scheme *runtime.Scheme, mapper meta.RESTMapper) (bool, error) {
+	gvk := v1alpha1.GroupVersion.WithKind(settingsKind)
+	if !scheme.Recognizes(gvk) {
+		return false, nil
+	}
+	if _, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); meta.IsNoMatchError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("settings: %w", err)
+	}
+	return true, nil
+}
+
+// setupSettingsController adds the controller applying the global settings from the named Pomerium object.
+// the PomeriumReconciler should also implement SettingsReconciler
+func setupSettingsController(mgr ctrl.Manager, pcr PomeriumReconciler, name string, standby *WarmStandby) error {
+	sr, ok := pcr.(SettingsReconciler)
+	if !ok {
+		return fmt.Errorf("global settings: %T does not apply settings", pcr)
+	}
+	installed, err := hasSettings(mgr.GetScheme(), mgr.GetRESTMapper())
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return fmt.Errorf("global settings: %s CRD is not installed", settingsKind)
+	}
+
+	sc := &settingsController{
+		name:               name,
+		Client:             mgr.GetClient(),
+		SettingsReconciler: sr,
+		Registry:           model.NewRegistry(),
+		standby:            standby,
+	}
+	return sc.SetupWithManager(mgr)
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *settingsController) SetupWithManager(mgr ctrl.Manager) error {
+	gvk, err := apiutil.GVKForObject(&corev1.Secret{}, mgr.GetScheme())
+	if err != nil {
+		return fmt.Errorf("cannot get kind: %w", err)
+	}
+	r.secretKind = gvk.Kind
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.Pomerium{}, builder.WithPredicates(
+			predicate.NewPredicateFuncs(func(obj client.Object) bool { return obj.GetName() == r.name }),
+			// the status updates are not reconciled again
+			predicate.GenerationChangedPredicate{},
+		)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.watchSecret)).
+		Complete(r)
+}
+
+// watchSecret returns the Pomerium object if it references the secret
+func (r *settingsController) watchSecret(a client.Object) []reconcile.Request {
+	name := types.NamespacedName{Name: a.GetName(), Namespace: a.GetNamespace()}
+	deps := r.DepsOfKind(model.Key{Kind: r.secretKind, NamespacedName: name}, settingsKind)
+	reqs := make([]reconcile.Request, 0, len(deps))
+	for _, k := range deps {
+		reqs = append(reqs, reconcile.Request{NamespacedName: k.NamespacedName})
+	}
+	return reqs
+}
+
+// Reconcile applies the global settings, and reports the outcome in the Pomerium object status.
+// the settings that could not be applied are retried once the object or the secrets it references change,
+// and only the databroker errors are retried regardless
+func (r *settingsController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("pomerium", req.Name)
+	if r.standby != nil && !r.standby.IsActive() {
+		return ctrl.Result{RequeueAfter: standbySettingsRetry}, nil
+	}
+	key := model.Key{Kind: settingsKind, NamespacedName: req.NamespacedName}
+	r.DeleteCascade(key)
+
+	obj := new(v1alpha1.Pomerium)
+	if err := r.Client.Get(ctx, req.NamespacedName, obj); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("get %s: %w", req.Name, err)
+		}
+		logger.Info("the global settings object is gone, removing the settings")
+		if _, err := r.SetSettings(ctx, nil); err != nil {
+			return ctrl.Result{}, fmt.Errorf("remove settings: %w", err)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	gs, err := r.fetchSettings(ctx, key, obj)
+	if err == nil {
+		_, err = r.SetSettings(ctx, gs)
+	}
+	if serr := r.updateStatus(ctx, obj, err); serr != nil {
+		return ctrl.Result{}, serr
+	}
+	if err != nil && !model.IsPermanentError(err) {
+		return ctrl.Result{}, fmt.Errorf("apply settings: %w", err)
+	}
+	if err != nil {
+		logger.Error(err, "the global settings could not be applied")
+	}
+	return ctrl.Result{}, nil
+}
+
+// fetchSettings resolves the secrets the Pomerium object references, and registers them as its dependencies,
+// so that it is reconciled once they are created or updated
+func (r *settingsController) fetchSettings(ctx context.Context, key model.Key, obj *v1alpha1.Pomerium) (*model.GlobalSettings, error) {
+	gs := &model.GlobalSettings{Pomerium: obj}
+	var err error
+	if idp := obj.Spec.IdentityProvider; idp != nil {
+		if gs.IdentityProviderSecret, err = r.fetchSecret(ctx, key, idp.Secret); err != nil {
+			return nil, fmt.Errorf("identityProvider: %w", err)
+		}
+	}
+	for _, ref := range obj.Spec.Certificates {
+		secret, err := r.fetchSecret(ctx, key, ref)
+		if err != nil {
+			return nil, fmt.Errorf("certificates: %w", err)
+		}
+		gs.Certificates = append(gs.Certificates, secret)
+	}
+	return gs, nil
+}
+
+// fetchSecret returns the secret referenced in namespace/name format.
+// a missing secret is a permanent error, as it is retried once the secret is created
+func (r *settingsController) fetchSecret(ctx context.Context, key model.Key, ref string) (*corev1.Secret, error) {
+	name, err := namespacedName(ref)
+	if err != nil {
+		return nil, model.NewPermanentError(fmt.Errorf("secret %q: %w", ref, err))
+	}
+	r.Add(key, model.Key{Kind: r.secretKind, NamespacedName: *name})
+
+	secret := new(corev1.Secret)
+	if err := r.Client.Get(ctx, *name, secret); apierrors.IsNotFound(err) {
+		return nil, model.NewPermanentError(fmt.Errorf("secret %s: %w", name.String(), err))
+	} else if err != nil {
+		return nil, fmt.Errorf("get secret %s: %w", name.String(), err)
+	}
+	return secret, nil
+}
+
+// updateStatus records the generation applied, or the reason it could not be
+func (r *settingsController) updateStatus(ctx context.Context, obj *v1alpha1.Pomerium, applyErr error) error {
+	next := obj.DeepCopy()
+	if applyErr == nil {
+		next.Status.ObservedGeneration = obj.Generation
+		next.Status.LastError = ""
+	} else {
+		next.Status.LastError = applyErr.Error()
+	}
+	if equality.Semantic.DeepEqual(obj.Status, next.Status) {
+		return nil
+	}
+	if err := r.Client.Status().Patch(ctx, next, client.MergeFrom(obj)); err != nil {
+		return fmt.Errorf("patch %s status: %w", obj.Name, err)
+	}
+	return nil
+}
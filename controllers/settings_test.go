@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// settingsRecorder is a SettingsReconciler that keeps the last applied settings
+type settingsRecorder struct {
+	last  *model.GlobalSettings
+	calls int
+	err   error
+}
+
+func (r *settingsRecorder) SetSettings(_ context.Context, gs *model.GlobalSettings) (bool, error) {
+	r.calls++
+	if r.err != nil {
+		return false, r.err
+	}
+	r.last = gs
+	return true, nil
+}
+
+func TestSettingsReconcile(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	obj := &v1alpha1.Pomerium{
+		ObjectMeta: metav1.ObjectMeta{Name: "global", Generation: 2},
+		Spec: v1alpha1.PomeriumSpec{
+			AuthenticateURL: "https://authenticate.localhost.pomerium.io",
+			IdentityProvider: &v1alpha1.IdentityProvider{
+				Provider: "oidc",
+				Secret:   "pomerium/idp",
+			},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "idp", Namespace: "pomerium"},
+		Data:       map[string][]byte{"client_id": []byte("id"), "client_secret": []byte("secret")},
+	}
+	secretName := types.NamespacedName{Namespace: "pomerium", Name: "idp"}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "global"}}
+	status := func(t *testing.T, r *settingsController) v1alpha1.PomeriumStatus {
+		t.Helper()
+		cur := new(v1alpha1.Pomerium)
+		require.NoError(t, r.Client.Get(ctx, req.NamespacedName, cur))
+		return cur.Status
+	}
+	setup := func(sr SettingsReconciler, objs ...runtime.Object) *settingsController {
+		return &settingsController{
+			name:               "global",
+			Client:             fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build(),
+			SettingsReconciler: sr,
+			Registry:           model.NewRegistry(),
+			secretKind:         "Secret",
+		}
+	}
+	watched := func(r *settingsController) []ctrl.Request {
+		return r.watchSecret(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName.Name, Namespace: secretName.Namespace}})
+	}
+
+	t.Run("applied", func(t *testing.T) {
+		sr := new(settingsRecorder)
+		r := setup(sr, obj.DeepCopy(), secret.DeepCopy())
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, sr.last)
+		assert.Equal(t, secret.Data, sr.last.IdentityProviderSecret.Data)
+		assert.Equal(t, v1alpha1.PomeriumStatus{ObservedGeneration: 2}, status(t, r))
+		assert.Equal(t, []ctrl.Request{req}, watched(r), "the secret rotation should re-apply the settings")
+	})
+	t.Run("missing secret", func(t *testing.T) {
+		sr := new(settingsRecorder)
+		r := setup(sr, obj.DeepCopy())
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err, "should wait for the secret to be created")
+		assert.Zero(t, sr.calls)
+		st := status(t, r)
+		assert.Zero(t, st.ObservedGeneration)
+		assert.Contains(t, st.LastError, "pomerium/idp")
+		assert.Equal(t, []ctrl.Request{req}, watched(r), "the secret creation should apply the settings")
+	})
+	t.Run("invalid settings", func(t *testing.T) {
+		prev := obj.DeepCopy()
+		prev.Status.ObservedGeneration = 1
+		sr := &settingsRecorder{err: model.NewPermanentError(errors.New("invalid"))}
+		r := setup(sr, prev, secret.DeepCopy())
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, v1alpha1.PomeriumStatus{ObservedGeneration: 1, LastError: "invalid"}, status(t, r),
+			"the last applied generation is kept")
+	})
+	t.Run("databroker error", func(t *testing.T) {
+		sr := &settingsRecorder{err: errors.New("unavailable")}
+		r := setup(sr, obj.DeepCopy(), secret.DeepCopy())
+		_, err := r.Reconcile(ctx, req)
+		assert.Error(t, err, "should be retried")
+		assert.Equal(t, "unavailable", status(t, r).LastError)
+	})
+	t.Run("deleted", func(t *testing.T) {
+		sr := &settingsRecorder{last: &model.GlobalSettings{}}
+		r := setup(sr, secret.DeepCopy())
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		assert.Nil(t, sr.last)
+		assert.Empty(t, watched(r))
+	})
+	t.Run("standby", func(t *testing.T) {
+		sr := new(settingsRecorder)
+		r := setup(sr, obj.DeepCopy(), secret.DeepCopy())
+		r.standby = NewWarmStandby()
+		res, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		assert.Zero(t, sr.calls)
+		assert.Equal(t, standbySettingsRetry, res.RequeueAfter)
+	})
+}
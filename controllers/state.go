@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// ErrNotReady is returned by the State methods until the controller caches are synced
+var ErrNotReady = errors.New("ingress controller caches are not synced yet")
+
+// SyncPhase describes where a managed ingress is in its reconciliation lifecycle
+type SyncPhase string
+
+const (
+	// SyncPhasePending indicates the ingress is managed, but was not yet applied to pomerium
+	SyncPhasePending SyncPhase = "Pending"
+	// SyncPhaseSynced indicates the ingress configuration was applied to pomerium
+	SyncPhaseSynced SyncPhase = "Synced"
+	// SyncPhaseError indicates the last reconciliation of the ingress failed
+	SyncPhaseError SyncPhase = "Error"
+
+	// reasonAwaitingReconcile is the reason of the pending ingresses
+	reasonAwaitingReconcile = "AwaitingReconcile"
+)
+
+// syncPhases lists all sync phases, so that the metrics of the phases without ingresses are reset to zero
+var syncPhases = []SyncPhase{SyncPhasePending, SyncPhaseSynced, SyncPhaseError}
+
+// IngressSyncState is the last known reconciliation state of a managed ingress
+type IngressSyncState struct {
+	Phase SyncPhase
+	// Reason is a machine readable code of the Phase cause, i.e. UpdateError
+	Reason string
+	// Message is a human readable description of the state, i.e. the last reconciliation error
+	Message string
+	// LastTransitionTime is when the Phase last changed. it keeps the monotonic clock reading,
+	// so the ordering of the states is not affected by wall clock adjustments
+	LastTransitionTime time.Time
+	// Warnings are the issues found by the last translation of the ingress, that did not prevent it from being applied
+	Warnings []string
+}
+
+// syncStates keeps track of the managed ingresses and their reconciliation states
+type syncStates struct {
+	sync.RWMutex
+	items map[types.NamespacedName]IngressSyncState
+}
+
+func newSyncStates() *syncStates {
+	return &syncStates{items: make(map[types.NamespacedName]IngressSyncState)}
+}
+
+// set updates an ingress state, only adjusting transition time if the phase has changed
+func (s *syncStates) set(name types.NamespacedName, phase SyncPhase, reason, msg string) IngressSyncState {
+	s.Lock()
+	defer s.Unlock()
+	defer s.updateMetrics()
+
+	cur, ok := s.items[name]
+	if !ok || cur.Phase != phase {
+		cur.LastTransitionTime = time.Now()
+	}
+	cur.Phase = phase
+	cur.Reason = reason
+	cur.Message = msg
+	s.items[name] = cur
+	return cur
+}
+
+// setPending marks ingress as pending, unless it is already being tracked
+func (s *syncStates) setPending(name types.NamespacedName) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.items[name]; ok {
+		return
+	}
+	s.items[name] = newPendingSyncState()
+	s.updateMetrics()
+}
+
+// setWarnings replaces the ingress translation warnings, and returns the ones that were not reported before
+func (s *syncStates) setWarnings(name types.NamespacedName, warnings []string) []string {
+	s.Lock()
+	defer s.Unlock()
+
+	cur, ok := s.items[name]
+	if !ok {
+		cur = newPendingSyncState()
+		defer s.updateMetrics()
+	}
+	prev := make(map[string]bool, len(cur.Warnings))
+	for _, w := range cur.Warnings {
+		prev[w] = true
+	}
+	var added []string
+	for _, w := range warnings {
+		if !prev[w] {
+			added = append(added, w)
+		}
+	}
+	cur.Warnings = warnings
+	s.items[name] = cur
+	return added
+}
+
+func (s *syncStates) delete(name types.NamespacedName) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.items, name)
+	s.updateMetrics()
+}
+
+func newPendingSyncState() IngressSyncState {
+	return IngressSyncState{Phase: SyncPhasePending, Reason: reasonAwaitingReconcile, LastTransitionTime: time.Now()}
+}
+
+// updateMetrics recounts the ingresses in each phase, so that the metrics always match the tracked states.
+// must be called with the lock held
+func (s *syncStates) updateMetrics() {
+	counts := make(map[SyncPhase]int, len(syncPhases))
+	for _, st := range s.items {
+		counts[st.Phase]++
+	}
+	for _, phase := range syncPhases {
+		ingressSyncPhases.WithLabelValues(string(phase)).Set(float64(counts[phase]))
+	}
+}
+
+func (s *syncStates) snapshot() map[types.NamespacedName]IngressSyncState {
+	s.RLock()
+	defer s.RUnlock()
+
+	dst := make(map[types.NamespacedName]IngressSyncState, len(s.items))
+	for k, v := range s.items {
+		dst[k] = v
+	}
+	return dst
+}
+
+// State provides read only access to the ingress controller caches and managed ingresses,
+// so that other controllers running within the same binary may reuse them.
+// It is safe for concurrent use, and returns ErrNotReady until the manager caches are synced.
+type State struct {
+	reader      client.Reader
+	scheme      *runtime.Scheme
+	registry    model.Registry
+	ingressKind string
+	states      *syncStates
+	synced      int32
+}
+
+// Cache returns a reader backed by the shared informer cache of the controller manager
+func (s *State) Cache() (client.Reader, error) {
+	if !s.isSynced() {
+		return nil, ErrNotReady
+	}
+	return s.reader, nil
+}
+
+// ManagedIngresses returns a snapshot of the ingresses currently managed by this controller
+func (s *State) ManagedIngresses() (map[types.NamespacedName]IngressSyncState, error) {
+	if !s.isSynced() {
+		return nil, ErrNotReady
+	}
+	return s.states.snapshot(), nil
+}
+
+// UnsyncedIngress is a managed ingress whose configuration is not currently applied to pomerium
+type UnsyncedIngress struct {
+	types.NamespacedName
+	IngressSyncState
+}
+
+// UnsyncedIngresses returns up to limit managed ingresses that are not synced, the longest unsynced first,
+// along with the total number of the unsynced ingresses. limit <= 0 returns all of them
+func (s *State) UnsyncedIngresses(limit int) ([]UnsyncedIngress, int, error) {
+	states, err := s.ManagedIngresses()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var unsynced []UnsyncedIngress
+	for name, st := range states {
+		if st.Phase != SyncPhaseSynced {
+			unsynced = append(unsynced, UnsyncedIngress{NamespacedName: name, IngressSyncState: st})
+		}
+	}
+	sort.Slice(unsynced, func(i, j int) bool {
+		a, b := unsynced[i], unsynced[j]
+		if !a.LastTransitionTime.Equal(b.LastTransitionTime) {
+			return a.LastTransitionTime.Before(b.LastTransitionTime)
+		}
+		return a.String() < b.String()
+	})
+	total := len(unsynced)
+	if limit > 0 && total > limit {
+		unsynced = unsynced[:limit]
+	}
+	return unsynced, total, nil
+}
+
+func (s *State) isSynced() bool {
+	return atomic.LoadInt32(&s.synced) == 1
+}
+
+// waitForCacheSync is a manager runnable that marks the state ready once the caches are synced
+type waitForCacheSync struct {
+	mgr ctrl.Manager
+	*State
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (w *waitForCacheSync) NeedLeaderElection() bool { return false }
+
+// Start implements manager.Runnable
+func (w *waitForCacheSync) Start(ctx context.Context) error {
+	if w.mgr.GetCache().WaitForCacheSync(ctx) {
+		atomic.StoreInt32(&w.synced, 1)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestUnsyncedIngresses(t *testing.T) {
+	states := newSyncStates()
+	s := &State{states: states}
+	_, _, err := s.UnsyncedIngresses(0)
+	assert.ErrorIs(t, err, ErrNotReady)
+	s.synced = 1
+
+	a, b, c, d := types.NamespacedName{Namespace: "default", Name: "a"}, types.NamespacedName{Namespace: "default", Name: "b"},
+		types.NamespacedName{Namespace: "default", Name: "c"}, types.NamespacedName{Namespace: "default", Name: "d"}
+	states.set(a, SyncPhaseError, reasonPomeriumConfigUpdateError, "failed")
+	states.setPending(b)
+	states.set(c, SyncPhaseSynced, reasonPomeriumConfigUpdated, msgPomeriumConfigUpdated)
+	states.setWarnings(d, []string{"warning"})
+
+	phases := func() map[SyncPhase]float64 {
+		dst := make(map[SyncPhase]float64)
+		for _, phase := range syncPhases {
+			dst[phase] = testutil.ToFloat64(ingressSyncPhases.WithLabelValues(string(phase)))
+		}
+		return dst
+	}
+	assert.Equal(t, map[SyncPhase]float64{SyncPhasePending: 2, SyncPhaseSynced: 1, SyncPhaseError: 1}, phases())
+
+	unsynced, total, err := s.UnsyncedIngresses(2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total, "metrics and unsynced ingresses should agree")
+	if assert.Len(t, unsynced, 2) {
+		assert.Equal(t, a, unsynced[0].NamespacedName, "the longest unsynced ingress should be first")
+		assert.Equal(t, reasonPomeriumConfigUpdateError, unsynced[0].Reason)
+		assert.Equal(t, b, unsynced[1].NamespacedName)
+		assert.Equal(t, reasonAwaitingReconcile, unsynced[1].Reason)
+	}
+
+	states.set(a, SyncPhaseSynced, reasonPomeriumConfigUpdated, msgPomeriumConfigUpdated)
+	states.delete(b)
+	states.delete(d)
+	assert.Equal(t, map[SyncPhase]float64{SyncPhasePending: 0, SyncPhaseSynced: 2, SyncPhaseError: 0}, phases())
+	unsynced, total, err = s.UnsyncedIngresses(0)
+	require.NoError(t, err)
+	assert.Empty(t, unsynced)
+	assert.Zero(t, total)
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	reasonIngressStatusUpdateError = "IngressStatusUpdateError"
+
+	msgServiceForbidden = "permission denied to get the pomerium proxy service: " +
+		"grant the ingress controller service account get, list and watch verbs on services"
+	msgIngressStatusForbidden = "permission denied to update ingress status: " +
+		"grant the ingress controller service account get, update and patch verbs on ingresses/status in the networking.k8s.io API group"
+)
+
+// StatusUpdaterHealth tracks the outcome of the ingress status updates from the pomerium proxy service,
+// that may fail independently from the pomerium configuration updates, i.e. due to missing RBAC permissions.
+// It is safe for concurrent use.
+type StatusUpdaterHealth struct {
+	sync.Mutex
+	lastSuccess   time.Time
+	lastError     error
+	lastErrorTime time.Time
+	// lastReported is the last error message that was reported as an event
+	lastReported string
+}
+
+// NewStatusUpdaterHealth creates a new status updater health tracker
+func NewStatusUpdaterHealth() *StatusUpdaterHealth {
+	return new(StatusUpdaterHealth)
+}
+
+// Check is a health check that fails if the last ingress status update has failed
+func (h *StatusUpdaterHealth) Check(_ *http.Request) error {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.lastError == nil {
+		return nil
+	}
+	return fmt.Errorf("ingress status update failed at %s (last success: %s): %w",
+		h.lastErrorTime.Format(time.RFC3339), formatTime(h.lastSuccess), h.lastError)
+}
+
+// record updates the status updater health with the outcome of the status update,
+// and returns the message to report, if this error differs from the previously reported one
+func (h *StatusUpdaterHealth) record(err error) (msg string, report bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	if err == nil {
+		h.lastSuccess = time.Now()
+		h.lastError = nil
+		h.lastReported = ""
+		return "", false
+	}
+
+	h.lastError = err
+	h.lastErrorTime = time.Now()
+
+	msg = err.Error()
+	var rbacErr *statusForbiddenError
+	if errors.As(err, &rbacErr) {
+		// the underlying error would mention individual ingresses
+		msg = rbacErr.msg
+	}
+	if msg == h.lastReported {
+		return msg, false
+	}
+	h.lastReported = msg
+	return msg, true
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// statusForbiddenError indicates the controller lacks permissions to update the ingress status
+type statusForbiddenError struct {
+	msg string
+	err error
+}
+
+func (e *statusForbiddenError) Error() string { return fmt.Sprintf("%s: %v", e.msg, e.err) }
+
+func (e *statusForbiddenError) Unwrap() error { return e.err }
+
+func (r *ingressController) updateIngressStatus(ctx context.Context, ingress *networkingv1.Ingress) error {
+	name := r.statusService(ingress)
+	if name == nil || r.skipWriteBack() {
+		return nil
+	}
+
+	svc := new(corev1.Service)
+	err := r.setIngressStatusFromService(ctx, ingress, *name, svc)
+	if svc.Name == "" {
+		svc.Name, svc.Namespace = name.Name, name.Namespace
+	}
+	r.recordStatusUpdate(ctx, svc, err)
+	return err
+}
+
+// statusService returns the proxy service the ingress status should be updated from,
+// that is the one set via status_from_service annotation, or the controller-wide one, if any
+func (r *ingressController) statusService(ingress *networkingv1.Ingress) *types.NamespacedName {
+	ic := &model.IngressConfig{
+		AnnotationPrefix:      r.annotationPrefix,
+		AllowedStatusServices: r.allowedStatusServices,
+		Ingress:               ingress,
+	}
+	name, err := ic.GetStatusFromService()
+	if err != nil {
+		// an invalid annotation would be already reported when applying the ingress routes
+		return nil
+	}
+	if name != nil {
+		return name
+	}
+	return r.updateStatusFromService
+}
+
+func (r *ingressController) setIngressStatusFromService(
+	ctx context.Context,
+	ingress *networkingv1.Ingress,
+	name types.NamespacedName,
+	svc *corev1.Service,
+) error {
+	if err := r.Client.Get(ctx, name, svc); err != nil {
+		if apierrors.IsForbidden(err) {
+			err = &statusForbiddenError{msg: msgServiceForbidden, err: err}
+		}
+		return fmt.Errorf("get pomerium-proxy service %s: %w", name.String(), err)
+	}
+
+	ingress.Status.LoadBalancer = *svc.Status.LoadBalancer.DeepCopy()
+	r.setIngressStatusListenerPort(ingress, svc)
+	if err := r.Client.Status().Update(ctx, ingress); err != nil {
+		if apierrors.IsForbidden(err) {
+			return &statusForbiddenError{msg: msgIngressStatusForbidden, err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// setIngressStatusListenerPort reports the proxy service port the ingress routes are attached to
+// via listener_port annotation, if the proxy service exposes it
+func (r *ingressController) setIngressStatusListenerPort(ingress *networkingv1.Ingress, svc *corev1.Service) {
+	ic := &model.IngressConfig{
+		AnnotationPrefix:     r.annotationPrefix,
+		AllowedListenerPorts: r.allowedListenerPorts,
+		Ingress:              ingress,
+	}
+	// an invalid annotation would be already reported when applying the ingress routes
+	port, err := ic.GetListenerPort()
+	if err != nil || port == 0 {
+		return
+	}
+
+	sp := getServicePort(svc, port)
+	if sp == nil {
+		return
+	}
+	for i := range ingress.Status.LoadBalancer.Ingress {
+		ingress.Status.LoadBalancer.Ingress[i].Ports = []corev1.PortStatus{{Port: sp.Port, Protocol: sp.Protocol}}
+	}
+}
+
+func getServicePort(svc *corev1.Service, port int32) *corev1.ServicePort {
+	for i := range svc.Spec.Ports {
+		if svc.Spec.Ports[i].Port == port {
+			return &svc.Spec.Ports[i]
+		}
+	}
+	return nil
+}
+
+// recordStatusUpdate updates status updater health and metrics,
+// and emits a single event on the proxy service for each distinct error
+func (r *ingressController) recordStatusUpdate(ctx context.Context, svc *corev1.Service, err error) {
+	if err != nil {
+		statusUpdates.WithLabelValues(metricResultError).Inc()
+		statusUpdaterHealthy.Set(0)
+	} else {
+		statusUpdates.WithLabelValues(metricResultSuccess).Inc()
+		statusUpdaterHealthy.Set(1)
+	}
+
+	msg, report := r.statusUpdaterHealth.record(err)
+	if !report {
+		return
+	}
+	log.FromContext(ctx).Error(err, "updating ingress status", "service", fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+	r.EventRecorder.Event(svc, corev1.EventTypeWarning, reasonIngressStatusUpdateError, msg)
+}
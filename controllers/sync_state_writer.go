@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	// SyncStateWriterNone does not record the ingress sync state on the ingress objects
+	SyncStateWriterNone = "none"
+	// SyncStateWriterAnnotation records the ingress sync state as a JSON annotation on the ingress,
+	// along with the last applied generation and the reconciliation error annotations
+	SyncStateWriterAnnotation = "annotation"
+)
+
+// SyncStateWriters lists supported sync state writers
+var SyncStateWriters = []string{SyncStateWriterNone, SyncStateWriterAnnotation}
+
+// syncStateWriter records the ingress sync state on the ingress object,
+// so that it is visible to the ingress owners. implementations should only update the ingress if the
+// recorded state has changed, as the ingress update triggers another reconciliation
+type syncStateWriter interface {
+	// Write records the current sync state on the ingress
+	Write(ctx context.Context, ingress *networkingv1.Ingress, state IngressSyncState) error
+	// Remove removes previously recorded sync state, once the ingress is no longer managed
+	Remove(ctx context.Context, ingress *networkingv1.Ingress) error
+}
+
+func newSyncStateWriter(kind string, c client.Client, annotationPrefix string) (syncStateWriter, error) {
+	switch kind {
+	case "", SyncStateWriterNone:
+		return noopSyncStateWriter{}, nil
+	case SyncStateWriterAnnotation:
+		return &annotationSyncStateWriter{
+			Client:        c,
+			key:           fmt.Sprintf("%s/%s", annotationPrefix, model.SyncStateAnnotation),
+			generationKey: fmt.Sprintf("%s/%s", annotationPrefix, model.LastAppliedGeneration),
+			errorKey:      fmt.Sprintf("%s/%s", annotationPrefix, model.ReconcileError),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown sync state writer %q, supported values are %v", kind, SyncStateWriters)
+	}
+}
+
+type noopSyncStateWriter struct{}
+
+// Write implements syncStateWriter
+func (noopSyncStateWriter) Write(context.Context, *networkingv1.Ingress, IngressSyncState) error {
+	return nil
+}
+
+// Remove implements syncStateWriter
+func (noopSyncStateWriter) Remove(context.Context, *networkingv1.Ingress) error { return nil }
+
+// syncStateAnnotation is a condition-like representation of the sync state
+type syncStateAnnotation struct {
+	Phase              SyncPhase   `json:"phase"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	Message            string      `json:"message,omitempty"`
+	Warnings           []string    `json:"warnings,omitempty"`
+}
+
+// annotationSyncStateWriter keeps the sync state as JSON in the ingress annotation.
+// the generation last applied to pomerium, and the error of the last failed reconciliation
+// are also kept in their own annotations, so that the CD tooling may wait for the ingress to be applied
+type annotationSyncStateWriter struct {
+	client.Client
+	key, generationKey, errorKey string
+}
+
+// Write implements syncStateWriter
+func (w *annotationSyncStateWriter) Write(ctx context.Context, ingress *networkingv1.Ingress, state IngressSyncState) error {
+	data, err := json.Marshal(syncStateAnnotation{
+		Phase:              state.Phase,
+		LastTransitionTime: metav1.NewTime(state.LastTransitionTime),
+		Message:            state.Message,
+		Warnings:           state.Warnings,
+	})
+	if err != nil {
+		return err
+	}
+
+	next := map[string]*string{w.key: proto.String(string(data))}
+	switch state.Phase {
+	case SyncPhaseSynced:
+		next[w.generationKey] = proto.String(strconv.FormatInt(ingress.Generation, 10))
+		next[w.errorKey] = nil
+	case SyncPhaseError:
+		next[w.errorKey] = proto.String(state.Message)
+	}
+	return w.update(ctx, ingress, next)
+}
+
+// Remove implements syncStateWriter
+func (w *annotationSyncStateWriter) Remove(ctx context.Context, ingress *networkingv1.Ingress) error {
+	return w.update(ctx, ingress, map[string]*string{w.key: nil, w.generationKey: nil, w.errorKey: nil})
+}
+
+// update sets the annotations to the given values, or removes the ones set to nil,
+// and only patches the ingress if any of them has changed
+func (w *annotationSyncStateWriter) update(ctx context.Context, ingress *networkingv1.Ingress, next map[string]*string) error {
+	changed := false
+	for key, val := range next {
+		cur, ok := ingress.Annotations[key]
+		if (val == nil && ok) || (val != nil && (!ok || cur != *val)) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return w.patch(ctx, ingress, func(dst *networkingv1.Ingress) {
+		if dst.Annotations == nil {
+			dst.Annotations = make(map[string]string)
+		}
+		for key, val := range next {
+			if val == nil {
+				delete(dst.Annotations, key)
+			} else {
+				dst.Annotations[key] = *val
+			}
+		}
+	})
+}
+
+func (w *annotationSyncStateWriter) patch(ctx context.Context, ingress *networkingv1.Ingress, fn func(*networkingv1.Ingress)) error {
+	dst := ingress.DeepCopy()
+	fn(dst)
+	if err := w.Client.Patch(ctx, dst, client.MergeFrom(ingress)); err != nil {
+		return fmt.Errorf("patch ingress %s/%s sync state annotations: %w", ingress.Namespace, ingress.Name, err)
+	}
+	// so that subsequent ingress status update is based on the current resource version
+	dst.DeepCopyInto(ingress)
+	return nil
+}
+
+// setSyncState updates the tracked ingress sync state, and records it on the ingress object
+func (r *ingressController) setSyncState(ctx context.Context, ingress *networkingv1.Ingress, phase SyncPhase, reason, msg string) {
+	name := types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}
+	state := r.syncStates.set(name, phase, reason, msg)
+	if r.syncStateWriter == nil || r.skipWriteBack() {
+		return
+	}
+	if err := r.syncStateWriter.Write(ctx, ingress, state); err != nil {
+		log.FromContext(ctx).Error(err, "recording ingress sync state")
+	}
+}
+
+// removeSyncState removes the sync state recorded on the ingress object that is no longer managed
+func (r *ingressController) removeSyncState(ctx context.Context, ingress *networkingv1.Ingress) {
+	if r.syncStateWriter == nil || r.skipWriteBack() {
+		return
+	}
+	if err := r.syncStateWriter.Remove(ctx, ingress); err != nil {
+		log.FromContext(ctx).Error(err, "removing ingress sync state")
+	}
+}
+
+// ignoreControllerAnnotationUpdates filters out the ingress updates that only change the annotations
+// written by the controller itself, so that recording the sync state does not trigger another reconciliation
+func ignoreControllerAnnotationUpdates(annotationPrefix string) predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(evt event.UpdateEvent) bool {
+			return !onlyControllerAnnotationsChanged(evt.ObjectOld, evt.ObjectNew, annotationPrefix)
+		},
+	}
+}
+
+// onlyControllerAnnotationsChanged checks whether the objects are the same, except for the controller annotations
+// and the metadata that changes with any update. the object is compared as a whole,
+// so that i.e. the ingress status updates made by the others are still reconciled
+func onlyControllerAnnotationsChanged(prev, next client.Object, annotationPrefix string) bool {
+	a, err := controllerAnnotationsStripped(prev, annotationPrefix)
+	if err != nil {
+		return false
+	}
+	b, err := controllerAnnotationsStripped(next, annotationPrefix)
+	if err != nil {
+		return false
+	}
+	return equality.Semantic.DeepEqual(a, b)
+}
+
+func controllerAnnotationsStripped(obj client.Object, annotationPrefix string) (map[string]interface{}, error) {
+	dst, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	unstructured.RemoveNestedField(dst, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(dst, "metadata", "managedFields")
+	for _, name := range model.ControllerAnnotations {
+		unstructured.RemoveNestedField(dst, "metadata", "annotations", fmt.Sprintf("%s/%s", annotationPrefix, name))
+	}
+	if annotations, _, _ := unstructured.NestedMap(dst, "metadata", "annotations"); len(annotations) == 0 {
+		unstructured.RemoveNestedField(dst, "metadata", "annotations")
+	}
+	return dst, nil
+}
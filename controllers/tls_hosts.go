@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// warningUnusedTLSHost is reported if spec.tls lists a host that none of the ingress rules match
+const warningUnusedTLSHost = "UnusedTLSHost"
+
+// tlsHostMatches checks whether the spec.tls host covers the rule host.
+// a wildcard entry covers a single leftmost label, as the certificate issued for it would
+func tlsHostMatches(tlsHost, host string) bool {
+	if strings.EqualFold(tlsHost, host) {
+		return true
+	}
+	if !strings.HasPrefix(tlsHost, "*.") {
+		return false
+	}
+	parts := strings.SplitN(host, ".", 2)
+	return len(parts) == 2 && parts[0] != "*" && strings.EqualFold(tlsHost[2:], parts[1])
+}
+
+// tlsBlockMatches checks whether the spec.tls entry covers the rule host,
+// an entry without hosts applies to all hosts of the ingress
+func tlsBlockMatches(tls networkingv1.IngressTLS, host string) bool {
+	if len(tls.Hosts) == 0 {
+		return true
+	}
+	for _, h := range tls.Hosts {
+		if tlsHostMatches(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleHosts returns the distinct hosts of the ingress rules
+func ruleHosts(ingress *networkingv1.Ingress) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" || seen[rule.Host] {
+			continue
+		}
+		seen[rule.Host] = true
+		hosts = append(hosts, rule.Host)
+	}
+	return hosts
+}
+
+// uncoveredHosts returns the rule hosts that none of the spec.tls entries referencing a secret cover,
+// and that would need the default certificate
+func uncoveredHosts(ingress *networkingv1.Ingress) []string {
+	var hosts []string
+	for _, host := range ruleHosts(ingress) {
+		covered := false
+		for _, tls := range ingress.Spec.TLS {
+			if tls.SecretName != "" && tlsBlockMatches(tls, host) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// warnUnusedTLSHosts warns about the spec.tls hosts that do not match any of the ingress rules.
+// the default backend is served on the spec.tls host, hence all of them are in use if it is set
+func warnUnusedTLSHosts(ic *model.IngressConfig) {
+	if ic.Spec.DefaultBackend != nil {
+		return
+	}
+	hosts := ruleHosts(ic.Ingress)
+	for _, tls := range ic.Spec.TLS {
+		for _, tlsHost := range tls.Hosts {
+			used := false
+			for _, host := range hosts {
+				if tlsHostMatches(tlsHost, host) {
+					used = true
+					break
+				}
+			}
+			if !used {
+				ic.Warn(warningUnusedTLSHost, fmt.Sprintf("spec.tls host %s does not match any of the ingress rules", tlsHost))
+			}
+		}
+	}
+}
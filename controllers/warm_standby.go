@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// WarmStandby lets the ingress controller run on a replica that does not hold the databroker lease,
+// keeping its caches and ingress configs up to date in memory, while performing no pomerium configuration
+// or ingress object updates. Once the lease is acquired, the warm ingress configs are applied to pomerium at once.
+//
+// WarmStandby implements PomeriumReconciler that keeps the ingress configs and forwards them to the target reconciler
+// only while active. It is safe for concurrent use.
+type WarmStandby struct {
+	mu     sync.Mutex
+	target PomeriumReconciler
+	active bool
+	// configs are the current ingress configs, kept regardless of whether active
+	configs map[types.NamespacedName]*model.IngressConfig
+
+	// warm is closed once the controller has performed the initial sync
+	warm     chan struct{}
+	warmOnce sync.Once
+}
+
+// NewWarmStandby creates a warm standby, that is initially not active
+func NewWarmStandby() *WarmStandby {
+	return &WarmStandby{
+		configs: make(map[types.NamespacedName]*model.IngressConfig),
+		warm:    make(chan struct{}),
+	}
+}
+
+func (w *WarmStandby) setTarget(pcr PomeriumReconciler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.target = pcr
+}
+
+// Activate waits for the initial ingress controller sync, applies the warm ingress configs to the target
+// reconciler, and forwards all subsequent updates to it until Deactivate is called
+func (w *WarmStandby) Activate(ctx context.Context) error {
+	select {
+	case <-w.warm:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.target == nil {
+		return fmt.Errorf("warm standby is not used by an ingress controller")
+	}
+	if _, err := w.target.Set(ctx, w.snapshot()); err != nil {
+		return fmt.Errorf("applying warm ingress configs: %w", err)
+	}
+	w.active = true
+	return nil
+}
+
+// Deactivate stops forwarding updates to the target reconciler, i.e. once the lease is lost
+func (w *WarmStandby) Deactivate() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.active = false
+}
+
+// IsActive checks whether the updates are applied to pomerium and the ingress objects
+func (w *WarmStandby) IsActive() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.active
+}
+
+func (w *WarmStandby) snapshot() []*model.IngressConfig {
+	ics := make([]*model.IngressConfig, 0, len(w.configs))
+	for _, ic := range w.configs {
+		ics = append(ics, ic)
+	}
+	// so that the ingresses are applied in the same order the initial sync would
+	sort.Slice(ics, func(i, j int) bool {
+		return ics[i].GetIngressNamespacedName().String() < ics[j].GetIngressNamespacedName().String()
+	})
+	return ics
+}
+
+// Upsert implements PomeriumReconciler
+func (w *WarmStandby) Upsert(ctx context.Context, ic *model.IngressConfig) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.configs[ic.GetIngressNamespacedName()] = ic
+	if !w.active {
+		return false, nil
+	}
+	return w.target.Upsert(ctx, ic)
+}
+
+// Set implements PomeriumReconciler
+func (w *WarmStandby) Set(ctx context.Context, ics []*model.IngressConfig) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.configs = make(map[types.NamespacedName]*model.IngressConfig, len(ics))
+	for _, ic := range ics {
+		w.configs[ic.GetIngressNamespacedName()] = ic
+	}
+	w.warmOnce.Do(func() { close(w.warm) })
+	if !w.active {
+		return false, nil
+	}
+	return w.target.Set(ctx, ics)
+}
+
+// Delete implements PomeriumReconciler
+func (w *WarmStandby) Delete(ctx context.Context, name types.NamespacedName) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.configs, name)
+	if !w.active {
+		return nil
+	}
+	return w.target.Delete(ctx, name)
+}
+
+// isStandby checks whether the controller should refrain from updating the ingress objects
+func (r *ingressController) isStandby() bool {
+	return r.warmStandby != nil && !r.warmStandby.IsActive()
+}
+
+// standbyEventRecorder drops the events while in standby
+type standbyEventRecorder struct {
+	record.EventRecorder
+	standby *WarmStandby
+}
+
+// Event implements record.EventRecorder
+func (r *standbyEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.standby.IsActive() {
+		r.EventRecorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// Eventf implements record.EventRecorder
+func (r *standbyEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.standby.IsActive() {
+		r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// AnnotatedEventf implements record.EventRecorder
+func (r *standbyEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.standby.IsActive() {
+		r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// recordingReconciler records the ingresses applied to pomerium
+type recordingReconciler struct {
+	upserts, deletes []types.NamespacedName
+	sets             [][]types.NamespacedName
+}
+
+func (r *recordingReconciler) Upsert(_ context.Context, ic *model.IngressConfig) (bool, error) {
+	r.upserts = append(r.upserts, ic.GetIngressNamespacedName())
+	return true, nil
+}
+
+func (r *recordingReconciler) Set(_ context.Context, ics []*model.IngressConfig) (bool, error) {
+	var names []types.NamespacedName
+	for _, ic := range ics {
+		names = append(names, ic.GetIngressNamespacedName())
+	}
+	r.sets = append(r.sets, names)
+	return true, nil
+}
+
+func (r *recordingReconciler) Delete(_ context.Context, name types.NamespacedName) error {
+	r.deletes = append(r.deletes, name)
+	return nil
+}
+
+func TestWarmStandbyNoWrites(t *testing.T) {
+	ctx := context.Background()
+	// mock client has no expectations set, so any ingress object update fails the test
+	mc := NewMockClient(gomock.NewController(t))
+	syncStateWriter, err := newSyncStateWriter(SyncStateWriterAnnotation, mc, DefaultAnnotationPrefix)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	target := new(recordingReconciler)
+	standby := NewWarmStandby()
+	standby.setTarget(target)
+
+	ctrl := ingressController{
+		Client:                  mc,
+		Scheme:                  clientgoscheme.Scheme,
+		Registry:                model.NewRegistry(),
+		PomeriumReconciler:      standby,
+		EventRecorder:           &standbyEventRecorder{EventRecorder: recorder, standby: standby},
+		syncStates:              newSyncStates(),
+		syncStateWriter:         syncStateWriter,
+		updateStatusFromService: &types.NamespacedName{Name: "pomerium-proxy", Namespace: "pomerium"},
+		statusUpdaterHealth:     NewStatusUpdaterHealth(),
+		hostConflicts:           newHostConflicts(HostConflictOldestWins),
+		warmStandby:             standby,
+		ingressKind:             "Ingress",
+		serviceKind:             "Service",
+	}
+
+	_, err = standby.Set(ctx, []*model.IngressConfig{NewTestIngressConfig("a", nil), NewTestIngressConfig("b", nil)})
+	require.NoError(t, err)
+	_, err = ctrl.upsertIngress(ctx, NewTestIngressConfig("c", nil))
+	require.NoError(t, err)
+	_, err = ctrl.deleteIngress(ctx, types.NamespacedName{Name: "a", Namespace: "default"}, "test")
+	require.NoError(t, err)
+
+	assert.Empty(t, target.sets, "no pomerium updates while standby")
+	assert.Empty(t, target.upserts, "no pomerium updates while standby")
+	assert.Empty(t, target.deletes, "no pomerium updates while standby")
+	assert.Empty(t, recorder.Events, "no events while standby")
+	states, ok := ctrl.syncStates.snapshot()[types.NamespacedName{Name: "c", Namespace: "default"}]
+	assert.True(t, ok, "sync state should be tracked in memory while standby")
+	assert.Equal(t, SyncPhaseSynced, states.Phase)
+
+	require.NoError(t, standby.Activate(ctx))
+	assert.Equal(t, [][]types.NamespacedName{{
+		{Name: "b", Namespace: "default"},
+		{Name: "c", Namespace: "default"},
+	}}, target.sets, "warm configs should be applied at once")
+
+	_, err = standby.Upsert(ctx, NewTestIngressConfig("d", nil))
+	require.NoError(t, err)
+	assert.Equal(t, []types.NamespacedName{{Name: "d", Namespace: "default"}}, target.upserts)
+
+	standby.Deactivate()
+	require.NoError(t, standby.Delete(ctx, types.NamespacedName{Name: "d", Namespace: "default"}))
+	assert.Empty(t, target.deletes, "no pomerium updates once deactivated")
+}
+
+func TestWarmStandbyActivateWaitsForSync(t *testing.T) {
+	standby := NewWarmStandby()
+	standby.setTarget(new(recordingReconciler))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, standby.Activate(ctx), context.Canceled, "should not activate before the initial sync")
+	assert.False(t, standby.IsActive())
+}
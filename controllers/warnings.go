@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// reportWarnings records the warnings of the last ingress translation, that replace the previous ones,
+// so that resolved warnings are cleared. Only the new warnings are reported as events.
+func (r *ingressController) reportWarnings(ctx context.Context, ic *model.IngressConfig) {
+	warnings := ic.Warnings.List()
+	txt := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		txt = append(txt, w.String())
+	}
+
+	name := ic.GetIngressNamespacedName()
+	translationWarnings.WithLabelValues(name.Namespace, name.Name).Set(float64(len(warnings)))
+	added := make(map[string]bool)
+	for _, w := range r.syncStates.setWarnings(name, txt) {
+		added[w] = true
+	}
+	for _, w := range warnings {
+		if !added[w.String()] {
+			continue
+		}
+		log.FromContext(ctx).Info("translation warning", "reason", w.Reason, "message", w.Message)
+		r.EventRecorder.Event(ic.Ingress, corev1.EventTypeWarning, w.Reason, w.Message)
+	}
+}
+
+// clearWarnings removes the warnings metric of the ingress that is no longer managed
+func clearWarnings(name types.NamespacedName) {
+	translationWarnings.DeleteLabelValues(name.Namespace, name.Name)
+}
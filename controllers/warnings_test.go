@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func TestReportWarnings(t *testing.T) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	ctrl := ingressController{
+		EventRecorder: recorder,
+		syncStates:    newSyncStates(),
+	}
+
+	translate := func(warnings ...model.Warning) *model.IngressConfig {
+		ic := NewTestIngressConfig("a", nil)
+		ic.Warnings = new(model.Warnings)
+		for _, w := range warnings {
+			ic.Warn(w.Reason, w.Message)
+		}
+		return ic
+	}
+	first := model.Warning{Reason: "First", Message: "first warning"}
+	second := model.Warning{Reason: "Second", Message: "second warning"}
+	name := NewTestIngressConfig("a", nil).GetIngressNamespacedName()
+
+	ctrl.reportWarnings(ctx, translate(first))
+	ctrl.reportWarnings(ctx, translate(first, second))
+	assert.Equal(t, []string{first.String(), second.String()}, ctrl.syncStates.snapshot()[name].Warnings)
+	assert.Len(t, recorder.Events, 2, "warnings should only be reported once")
+	assert.Equal(t, "Warning First first warning", <-recorder.Events)
+	assert.Equal(t, "Warning Second second warning", <-recorder.Events)
+
+	// warnings are cleared once the ingress translates cleanly
+	ctrl.reportWarnings(ctx, translate())
+	assert.Empty(t, ctrl.syncStates.snapshot()[name].Warnings)
+	assert.Empty(t, recorder.Events)
+}
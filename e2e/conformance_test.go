@@ -0,0 +1,449 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/controllers"
+	"github.com/pomerium/ingress-controller/pomerium"
+	"github.com/pomerium/ingress-controller/pomeriumtest"
+)
+
+const (
+	namespace        = "default"
+	className        = "pomerium"
+	annotationPrefix = "ingress.pomerium.io/"
+	// upstreamSlowPath is delayed by the upstream longer than the timeout under test
+	upstreamSlowPath  = "/slow"
+	upstreamSlowDelay = time.Second * 3
+
+	// propagation is how long a route may take to be applied by the controller and picked up by the proxy
+	propagation = time.Minute
+)
+
+// echo is the upstream view of the proxied request
+type echo struct {
+	Path    string      `json:"path"`
+	Host    string      `json:"host"`
+	Headers http.Header `json:"headers"`
+}
+
+// request is sent through the proxy to the case host, and its outcome is checked
+type request struct {
+	method string
+	path   string
+	header http.Header
+	check  func(resp *http.Response, e *echo) error
+}
+
+// conformanceCase is an ingress with the annotations under test, and the requests asserting their behavior
+type conformanceCase struct {
+	name        string
+	annotations map[string]string
+	// paths default to a single / Prefix path
+	paths    []networkingv1.HTTPIngressPath
+	requests []request
+}
+
+func TestConformance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logf.SetLogger(zapr.NewLogger(zaptest.NewLogger(t)))
+
+	upstreamPort := startUpstream(t)
+	dbc, err := startPomerium(ctx, t)
+	require.NoError(t, err)
+
+	h, err := pomeriumtest.StartHarness()
+	require.NoError(t, err)
+	defer func() { require.NoError(t, h.Stop()) }()
+
+	c, err := h.StartControllerWithReconciler(&pomerium.ConfigReconciler{DataBrokerServiceClient: dbc},
+		controllers.WithDisableCertCheck())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, c.Stop()) }()
+
+	require.NoError(t, h.Create(ctx, &networkingv1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{Name: className},
+		Spec:       networkingv1.IngressClassSpec{Controller: controllers.DefaultClassControllerName},
+	}))
+	require.NoError(t, h.Create(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "upstream", Namespace: namespace},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: "127.0.0.1",
+			Ports:        []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: upstreamPort}},
+		},
+	}))
+
+	cases := conformanceCases()
+	for _, tc := range cases {
+		require.NoError(t, h.Create(ctx, tc.ingress()), tc.name)
+	}
+
+	results := make(map[string]error, len(cases))
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.run(ctx)
+			results[tc.name] = err
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	report(t, cases, results)
+}
+
+func conformanceCases() []conformanceCase {
+	public := map[string]string{"allow_public_unauthenticated_access": "true"}
+	withPublic := func(kvs map[string]string) map[string]string {
+		for k, v := range public {
+			kvs[k] = v
+		}
+		return kvs
+	}
+	typePrefix, typeExact, typeImpl := networkingv1.PathTypePrefix, networkingv1.PathTypeExact, networkingv1.PathTypeImplementationSpecific
+	path := func(p string, pathType networkingv1.PathType) networkingv1.HTTPIngressPath {
+		return networkingv1.HTTPIngressPath{Path: p, PathType: &pathType}
+	}
+
+	return []conformanceCase{
+		{
+			name:        "public access",
+			annotations: public,
+			requests:    []request{{path: "/", check: expectUpstreamPath("/")}},
+		},
+		{
+			name:        "path types",
+			annotations: public,
+			paths:       []networkingv1.HTTPIngressPath{path("/prefix", typePrefix), path("/exact", typeExact)},
+			requests: []request{
+				{path: "/prefix/sub", check: expectUpstreamPath("/prefix/sub")},
+				{path: "/prefixed", check: expectStatus(http.StatusNotFound)},
+				{path: "/exact", check: expectUpstreamPath("/exact")},
+				{path: "/exact/sub", check: expectStatus(http.StatusNotFound)},
+			},
+		},
+		{
+			name:        "path regex",
+			annotations: withPublic(map[string]string{"path_regex": "true"}),
+			paths:       []networkingv1.HTTPIngressPath{path(`/items/[0-9]+`, typeImpl)},
+			requests: []request{
+				{path: "/items/42", check: expectUpstreamPath("/items/42")},
+				{path: "/items/abc", check: expectStatus(http.StatusNotFound)},
+			},
+		},
+		{
+			name:        "case insensitive paths",
+			annotations: withPublic(map[string]string{"case_insensitive_paths": "true"}),
+			paths:       []networkingv1.HTTPIngressPath{path("/api", typePrefix)},
+			requests:    []request{{path: "/API/users", check: expectUpstreamPath("/API/users")}},
+		},
+		{
+			name:        "allowed users deny unauthenticated",
+			annotations: map[string]string{"allowed_users": "alice@example.com"},
+			requests:    []request{{path: "/", check: expectSignInRedirect}},
+		},
+		{
+			name: "allowed source ranges",
+			annotations: map[string]string{
+				"allow_any_authenticated_user": "true",
+				"allowed_source_ranges":        "10.0.0.0/8",
+			},
+			requests: []request{{path: "/", check: expectStatus(http.StatusForbidden)}},
+		},
+		{
+			name:        "allowed methods",
+			annotations: withPublic(map[string]string{"allowed_methods": "GET"}),
+			requests: []request{
+				{path: "/", check: expectUpstreamPath("/")},
+				{method: http.MethodDelete, path: "/", check: expectStatus(http.StatusForbidden)},
+			},
+		},
+		{
+			name:        "set request headers",
+			annotations: withPublic(map[string]string{"set_request_headers": `{"X-E2E": "set"}`}),
+			requests: []request{{path: "/", check: expectUpstream(func(e *echo) error {
+				return expectEqual("X-E2E request header", "set", e.Headers.Get("X-E2E"))
+			})}},
+		},
+		{
+			name:        "remove request headers",
+			annotations: withPublic(map[string]string{"remove_request_headers": `["X-Remove"]`}),
+			requests: []request{{path: "/", header: http.Header{"X-Remove": {"value"}}, check: expectUpstream(func(e *echo) error {
+				return expectEqual("X-Remove request header", "", e.Headers.Get("X-Remove"))
+			})}},
+		},
+		{
+			name: "set response headers",
+			annotations: withPublic(map[string]string{"set_response_headers": `
+X-Frame-Options: DENY
+Content-Security-Policy: >-
+  default-src 'self';
+  script-src 'self' https://cdn.example.com
+`}),
+			requests: []request{{path: "/", check: func(resp *http.Response, e *echo) error {
+				if err := expectEqual("X-Frame-Options response header", "DENY", resp.Header.Get("X-Frame-Options")); err != nil {
+					return err
+				}
+				return expectEqual("Content-Security-Policy response header", "default-src 'self'; script-src 'self' https://cdn.example.com",
+					resp.Header.Get("Content-Security-Policy"))
+			}}},
+		},
+		{
+			name:        "prefix rewrite",
+			annotations: withPublic(map[string]string{"prefix_rewrite": "/rewritten"}),
+			paths:       []networkingv1.HTTPIngressPath{path("/app", typePrefix)},
+			requests:    []request{{path: "/app/page", check: expectUpstreamPath("/rewritten/page")}},
+		},
+		{
+			name:        "host rewrite",
+			annotations: withPublic(map[string]string{"host_rewrite": "upstream.example.com"}),
+			requests: []request{{path: "/", check: expectUpstream(func(e *echo) error {
+				return expectEqual("upstream host", "upstream.example.com", e.Host)
+			})}},
+		},
+		{
+			name:        "timeout",
+			annotations: withPublic(map[string]string{"timeout": "1s"}),
+			requests: []request{
+				{path: "/", check: expectUpstreamPath("/")},
+				{path: upstreamSlowPath, check: expectStatus(http.StatusGatewayTimeout)},
+			},
+		},
+	}
+}
+
+func (tc *conformanceCase) host() string {
+	return strings.ReplaceAll(tc.name, " ", "-") + ".localhost.pomerium.io"
+}
+
+func (tc *conformanceCase) ingress() client.Object {
+	paths := tc.paths
+	if len(paths) == 0 {
+		typePrefix := networkingv1.PathTypePrefix
+		paths = []networkingv1.HTTPIngressPath{{Path: "/", PathType: &typePrefix}}
+	}
+	for i := range paths {
+		paths[i].Backend.Service = &networkingv1.IngressServiceBackend{
+			Name: "upstream",
+			Port: networkingv1.ServiceBackendPort{Name: "http"},
+		}
+	}
+	annotations := make(map[string]string, len(tc.annotations))
+	for k, v := range tc.annotations {
+		annotations[annotationPrefix+k] = v
+	}
+	name := className
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        strings.ReplaceAll(tc.name, " ", "-"),
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &name,
+			Rules: []networkingv1.IngressRule{{
+				Host: tc.host(),
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+				},
+			}},
+		},
+	}
+}
+
+// run retries the case requests until they all pass, as the route needs time to propagate to the proxy
+func (tc *conformanceCase) run(ctx context.Context) error {
+	hc := &http.Client{
+		Timeout: time.Second * 10,
+		// the sign in redirects are asserted
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	deadline := time.Now().Add(propagation)
+	for {
+		err := tc.runOnce(ctx, hc)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (tc *conformanceCase) runOnce(ctx context.Context, hc *http.Client) error {
+	for _, r := range tc.requests {
+		method := r.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req, err := http.NewRequestWithContext(ctx, method, "http://"+proxyAddr+r.path, nil)
+		if err != nil {
+			return err
+		}
+		req.Host = tc.host()
+		for k, v := range r.header {
+			req.Header[k] = v
+		}
+
+		resp, err := hc.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", method, r.path, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", method, r.path, err)
+		}
+
+		var e *echo
+		if resp.Header.Get("X-Upstream") == "e2e" {
+			e = new(echo)
+			if err := json.Unmarshal(body, e); err != nil {
+				return fmt.Errorf("%s %s: upstream response: %w", method, r.path, err)
+			}
+		}
+		if err := r.check(resp, e); err != nil {
+			return fmt.Errorf("%s %s: %w", method, r.path, err)
+		}
+	}
+	return nil
+}
+
+func expectStatus(code int) func(*http.Response, *echo) error {
+	return func(resp *http.Response, _ *echo) error {
+		if resp.StatusCode != code {
+			return fmt.Errorf("expected status %d, got %d", code, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+func expectUpstream(fn func(e *echo) error) func(*http.Response, *echo) error {
+	return func(resp *http.Response, e *echo) error {
+		if e == nil {
+			return fmt.Errorf("expected the request to reach the upstream, got status %d", resp.StatusCode)
+		}
+		return fn(e)
+	}
+}
+
+func expectUpstreamPath(path string) func(*http.Response, *echo) error {
+	return expectUpstream(func(e *echo) error {
+		return expectEqual("upstream path", path, e.Path)
+	})
+}
+
+func expectSignInRedirect(resp *http.Response, _ *echo) error {
+	if resp.StatusCode != http.StatusFound {
+		return fmt.Errorf("expected sign in redirect, got status %d", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); !strings.Contains(loc, authenticateHost) {
+		return fmt.Errorf("expected sign in redirect to %s, got %q", authenticateHost, loc)
+	}
+	return nil
+}
+
+func expectEqual(what, want, got string) error {
+	if want != got {
+		return fmt.Errorf("%s: expected %q, got %q", what, want, got)
+	}
+	return nil
+}
+
+// startUpstream starts the upstream server, that echoes the request back as JSON, and returns its port
+func startUpstream(t *testing.T) int32 {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == upstreamSlowPath {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(upstreamSlowDelay):
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Upstream", "e2e")
+		_ = json.NewEncoder(w).Encode(echo{Path: r.URL.Path, Host: r.Host, Headers: r.Header})
+	})}
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Logf("upstream: %v", err)
+		}
+	}()
+	t.Cleanup(func() { _ = srv.Close() })
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+// report lists which annotations passed, an annotation fails if any of the cases using it has failed
+func report(t *testing.T, cases []conformanceCase, results map[string]error) {
+	t.Helper()
+
+	passed := make(map[string]bool)
+	for _, tc := range cases {
+		for k := range tc.annotations {
+			if _, ok := passed[k]; !ok {
+				passed[k] = true
+			}
+			passed[k] = passed[k] && results[tc.name] == nil
+		}
+	}
+	annotations := make([]string, 0, len(passed))
+	for k := range passed {
+		annotations = append(annotations, k)
+	}
+	sort.Strings(annotations)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Ingress annotations conformance: %s\n\n| annotation | result |\n| --- | --- |\n", pomeriumImage())
+	for _, k := range annotations {
+		result := "PASS"
+		if !passed[k] {
+			result = "FAIL"
+		}
+		fmt.Fprintf(&b, "| %s | %s |\n", k, result)
+	}
+	fmt.Fprintf(&b, "\n| case | result |\n| --- | --- |\n")
+	for _, tc := range cases {
+		result := "PASS"
+		if err := results[tc.name]; err != nil {
+			result = "FAIL: " + strings.ReplaceAll(err.Error(), "|", `\|`)
+		}
+		fmt.Fprintf(&b, "| %s | %s |\n", tc.name, result)
+	}
+
+	t.Log("\n" + b.String())
+	if path := os.Getenv("E2E_REPORT"); path != "" {
+		require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o644))
+	}
+}
@@ -0,0 +1,9 @@
+// Package e2e holds the end-to-end conformance tests, that run the ingress controller against a local kubernetes
+// API server and a real Pomerium all-in-one container, and assert the HTTP behavior of the ingress annotations
+// through the proxy. They require docker and the envtest binaries, and only build with the e2e tag:
+//
+//	KUBEBUILDER_ASSETS=... go test -tags e2e ./e2e
+//
+// The Pomerium image defaults to the version the controller is built against, and may be overridden
+// via E2E_POMERIUM_IMAGE. The conformance report is logged, and written to E2E_REPORT file if set.
+package e2e
@@ -0,0 +1,130 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/grpcutil"
+)
+
+const (
+	// the container shares the host network, so that it may reach the upstream server started by the test
+	proxyAddr      = "127.0.0.1:18080"
+	dataBrokerAddr = "127.0.0.1:15443"
+
+	authenticateHost = "authenticate.localhost.pomerium.io"
+
+	pomeriumModule     = "github.com/pomerium/pomerium"
+	pomeriumImageEnv   = "E2E_POMERIUM_IMAGE"
+	defaultPomeriumTag = "v0.17.2"
+)
+
+// the secrets are only used by the throwaway test instance
+var (
+	sharedSecret = bytes.Repeat([]byte{1}, 32)
+	cookieSecret = bytes.Repeat([]byte{2}, 32)
+)
+
+const pomeriumConfig = `
+address: %q
+grpc_address: %q
+grpc_insecure: true
+insecure_server: true
+shared_secret: %q
+cookie_secret: %q
+authenticate_service_url: https://%s
+idp_provider: google
+idp_client_id: e2e
+idp_client_secret: e2e
+`
+
+// pomeriumImage returns the image of the Pomerium version the controller is built against,
+// so that the annotation semantics are checked again on each Pomerium version bump
+func pomeriumImage() string {
+	if img := os.Getenv(pomeriumImageEnv); img != "" {
+		return img
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			if dep.Path == pomeriumModule {
+				return "pomerium/pomerium:" + dep.Version
+			}
+		}
+	}
+	return "pomerium/pomerium:" + defaultPomeriumTag
+}
+
+// startPomerium runs the Pomerium all-in-one container, that is removed once the test completes,
+// and returns a client of its databroker once it is ready
+func startPomerium(ctx context.Context, t *testing.T) (databroker.DataBrokerServiceClient, error) {
+	t.Helper()
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		return nil, fmt.Errorf("docker is required: %w", err)
+	}
+
+	dir := t.TempDir()
+	// the container does not run as the test user
+	if err := os.Chmod(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cfg := fmt.Sprintf(pomeriumConfig, proxyAddr, dataBrokerAddr,
+		base64.StdEncoding.EncodeToString(sharedSecret), base64.StdEncoding.EncodeToString(cookieSecret), authenticateHost)
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(cfg), 0o644); err != nil {
+		return nil, err
+	}
+
+	image := pomeriumImage()
+	t.Logf("starting %s", image)
+	out, err := exec.CommandContext(ctx, "docker", "run", "--detach", "--network", "host",
+		"--volume", dir+":/pomerium:ro", image).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker run %s: %w", image, err)
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		if t.Failed() {
+			logs, _ := exec.Command("docker", "logs", "--tail", "200", id).CombinedOutput()
+			t.Logf("pomerium logs:\n%s", logs)
+		}
+		_ = exec.Command("docker", "rm", "--force", id).Run()
+	})
+
+	conn, err := grpcutil.NewGRPCClientConn(ctx, &grpcutil.Options{
+		Address:        &url.URL{Scheme: "http", Host: dataBrokerAddr},
+		ServiceName:    "databroker",
+		SignedJWTKey:   sharedSecret,
+		RequestTimeout: time.Minute,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("databroker connection: %w", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	client := databroker.NewDataBrokerServiceClient(conn)
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		_, err = client.Query(ctx, &databroker.QueryRequest{Type: "type.googleapis.com/pomerium.config.Config", Limit: 1})
+		if err == nil {
+			return client, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("waiting for the databroker: %w", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
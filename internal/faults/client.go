@@ -0,0 +1,129 @@
+package faults
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+)
+
+// client injects the faults before calling the underlying databroker client
+type client struct {
+	databroker.DataBrokerServiceClient
+	*Injector
+}
+
+// Wrap returns a databroker client that injects the faults configured in the injector into the calls
+func Wrap(c databroker.DataBrokerServiceClient, i *Injector) databroker.DataBrokerServiceClient {
+	return &client{DataBrokerServiceClient: c, Injector: i}
+}
+
+// AcquireLease implements databroker.DataBrokerServiceClient
+func (c *client) AcquireLease(ctx context.Context, in *databroker.AcquireLeaseRequest, opts ...grpc.CallOption) (*databroker.AcquireLeaseResponse, error) {
+	if err := c.inject(ctx, "AcquireLease"); err != nil {
+		return nil, err
+	}
+	return c.DataBrokerServiceClient.AcquireLease(ctx, in, opts...)
+}
+
+// Get implements databroker.DataBrokerServiceClient
+func (c *client) Get(ctx context.Context, in *databroker.GetRequest, opts ...grpc.CallOption) (*databroker.GetResponse, error) {
+	if err := c.inject(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return c.DataBrokerServiceClient.Get(ctx, in, opts...)
+}
+
+// Put implements databroker.DataBrokerServiceClient
+func (c *client) Put(ctx context.Context, in *databroker.PutRequest, opts ...grpc.CallOption) (*databroker.PutResponse, error) {
+	if err := c.inject(ctx, "Put"); err != nil {
+		return nil, err
+	}
+	return c.DataBrokerServiceClient.Put(ctx, in, opts...)
+}
+
+// Query implements databroker.DataBrokerServiceClient
+func (c *client) Query(ctx context.Context, in *databroker.QueryRequest, opts ...grpc.CallOption) (*databroker.QueryResponse, error) {
+	if err := c.inject(ctx, "Query"); err != nil {
+		return nil, err
+	}
+	return c.DataBrokerServiceClient.Query(ctx, in, opts...)
+}
+
+// ReleaseLease implements databroker.DataBrokerServiceClient
+func (c *client) ReleaseLease(ctx context.Context, in *databroker.ReleaseLeaseRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	if err := c.inject(ctx, "ReleaseLease"); err != nil {
+		return nil, err
+	}
+	return c.DataBrokerServiceClient.ReleaseLease(ctx, in, opts...)
+}
+
+// RenewLease implements databroker.DataBrokerServiceClient
+func (c *client) RenewLease(ctx context.Context, in *databroker.RenewLeaseRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	if err := c.inject(ctx, "RenewLease"); err != nil {
+		return nil, err
+	}
+	return c.DataBrokerServiceClient.RenewLease(ctx, in, opts...)
+}
+
+// SetOptions implements databroker.DataBrokerServiceClient
+func (c *client) SetOptions(ctx context.Context, in *databroker.SetOptionsRequest, opts ...grpc.CallOption) (*databroker.SetOptionsResponse, error) {
+	if err := c.inject(ctx, "SetOptions"); err != nil {
+		return nil, err
+	}
+	return c.DataBrokerServiceClient.SetOptions(ctx, in, opts...)
+}
+
+// Sync implements databroker.DataBrokerServiceClient
+func (c *client) Sync(ctx context.Context, in *databroker.SyncRequest, opts ...grpc.CallOption) (databroker.DataBrokerService_SyncClient, error) {
+	if err := c.inject(ctx, "Sync"); err != nil {
+		return nil, err
+	}
+	s, err := c.DataBrokerServiceClient.Sync(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &syncClient{DataBrokerService_SyncClient: s, Injector: c.Injector}, nil
+}
+
+// SyncLatest implements databroker.DataBrokerServiceClient
+func (c *client) SyncLatest(ctx context.Context, in *databroker.SyncLatestRequest, opts ...grpc.CallOption) (databroker.DataBrokerService_SyncLatestClient, error) {
+	if err := c.inject(ctx, "SyncLatest"); err != nil {
+		return nil, err
+	}
+	s, err := c.DataBrokerServiceClient.SyncLatest(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &syncLatestClient{DataBrokerService_SyncLatestClient: s, Injector: c.Injector}, nil
+}
+
+// syncClient breaks the stream on forced disconnect
+type syncClient struct {
+	databroker.DataBrokerService_SyncClient
+	*Injector
+}
+
+// Recv implements databroker.DataBrokerService_SyncClient
+func (s *syncClient) Recv() (*databroker.SyncResponse, error) {
+	if err := s.disconnected("Sync"); err != nil {
+		return nil, err
+	}
+	return s.DataBrokerService_SyncClient.Recv()
+}
+
+// syncLatestClient breaks the stream on forced disconnect
+type syncLatestClient struct {
+	databroker.DataBrokerService_SyncLatestClient
+	*Injector
+}
+
+// Recv implements databroker.DataBrokerService_SyncLatestClient
+func (s *syncLatestClient) Recv() (*databroker.SyncLatestResponse, error) {
+	if err := s.disconnected("SyncLatest"); err != nil {
+		return nil, err
+	}
+	return s.DataBrokerService_SyncLatestClient.Recv()
+}
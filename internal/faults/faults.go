@@ -0,0 +1,193 @@
+// Package faults injects failures into the databroker client calls, so that the controller retry, backoff
+// and lease behavior may be validated without a misbehaving databroker. It is only meant for testing.
+package faults
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pomerium/ingress-controller/internal/filewatch"
+)
+
+// MatchAll is a Rule method that matches all databroker methods
+const MatchAll = "*"
+
+// Rule describes the faults injected into the calls of a databroker method
+type Rule struct {
+	// Method is the databroker method name, i.e. Put, or * to match all methods
+	Method string `yaml:"method"`
+	// ErrorRate is the probability of a call failing, within 0-1
+	ErrorRate float64 `yaml:"errorRate,omitempty"`
+	// Code is the gRPC status code of the injected errors, Unavailable by default
+	Code string `yaml:"code,omitempty"`
+	// Latency is added to each call
+	Latency time.Duration `yaml:"latency,omitempty"`
+	// Disconnect fails all calls and breaks the open streams, as if the databroker connection was lost
+	Disconnect bool `yaml:"disconnect,omitempty"`
+
+	code codes.Code
+}
+
+// Rules is the fault injection configuration, as loaded from a file or set via the debug endpoint
+type Rules struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+var codesByName = func() map[string]codes.Code {
+	m := make(map[string]codes.Code)
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		m[strings.ToLower(c.String())] = c
+	}
+	return m
+}()
+
+// ParseRules parses YAML or JSON encoded Rules
+func ParseRules(data []byte) ([]Rule, error) {
+	var src Rules
+	if err := yaml.Unmarshal(data, &src); err != nil {
+		return nil, err
+	}
+	for i := range src.Rules {
+		if err := src.Rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return src.Rules, nil
+}
+
+func (r *Rule) validate() error {
+	if r.Method == "" {
+		return fmt.Errorf("method is required, use %s to match all methods", MatchAll)
+	}
+	if r.ErrorRate < 0 || r.ErrorRate > 1 {
+		return fmt.Errorf("errorRate %v should be within 0-1", r.ErrorRate)
+	}
+	if r.Latency < 0 {
+		return fmt.Errorf("negative latency %v", r.Latency)
+	}
+	r.code = codes.Unavailable
+	if r.Code != "" {
+		c, ok := codesByName[strings.ToLower(r.Code)]
+		if !ok || c == codes.OK {
+			return fmt.Errorf("unknown gRPC error code %q", r.Code)
+		}
+		r.code = c
+	}
+	return nil
+}
+
+func (r *Rule) matches(method string) bool {
+	return r.Method == MatchAll || strings.EqualFold(r.Method, method)
+}
+
+// Injector holds the current fault injection rules. It is safe for concurrent use.
+type Injector struct {
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// NewInjector creates an injector with no rules, that does not affect the calls until the rules are set
+func NewInjector() *Injector {
+	return new(Injector)
+}
+
+// SetRules replaces the current rules
+func (i *Injector) SetRules(rules []Rule) error {
+	dst := make([]Rule, len(rules))
+	copy(dst, rules)
+	for n := range dst {
+		if err := dst[n].validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", n, err)
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.rules = dst
+	return nil
+}
+
+// GetRules returns the current rules
+func (i *Injector) GetRules() []Rule {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return append([]Rule(nil), i.rules...)
+}
+
+// LoadFile replaces the current rules with the ones from the file
+func (i *Injector) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules, err := ParseRules(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return i.SetRules(rules)
+}
+
+// WatchFile reloads the rules from the file whenever it is modified, until the context is canceled
+func (i *Injector) WatchFile(ctx context.Context, path string, opts ...filewatch.Option) {
+	logger := log.FromContext(ctx).WithName("fault-injection").WithValues("file", path)
+	filewatch.Watch(ctx, path, func() {
+		if err := i.LoadFile(path); err != nil {
+			logger.Error(err, "loading fault injection rules")
+		} else {
+			logger.Info("loaded fault injection rules", "rules", len(i.GetRules()))
+		}
+	}, opts...)
+}
+
+// inject applies the rules matching the method, and returns the injected error, if any
+func (i *Injector) inject(ctx context.Context, method string) error {
+	var latency time.Duration
+	var err error
+	for _, r := range i.GetRules() {
+		if !r.matches(method) {
+			continue
+		}
+		latency += r.Latency
+		if err != nil {
+			continue
+		}
+		if r.Disconnect {
+			err = status.Errorf(codes.Unavailable, "fault injection: %s: forced disconnect", method)
+		} else if r.ErrorRate > 0 && rand.Float64() < r.ErrorRate { //nolint:gosec
+			err = status.Errorf(r.code, "fault injection: %s: injected error", method)
+		}
+	}
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+// disconnected returns an error if the open streams of the method should be broken
+func (i *Injector) disconnected(method string) error {
+	for _, r := range i.GetRules() {
+		if r.Disconnect && r.matches(method) {
+			return status.Errorf(codes.Unavailable, "fault injection: %s: forced disconnect", method)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package faults_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+
+	"github.com/pomerium/ingress-controller/internal/faults"
+	"github.com/pomerium/ingress-controller/pomeriumtest"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := faults.ParseRules([]byte(`
+rules:
+- method: Put
+  errorRate: 0.5
+  code: resourceexhausted
+  latency: 200ms
+- method: "*"
+  disconnect: true
+`))
+	require.NoError(t, err)
+	if assert.Len(t, rules, 2) {
+		assert.Equal(t, time.Millisecond*200, rules[0].Latency)
+		assert.Equal(t, 0.5, rules[0].ErrorRate)
+		assert.True(t, rules[1].Disconnect)
+	}
+
+	for _, txt := range []string{
+		`rules: [{errorRate: 1}]`,
+		`rules: [{method: Put, errorRate: 2}]`,
+		`rules: [{method: Put, code: OK}]`,
+		`rules: [{method: Put, code: Broken}]`,
+		`rules: [{method: Put, latency: -1s}]`,
+	} {
+		_, err := faults.ParseRules([]byte(txt))
+		assert.Error(t, err, txt)
+	}
+}
+
+func TestInject(t *testing.T) {
+	ctx := context.Background()
+	injector := faults.NewInjector()
+	db := faults.Wrap(pomeriumtest.NewDataBroker(), injector)
+	put := func() error {
+		_, err := db.Put(ctx, &databroker.PutRequest{Record: &databroker.Record{Type: "type", Id: "id"}})
+		return err
+	}
+
+	require.NoError(t, put(), "no rules")
+
+	require.NoError(t, injector.SetRules([]faults.Rule{{Method: "Put", ErrorRate: 1, Code: "Internal"}}))
+	assert.Equal(t, codes.Internal, status.Code(put()))
+	_, err := db.Get(ctx, &databroker.GetRequest{Type: "type", Id: "id"})
+	assert.NoError(t, err, "other methods are not affected")
+
+	require.NoError(t, injector.SetRules([]faults.Rule{{Method: faults.MatchAll, Disconnect: true}}))
+	assert.Equal(t, codes.Unavailable, status.Code(put()))
+
+	require.NoError(t, injector.SetRules([]faults.Rule{{Method: "put", Latency: time.Hour}}))
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+	_, err = db.Put(ctx, &databroker.PutRequest{Record: &databroker.Record{Type: "type", Id: "id"}})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestHandler(t *testing.T) {
+	injector := faults.NewInjector()
+
+	w := httptest.NewRecorder()
+	injector.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/debug/faults",
+		strings.NewReader(`{"rules": [{"method": "Put", "errorRate": 1}]}`)))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Len(t, injector.GetRules(), 1)
+
+	w = httptest.NewRecorder()
+	injector.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/debug/faults", strings.NewReader(`rules: [{errorRate: 1}]`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, injector.GetRules(), 1, "rules should be kept on error")
+
+	w = httptest.NewRecorder()
+	injector.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/faults", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "method: Put")
+}
+
+// leaseHandler counts the times the lease was acquired
+type leaseHandler struct {
+	databroker.DataBrokerServiceClient
+	acquired int32
+}
+
+func (h *leaseHandler) GetDataBrokerServiceClient() databroker.DataBrokerServiceClient {
+	return h.DataBrokerServiceClient
+}
+
+func (h *leaseHandler) RunLeased(ctx context.Context) error {
+	atomic.AddInt32(&h.acquired, 1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestLeaseOutage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	injector := faults.NewInjector()
+	h := &leaseHandler{DataBrokerServiceClient: faults.Wrap(pomeriumtest.NewDataBroker(), injector)}
+	leaser := databroker.NewLeaser("test", time.Millisecond*200, h)
+	done := make(chan error, 1)
+	go func() { done <- leaser.Run(ctx) }()
+
+	acquired := func() int32 { return atomic.LoadInt32(&h.acquired) }
+	require.Eventually(t, func() bool { return acquired() == 1 }, time.Second*5, time.Millisecond*10)
+
+	// the lease may not be renewed during the outage
+	require.NoError(t, injector.SetRules([]faults.Rule{{Method: faults.MatchAll, Disconnect: true}}))
+	time.Sleep(time.Millisecond * 500)
+	assert.Equal(t, int32(1), acquired())
+
+	require.NoError(t, injector.SetRules(nil))
+	assert.Eventually(t, func() bool { return acquired() == 2 }, time.Second*10, time.Millisecond*10,
+		"lease should be acquired again once the databroker is available")
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
@@ -0,0 +1,40 @@
+package faults
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxRulesSize limits the request body of the rules update
+const maxRulesSize = 1 << 20
+
+// ServeHTTP returns the current rules on GET, and replaces them with the YAML or JSON encoded Rules on PUT
+func (i *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxRulesSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rules, err := ParseRules(data)
+		if err == nil {
+			err = i.SetRules(rules)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", fmt.Sprintf("%s, %s", http.MethodGet, http.MethodPut))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	_ = yaml.NewEncoder(w).Encode(Rules{Rules: i.GetRules()})
+}
@@ -0,0 +1,167 @@
+// Package filewatch notifies about the changes of the files used as the controller inputs. It watches the parent
+// directory rather than the file itself, so that the atomic rename-over writes and the Kubernetes volume mount
+// ..data symlink swaps are detected, and polls the file as well, as fsnotify may be unavailable or unreliable
+// on some platforms and file systems.
+package filewatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// DefaultPollInterval is how often the file is checked, regardless of the fsnotify events
+	DefaultPollInterval = time.Second * 5
+	// DefaultDebounce is how long the file has to stay unchanged before the change is reported,
+	// so that a write in progress is only reported once
+	DefaultDebounce = time.Millisecond * 100
+)
+
+// Option customizes the file watch
+type Option func(*watcher)
+
+// WithPollInterval sets how often the file is checked, regardless of the fsnotify events
+func WithPollInterval(d time.Duration) Option {
+	return func(w *watcher) {
+		w.pollInterval = d
+	}
+}
+
+// WithDebounce sets how long the file has to stay unchanged before the change is reported
+func WithDebounce(d time.Duration) Option {
+	return func(w *watcher) {
+		w.debounce = d
+	}
+}
+
+// WithPollingOnly disables fsnotify, only polling the file
+func WithPollingOnly() Option {
+	return func(w *watcher) {
+		w.pollingOnly = true
+	}
+}
+
+type watcher struct {
+	path         string
+	pollInterval time.Duration
+	debounce     time.Duration
+	pollingOnly  bool
+	onChange     func()
+
+	// last is the last observed state of the file, nil if it did not exist
+	last os.FileInfo
+}
+
+// Watch calls onChange whenever the file is modified, replaced, deleted or created, until the context is canceled.
+// the symlinks are followed, so a change of the symlink target is reported as well.
+// onChange is not called for the initial state of the file, and is never called concurrently
+func Watch(ctx context.Context, path string, onChange func(), opts ...Option) {
+	w := &watcher{
+		path:         filepath.Clean(path),
+		pollInterval: DefaultPollInterval,
+		debounce:     DefaultDebounce,
+		onChange:     onChange,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.run(ctx)
+}
+
+func (w *watcher) run(ctx context.Context) {
+	w.last = stat(w.path)
+	events, stop := w.notify(ctx)
+	defer stop()
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	// debounce is only armed while a change is waiting to be reported
+	debounce := time.NewTimer(w.debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	pending := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-events:
+			if !ok {
+				// fsnotify has stopped, keep polling
+				events = nil
+			}
+		case <-ticker.C:
+		case <-debounce.C:
+			pending = false
+			w.onChange()
+			continue
+		}
+
+		if !w.changed() {
+			continue
+		}
+		// each change restarts the debounce, so that the change is reported once the file settles
+		if pending && !debounce.Stop() {
+			<-debounce.C
+		}
+		debounce.Reset(w.debounce)
+		pending = true
+	}
+}
+
+// notify returns the events of the file parent directory, or a nil channel if fsnotify may not be used
+func (w *watcher) notify(ctx context.Context) (<-chan fsnotify.Event, func()) {
+	if w.pollingOnly {
+		return nil, func() {}
+	}
+
+	logger := log.FromContext(ctx).WithName("filewatch").WithValues("file", w.path)
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error(err, "file system notifications are not available, polling the file")
+		return nil, func() {}
+	}
+	if err = fsw.Add(filepath.Dir(w.path)); err != nil {
+		_ = fsw.Close()
+		logger.Error(err, "watching the file directory, polling the file")
+		return nil, func() {}
+	}
+	go func() {
+		for err := range fsw.Errors {
+			logger.Error(err, "file system notifications")
+		}
+	}()
+	return fsw.Events, func() { _ = fsw.Close() }
+}
+
+// changed checks whether the file has changed since it was last observed
+func (w *watcher) changed() bool {
+	cur := stat(w.path)
+	prev := w.last
+	w.last = cur
+
+	switch {
+	case prev == nil || cur == nil:
+		return (prev == nil) != (cur == nil)
+	case !os.SameFile(prev, cur):
+		// the file was replaced, i.e. renamed over or the symlink was swapped
+		return true
+	default:
+		return prev.Size() != cur.Size() || !prev.ModTime().Equal(cur.ModTime())
+	}
+}
+
+// stat returns the file info following the symlinks, or nil if the file does not exist or may not be accessed
+func stat(path string) os.FileInfo {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	return fi
+}
@@ -0,0 +1,132 @@
+package filewatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	for _, mode := range []struct {
+		name string
+		opts []Option
+	}{
+		// a long poll interval, so that only the fsnotify events may trigger the checks in time
+		{"fsnotify", []Option{WithPollInterval(time.Hour)}},
+		{"polling", []Option{WithPollingOnly(), WithPollInterval(time.Millisecond * 10)}},
+	} {
+		t.Run(mode.name, func(t *testing.T) {
+			for _, tc := range []struct {
+				name string
+				// setup creates the watched file and returns its path, and a function that changes it
+				setup func(t *testing.T, dir string) (string, []func())
+			}{
+				{"write", func(t *testing.T, dir string) (string, []func()) {
+					path := filepath.Join(dir, "file")
+					writeFile(t, path, "a")
+					return path, []func(){func() { writeFile(t, path, "bb") }}
+				}},
+				{"rename over", func(t *testing.T, dir string) (string, []func()) {
+					path := filepath.Join(dir, "file")
+					writeFile(t, path, "a")
+					return path, []func(){func() {
+						tmp := filepath.Join(dir, "file.tmp")
+						writeFile(t, tmp, "b")
+						require.NoError(t, os.Rename(tmp, path))
+					}}
+				}},
+				{"kubernetes volume mount", func(t *testing.T, dir string) (string, []func()) {
+					// the mounted files are symlinks to ..data/file, and ..data is swapped to a new timestamped directory
+					writeFile(t, filepath.Join(dir, "..2022_01_01", "file"), "a")
+					require.NoError(t, os.Symlink("..2022_01_01", filepath.Join(dir, "..data")))
+					path := filepath.Join(dir, "file")
+					require.NoError(t, os.Symlink(filepath.Join("..data", "file"), path))
+					return path, []func(){func() {
+						writeFile(t, filepath.Join(dir, "..2022_01_02", "file"), "b")
+						require.NoError(t, os.Symlink("..2022_01_02", filepath.Join(dir, "..data_tmp")))
+						require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+						require.NoError(t, os.RemoveAll(filepath.Join(dir, "..2022_01_01")))
+					}}
+				}},
+				{"delete and recreate", func(t *testing.T, dir string) (string, []func()) {
+					path := filepath.Join(dir, "file")
+					writeFile(t, path, "a")
+					return path, []func(){
+						func() { require.NoError(t, os.Remove(path)) },
+						func() { writeFile(t, path, "a") },
+					}
+				}},
+			} {
+				t.Run(tc.name, func(t *testing.T) {
+					path, changes := tc.setup(t, t.TempDir())
+					changed := watch(t, path, mode.opts...)
+					expectNone(t, changed)
+					for _, change := range changes {
+						change()
+						expectChange(t, changed)
+					}
+					expectNone(t, changed)
+				})
+			}
+		})
+	}
+}
+
+func TestWatchDebounce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	writeFile(t, path, "")
+	changed := watch(t, path, WithPollingOnly(), WithPollInterval(time.Millisecond*10), WithDebounce(time.Millisecond*200))
+	for i := 1; i <= 5; i++ {
+		writeFile(t, path, string(make([]byte, i)))
+		time.Sleep(time.Millisecond * 20)
+	}
+	expectChange(t, changed)
+	expectNone(t, changed)
+}
+
+func watch(t *testing.T, path string, opts ...Option) <-chan struct{} {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Watch(ctx, path, func() { changed <- struct{}{} }, append([]Option{WithDebounce(time.Millisecond * 20)}, opts...)...)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	// let the watch observe the initial state of the file
+	time.Sleep(time.Millisecond * 50)
+	return changed
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func expectChange(t *testing.T, changed <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-changed:
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "change was not reported")
+	}
+}
+
+func expectNone(t *testing.T, changed <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-changed:
+		require.FailNow(t, "unexpected change reported")
+	case <-time.After(time.Millisecond * 200):
+	}
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/azure"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -32,5 +33,7 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	_ = c.ExecuteContext(context.Background())
+	if err = c.ExecuteContext(context.Background()); err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// CanaryBackend is another service of the ingress namespace, that receives a share of the ingress traffic
+type CanaryBackend struct {
+	ServiceName string
+	ServicePort networkingv1.ServiceBackendPort
+	// Weight is the percentage of requests sent to the canary service
+	Weight int32
+}
+
+// GetCanary returns the canary service set via annotation, or nil if not set
+func (ic *IngressConfig) GetCanary() (*CanaryBackend, error) {
+	return GetCanary(ic.Ingress, ic.AnnotationPrefix)
+}
+
+// GetCanary returns the canary service set via the ingress annotation, or nil if not set.
+// it is also used by the controller to fetch the canary service before the ingress config is assembled
+func GetCanary(ingress *networkingv1.Ingress, annotationPrefix string) (*CanaryBackend, error) {
+	txt, ok := ingress.Annotations[annotationPrefix+"/"+Canary]
+	if !ok {
+		return nil, nil
+	}
+	c, err := parseCanary(txt)
+	if err != nil {
+		return nil, NewPermanentError(fmt.Errorf("%s: %w", Canary, err))
+	}
+	return c, nil
+}
+
+func parseCanary(txt string) (*CanaryBackend, error) {
+	var src struct {
+		ServiceName string `yaml:"serviceName"`
+		ServicePort string `yaml:"servicePort"`
+		Weight      *int32 `yaml:"weight"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader([]byte(txt)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&src); err != nil {
+		return nil, err
+	}
+
+	c := &CanaryBackend{ServiceName: src.ServiceName}
+	if c.ServiceName == "" {
+		return nil, errors.New("serviceName is required")
+	}
+	if src.ServicePort == "" {
+		return nil, errors.New("servicePort is required")
+	}
+	if port, err := strconv.ParseInt(src.ServicePort, 10, 32); err == nil {
+		c.ServicePort.Number = int32(port)
+	} else {
+		c.ServicePort.Name = src.ServicePort
+	}
+	if src.Weight == nil {
+		return nil, errors.New("weight is required")
+	}
+	if c.Weight = *src.Weight; c.Weight < 0 || c.Weight > 100 {
+		return nil, fmt.Errorf("weight %d must be between 0 and 100", c.Weight)
+	}
+	return c, nil
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetCanary(t *testing.T) {
+	ingress := func(txt string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"a/canary": txt}}}
+	}
+
+	c, err := GetCanary(&networkingv1.Ingress{}, "a")
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	c, err = GetCanary(ingress("{serviceName: app-canary, servicePort: 8080, weight: 10}"), "a")
+	require.NoError(t, err)
+	assert.Equal(t, &CanaryBackend{
+		ServiceName: "app-canary",
+		ServicePort: networkingv1.ServiceBackendPort{Number: 8080},
+		Weight:      10,
+	}, c)
+
+	c, err = GetCanary(ingress("serviceName: app-canary\nservicePort: http\nweight: 0\n"), "a")
+	require.NoError(t, err)
+	assert.Equal(t, networkingv1.ServiceBackendPort{Name: "http"}, c.ServicePort)
+	assert.Zero(t, c.Weight)
+
+	for _, txt := range []string{
+		"{servicePort: 80, weight: 10}",
+		"{serviceName: app-canary, weight: 10}",
+		"{serviceName: app-canary, servicePort: 80}",
+		"{serviceName: app-canary, servicePort: 80, weight: 101}",
+		"{serviceName: app-canary, servicePort: 80, weight: -1}",
+		"{serviceName: app-canary, servicePort: 80, weight: 10, namespace: other}",
+		"app-canary",
+	} {
+		_, err := GetCanary(ingress(txt), "a")
+		assert.Error(t, err, txt)
+		assert.True(t, IsPermanentError(err), txt)
+	}
+}
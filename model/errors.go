@@ -0,0 +1,66 @@
+package model
+
+import "errors"
+
+// PermanentError is an error that would not resolve by itself, i.e. caused by an invalid resource spec.
+// it should not be retried, as the affected resources would be reconciled again once they are updated
+type PermanentError struct {
+	Err error
+}
+
+// NewPermanentError marks error as permanent, or returns nil if err is nil
+func NewPermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// Error implements error interface
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// TransientError is an error that may resolve by itself, i.e. an API call failure or a referenced object
+// that does not exist yet, and should be retried
+type TransientError struct {
+	Err error
+}
+
+// NewTransientError marks error as transient, or returns nil if err is nil
+func NewTransientError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TransientError{Err: err}
+}
+
+// Error implements error interface
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanentError reports whether the error should not be retried.
+// the outermost classification in the error chain takes precedence,
+// and errors that were not classified are considered transient.
+func IsPermanentError(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch err.(type) {
+		case *PermanentError:
+			return true
+		case *TransientError:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPermanentError(t *testing.T) {
+	base := errors.New("error")
+	for _, tc := range []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"nil", nil, false},
+		{"unclassified", base, false},
+		{"permanent", NewPermanentError(base), true},
+		{"transient", NewTransientError(base), false},
+		{"wrapped permanent", fmt.Errorf("parsing: %w", NewPermanentError(base)), true},
+		{"wrapped transient", fmt.Errorf("get: %w", NewTransientError(base)), false},
+		{"outer classification wins", NewTransientError(fmt.Errorf("x: %w", NewPermanentError(base))), false},
+		{"route errors", NewPermanentError(RouteErrors{{Host: "a", Path: "/", Err: base}}), true},
+	} {
+		assert.Equal(t, tc.permanent, IsPermanentError(tc.err), tc.name)
+	}
+
+	assert.NoError(t, NewPermanentError(nil))
+	assert.NoError(t, NewTransientError(nil))
+	assert.ErrorIs(t, NewPermanentError(base), base)
+	assert.Equal(t, base.Error(), NewTransientError(base).Error())
+}
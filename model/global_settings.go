@@ -0,0 +1,16 @@
+package model
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+)
+
+// GlobalSettings is the Pomerium object holding the global settings, along with the secrets it references
+type GlobalSettings struct {
+	*v1alpha1.Pomerium
+	// IdentityProviderSecret is the secret spec.identityProvider.secret refers to, if set
+	IdentityProviderSecret *corev1.Secret
+	// Certificates are the TLS secrets spec.certificates refer to
+	Certificates []*corev1.Secret
+}
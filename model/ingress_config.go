@@ -4,6 +4,7 @@ package model
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
@@ -27,10 +28,27 @@ const (
 	SecureUpstream = "secure_upstream"
 	// PathRegex indicates that paths of ImplementationSpecific type should be treated as regular expression
 	PathRegex = "path_regex"
-	// UseServiceProxy will use standard k8s service proxy as upstream, opposed to individual endpoints
+	// CaseInsensitivePaths makes the route paths match regardless of the case
+	CaseInsensitivePaths = "case_insensitive_paths"
+	// CombinePaths when set, paths of a rule sharing the same backend are emitted as a single regex route
+	CombinePaths = "combine_paths"
+	// UseServiceProxy will use standard k8s service proxy as upstream, opposed to individual endpoints.
+	// if set to false, it overrides IngressConfig.ServiceProxyUpstreams for the ingress
 	UseServiceProxy = "service_proxy_upstream"
+	// UseNotReadyEndpoints includes the endpoint addresses that are not ready in the upstreams, i.e. of the slow starting ACME solvers
+	UseNotReadyEndpoints = "use_not_ready_endpoints"
 	// TCPUpstream indicates this route is a TCP service https://www.pomerium.com/docs/tcp/
 	TCPUpstream = "tcp_upstream"
+	// SyncStateAnnotation is set by the controller to record the ingress sync state, if enabled
+	SyncStateAnnotation = "sync_state"
+	// LastAppliedGeneration is set by the controller to the ingress metadata.generation last applied to pomerium,
+	// along with SyncStateAnnotation
+	LastAppliedGeneration = "last_applied_generation"
+	// ReconcileError is set by the controller to the last reconciliation error, and is removed once the ingress is applied,
+	// along with SyncStateAnnotation
+	ReconcileError = "reconcile_error"
+	// ListenerPort attaches the routes to a non-default proxy listener port
+	ListenerPort = "listener_port"
 	// KubernetesServiceAccountTokenSecret allows k8s service authentication via pomerium
 	// nolint: gosec
 	KubernetesServiceAccountTokenSecret = "kubernetes_service_account_token_secret"
@@ -40,23 +58,84 @@ const (
 	SetRequestHeadersSecret = "set_request_headers_secret"
 	// SetResponseHeadersSecret defines a secret to copy response headers from
 	SetResponseHeadersSecret = "set_response_headers_secret"
+	// PolicyConfigMap defines a config map holding Pomerium Policy Language policy, as an alternative to the inline policy
+	PolicyConfigMap = "policy_configmap"
+	// PolicyConfigMapKey defines key within the config map that contains the policy
+	PolicyConfigMapKey = "policy"
+	// DisableDefaultHeaders opts the ingress out of the controller default response headers
+	DisableDefaultHeaders = "disable_default_headers"
+	// LongLivedConnections expands into the route options suitable for long-polling and websocket clients
+	LongLivedConnections = "long_lived_connections"
+	// RouteTTL is a duration after which the ingress routes are removed, unless the ingress is updated
+	RouteTTL = "route_ttl"
+	// StatusFromService overrides the proxy service, in namespace/name format, the ingress status is updated from
+	StatusFromService = "status_from_service"
+	// Redirect makes the ingress routes respond with a redirect, rather than proxying to the backend services
+	Redirect = "redirect"
+	// Canary sends a share of the ingress traffic to another service, see CanaryBackend
+	Canary = "canary"
 )
 
+// ControllerAnnotations are written by the controller itself, rather than configure the ingress
+var ControllerAnnotations = []string{SyncStateAnnotation, LastAppliedGeneration, ReconcileError}
+
 // IngressConfig represents ingress and all other required resources
 type IngressConfig struct {
 	AnnotationPrefix string
+	// ServiceAnnotationPrefix if set, is a prefix (without /) for the backend Service annotations,
+	// that are applied to the routes of that service beneath the Ingress annotations
+	ServiceAnnotationPrefix string
+	// Revision is a monotonically increasing number assigned by the controller to each translated config,
+	// that allows to tell whether one observed config is newer than the other
+	Revision uint64
+	// AllowedListenerPorts are the non-default proxy listener ports the ingress may use via ListenerPort annotation
+	AllowedListenerPorts []int32
+	// AllowedStatusServices are the proxy services the ingress may take its status from via StatusFromService annotation
+	AllowedStatusServices []types.NamespacedName
+	// DefaultResponseHeaders are set on the responses of all routes, unless the route sets the same header
+	// or the ingress opts out via DisableDefaultHeaders annotation
+	DefaultResponseHeaders map[string]string
+	// SkipCertificates if set, the downstream TLS certificates and client CA are not forwarded to Pomerium,
+	// as it runs with insecure_server and would neither serve nor validate them
+	SkipCertificates bool
+	// ServiceProxyUpstreams if set, the k8s service proxy is used as upstream by default, opposed to individual endpoints
+	ServiceProxyUpstreams bool
+	// AllowWildcardHosts if set, the rules with a wildcard host are translated, otherwise they are rejected,
+	// as Pomerium v0.17.x authorizes the requests by the route whose host equals the request host
+	AllowWildcardHosts bool
 	*networkingv1.Ingress
 	Endpoints map[types.NamespacedName]*corev1.Endpoints
 	Secrets   map[types.NamespacedName]*corev1.Secret
 	Services  map[types.NamespacedName]*corev1.Service
+	// ConfigMaps referenced by the ingress annotations
+	ConfigMaps map[types.NamespacedName]*corev1.ConfigMap
+	// ClassDefaults are the route annotations, without prefix, set by the IngressClass parameters,
+	// that apply unless the ingress or its backend service sets the same annotation
+	ClassDefaults map[string]string
+	// ClassParameters is the IngressClass parameters object ClassDefaults come from, nil if none
+	ClassParameters *types.NamespacedName
+	// Warnings found while translating the ingress, that did not prevent its routes from being applied
+	Warnings *Warnings
+	// RouteCount is the number of routes the ingress was translated into by the last upsert
+	RouteCount int
+}
+
+// Warn records a translation warning for the ingress
+func (ic *IngressConfig) Warn(reason, msg string) {
+	if ic.Warnings == nil {
+		ic.Warnings = new(Warnings)
+	}
+	ic.Warnings.Add(reason, msg)
 }
 
 // IsAnnotationSet checks if a boolean annotation is set to true
 func (ic *IngressConfig) IsAnnotationSet(name string) bool {
-	return strings.ToLower(ic.Ingress.Annotations[fmt.Sprintf("%s/%s", ic.AnnotationPrefix, name)]) == "true"
+	// this is called for every path, hence avoiding allocations here
+	return strings.EqualFold(ic.Ingress.Annotations[ic.AnnotationPrefix+"/"+name], "true")
 }
 
-// IsSecureUpstream returns true if upstream endpoints should be HTTPS
+// IsSecureUpstream returns true if upstream endpoints should be HTTPS, as set by the annotation.
+// without the annotation, the backend service port appProtocol is used by the translation
 func (ic *IngressConfig) IsSecureUpstream() bool {
 	return ic.IsAnnotationSet(SecureUpstream)
 }
@@ -71,9 +150,82 @@ func (ic *IngressConfig) IsPathRegex() bool {
 	return ic.IsAnnotationSet(PathRegex)
 }
 
+// IsCaseInsensitivePaths returns true if paths in the Ingress spec should be matched regardless of the case
+func (ic *IngressConfig) IsCaseInsensitivePaths() bool {
+	return ic.IsAnnotationSet(CaseInsensitivePaths)
+}
+
+// IsCombinePaths returns true if paths of a rule sharing the same backend should be combined into a single route
+func (ic *IngressConfig) IsCombinePaths() bool {
+	return ic.IsAnnotationSet(CombinePaths)
+}
+
+// IsRedirect returns true if the ingress routes redirect, in which case the backend services are not resolved
+func (ic *IngressConfig) IsRedirect() bool {
+	_, ok := ic.Ingress.Annotations[ic.AnnotationPrefix+"/"+Redirect]
+	return ok
+}
+
 // UseServiceProxy disables use of endpoints and would use standard k8s service proxy instead
 func (ic *IngressConfig) UseServiceProxy() bool {
-	return ic.IsAnnotationSet(UseServiceProxy)
+	return IsServiceProxyUpstream(ic.Ingress, ic.AnnotationPrefix, ic.ServiceProxyUpstreams)
+}
+
+// UseNotReadyEndpoints returns true if the endpoint addresses that are not ready should also be used as upstreams
+func (ic *IngressConfig) UseNotReadyEndpoints() bool {
+	return ic.IsAnnotationSet(UseNotReadyEndpoints)
+}
+
+// IsServiceProxyUpstream checks whether the ingress uses the k8s service proxy as upstream,
+// the annotation takes precedence over the controller default.
+// it is also used by the controller to skip fetching the endpoints before the ingress config is assembled
+func IsServiceProxyUpstream(ingress *networkingv1.Ingress, annotationPrefix string, byDefault bool) bool {
+	txt, ok := ingress.Annotations[annotationPrefix+"/"+UseServiceProxy]
+	if !ok {
+		return byDefault
+	}
+	return strings.EqualFold(txt, "true")
+}
+
+// GetListenerPort returns the proxy listener port set via annotation, or 0 for the default listener.
+// the port must be one of the allowed listener ports
+func (ic *IngressConfig) GetListenerPort() (int32, error) {
+	txt, ok := ic.Ingress.Annotations[ic.AnnotationPrefix+"/"+ListenerPort]
+	if !ok {
+		return 0, nil
+	}
+	port, err := strconv.ParseInt(txt, 10, 32)
+	if err != nil {
+		return 0, NewPermanentError(fmt.Errorf("%s: %w", ListenerPort, err))
+	}
+	for _, allowed := range ic.AllowedListenerPorts {
+		if int32(port) == allowed {
+			return allowed, nil
+		}
+	}
+	return 0, NewPermanentError(fmt.Errorf("%s: port %d is not one of the allowed listener ports %v",
+		ListenerPort, port, ic.AllowedListenerPorts))
+}
+
+// GetStatusFromService returns the proxy service set via annotation to update the ingress status from,
+// or nil if not set. the service must be one of the allowed status services
+func (ic *IngressConfig) GetStatusFromService() (*types.NamespacedName, error) {
+	txt, ok := ic.Ingress.Annotations[ic.AnnotationPrefix+"/"+StatusFromService]
+	if !ok {
+		return nil, nil
+	}
+	parts := strings.Split(txt, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, NewPermanentError(fmt.Errorf("%s: %q must be in namespace/name format", StatusFromService, txt))
+	}
+	name := types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	for _, allowed := range ic.AllowedStatusServices {
+		if name == allowed {
+			return &name, nil
+		}
+	}
+	return nil, NewPermanentError(fmt.Errorf("%s: service %s is not one of the allowed status services %v",
+		StatusFromService, name, ic.AllowedStatusServices))
 }
 
 // GetNamespacedName returns namespaced name of a resource
@@ -93,13 +245,37 @@ func (ic *IngressConfig) GetServicePortByName(name types.NamespacedName, port st
 		return 0, fmt.Errorf("service %s was not pre-fetched, this is a bug", name.String())
 	}
 
+	available := make([]string, 0, len(svc.Spec.Ports))
 	for _, servicePort := range svc.Spec.Ports {
 		if servicePort.Name == port {
 			return servicePort.Port, nil
 		}
+		if servicePort.Name != "" {
+			available = append(available, servicePort.Name)
+		} else {
+			available = append(available, strconv.Itoa(int(servicePort.Port)))
+		}
 	}
 
-	return 0, fmt.Errorf("could not find port %s on service %s", port, name.String())
+	return 0, &PortNotFoundError{Service: name, Port: port, Available: available}
+}
+
+// PortNotFoundError is returned if the named port referenced by the ingress backend does not exist on the service,
+// i.e. after the service port was renamed
+type PortNotFoundError struct {
+	Service types.NamespacedName
+	Port    string
+	// Available are the port names of the service, or numbers of its unnamed ports
+	Available []string
+}
+
+// Error implements error interface
+func (e *PortNotFoundError) Error() string {
+	available := strings.Join(e.Available, ", ")
+	if available == "" {
+		available = "none"
+	}
+	return fmt.Sprintf("port %s not found on service %s; available: %s", e.Port, e.Service.String(), available)
 }
 
 const (
@@ -122,11 +298,38 @@ type TLSCert struct {
 // Clone creates a deep copy of the ingress config
 func (ic *IngressConfig) Clone() *IngressConfig {
 	dst := &IngressConfig{
-		AnnotationPrefix: ic.AnnotationPrefix,
-		Ingress:          ic.Ingress.DeepCopy(),
-		Endpoints:        make(map[types.NamespacedName]*corev1.Endpoints, len(ic.Endpoints)),
-		Secrets:          make(map[types.NamespacedName]*corev1.Secret, len(ic.Secrets)),
-		Services:         make(map[types.NamespacedName]*corev1.Service, len(ic.Services)),
+		AnnotationPrefix:        ic.AnnotationPrefix,
+		ServiceAnnotationPrefix: ic.ServiceAnnotationPrefix,
+		Revision:                ic.Revision,
+		AllowedListenerPorts:    append([]int32(nil), ic.AllowedListenerPorts...),
+		AllowedStatusServices:   append([]types.NamespacedName(nil), ic.AllowedStatusServices...),
+		SkipCertificates:        ic.SkipCertificates,
+		ServiceProxyUpstreams:   ic.ServiceProxyUpstreams,
+		AllowWildcardHosts:      ic.AllowWildcardHosts,
+		RouteCount:              ic.RouteCount,
+		Ingress:                 ic.Ingress.DeepCopy(),
+		Endpoints:               make(map[types.NamespacedName]*corev1.Endpoints, len(ic.Endpoints)),
+		Secrets:                 make(map[types.NamespacedName]*corev1.Secret, len(ic.Secrets)),
+		Services:                make(map[types.NamespacedName]*corev1.Service, len(ic.Services)),
+		ConfigMaps:              make(map[types.NamespacedName]*corev1.ConfigMap, len(ic.ConfigMaps)),
+	}
+
+	if ic.ClassDefaults != nil {
+		dst.ClassDefaults = make(map[string]string, len(ic.ClassDefaults))
+		for k, v := range ic.ClassDefaults {
+			dst.ClassDefaults[k] = v
+		}
+	}
+	if ic.ClassParameters != nil {
+		name := *ic.ClassParameters
+		dst.ClassParameters = &name
+	}
+
+	if ic.Warnings != nil {
+		dst.Warnings = new(Warnings)
+		for _, w := range ic.Warnings.List() {
+			dst.Warnings.Add(w.Reason, w.Message)
+		}
 	}
 
 	for k, v := range ic.Secrets {
@@ -137,6 +340,10 @@ func (ic *IngressConfig) Clone() *IngressConfig {
 		dst.Services[k] = v.DeepCopy()
 	}
 
+	for k, v := range ic.ConfigMaps {
+		dst.ConfigMaps[k] = v.DeepCopy()
+	}
+
 	return dst
 }
 
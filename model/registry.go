@@ -21,6 +21,8 @@ type Registry interface {
 	// Deps returns list of dependencies given object key has
 	Deps(x Key) []Key
 	DepsOfKind(x Key, kind string) []Key
+	// Keys returns all keys that have dependencies
+	Keys() []Key
 	// DeleteCascade deletes key x and also any dependent keys that do not have other dependencies
 	DeleteCascade(x Key)
 }
@@ -93,6 +95,18 @@ func (r *registry) DepsOfKind(x Key, kind string) []Key {
 	return keys
 }
 
+// Keys returns all keys that have dependencies
+func (r *registry) Keys() []Key {
+	r.RLock()
+	defer r.RUnlock()
+
+	keys := make([]Key, 0, len(r.items))
+	for k := range r.items {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 func (r *registry) DeleteCascade(x Key) {
 	r.Lock()
 	defer r.Unlock()
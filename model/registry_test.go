@@ -22,6 +22,7 @@ func TestRegistry(t *testing.T) {
 	assert.ElementsMatch(t, []Key{a}, r.Deps(b))
 	assert.ElementsMatch(t, []Key{a}, r.DepsOfKind(b, "a"))
 	assert.ElementsMatch(t, []Key{a, d}, r.Deps(c))
+	assert.ElementsMatch(t, []Key{a, b, c, d}, r.Keys())
 	r.DeleteCascade(c)
 	assert.ElementsMatch(t, []Key{b}, r.Deps(a))
 	assert.Empty(t, r.Deps(d))
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RouteError describes an Ingress path that could not be converted into a valid route
+type RouteError struct {
+	// Host and Path identify the Ingress rule path
+	Host string
+	Path string
+	Err  error
+}
+
+// Error implements error interface
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("%s%s: %v", e.Host, e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// RouteErrors is returned when some of the Ingress routes were invalid and were skipped,
+// while the remaining valid routes were applied
+type RouteErrors []*RouteError
+
+// Error implements error interface
+func (e RouteErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d invalid route(s) skipped: %s", len(e), strings.Join(msgs, "; "))
+}
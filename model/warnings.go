@@ -0,0 +1,51 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Warning is an issue found while translating an ingress, that does not prevent its routes from being applied
+type Warning struct {
+	// Reason is a short CamelCase identifier of the issue
+	Reason  string
+	Message string
+}
+
+// String implements fmt.Stringer
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Reason, w.Message)
+}
+
+// Warnings collects the translation warnings of an ingress, ignoring the duplicates,
+// as the same ingress may be translated more than once per reconciliation.
+// The zero value is ready to use, and it is safe for concurrent use.
+type Warnings struct {
+	mu    sync.Mutex
+	items []Warning
+}
+
+// Add records a warning, unless it was already recorded
+func (w *Warnings) Add(reason, msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	warning := Warning{Reason: reason, Message: msg}
+	for _, cur := range w.items {
+		if cur == warning {
+			return
+		}
+	}
+	w.items = append(w.items, warning)
+}
+
+// List returns the recorded warnings in the order they were added
+func (w *Warnings) List() []Warning {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]Warning(nil), w.items...)
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarnings(t *testing.T) {
+	var ic IngressConfig
+	assert.Empty(t, ic.Warnings.List())
+
+	ic.Warn("A", "first")
+	ic.Warn("B", "second")
+	ic.Warn("A", "first")
+	ic.Warn("A", "third")
+	assert.Equal(t, []Warning{
+		{Reason: "A", Message: "first"},
+		{Reason: "B", Message: "second"},
+		{Reason: "A", Message: "third"},
+	}, ic.Warnings.List())
+
+	dst := ic.Clone()
+	dst.Warn("C", "clone only")
+	assert.Len(t, ic.Warnings.List(), 3, "clone warnings should not affect the original")
+}
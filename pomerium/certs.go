@@ -1,37 +1,18 @@
 package pomerium
 
 import (
-	"context"
 	"fmt"
 	"net/url"
 
 	pb "github.com/pomerium/pomerium/pkg/grpc/config"
-
-	"github.com/pomerium/ingress-controller/model"
 )
 
-// upsertCerts updates certificate bundle
-func upsertCerts(ctx context.Context, cfg *pb.Config, ic *model.IngressConfig) error {
-	certs, err := ic.ParseTLSCerts(ctx)
-	if err != nil {
-		return err
-	}
-
-	addCerts(cfg, certs)
-	return nil
-}
-
-func addCerts(cfg *pb.Config, certs []*model.TLSCert) {
+// addCerts adds the certificates to the config settings
+func addCerts(cfg *pb.Config, certs []*pb.Settings_Certificate) {
 	if cfg.Settings == nil {
 		cfg.Settings = new(pb.Settings)
 	}
-
-	for _, cert := range certs {
-		cfg.Settings.Certificates = append(cfg.Settings.Certificates, &pb.Settings_Certificate{
-			CertBytes: cert.Cert,
-			KeyBytes:  cert.Key,
-		})
-	}
+	cfg.Settings.Certificates = append(cfg.Settings.Certificates, certs...)
 }
 
 func removeUnusedCerts(cfg *pb.Config) error {
@@ -0,0 +1,80 @@
+package pomerium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+)
+
+// DefaultMaxRouteDeletionPercent is the default share of the existing routes a full sync may delete
+const DefaultMaxRouteDeletionPercent = 50
+
+// ErrMassRouteDeletion is returned by Set if it would delete too many of the existing routes
+var ErrMassRouteDeletion = errors.New("refusing to delete routes in bulk")
+
+var massRouteDeletionsRefused = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "pomerium_ingress_mass_route_deletions_refused_total",
+	Help: "Number of full syncs refused, as they would delete too many of the existing routes",
+})
+
+func init() {
+	// metrics are served by the controller manager
+	metrics.Registry.MustRegister(massRouteDeletionsRefused)
+}
+
+// DeletionGuard protects against a full sync wiping the existing routes at once,
+// i.e. due to a bug or a misconfigured namespace filter that results in an empty desired state
+type DeletionGuard struct {
+	// MaxPercent is the share of the existing routes a full sync may delete, 0-100
+	MaxPercent int
+	// Allow disables the guard, that is an operator override
+	Allow bool
+	// ConfirmFile if set and the file exists, allows the full sync to proceed
+	ConfirmFile string
+}
+
+// check returns ErrMassRouteDeletion if next config would delete more than the allowed share of the prev config routes
+func (g *DeletionGuard) check(ctx context.Context, prev, next *pb.Config) error {
+	if g == nil || g.Allow {
+		return nil
+	}
+
+	keep := make(map[routeMatch]bool, len(next.GetRoutes()))
+	for _, r := range next.GetRoutes() {
+		keep[getRouteMatch(r)] = true
+	}
+	existing, deleted := len(prev.GetRoutes()), 0
+	for _, r := range prev.GetRoutes() {
+		if !keep[getRouteMatch(r)] {
+			deleted++
+		}
+	}
+	if deleted == 0 || deleted*100 <= existing*g.MaxPercent {
+		return nil
+	}
+
+	logger := log.FromContext(ctx).WithValues("existing", existing, "deleted", deleted, "max-percent", g.MaxPercent)
+	if g.isConfirmed() {
+		logger.Info("deleting routes in bulk, as confirmed by file", "file", g.ConfirmFile)
+		return nil
+	}
+	massRouteDeletionsRefused.Inc()
+	err := fmt.Errorf("%w: %d of %d existing routes would be deleted, over %d%% allowed", ErrMassRouteDeletion, deleted, existing, g.MaxPercent)
+	logger.Error(err, "full sync refused, set the override flag or create the confirmation file to proceed", "file", g.ConfirmFile)
+	return err
+}
+
+func (g *DeletionGuard) isConfirmed() bool {
+	if g.ConfirmFile == "" {
+		return false
+	}
+	_, err := os.Stat(g.ConfirmFile)
+	return err == nil
+}
@@ -0,0 +1,122 @@
+package pomerium
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+)
+
+// OrphanRoute is a route published to the databroker that no live ingress accounts for
+type OrphanRoute struct {
+	// RecordID is the config record holding the route
+	RecordID string
+	// Ingress the route was generated for, empty for the legacy routes without the ownership marker
+	Ingress types.NamespacedName
+	Route   *pb.Route
+}
+
+// FindOrphanRoutes scans all config records for the routes this reconciler published for the ingresses that are not live.
+// if legacy is set, the routes without the ownership marker whose name matches it are reported as well,
+// as the controller never publishes such routes
+func (r *ConfigReconciler) FindOrphanRoutes(
+	ctx context.Context,
+	live func(types.NamespacedName) bool,
+	legacy *regexp.Regexp,
+) ([]OrphanRoute, error) {
+	records, err := r.listConfigRecords(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list config records: %w", err)
+	}
+
+	var orphans []OrphanRoute
+	for _, rec := range records {
+		for _, route := range rec.cfg.Routes {
+			if name, ok := r.isOrphan(route, live, legacy); ok {
+				orphans = append(orphans, OrphanRoute{RecordID: rec.GetId(), Ingress: name, Route: route})
+			}
+		}
+	}
+	return orphans, nil
+}
+
+func (r *ConfigReconciler) isOrphan(
+	route *pb.Route,
+	live func(types.NamespacedName) bool,
+	legacy *regexp.Regexp,
+) (types.NamespacedName, bool) {
+	var id routeID
+	if err := id.Unmarshal(route.Id); err != nil {
+		return types.NamespacedName{}, legacy != nil && legacy.MatchString(route.Name)
+	}
+	// routes of other clusters are not attributed to the ingresses of this one
+	if id.Cluster != r.Cluster {
+		return types.NamespacedName{}, false
+	}
+	name := types.NamespacedName{Namespace: id.Namespace, Name: id.Name}
+	return name, !live(name)
+}
+
+// DeleteOrphanRoutes removes the routes from their config records, along with the certificates no longer in use.
+// the records are read again, and the routes that changed since they were found are kept.
+// it returns the number of routes removed
+func (r *ConfigReconciler) DeleteOrphanRoutes(ctx context.Context, orphans []OrphanRoute) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byRecord := make(map[string][]*pb.Route)
+	for _, o := range orphans {
+		byRecord[o.RecordID] = append(byRecord[o.RecordID], o.Route)
+	}
+
+	records, err := r.listConfigRecords(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list config records: %w", err)
+	}
+
+	logger := log.FromContext(ctx)
+	deleted := 0
+	for _, rec := range records {
+		remove, ok := byRecord[rec.GetId()]
+		if !ok {
+			continue
+		}
+		routes := rec.cfg.Routes[:0]
+		for _, route := range rec.cfg.Routes {
+			if containsRoute(remove, route) {
+				continue
+			}
+			routes = append(routes, route)
+		}
+		n := len(rec.cfg.Routes) - len(routes)
+		if n == 0 {
+			continue
+		}
+		rec.cfg.Routes = routes
+		if err := removeUnusedCerts(rec.cfg); err != nil {
+			return deleted, fmt.Errorf("removing unused certs from %s: %w", rec.GetId(), err)
+		}
+		sort.Sort(routeList(rec.cfg.Routes))
+		if err := r.putConfig(ctx, rec.GetId(), rec.cfg); err != nil {
+			return deleted, fmt.Errorf("updating config record %s: %w", rec.GetId(), err)
+		}
+		logger.Info("deleted orphan routes", "record", rec.GetId(), "routes", n)
+		deleted += n
+	}
+	return deleted, nil
+}
+
+func containsRoute(routes []*pb.Route, route *pb.Route) bool {
+	for _, r := range routes {
+		if proto.Equal(r, route) {
+			return true
+		}
+	}
+	return false
+}
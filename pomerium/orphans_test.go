@@ -0,0 +1,63 @@
+package pomerium
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+)
+
+func TestOrphanRoutes(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	primary := &ConfigReconciler{DataBrokerServiceClient: db, Cluster: "primary"}
+	other := &ConfigReconciler{DataBrokerServiceClient: db, Cluster: "other"}
+
+	live := manyPathsIngress(1, nil)
+	gone := manyPathsIngress(2, nil)
+	gone.Name = "gone"
+	gone.Spec.Rules[0].Host = "gone.localhost.pomerium.io"
+	for _, r := range []*ConfigReconciler{primary, other} {
+		_, err := r.Upsert(ctx, live)
+		require.NoError(t, err)
+	}
+	_, err := primary.Upsert(ctx, gone)
+	require.NoError(t, err)
+	cfg, err := primary.getConfig(ctx)
+	require.NoError(t, err)
+	cfg.Routes = append(cfg.Routes, &pb.Route{Name: "legacy-route", From: "https://legacy.localhost.pomerium.io"})
+	require.NoError(t, primary.putConfig(ctx, primary.recordID(), cfg))
+
+	isLive := func(name types.NamespacedName) bool {
+		return name == types.NamespacedName{Namespace: live.Namespace, Name: live.Name}
+	}
+	orphans, err := primary.FindOrphanRoutes(ctx, isLive, nil)
+	require.NoError(t, err)
+	if assert.Len(t, orphans, 2, "routes of the other cluster and unmarked routes should be ignored") {
+		for _, o := range orphans {
+			assert.Equal(t, primary.recordID(), o.RecordID)
+			assert.Equal(t, types.NamespacedName{Namespace: "default", Name: "gone"}, o.Ingress)
+		}
+	}
+
+	orphans, err = primary.FindOrphanRoutes(ctx, isLive, regexp.MustCompile("^legacy-"))
+	require.NoError(t, err)
+	require.Len(t, orphans, 3)
+
+	n, err := primary.DeleteOrphanRoutes(ctx, orphans)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, map[string][]string{
+		"primary": {"https://service.localhost.pomerium.io"},
+		"other":   {"https://service.localhost.pomerium.io"},
+	}, db.clusterRoutes(t))
+
+	n, err = primary.DeleteOrphanRoutes(ctx, orphans)
+	require.NoError(t, err)
+	assert.Zero(t, n, "deleting again should be a no-op")
+}
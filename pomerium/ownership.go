@@ -0,0 +1,271 @@
+package pomerium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/protoutil"
+)
+
+const (
+	queryPageSize = 100
+	// maxTakeOverAttempts limits how many times the other cluster record is re-read if it keeps changing
+	maxTakeOverAttempts = 3
+)
+
+// recordID returns the databroker config record id owned by this reconciler
+func (r *ConfigReconciler) recordID() string {
+	if r.Cluster == "" {
+		return configID
+	}
+	return fmt.Sprintf("%s-%s", configID, r.Cluster)
+}
+
+// splitOwned separates the routes published by the ingress controller, that carry the ownership marker in their id,
+// from the ones that were added to the config record otherwise, i.e. manually, and are left intact
+func splitOwned(routes []*pb.Route) (owned, other routeList) {
+	for _, route := range routes {
+		var id routeID
+		if err := id.Unmarshal(route.Id); err != nil {
+			other = append(other, route)
+		} else {
+			owned = append(owned, route)
+		}
+	}
+	return owned, other
+}
+
+// setOwner marks all config routes published by the ingress controller as owned by this cluster
+func (r *ConfigReconciler) setOwner(cfg *pb.Config) error {
+	for _, route := range cfg.Routes {
+		var id routeID
+		if err := id.Unmarshal(route.Id); err != nil {
+			// not published by the ingress controller
+			continue
+		}
+		id.Cluster, id.Priority = r.Cluster, r.ClusterPriority
+		txt, err := id.Marshal()
+		if err != nil {
+			return err
+		}
+		route.Id = txt
+	}
+	return nil
+}
+
+// configRecord is a config record published to the databroker, along with its decoded config
+type configRecord struct {
+	*databroker.Record
+	cfg *pb.Config
+	// takenOver lists the routes removed from the record, as they were taken over by this cluster
+	takenOver []routeMatch
+}
+
+// routeMatch identifies routes that would compete for the same requests
+type routeMatch struct {
+	from, prefix, path, regex string
+}
+
+func getRouteMatch(r *pb.Route) routeMatch {
+	return routeMatch{from: r.From, prefix: r.Prefix, path: r.Path, regex: r.Regex}
+}
+
+// resolveCompetingRoutes handles routes that other clusters publish for the same from URL and path:
+// routes owned by a lower priority cluster are taken over, by removing them from the other cluster record,
+// while routes owned by a higher priority cluster are never clobbered, and ours are withdrawn instead.
+// the other cluster records are not written, but returned, so that they are only updated along with ours.
+// this is only done if the cluster name is set.
+func (r *ConfigReconciler) resolveCompetingRoutes(ctx context.Context, cfg *pb.Config) ([]*configRecord, error) {
+	if r.Cluster == "" {
+		return nil, nil
+	}
+
+	records, err := r.getCompetingRecords(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get other cluster configs: %w", err)
+	}
+
+	type competitor struct {
+		*configRecord
+		id routeID
+	}
+	competitors := make(map[routeMatch][]competitor)
+	for _, rec := range records {
+		for _, route := range rec.cfg.Routes {
+			var id routeID
+			if err := id.Unmarshal(route.Id); err != nil {
+				// not published by the ingress controller
+				continue
+			}
+			key := getRouteMatch(route)
+			competitors[key] = append(competitors[key], competitor{rec, id})
+		}
+	}
+
+	logger := log.FromContext(ctx)
+	routes := cfg.Routes[:0]
+	for _, route := range cfg.Routes {
+		key := getRouteMatch(route)
+		keep := true
+		for _, c := range competitors[key] {
+			switch {
+			case c.id.Priority > r.ClusterPriority:
+				logger.Info("WARNING: route is owned by a higher priority cluster, not publishing",
+					"from", route.From, "cluster", c.id.Cluster, "priority", c.id.Priority)
+				keep = false
+			case c.id.Priority == r.ClusterPriority:
+				logger.Info("WARNING: route is also published by a cluster with the same priority",
+					"from", route.From, "cluster", c.id.Cluster, "priority", c.id.Priority)
+			}
+		}
+		if !keep {
+			continue
+		}
+		for _, c := range competitors[key] {
+			if c.id.Priority < r.ClusterPriority && c.removeRoute(key, r.ClusterPriority) {
+				logger.Info("taking over route from a lower priority cluster",
+					"from", route.From, "cluster", c.id.Cluster, "priority", c.id.Priority)
+				c.takenOver = append(c.takenOver, key)
+			}
+		}
+		routes = append(routes, route)
+	}
+	cfg.Routes = routes
+
+	takeovers := records[:0]
+	for _, rec := range records {
+		if len(rec.takenOver) > 0 {
+			takeovers = append(takeovers, rec)
+		}
+	}
+	return takeovers, nil
+}
+
+// removeRoute removes the routes matching the key, that were published by a cluster of a lower priority,
+// and reports whether any were removed
+func (rec *configRecord) removeRoute(key routeMatch, priority int) bool {
+	removed := false
+	routes := rec.cfg.Routes[:0]
+	for _, route := range rec.cfg.Routes {
+		var id routeID
+		if getRouteMatch(route) == key && id.Unmarshal(route.Id) == nil && id.Priority < priority {
+			removed = true
+			continue
+		}
+		routes = append(routes, route)
+	}
+	rec.cfg.Routes = routes
+	return removed
+}
+
+// takeOver removes the routes taken over from the other cluster records.
+// the databroker has no conditional writes, hence each record is read again right before it is written,
+// and if it was updated meanwhile, the routes are removed from its current contents instead,
+// so that the concurrent updates of the other cluster are not lost
+func (r *ConfigReconciler) takeOver(ctx context.Context, records []*configRecord) error {
+	for _, rec := range records {
+		if err := r.takeOverRecord(ctx, rec); err != nil {
+			return fmt.Errorf("updating config record %s: %w", rec.Id, err)
+		}
+	}
+	return nil
+}
+
+func (r *ConfigReconciler) takeOverRecord(ctx context.Context, rec *configRecord) error {
+	for attempt := 0; attempt < maxTakeOverAttempts; attempt++ {
+		resp, err := r.Get(ctx, &databroker.GetRequest{Type: rec.GetType(), Id: rec.GetId()})
+		if status.Code(err) == codes.NotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		current := resp.GetRecord()
+		if current.GetDeletedAt() != nil {
+			return nil
+		}
+		if current.GetVersion() == rec.GetVersion() {
+			if err := removeUnusedCerts(rec.cfg); err != nil {
+				return fmt.Errorf("removing unused certs: %w", err)
+			}
+			return r.putConfig(ctx, rec.GetId(), rec.cfg)
+		}
+
+		cfg := new(pb.Config)
+		if err := current.GetData().UnmarshalTo(cfg); err != nil {
+			return fmt.Errorf("unmarshal config record: %w", err)
+		}
+		next := &configRecord{Record: current, cfg: cfg, takenOver: rec.takenOver}
+		removed := false
+		for _, key := range rec.takenOver {
+			removed = next.removeRoute(key, r.ClusterPriority) || removed
+		}
+		if !removed {
+			return nil
+		}
+		rec = next
+	}
+	return errors.New("the record is being updated concurrently")
+}
+
+// getCompetingRecords returns config records other than the one owned by this reconciler
+func (r *ConfigReconciler) getCompetingRecords(ctx context.Context) ([]*configRecord, error) {
+	all, err := r.listConfigRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records := all[:0]
+	for _, rec := range all {
+		if rec.GetId() != r.recordID() {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// listConfigRecords returns all config records that are not deleted
+func (r *ConfigReconciler) listConfigRecords(ctx context.Context) ([]*configRecord, error) {
+	var records []*configRecord
+	typeURL := protoutil.NewAny(new(pb.Config)).GetTypeUrl()
+	for offset := int64(0); ; offset += queryPageSize {
+		resp, err := r.Query(ctx, &databroker.QueryRequest{
+			Type:   typeURL,
+			Offset: offset,
+			Limit:  queryPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range resp.GetRecords() {
+			if rec.GetDeletedAt() != nil {
+				continue
+			}
+			cfg := new(pb.Config)
+			if err := rec.GetData().UnmarshalTo(cfg); err != nil {
+				return nil, fmt.Errorf("unmarshal config record %s: %w", rec.GetId(), err)
+			}
+			records = append(records, &configRecord{Record: rec, cfg: cfg})
+		}
+		if offset+queryPageSize >= resp.GetTotalCount() {
+			return records, nil
+		}
+	}
+}
+
+func (r *ConfigReconciler) putConfig(ctx context.Context, id string, cfg *pb.Config) error {
+	any := protoutil.NewAny(cfg)
+	_, err := r.Put(ctx, &databroker.PutRequest{
+		Record: &databroker.Record{
+			Type: any.GetTypeUrl(),
+			Id:   id,
+			Data: any,
+		},
+	})
+	return err
+}
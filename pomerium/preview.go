@@ -0,0 +1,47 @@
+package pomerium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// BuildConfig returns the pomerium config the ingresses would be applied as, without the databroker,
+// so that the routes of the ingresses may be previewed. as with Set, the ingresses that could not be applied
+// are skipped, and the error lists them along with the invalid routes of the ingresses applied partially
+func BuildConfig(ctx context.Context, ics []*model.IngressConfig) (*pb.Config, error) {
+	var errs *multierror.Error
+	cfg := new(pb.Config)
+	for _, ic := range ics {
+		name := ic.GetIngressNamespacedName().String()
+		next := proto.Clone(cfg).(*pb.Config)
+
+		var routeErrs model.RouteErrors
+		err := upsert(ctx, next, ic)
+		if err != nil && !errors.As(err, &routeErrs) {
+			errs = multierror.Append(errs, fmt.Errorf("ingress %s: %w", name, err))
+			continue
+		}
+		if err := validate(ctx, next, string(ic.Ingress.UID)); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("ingress %s: config validation: %w", name, err))
+			continue
+		}
+		if len(routeErrs) > 0 {
+			errs = multierror.Append(errs, fmt.Errorf("ingress %s: %w", name, routeErrs))
+		}
+		cfg = next
+	}
+
+	if err := removeUnusedCerts(cfg); err != nil {
+		return nil, fmt.Errorf("removing unused certs: %w", err)
+	}
+	routeList(cfg.Routes).Sort()
+	return cfg, errs.ErrorOrNil()
+}
@@ -1,33 +1,20 @@
 package pomerium
 
 import (
-	"encoding/json"
 	"fmt"
+	"regexp"
 	"sort"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/types"
 
 	pb "github.com/pomerium/pomerium/pkg/grpc/config"
-)
-
-type routeID struct {
-	Name      string `json:"n"`
-	Namespace string `json:"ns"`
-	Host      string `json:"h"`
-	Path      string `json:"p"`
-}
 
-func (r *routeID) Marshal() (string, error) {
-	data, err := json.Marshal(r)
-	if err != nil {
-		return "", err
-	}
-	return string(data), nil
-}
+	"github.com/pomerium/ingress-controller/translate"
+)
 
-func (r *routeID) Unmarshal(txt string) error {
-	return json.Unmarshal([]byte(txt), r)
-}
+// routeID is the route ID, identifying the ingress, host and path the route was generated for
+type routeID = translate.RouteID
 
 type routeList []*pb.Route
 type routeMap map[routeID]*pb.Route
@@ -39,7 +26,12 @@ func (routes routeList) Swap(i, j int) { routes[i], routes[j] = routes[j], route
 // Less reports whether the element with
 // index i should sort before the element with index j.
 // as envoy parses routes as presented, we should presents routes with longer paths first
-// exact Path always takes priority over Prefix matching
+// exact Path always takes priority over Prefix matching.
+// the element-wise Prefix path type routes have both the prefix and the regex set,
+// and are ordered along with the plain prefix routes by their prefix.
+// the case-insensitive routes are ordered along with the case-sensitive ones by their ingress path, see sortPaths.
+// regex routes are ordered by the regex itself, as the declaration order is not retained in the route.
+// the routes matching the same request, i.e. published by different ingresses, are ordered by ingress namespace/name
 func (routes routeList) Less(i, j int) bool {
 	// from ASC
 	iFrom, jFrom := routes[i].GetFrom(), routes[j].GetFrom()
@@ -50,13 +42,37 @@ func (routes routeList) Less(i, j int) bool {
 		return false
 	}
 
+	iPath, iPrefix := sortPaths(routes[i])
+	jPath, jPrefix := sortPaths(routes[j])
+
 	// path DESC
-	iPath, jPath := routes[i].GetPath(), routes[j].GetPath()
+	if c := comparePaths(iPath, jPath); c != 0 {
+		return c > 0
+	}
+
+	// regex only routes first
+	iRegexOnly := routes[i].GetRegex() != "" && iPath == "" && iPrefix == ""
+	jRegexOnly := routes[j].GetRegex() != "" && jPath == "" && jPrefix == ""
 	switch {
-	case iPath < jPath:
+	case iRegexOnly && !jRegexOnly:
+		return true
+	case !iRegexOnly && jRegexOnly:
 		return false
-	case iPath > jPath:
+	}
+
+	// prefix DESC
+	if c := comparePaths(iPrefix, jPrefix); c != 0 {
+		return c > 0
+	}
+
+	// case-sensitive routes first, as they are more specific
+	iInsensitive := strings.HasPrefix(routes[i].GetRegex(), caseInsensitiveFlag)
+	jInsensitive := strings.HasPrefix(routes[j].GetRegex(), caseInsensitiveFlag)
+	switch {
+	case !iInsensitive && jInsensitive:
 		return true
+	case iInsensitive && !jInsensitive:
+		return false
 	}
 
 	// regex DESC
@@ -68,13 +84,13 @@ func (routes routeList) Less(i, j int) bool {
 		return true
 	}
 
-	// prefix DESC
-	iPrefix, jPrefix := routes[i].GetPrefix(), routes[j].GetPrefix()
+	// ingress namespace/name ASC
+	iName, jName := routeIngressName(routes[i]), routeIngressName(routes[j])
 	switch {
-	case iPrefix < jPrefix:
-		return false
-	case iPrefix > jPrefix:
+	case iName < jName:
 		return true
+	case iName > jName:
+		return false
 	}
 
 	// finally, by id
@@ -89,6 +105,44 @@ func (routes routeList) Less(i, j int) bool {
 	return false
 }
 
+// routeIngressName returns the namespace/name of the ingress the route was generated for,
+// or an empty string for the routes not published by the ingress controller
+func routeIngressName(r *pb.Route) string {
+	var id routeID
+	if err := id.Unmarshal(r.GetId()); err != nil {
+		return ""
+	}
+	return types.NamespacedName{Namespace: id.Namespace, Name: id.Name}.String()
+}
+
+// caseInsensitiveFlag starts the regex of the case-insensitive routes
+const caseInsensitiveFlag = "(?i)"
+
+// sortPaths returns the exact path and the prefix the route is ordered by.
+// the case-insensitive routes only have the regex set, as pomerium would match a path or a prefix case-sensitively,
+// and are ordered by the ingress path from the route id instead, that is exact if the regex has no wildcard
+func sortPaths(r *pb.Route) (path, prefix string) {
+	regex := r.GetRegex()
+	if !strings.HasPrefix(regex, caseInsensitiveFlag) || r.GetPath() != "" || r.GetPrefix() != "" {
+		return r.GetPath(), r.GetPrefix()
+	}
+	var id routeID
+	// the combined paths routes have the regex in place of the path
+	if err := id.Unmarshal(r.GetId()); err != nil || !strings.HasPrefix(id.Path, "/") {
+		return "", ""
+	}
+	if regex == caseInsensitiveFlag+regexp.QuoteMeta(id.Path) {
+		return id.Path, ""
+	}
+	return "", id.Path
+}
+
+// comparePaths compares the paths in lower case, so that the case-insensitive paths are ordered
+// along with the case-sensitive ones they overlap with
+func comparePaths(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
 func (routes routeList) toMap() (routeMap, error) {
 	m := make(routeMap, len(routes))
 	for _, r := range routes {
@@ -96,6 +150,8 @@ func (routes routeList) toMap() (routeMap, error) {
 		if err := key.Unmarshal(r.Id); err != nil {
 			return nil, fmt.Errorf("cannot decode route id %s: %w", r.Id, err)
 		}
+		// routes are matched regardless of the publishing cluster, that is set when saving the config
+		key.Cluster, key.Priority = "", 0
 		if _, exists := m[key]; exists {
 			return nil, fmt.Errorf("duplicate route %+v", key)
 		}
@@ -0,0 +1,77 @@
+package pomerium
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/translate"
+)
+
+// RenderRoutes returns the redacted pomerium routes generated for the ingress, in the order they would be applied.
+// the routes that could not be generated are skipped, as they are reported by the reconciler
+func RenderRoutes(ctx context.Context, ic *model.IngressConfig) ([]v1alpha1.RenderedRoute, error) {
+	var routeErrs model.RouteErrors
+	routes, err := translate.Routes(ctx, ic)
+	if err != nil && !errors.As(err, &routeErrs) {
+		return nil, err
+	}
+	routeList(routes).Sort()
+
+	out := make([]v1alpha1.RenderedRoute, 0, len(routes))
+	for _, r := range routes {
+		out = append(out, renderRoute(r))
+	}
+	return out, nil
+}
+
+// renderRoute only copies the explicitly listed route fields,
+// so that no secret material may end up in the rendered route
+func renderRoute(r *pb.Route) v1alpha1.RenderedRoute {
+	dst := v1alpha1.RenderedRoute{
+		Name:                             r.GetName(),
+		From:                             redactURL(r.GetFrom()),
+		Prefix:                           r.GetPrefix(),
+		Path:                             r.GetPath(),
+		Regex:                            r.GetRegex(),
+		PrefixRewrite:                    r.GetPrefixRewrite(),
+		AllowPublicUnauthenticatedAccess: r.GetAllowPublicUnauthenticatedAccess(),
+		AllowAnyAuthenticatedUser:        r.GetAllowAnyAuthenticatedUser(),
+		AllowWebsockets:                  r.GetAllowWebsockets(),
+		PreserveHostHeader:               r.GetPreserveHostHeader(),
+		PassIdentityHeaders:              r.GetPassIdentityHeaders(),
+		TLSSkipVerify:                    r.GetTlsSkipVerify(),
+		TLSServerName:                    r.GetTlsServerName(),
+		Policies:                         len(r.GetPolicies()),
+	}
+	for _, to := range r.GetTo() {
+		dst.To = append(dst.To, redactURL(to))
+	}
+	if r.Timeout != nil {
+		dst.Timeout = r.Timeout.AsDuration().String()
+	}
+	for name := range r.GetSetRequestHeaders() {
+		dst.SetRequestHeaders = append(dst.SetRequestHeaders, name)
+	}
+	sort.Strings(dst.SetRequestHeaders)
+	return dst
+}
+
+// redactURL masks the password, should the URL have one, keeping the load balancing weight suffix
+func redactURL(txt string) string {
+	weight := ""
+	if i := strings.LastIndexByte(txt, ','); i >= 0 {
+		txt, weight = txt[:i], txt[i:]
+	}
+	u, err := url.Parse(txt)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted() + weight
+}
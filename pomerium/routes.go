@@ -2,57 +2,110 @@ package pomerium
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/translate"
 	pb "github.com/pomerium/pomerium/pkg/grpc/config"
 )
 
+// warningUntrustedSourceAddress is reported if allowed source ranges may not be reliably enforced
+const warningUntrustedSourceAddress = "UntrustedSourceAddress"
+
+// upsert updates config with the ingress routes and certs.
+// if some of the ingress routes were invalid, the valid ones are still applied and model.RouteErrors is returned
 func upsert(ctx context.Context, cfg *pb.Config, ic *model.IngressConfig) error {
-	if err := upsertRoutes(ctx, cfg, ic); err != nil {
-		return fmt.Errorf("upsert routes: %w", err)
+	res, err := translateIngress(ctx, ic)
+	if res == nil {
+		return err
+	}
+	if err := applyResult(ctx, cfg, ic, res); err != nil {
+		return err
 	}
+	return err
+}
+
+// translateIngress converts the ingress into the routes and certs.
+// if some of the ingress routes were invalid, the valid ones are returned along with model.RouteErrors
+func translateIngress(ctx context.Context, ic *model.IngressConfig) (*translate.Result, error) {
+	var routeErrs model.RouteErrors
+	res, err := translate.Ingress(ctx, ic)
+	if err != nil && !errors.As(err, &routeErrs) {
+		return nil, fmt.Errorf("translating ingress: %w", model.NewPermanentError(err))
+	}
+	ic.RouteCount = len(res.Routes)
+	if len(routeErrs) > 0 {
+		return res, routeErrs
+	}
+	return res, nil
+}
 
-	if err := upsertCerts(ctx, cfg, ic); err != nil {
-		return fmt.Errorf("updating certs: %w", err)
+// applyResult updates config with the translated ingress routes and certs
+func applyResult(ctx context.Context, cfg *pb.Config, ic *model.IngressConfig, res *translate.Result) error {
+	if err := mergeRoutes(cfg, res.Routes, ic.GetIngressNamespacedName()); err != nil {
+		return fmt.Errorf("upsert routes: %w", err)
 	}
+	addCerts(cfg, res.Certificates)
 
+	warnUntrustedSourceAddress(ctx, cfg, ic)
 	return nil
 }
 
+// warnUntrustedSourceAddress warns if source address restrictions are used for the ingress,
+// but pomerium settings suggest the client address would not be reliably known
+func warnUntrustedSourceAddress(ctx context.Context, cfg *pb.Config, ic *model.IngressConfig) {
+	if _, ok := ic.Ingress.Annotations[fmt.Sprintf("%s/%s", ic.AnnotationPrefix, translate.AllowedSourceRanges)]; !ok {
+		return
+	}
+	if cfg.GetSettings().GetSkipXffAppend() {
+		log.FromContext(ctx).Info("WARNING: skip_xff_append is set in pomerium settings, client address may not be trusted",
+			"ingress", ic.GetIngressNamespacedName().String(), "annotation", translate.AllowedSourceRanges)
+		ic.Warn(warningUntrustedSourceAddress,
+			fmt.Sprintf("%s: skip_xff_append is set in pomerium settings, client address may not be trusted", translate.AllowedSourceRanges))
+	}
+	// the ranges are checked against the peer address envoy appends to X-Forwarded-For,
+	// that is the address of the nearest trusted proxy rather than of the client
+	if cfg.GetSettings().GetXffNumTrustedHops() > 0 {
+		log.FromContext(ctx).Info("WARNING: xff_num_trusted_hops is set in pomerium settings, the proxy address is checked instead of the client one",
+			"ingress", ic.GetIngressNamespacedName().String(), "annotation", translate.AllowedSourceRanges)
+		ic.Warn(warningUntrustedSourceAddress,
+			fmt.Sprintf("%s: xff_num_trusted_hops is set in pomerium settings, the proxy address is checked instead of the client one", translate.AllowedSourceRanges))
+	}
+}
+
 func mergeRoutes(dst *pb.Config, src routeList, name types.NamespacedName) error {
 	srcMap, err := src.toMap()
 	if err != nil {
 		return fmt.Errorf("indexing new routes: %w", err)
 	}
-	dstMap, err := routeList(dst.Routes).toMap()
+	owned, other := splitOwned(dst.Routes)
+	dstMap, err := owned.toMap()
 	if err != nil {
 		return fmt.Errorf("indexing current config routes: %w", err)
 	}
 	// remove any existing routes of the ingress we are merging
 	dstMap.removeName(name)
 	dstMap.merge(srcMap)
-	dst.Routes = dstMap.toList()
+	routes := append(dstMap.toList(), other...)
+	routes.Sort()
+	dst.Routes = routes
 
 	return nil
 }
 
-func upsertRoutes(ctx context.Context, cfg *pb.Config, ic *model.IngressConfig) error {
-	ingRoutes, err := ingressToRoutes(ctx, ic)
-	if err != nil {
-		return fmt.Errorf("parsing ingress: %w", err)
-	}
-	return mergeRoutes(cfg, ingRoutes, types.NamespacedName{Name: ic.Ingress.Name, Namespace: ic.Ingress.Namespace})
-}
-
 func deleteRoutes(ctx context.Context, cfg *pb.Config, namespacedName types.NamespacedName) error {
-	rm, err := routeList(cfg.Routes).toMap()
+	owned, other := splitOwned(cfg.Routes)
+	rm, err := owned.toMap()
 	if err != nil {
 		return err
 	}
 	rm.removeName(namespacedName)
-	cfg.Routes = rm.toList()
+	routes := append(rm.toList(), other...)
+	routes.Sort()
+	cfg.Routes = routes
 	return nil
 }
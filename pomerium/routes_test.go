@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"regexp"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
@@ -19,12 +23,13 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/translate"
 	pb "github.com/pomerium/pomerium/pkg/grpc/config"
 )
 
 func TestHttp01Solver(t *testing.T) {
 	ptype := networkingv1.PathTypeExact
-	routes, err := ingressToRoutes(context.Background(), &model.IngressConfig{
+	routes, err := translate.Routes(context.Background(), &model.IngressConfig{
 		Ingress: &networkingv1.Ingress{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "cm-acme-http-solver-9m9mw",
@@ -142,7 +147,7 @@ func TestUpsertIngress(t *testing.T) {
 	}
 
 	cfg := new(pb.Config)
-	require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+	require.NoError(t, upsert(context.Background(), cfg, ic))
 	routes, err := routeList(cfg.Routes).toMap()
 	require.NoError(t, err)
 	require.NotNil(t, routes[routeID{
@@ -164,14 +169,14 @@ func TestUpsertIngress(t *testing.T) {
 			},
 		},
 	})
-	require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+	require.NoError(t, upsert(context.Background(), cfg, ic))
 	routes, err = routeList(cfg.Routes).toMap()
 	require.NoError(t, err)
 	require.NotNil(t, routes[routeID{Name: "ingress", Namespace: "default", Path: "/a", Host: "service.localhost.pomerium.io"}])
 	require.NotNil(t, routes[routeID{Name: "ingress", Namespace: "default", Path: "/b", Host: "service.localhost.pomerium.io"}])
 
 	ic.Ingress.Spec.Rules[0].HTTP.Paths[0].Path = "/c"
-	require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+	require.NoError(t, upsert(context.Background(), cfg, ic))
 	routes, err = routeList(cfg.Routes).toMap()
 	require.NoError(t, err)
 	require.Nil(t, routes[routeID{Name: "ingress", Namespace: "default", Path: "/a", Host: "service.localhost.pomerium.io"}])
@@ -248,7 +253,7 @@ func TestSecureUpstream(t *testing.T) {
 	}
 
 	cfg := new(pb.Config)
-	require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+	require.NoError(t, upsert(context.Background(), cfg, ic))
 	routes, err := routeList(cfg.Routes).toMap()
 	require.NoError(t, err)
 	route := routes[routeID{
@@ -326,19 +331,26 @@ func TestTCPUpstream(t *testing.T) {
 		paths       []networkingv1.HTTPIngressPath
 		expectError bool
 	}{
-		{"invalid path/type", map[string]string{
+		{"root path", map[string]string{
 			fmt.Sprintf("p/%s", model.TCPUpstream): "true",
 		}, []networkingv1.HTTPIngressPath{{
 			Path:     "/",
 			PathType: &typePrefix,
 			Backend:  backend,
-		}}, true},
-		{"invalid path/type", map[string]string{
+		}}, false},
+		{"no path", map[string]string{
 			fmt.Sprintf("p/%s", model.TCPUpstream): "true",
 		}, []networkingv1.HTTPIngressPath{{
 			PathType: &typeImpSpec,
 			Backend:  backend,
 		}}, false},
+		{"invalid path", map[string]string{
+			fmt.Sprintf("p/%s", model.TCPUpstream): "true",
+		}, []networkingv1.HTTPIngressPath{{
+			Path:     "/db",
+			PathType: &typePrefix,
+			Backend:  backend,
+		}}, true},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			ic := base
@@ -346,7 +358,7 @@ func TestTCPUpstream(t *testing.T) {
 			ic.Spec.Rules[0].HTTP.Paths = tc.paths
 
 			cfg := new(pb.Config)
-			err := upsertRoutes(context.Background(), cfg, &ic)
+			err := upsert(context.Background(), cfg, &ic)
 			if tc.expectError {
 				require.Error(t, err)
 				return
@@ -358,10 +370,10 @@ func TestTCPUpstream(t *testing.T) {
 			route := routes[routeID{
 				Name:      "ingress",
 				Namespace: "default",
-				Path:      tc.paths[0].Path,
 				Host:      "service.localhost.pomerium.io",
 			}]
-			require.NotNil(t, route, "route not found in %v", routes)
+			require.NotNil(t, route, "route without the path not found in %v", routes)
+			assert.Empty(t, route.Prefix)
 			assert.Equal(t, []string{
 				"tcp://1.2.3.4:12345",
 			}, route.To)
@@ -429,7 +441,7 @@ func TestExternalService(t *testing.T) {
 		}
 
 		cfg := new(pb.Config)
-		if err := upsertRoutes(context.Background(), cfg, ic); err != nil {
+		if err := upsert(context.Background(), cfg, ic); err != nil {
 			return nil, fmt.Errorf("upsert routes: %w", err)
 		}
 		routes, err := routeList(cfg.Routes).toMap()
@@ -512,7 +524,7 @@ func TestDefaultBackendService(t *testing.T) {
 		ic := icTemplate()
 		cfg := new(pb.Config)
 		t.Log(protojson.Format(cfg))
-		require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+		require.NoError(t, upsert(context.Background(), cfg, ic))
 		require.Len(t, cfg.Routes, 1)
 		assert.Equal(t, "/", cfg.Routes[0].Prefix)
 	})
@@ -535,7 +547,7 @@ func TestDefaultBackendService(t *testing.T) {
 				},
 			}}}
 		cfg := new(pb.Config)
-		require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+		require.NoError(t, upsert(context.Background(), cfg, ic))
 		sort.Sort(routeList(cfg.Routes))
 		require.Len(t, cfg.Routes, 3)
 		assert.Equal(t, "/", cfg.Routes[2].Prefix, protojson.Format(cfg))
@@ -600,7 +612,7 @@ func TestRegexPath(t *testing.T) {
 	}
 
 	cfg := new(pb.Config)
-	require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+	require.NoError(t, upsert(context.Background(), cfg, ic))
 	routes, err := routeList(cfg.Routes).toMap()
 	require.NoError(t, err)
 	route := routes[routeID{
@@ -615,6 +627,198 @@ func TestRegexPath(t *testing.T) {
 	assert.Empty(t, route.Path, "path")
 }
 
+func TestCaseInsensitivePaths(t *testing.T) {
+	pathTypePrefix := networkingv1.PathTypePrefix
+	pathTypeExact := networkingv1.PathTypeExact
+	ic := &model.IngressConfig{
+		AnnotationPrefix: "p",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "ingress",
+				Namespace: "default",
+				Annotations: map[string]string{
+					fmt.Sprintf("p/%s", model.CaseInsensitivePaths): "true",
+				},
+			},
+			Spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{
+					Host: "service.localhost.pomerium.io",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{{
+								Path:     "/api//v1.0",
+								PathType: &pathTypePrefix,
+								Backend: networkingv1.IngressBackend{
+									Service: &networkingv1.IngressServiceBackend{
+										Name: "service",
+										Port: networkingv1.ServiceBackendPort{Name: "http"},
+									},
+								},
+							}, {
+								Path:     "/Exact",
+								PathType: &pathTypeExact,
+								Backend: networkingv1.IngressBackend{
+									Service: &networkingv1.IngressServiceBackend{
+										Name: "service",
+										Port: networkingv1.ServiceBackendPort{Name: "http"},
+									},
+								},
+							}},
+						},
+					},
+				}},
+			},
+		},
+		Services: map[types.NamespacedName]*corev1.Service{
+			{Name: "service", Namespace: "default"}: {
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "service",
+					Namespace: "default",
+				},
+				Spec: corev1.ServiceSpec{
+					Ports: []corev1.ServicePort{{
+						Name:       "http",
+						Protocol:   "TCP",
+						Port:       80,
+						TargetPort: intstr.IntOrString{IntVal: 80},
+					}},
+				},
+			},
+		},
+	}
+
+	cfg := new(pb.Config)
+	require.NoError(t, upsert(context.Background(), cfg, ic))
+	routes, err := routeList(cfg.Routes).toMap()
+	require.NoError(t, err)
+
+	route := routes[routeID{Name: "ingress", Namespace: "default", Path: "/api/v1.0", Host: "service.localhost.pomerium.io"}]
+	require.NotNil(t, route, "duplicate slashes should be normalized: %v", routes)
+	assert.Equal(t, []model.Warning{{
+		Reason:  "DuplicateSlashes",
+		Message: "host service.localhost.pomerium.io path /api//v1.0 contains duplicate slashes, normalized to /api/v1.0",
+	}}, ic.Warnings.List(), "the ingress owner should be warned about the normalized path")
+	assert.Equal(t, `(?i)/api/v1\.0(?:/.*)?`, route.Regex)
+	assert.Empty(t, route.Prefix)
+	re := regexp.MustCompile("^" + route.Regex + "$")
+	assert.True(t, re.MatchString("/API/V1.0/users"))
+	assert.False(t, re.MatchString("/api/v1x0"))
+
+	route = routes[routeID{Name: "ingress", Namespace: "default", Path: "/Exact", Host: "service.localhost.pomerium.io"}]
+	require.NotNil(t, route)
+	assert.Equal(t, `(?i)/Exact`, route.Regex)
+	assert.Empty(t, route.Path)
+
+	ic.Ingress.Annotations[fmt.Sprintf("p/%s", model.PathRegex)] = "true"
+	assert.Error(t, upsert(context.Background(), new(pb.Config), ic), "regex and case insensitive paths are ambiguous")
+}
+
+func TestServiceAnnotations(t *testing.T) {
+	pathTypePrefix := networkingv1.PathTypePrefix
+	mkConfig := func(ingressAnnotations, serviceAnnotations map[string]string) *model.IngressConfig {
+		return &model.IngressConfig{
+			AnnotationPrefix:        "p",
+			ServiceAnnotationPrefix: "s",
+			Ingress: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "ingress",
+					Namespace:   "default",
+					Annotations: ingressAnnotations,
+				},
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{
+						Host: "service.localhost.pomerium.io",
+						IngressRuleValue: networkingv1.IngressRuleValue{
+							HTTP: &networkingv1.HTTPIngressRuleValue{
+								Paths: []networkingv1.HTTPIngressPath{{
+									Path:     "/",
+									PathType: &pathTypePrefix,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "service",
+											Port: networkingv1.ServiceBackendPort{Name: "http"},
+										},
+									},
+								}},
+							},
+						},
+					}},
+				},
+			},
+			Services: map[types.NamespacedName]*corev1.Service{
+				{Name: "service", Namespace: "default"}: {
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "service",
+						Namespace:   "default",
+						Annotations: serviceAnnotations,
+					},
+					Spec: corev1.ServiceSpec{
+						Ports: []corev1.ServicePort{{
+							Name:       "http",
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.IntOrString{IntVal: 80},
+						}},
+					},
+				},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		name               string
+		ingressAnnotations map[string]string
+		serviceAnnotations map[string]string
+		expectTo           string
+		expectTimeout      string
+		expectError        bool
+	}{
+		{
+			name:               "ingress only",
+			ingressAnnotations: map[string]string{"p/secure_upstream": "true", "p/timeout": "10s"},
+			expectTo:           "https://service.default.svc.cluster.local:80",
+			expectTimeout:      "10s",
+		},
+		{
+			name:               "service only",
+			serviceAnnotations: map[string]string{"s/secure_upstream": "true", "s/timeout": "20s", "p/timeout": "30s"},
+			expectTo:           "https://service.default.svc.cluster.local:80",
+			expectTimeout:      "20s",
+		},
+		{
+			name:               "ingress wins",
+			ingressAnnotations: map[string]string{"p/timeout": "10s", "p/secure_upstream": "false"},
+			serviceAnnotations: map[string]string{"s/timeout": "20s", "s/secure_upstream": "true"},
+			expectTo:           "http://service.default.svc.cluster.local:80",
+			expectTimeout:      "10s",
+		},
+		{
+			name:               "secrets are not supported on services",
+			serviceAnnotations: map[string]string{"s/tls_client_secret": "secret"},
+			expectError:        true,
+		},
+		{
+			name:               "unknown service annotation",
+			serviceAnnotations: map[string]string{"s/unknown": "value"},
+			expectError:        true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ic := mkConfig(tc.ingressAnnotations, tc.serviceAnnotations)
+			routes, err := translate.Routes(context.Background(), ic)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, routes, 1)
+			assert.Equal(t, []string{tc.expectTo}, routes[0].To)
+			assert.Equal(t, tc.expectTimeout, routes[0].Timeout.AsDuration().String())
+			assert.Equal(t, tc.ingressAnnotations, ic.Ingress.Annotations, "ingress annotations should not be modified")
+		})
+	}
+}
+
 func TestUseServiceProxy(t *testing.T) {
 	pathTypePrefix := networkingv1.PathTypePrefix
 	ic := &model.IngressConfig{
@@ -661,7 +865,7 @@ func TestUseServiceProxy(t *testing.T) {
 				},
 				Subsets: []corev1.EndpointSubset{{
 					Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
-					Ports:     []corev1.EndpointPort{{Port: 80}},
+					Ports:     []corev1.EndpointPort{{Name: "http", Port: 80}},
 				}},
 			}},
 		Services: map[types.NamespacedName]*corev1.Service{
@@ -684,7 +888,7 @@ func TestUseServiceProxy(t *testing.T) {
 	}
 
 	cfg := new(pb.Config)
-	require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+	require.NoError(t, upsert(context.Background(), cfg, ic))
 	routes, err := routeList(cfg.Routes).toMap()
 	require.NoError(t, err)
 	route := routes[routeID{
@@ -697,6 +901,26 @@ func TestUseServiceProxy(t *testing.T) {
 	require.Equal(t, []string{
 		"http://service.default.svc.cluster.local:80",
 	}, route.To)
+
+	// the controller default applies to the ingress without the annotation, that may also opt out of it
+	for _, tc := range []struct {
+		annotation string
+		expect     string
+	}{
+		{"", "http://service.default.svc.cluster.local:80"},
+		{"false", "http://1.2.3.4:80"},
+	} {
+		ic.ServiceProxyUpstreams = true
+		ic.Ingress.Annotations = nil
+		if tc.annotation != "" {
+			ic.Ingress.Annotations = map[string]string{fmt.Sprintf("p/%s", model.UseServiceProxy): tc.annotation}
+		}
+		cfg := new(pb.Config)
+		require.NoError(t, upsert(context.Background(), cfg, ic))
+		if assert.Len(t, cfg.Routes, 1) {
+			assert.Equal(t, []string{tc.expect}, cfg.Routes[0].To, "annotation=%q", tc.annotation)
+		}
+	}
 }
 
 func TestSortRoutes(t *testing.T) {
@@ -766,6 +990,44 @@ func TestServicePortsAndEndpoints(t *testing.T) {
 		expectTO        []string
 		expectError     bool
 	}{
+		{
+			"ipv6 endpoints",
+			networkingv1.ServiceBackendPort{Number: 8080},
+			[]corev1.ServicePort{{
+				Port:       8080,
+				TargetPort: intstr.IntOrString{IntVal: 80},
+			}},
+			[]corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "fd00::1"}, {IP: "fd00::2"}},
+				Ports:     []corev1.EndpointPort{{Port: 80}},
+			}},
+			[]string{
+				"http://[fd00::1]:80",
+				"http://[fd00::2]:80",
+			},
+			false,
+		},
+		{
+			"dual stack endpoints",
+			networkingv1.ServiceBackendPort{Name: "http"},
+			[]corev1.ServicePort{{
+				Name:       "http",
+				Port:       8080,
+				TargetPort: intstr.IntOrString{IntVal: 80},
+			}},
+			[]corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: 80}},
+			}, {
+				Addresses: []corev1.EndpointAddress{{IP: "fd00::1"}},
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: 80}},
+			}},
+			[]string{
+				"http://1.2.3.4:80",
+				"http://[fd00::1]:80",
+			},
+			false,
+		},
 		{
 			"unnamed port",
 			networkingv1.ServiceBackendPort{Number: 8080},
@@ -842,6 +1104,90 @@ func TestServicePortsAndEndpoints(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"named target port",
+			networkingv1.ServiceBackendPort{Name: "http"},
+			[]corev1.ServicePort{{
+				Name:       "http",
+				Port:       8000,
+				TargetPort: intstr.FromString("web"),
+			}},
+			[]corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+			}},
+			[]string{
+				"http://1.2.3.4:8080",
+			},
+			false,
+		},
+		{
+			"named target port referred by number",
+			networkingv1.ServiceBackendPort{Number: 8000},
+			[]corev1.ServicePort{{
+				Name:       "http",
+				Port:       8000,
+				TargetPort: intstr.FromString("web"),
+			}, {
+				Name:       "metrics",
+				Port:       9090,
+				TargetPort: intstr.FromString("metrics"),
+			}},
+			[]corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
+				Ports: []corev1.EndpointPort{
+					{Name: "metrics", Port: 8090},
+					{Name: "http", Port: 8080},
+				},
+			}},
+			[]string{
+				"http://1.2.3.4:8080",
+			},
+			false,
+		},
+		{
+			// the pods may expose the named container port on different numbers
+			"named target port with mixed subsets",
+			networkingv1.ServiceBackendPort{Name: "http"},
+			[]corev1.ServicePort{{
+				Name:       "http",
+				Port:       8000,
+				TargetPort: intstr.FromString("web"),
+			}},
+			[]corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}, {IP: "1.2.3.5"}},
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+			}, {
+				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.6"}},
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: 9090}},
+			}},
+			[]string{
+				"http://1.2.3.4:8080",
+				"http://1.2.3.5:8080",
+				"http://1.2.3.6:9090",
+			},
+			false,
+		},
+		{
+			"numeric target port with mixed subsets",
+			networkingv1.ServiceBackendPort{Name: "http"},
+			[]corev1.ServicePort{{
+				Name:       "http",
+				Port:       8000,
+				TargetPort: intstr.FromInt(8080),
+			}},
+			[]corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+			}, {
+				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.6"}},
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: 9090}},
+			}},
+			[]string{
+				"http://1.2.3.4:8080",
+			},
+			false,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			pathTypePrefix := networkingv1.PathTypePrefix
@@ -899,7 +1245,7 @@ func TestServicePortsAndEndpoints(t *testing.T) {
 			}
 
 			cfg := new(pb.Config)
-			require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+			require.NoError(t, upsert(context.Background(), cfg, ic))
 			routes, err := routeList(cfg.Routes).toMap()
 			require.NoError(t, err)
 			route := routes[routeID{
@@ -1049,7 +1395,7 @@ func TestEndpointsHTTPS(t *testing.T) {
 			}
 
 			cfg := new(pb.Config)
-			require.NoError(t, upsertRoutes(context.Background(), cfg, ic))
+			require.NoError(t, upsert(context.Background(), cfg, ic))
 			routes, err := routeList(cfg.Routes).toMap()
 			require.NoError(t, err)
 			route := routes[routeID{
@@ -1064,3 +1410,519 @@ func TestEndpointsHTTPS(t *testing.T) {
 		})
 	}
 }
+
+// manyPathsIngress generates an ingress with n paths sharing the same backend service
+func manyPathsIngress(n int, annotations map[string]string) *model.IngressConfig {
+	typePrefix := networkingv1.PathTypePrefix
+	paths := make([]networkingv1.HTTPIngressPath, 0, n)
+	for i := 0; i < n; i++ {
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:     fmt.Sprintf("/api/v1/resource-%d", i),
+			PathType: &typePrefix,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: "service",
+					Port: networkingv1.ServiceBackendPort{Name: "http"},
+				},
+			},
+		})
+	}
+	return &model.IngressConfig{
+		AnnotationPrefix: "a",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", Annotations: annotations},
+			Spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{
+					Host: "service.localhost.pomerium.io",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+					},
+				}},
+			},
+		},
+		Secrets: map[types.NamespacedName]*corev1.Secret{
+			{Name: "ca", Namespace: "default"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "default"},
+				Data:       map[string][]byte{translate.CAKey: make([]byte, 4096)},
+			},
+		},
+		Services: map[types.NamespacedName]*corev1.Service{
+			{Name: "service", Namespace: "default"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					Ports: []corev1.ServicePort{{
+						Name:       "http",
+						Port:       80,
+						TargetPort: intstr.FromInt(8080),
+					}},
+				},
+			},
+		},
+		Endpoints: map[types.NamespacedName]*corev1.Endpoints{
+			{Name: "service", Namespace: "default"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+				Subsets: []corev1.EndpointSubset{{
+					Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}, {IP: "10.0.0.3"}},
+					Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+				}},
+			},
+		},
+	}
+}
+
+var manyPathsAnnotations = map[string]string{
+	"a/allowed_domains":      `["pomerium.com"]`,
+	"a/set_request_headers":  `{"X-Team": "api"}`,
+	"a/timeout":              "30s",
+	"a/tls_custom_ca_secret": "ca",
+	"a/secure_upstream":      "true",
+}
+
+func BenchmarkManyPaths(b *testing.B) {
+	for _, combine := range []bool{false, true} {
+		annotations := make(map[string]string, len(manyPathsAnnotations)+1)
+		for k, v := range manyPathsAnnotations {
+			annotations[k] = v
+		}
+		annotations["a/combine_paths"] = fmt.Sprint(combine)
+		ic := manyPathsIngress(500, annotations)
+
+		b.Run(fmt.Sprintf("combine_paths=%v", combine), func(b *testing.B) {
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := translate.Routes(ctx, ic); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestManyPathsBudget ensures translation of a single ingress with many paths stays within the allocations budget,
+// the time budget is checked by BenchmarkManyPathsBudget, as it depends on the machine speed
+func TestManyPathsBudget(t *testing.T) {
+	const (
+		paths         = 500
+		allocsPerPath = 50
+	)
+
+	ic := manyPathsIngress(paths, manyPathsAnnotations)
+	ctx := context.Background()
+	allocs := testing.AllocsPerRun(5, func() {
+		routes, err := translate.Routes(ctx, ic)
+		require.NoError(t, err)
+		require.Len(t, routes, paths)
+	})
+	assert.LessOrEqual(t, allocs, float64(paths*allocsPerPath), "allocations")
+}
+
+// BenchmarkManyPathsBudget fails if translation of a single ingress with many paths exceeds the time budget
+func BenchmarkManyPathsBudget(b *testing.B) {
+	const maxTranslation = 250 * time.Millisecond
+
+	ic := manyPathsIngress(500, manyPathsAnnotations)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := translate.Routes(ctx, ic); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if perOp := time.Since(start) / time.Duration(b.N); perOp > maxTranslation {
+		b.Errorf("translation time %s exceeds %s", perOp, maxTranslation)
+	}
+}
+
+func TestCombinePaths(t *testing.T) {
+	typeExact := networkingv1.PathTypeExact
+	ic := manyPathsIngress(3, map[string]string{
+		"a/combine_paths": "true",
+	})
+	paths := ic.Spec.Rules[0].HTTP.Paths
+	paths[2].PathType = &typeExact
+	ctx := context.Background()
+
+	routes, err := translate.Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Equal(t, `(?:/api/v1/resource-0(?:/.*)?|/api/v1/resource-1(?:/.*)?|/api/v1/resource-2)`, routes[0].Regex)
+	assert.Empty(t, routes[0].Prefix)
+	assert.Empty(t, routes[0].Path)
+	assert.Equal(t, "https://service.localhost.pomerium.io", routes[0].From)
+	assert.Equal(t, "default-ingress-service-localhost-pomerium-io", routes[0].Name)
+	assert.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8080"}, routes[0].To)
+	re := regexp.MustCompile("^" + routes[0].Regex + "$")
+	for path, match := range map[string]bool{
+		"/api/v1/resource-0":     true,
+		"/api/v1/resource-1/sub": true,
+		"/api/v1/resource-10":    false,
+		"/api/v1/resource-2":     true,
+		"/api/v1/resource-2/sub": false,
+		"/API/v1/resource-0":     false,
+	} {
+		assert.Equal(t, match, re.MatchString(path), path)
+	}
+
+	ic.Ingress.Annotations["a/case_insensitive_paths"] = "true"
+	routes, err = translate.Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.True(t, regexp.MustCompile("^"+routes[0].Regex+"$").MatchString("/API/v1/resource-0"))
+	delete(ic.Ingress.Annotations, "a/case_insensitive_paths")
+
+	ic.Ingress.Annotations["a/prefix_rewrite"] = "/v2"
+	_, err = translate.Routes(ctx, ic)
+	assert.ErrorContains(t, err, "prefix_rewrite", "the prefix to rewrite depends on the matched path")
+	delete(ic.Ingress.Annotations, "a/prefix_rewrite")
+
+	ic.Ingress.Annotations["a/regex_rewrite_pattern"] = "^/api/v1"
+	ic.Ingress.Annotations["a/regex_rewrite_substitution"] = "/v2"
+	routes, err = translate.Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Equal(t, "^/api/v1", routes[0].RegexRewritePattern)
+	delete(ic.Ingress.Annotations, "a/regex_rewrite_pattern")
+	delete(ic.Ingress.Annotations, "a/regex_rewrite_substitution")
+
+	paths[1].Backend.Service = &networkingv1.IngressServiceBackend{
+		Name: "service",
+		Port: networkingv1.ServiceBackendPort{Number: 80},
+	}
+	_, err = translate.Routes(ctx, ic)
+	assert.Error(t, err, "paths with different backends should not be combined")
+}
+
+func TestListenerPort(t *testing.T) {
+	ctx := context.Background()
+	ic := manyPathsIngress(2, map[string]string{
+		"a/listener_port": "8443",
+	})
+
+	_, err := translate.Routes(ctx, ic)
+	assert.Error(t, err, "port is not allowed")
+	assert.True(t, model.IsPermanentError(err), err)
+
+	ic.AllowedListenerPorts = []int32{8443, 9443}
+	routes, err := translate.Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	for _, r := range routes {
+		assert.Equal(t, "https://service.localhost.pomerium.io:8443", r.From)
+	}
+
+	ic.Ingress.Annotations["a/tcp_upstream"] = "true"
+	_, err = translate.Routes(ctx, ic)
+	assert.Error(t, err)
+	delete(ic.Ingress.Annotations, "a/tcp_upstream")
+
+	ic.Ingress.Annotations["a/listener_port"] = "https"
+	_, err = translate.Routes(ctx, ic)
+	assert.Error(t, err)
+}
+
+func TestStatusFromService(t *testing.T) {
+	ctx := context.Background()
+	ic := manyPathsIngress(1, map[string]string{
+		"a/status_from_service": "pomerium/internal-proxy",
+	})
+
+	_, err := translate.Routes(ctx, ic)
+	assert.Error(t, err, "service is not allowed")
+	assert.True(t, model.IsPermanentError(err), err)
+
+	ic.AllowedStatusServices = []types.NamespacedName{{Namespace: "pomerium", Name: "internal-proxy"}}
+	_, err = translate.Routes(ctx, ic)
+	require.NoError(t, err)
+	name, err := ic.GetStatusFromService()
+	require.NoError(t, err)
+	assert.Equal(t, &ic.AllowedStatusServices[0], name)
+
+	ic.Ingress.Annotations["a/status_from_service"] = "internal-proxy"
+	_, err = translate.Routes(ctx, ic)
+	assert.Error(t, err)
+}
+
+func TestUntrustedSourceAddressWarning(t *testing.T) {
+	ic := &model.IngressConfig{
+		AnnotationPrefix: "a",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "ingress",
+				Namespace:   "default",
+				Annotations: map[string]string{"a/allowed_source_ranges": `["10.0.0.0/8"]`},
+			},
+		},
+	}
+	warnUntrustedSourceAddress(context.Background(), new(pb.Config), ic)
+	assert.Empty(t, ic.Warnings.List())
+
+	cfg := &pb.Config{Settings: &pb.Settings{SkipXffAppend: proto.Bool(true)}}
+	warnUntrustedSourceAddress(context.Background(), cfg, ic)
+	warnUntrustedSourceAddress(context.Background(), cfg, ic)
+	if warnings := ic.Warnings.List(); assert.Len(t, warnings, 1, "duplicate warnings should be ignored") {
+		assert.Equal(t, warningUntrustedSourceAddress, warnings[0].Reason)
+	}
+
+	ic.Warnings = nil
+	cfg = &pb.Config{Settings: &pb.Settings{XffNumTrustedHops: proto.Uint32(1)}}
+	warnUntrustedSourceAddress(context.Background(), cfg, ic)
+	if warnings := ic.Warnings.List(); assert.Len(t, warnings, 1) {
+		assert.Contains(t, warnings[0].Message, "xff_num_trusted_hops")
+	}
+}
+
+// TestPathTypeConformance checks the examples of https://kubernetes.io/docs/concepts/services-networking/ingress/#examples
+// against the routes as envoy would match them, that is the first route in order whose path matches the request
+func TestPathTypeConformance(t *testing.T) {
+	typePrefix, typeExact := networkingv1.PathTypePrefix, networkingv1.PathTypeExact
+	prefix := func(p string) networkingv1.HTTPIngressPath {
+		return networkingv1.HTTPIngressPath{Path: p, PathType: &typePrefix}
+	}
+	exact := func(p string) networkingv1.HTTPIngressPath {
+		return networkingv1.HTTPIngressPath{Path: p, PathType: &typeExact}
+	}
+
+	for _, tc := range []struct {
+		paths []networkingv1.HTTPIngressPath
+		// match maps the request path to the expected matching ingress path, or none
+		match map[string]string
+	}{
+		{[]networkingv1.HTTPIngressPath{prefix("/")}, map[string]string{"/": "Prefix /", "/foo": "Prefix /", "/foo/bar": "Prefix /"}},
+		{[]networkingv1.HTTPIngressPath{exact("/foo")}, map[string]string{"/foo": "Exact /foo", "/bar": "", "/foo/": ""}},
+		{[]networkingv1.HTTPIngressPath{exact("/foo/")}, map[string]string{"/foo": "", "/foo/": "Exact /foo/"}},
+		{[]networkingv1.HTTPIngressPath{prefix("/foo")}, map[string]string{"/foo": "Prefix /foo", "/foo/": "Prefix /foo"}},
+		{[]networkingv1.HTTPIngressPath{prefix("/foo/")}, map[string]string{"/foo": "Prefix /foo/", "/foo/": "Prefix /foo/"}},
+		{[]networkingv1.HTTPIngressPath{prefix("/aaa/bb")}, map[string]string{"/aaa/bbb": ""}},
+		{[]networkingv1.HTTPIngressPath{prefix("/aaa/bbb")}, map[string]string{
+			"/aaa/bbb":     "Prefix /aaa/bbb",
+			"/aaa/bbb/":    "Prefix /aaa/bbb",
+			"/aaa/bbb/ccc": "Prefix /aaa/bbb",
+			"/aaa/bbbxyz":  "",
+		}},
+		{[]networkingv1.HTTPIngressPath{prefix("/aaa/bbb/")}, map[string]string{"/aaa/bbb": "Prefix /aaa/bbb/"}},
+		{[]networkingv1.HTTPIngressPath{prefix("/"), prefix("/aaa")}, map[string]string{"/aaa/ccc": "Prefix /aaa"}},
+		{[]networkingv1.HTTPIngressPath{prefix("/"), prefix("/aaa"), prefix("/aaa/bbb")}, map[string]string{
+			"/aaa/bbb": "Prefix /aaa/bbb",
+			"/ccc":     "Prefix /",
+		}},
+		{[]networkingv1.HTTPIngressPath{prefix("/aaa")}, map[string]string{"/ccc": ""}},
+		{[]networkingv1.HTTPIngressPath{prefix("/foo"), exact("/foo")}, map[string]string{"/foo": "Exact /foo"}},
+		{[]networkingv1.HTTPIngressPath{prefix("/"), exact("/admin")}, map[string]string{
+			"/admin":         "Exact /admin",
+			"/admin/":        "Prefix /",
+			"/administrator": "Prefix /",
+		}},
+	} {
+		ic := manyPathsIngress(0, nil)
+		var desc []string
+		for _, p := range tc.paths {
+			p.Backend.Service = &networkingv1.IngressServiceBackend{Name: "service", Port: networkingv1.ServiceBackendPort{Name: "http"}}
+			ic.Spec.Rules[0].HTTP.Paths = append(ic.Spec.Rules[0].HTTP.Paths, p)
+			desc = append(desc, fmt.Sprintf("%s %s", *p.PathType, p.Path))
+		}
+		routes, err := translate.Routes(context.Background(), ic)
+		require.NoError(t, err, desc)
+		routeList(routes).Sort()
+
+		for path, want := range tc.match {
+			got := ""
+			for _, r := range routes {
+				if routeMatchesPath(t, r, path) {
+					var id routeID
+					require.NoError(t, id.Unmarshal(r.Id))
+					if r.Path != "" {
+						got = fmt.Sprintf("%s %s", typeExact, id.Path)
+					} else {
+						got = fmt.Sprintf("%s %s", typePrefix, id.Path)
+					}
+					break
+				}
+			}
+			assert.Equal(t, want, got, "paths %v, request %s", desc, path)
+		}
+	}
+}
+
+// TestPathTypes checks the requests matched by a single path of each path type
+func TestPathTypes(t *testing.T) {
+	typePrefix, typeExact, typeImpl := networkingv1.PathTypePrefix, networkingv1.PathTypeExact, networkingv1.PathTypeImplementationSpecific
+	requests := []string{"/", "/foo", "/foo/", "/foo/bar", "/foobar"}
+
+	for _, tc := range []struct {
+		pathType networkingv1.PathType
+		path     string
+		// match lists the requests that are expected to match the path
+		match []string
+	}{
+		{typeExact, "/", []string{"/"}},
+		{typeExact, "/foo", []string{"/foo"}},
+		{typeExact, "/foo/", []string{"/foo/"}},
+		{typePrefix, "/", requests},
+		{typePrefix, "/foo", []string{"/foo", "/foo/", "/foo/bar"}},
+		{typePrefix, "/foo/", []string{"/foo", "/foo/", "/foo/bar"}},
+		// implementation specific paths are matched as a string prefix
+		{typeImpl, "/", requests},
+		{typeImpl, "/foo", []string{"/foo", "/foo/", "/foo/bar", "/foobar"}},
+		{typeImpl, "/foo/", []string{"/foo/", "/foo/bar"}},
+	} {
+		t.Run(fmt.Sprintf("%s %s", tc.pathType, tc.path), func(t *testing.T) {
+			pathType := tc.pathType
+			ic := manyPathsIngress(1, nil)
+			ic.Spec.Rules[0].HTTP.Paths[0].Path = tc.path
+			ic.Spec.Rules[0].HTTP.Paths[0].PathType = &pathType
+			routes, err := translate.Routes(context.Background(), ic)
+			require.NoError(t, err)
+			require.Len(t, routes, 1)
+
+			match := make(map[string]bool)
+			for _, path := range tc.match {
+				match[path] = true
+			}
+			for _, path := range requests {
+				assert.Equal(t, match[path], routeMatchesPath(t, routes[0], path), "request %s", path)
+			}
+		})
+	}
+}
+
+// routeMatchesPath matches the request path the way envoy does, using the first of the regex, path or prefix that is set
+func routeMatchesPath(t *testing.T, r *pb.Route, path string) bool {
+	t.Helper()
+	switch {
+	case r.Regex != "":
+		return regexp.MustCompile("^(?:" + r.Regex + ")$").MatchString(path)
+	case r.Path != "":
+		return r.Path == path
+	default:
+		return strings.HasPrefix(path, r.Prefix)
+	}
+}
+
+// TestRouteOrder checks the overlapping paths of the ingresses sharing the host are published
+// with the more specific ones first, regardless of the order the ingresses are reconciled in
+func TestRouteOrder(t *testing.T) {
+	ctx := context.Background()
+	typeExact := networkingv1.PathTypeExact
+	ingress := func(namespace, name string, paths ...string) *model.IngressConfig {
+		ic := manyPathsIngress(len(paths), nil)
+		ic.Ingress.Name, ic.Ingress.Namespace = name, namespace
+		for i, p := range paths {
+			path := &ic.Ingress.Spec.Rules[0].HTTP.Paths[i]
+			if strings.HasPrefix(p, "=") {
+				p, path.PathType = strings.TrimPrefix(p, "="), &typeExact
+			}
+			path.Path = p
+		}
+		for key, obj := range ic.Services {
+			delete(ic.Services, key)
+			key.Namespace, obj.Namespace = namespace, namespace
+			ic.Services[key] = obj
+		}
+		return ic
+	}
+	ingresses := []*model.IngressConfig{
+		ingress("b", "api", "/", "/api"),
+		ingress("a", "web", "/api/v2", "/api"),
+		ingress("a", "api", "=/api", "/api/v2"),
+	}
+	expect := []string{
+		"a/api path=/api",
+		"a/api prefix=/api/v2",
+		"a/web prefix=/api/v2",
+		"a/web prefix=/api",
+		"b/api prefix=/api",
+		"b/api prefix=/",
+	}
+	order := func(cfg *pb.Config) []string {
+		var names []string
+		for _, route := range cfg.Routes {
+			var id routeID
+			require.NoError(t, id.Unmarshal(route.Id))
+			match := "prefix=" + route.Prefix
+			if route.Path != "" {
+				match = "path=" + route.Path
+			}
+			names = append(names, fmt.Sprintf("%s/%s %s", id.Namespace, id.Name, match))
+		}
+		return names
+	}
+
+	random := rand.New(rand.NewSource(0))
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+	for i := 0; i < 10; i++ {
+		random.Shuffle(len(ingresses), func(i, j int) { ingresses[i], ingresses[j] = ingresses[j], ingresses[i] })
+
+		_, err := r.Set(ctx, ingresses)
+		require.NoError(t, err)
+		assert.Equal(t, expect, order(db.config(t)), "set")
+
+		for _, ic := range ingresses {
+			_, err := r.Upsert(ctx, ic)
+			require.NoError(t, err)
+			assert.Equal(t, expect, order(db.config(t)), "upsert %s", ic.Ingress.Name)
+		}
+	}
+}
+
+// TestCaseInsensitiveRouteOrder checks the case-insensitive paths are ordered along with
+// the overlapping case-sensitive paths of another ingress sharing the host, rather than always first
+func TestCaseInsensitiveRouteOrder(t *testing.T) {
+	ctx := context.Background()
+	typeExact := networkingv1.PathTypeExact
+	ingress := func(name string, annotations map[string]string, paths ...string) *model.IngressConfig {
+		ic := manyPathsIngress(len(paths), annotations)
+		ic.Ingress.Name = name
+		for i, p := range paths {
+			path := &ic.Ingress.Spec.Rules[0].HTTP.Paths[i]
+			if strings.HasPrefix(p, "=") {
+				p, path.PathType = strings.TrimPrefix(p, "="), &typeExact
+			}
+			path.Path = p
+		}
+		return ic
+	}
+
+	var routes routeList
+	for _, ic := range []*model.IngressConfig{
+		ingress("sensitive", nil, "/", "/api/v2", "=/Login"),
+		ingress("insensitive", map[string]string{"a/case_insensitive_paths": "true"}, "/API", "/Api/v2/Users", "=/login"),
+	} {
+		r, err := translate.Routes(ctx, ic)
+		require.NoError(t, err)
+		routes = append(routes, r...)
+	}
+	random := rand.New(rand.NewSource(0))
+	for i := 0; i < 10; i++ {
+		shuffleRoutes(random, routes)
+		routes.Sort()
+
+		for path, want := range map[string]string{
+			"/api":              "insensitive /API",
+			"/api/v1":           "insensitive /API",
+			"/api/v2/x":         "sensitive /api/v2",
+			"/API/V2/X":         "insensitive /API",
+			"/api/v2/users/bob": "insensitive /Api/v2/Users",
+			"/Login":            "sensitive /Login",
+			"/LOGIN":            "insensitive /login",
+			"/login/x":          "sensitive /",
+			"/x":                "sensitive /",
+		} {
+			got := ""
+			for _, r := range routes {
+				if routeMatchesPath(t, r, path) {
+					var id routeID
+					require.NoError(t, id.Unmarshal(r.Id))
+					got = fmt.Sprintf("%s %s", id.Name, id.Path)
+					break
+				}
+			}
+			assert.Equal(t, want, got, "request %s", path)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package pomerium
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/protoutil"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	// idpClientIDKey and idpClientSecretKey are the keys of the identity provider secret
+	idpClientIDKey     = "client_id"
+	idpClientSecretKey = "client_secret"
+)
+
+// settingsRecordID returns the databroker config record id holding the global settings,
+// that is kept apart from the routes, so that Set does not discard them
+func (r *ConfigReconciler) settingsRecordID() string {
+	return r.recordID() + "-settings"
+}
+
+// SetSettings applies the global settings to the databroker, alongside the routes.
+// nil settings remove the previously applied ones.
+// a model.PermanentError is returned if the settings are invalid, in which case the previous ones are kept
+func (r *ConfigReconciler) SetSettings(ctx context.Context, gs *model.GlobalSettings) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if gs == nil {
+		return r.deleteSettings(ctx)
+	}
+
+	next := new(pb.Config)
+	var err error
+	if next.Settings, err = translateSettings(gs); err != nil {
+		return false, model.NewPermanentError(err)
+	}
+	if err := validate(ctx, next, "settings"); err != nil {
+		return false, fmt.Errorf("settings validation: %w", model.NewPermanentError(err))
+	}
+
+	prev, err := r.getConfigRecord(ctx, r.settingsRecordID())
+	if err != nil {
+		return false, fmt.Errorf("get settings: %w", err)
+	}
+	if proto.Equal(prev, next) {
+		log.FromContext(ctx).V(1).Info("no changes in the settings")
+		return false, nil
+	}
+	if err := r.putConfig(ctx, r.settingsRecordID(), next); err != nil {
+		return false, err
+	}
+	log.FromContext(ctx).Info("new pomerium settings applied")
+	return true, nil
+}
+
+func (r *ConfigReconciler) deleteSettings(ctx context.Context) (bool, error) {
+	prev, err := r.getConfigRecord(ctx, r.settingsRecordID())
+	if err != nil {
+		return false, fmt.Errorf("get settings: %w", err)
+	}
+	if proto.Equal(prev, new(pb.Config)) {
+		return false, nil
+	}
+
+	any := protoutil.NewAny(&pb.Config{})
+	_, err = r.Put(ctx, &databroker.PutRequest{
+		Record: &databroker.Record{
+			Type:      any.GetTypeUrl(),
+			Id:        r.settingsRecordID(),
+			Data:      any,
+			DeletedAt: timestamppb.Now(),
+		},
+	})
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// translateSettings converts the Pomerium object spec into the pomerium settings
+func translateSettings(gs *model.GlobalSettings) (*pb.Settings, error) {
+	spec := gs.Spec
+	settings := new(pb.Settings)
+
+	if spec.AuthenticateURL != "" {
+		settings.AuthenticateServiceUrl = proto.String(spec.AuthenticateURL)
+	}
+
+	if idp := spec.IdentityProvider; idp != nil {
+		secret := gs.IdentityProviderSecret
+		if secret == nil {
+			return nil, fmt.Errorf("identityProvider: secret %s is missing", idp.Secret)
+		}
+		id, ok := secret.Data[idpClientIDKey]
+		if !ok {
+			return nil, fmt.Errorf("identityProvider: secret %s has no %s key", idp.Secret, idpClientIDKey)
+		}
+		sec, ok := secret.Data[idpClientSecretKey]
+		if !ok {
+			return nil, fmt.Errorf("identityProvider: secret %s has no %s key", idp.Secret, idpClientSecretKey)
+		}
+		settings.IdpProvider = proto.String(idp.Provider)
+		if idp.URL != "" {
+			settings.IdpProviderUrl = proto.String(idp.URL)
+		}
+		settings.IdpClientId = proto.String(string(id))
+		settings.IdpClientSecret = proto.String(string(sec))
+		settings.Scopes = append(settings.Scopes, idp.Scopes...)
+	}
+
+	for _, secret := range gs.Certificates {
+		if secret.Type != corev1.SecretTypeTLS {
+			return nil, fmt.Errorf("certificates: secret %s/%s is of type %s, expected %s",
+				secret.Namespace, secret.Name, secret.Type, corev1.SecretTypeTLS)
+		}
+		settings.Certificates = append(settings.Certificates, &pb.Settings_Certificate{
+			CertBytes: secret.Data[corev1.TLSCertKey],
+			KeyBytes:  secret.Data[corev1.TLSPrivateKeyKey],
+		})
+	}
+	return settings, nil
+}
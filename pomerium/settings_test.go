@@ -0,0 +1,147 @@
+package pomerium
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/protoutil"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func (f *fakeDataBroker) settings(t *testing.T, id string) *pb.Settings {
+	t.Helper()
+
+	f.Lock()
+	defer f.Unlock()
+
+	r, ok := f.records[protoutil.NewAny(new(pb.Config)).GetTypeUrl()+"/"+id]
+	if !ok {
+		return nil
+	}
+	cfg := new(pb.Config)
+	require.NoError(t, r.GetData().UnmarshalTo(cfg))
+	return cfg.GetSettings()
+}
+
+func testGlobalSettings(t *testing.T, clientSecret string) *model.GlobalSettings {
+	t.Helper()
+
+	return &model.GlobalSettings{
+		Pomerium: &v1alpha1.Pomerium{
+			ObjectMeta: metav1.ObjectMeta{Name: "global"},
+			Spec: v1alpha1.PomeriumSpec{
+				AuthenticateURL: "https://authenticate.localhost.pomerium.io",
+				IdentityProvider: &v1alpha1.IdentityProvider{
+					Provider: "oidc",
+					URL:      "https://idp.localhost.pomerium.io",
+					Secret:   "pomerium/idp",
+					Scopes:   []string{"openid", "email"},
+				},
+				Certificates: []string{"default/authenticate"},
+			},
+		},
+		IdentityProviderSecret: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "idp", Namespace: "pomerium"},
+			Data: map[string][]byte{
+				idpClientIDKey:     []byte("client-id"),
+				idpClientSecretKey: []byte(clientSecret),
+			},
+		},
+		Certificates: []*corev1.Secret{
+			testCertSecret(t, "authenticate", time.Now().Add(time.Hour), "authenticate.localhost.pomerium.io"),
+		},
+	}
+}
+
+func TestSetSettings(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+
+	gs := testGlobalSettings(t, "secret-1")
+	changed, err := r.SetSettings(ctx, gs)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	settings := db.settings(t, "ingress-controller-settings")
+	require.NotNil(t, settings)
+	assert.Equal(t, "https://authenticate.localhost.pomerium.io", settings.GetAuthenticateServiceUrl())
+	assert.Equal(t, "oidc", settings.GetIdpProvider())
+	assert.Equal(t, "https://idp.localhost.pomerium.io", settings.GetIdpProviderUrl())
+	assert.Equal(t, "client-id", settings.GetIdpClientId())
+	assert.Equal(t, "secret-1", settings.GetIdpClientSecret())
+	assert.Equal(t, []string{"openid", "email"}, settings.GetScopes())
+	assert.Len(t, settings.GetCertificates(), 1, "the certificates not used by any route are kept")
+
+	changed, err = r.SetSettings(ctx, gs)
+	require.NoError(t, err)
+	assert.False(t, changed, "unchanged settings")
+
+	// the routes are kept in the record of their own, so that neither update discards the other
+	_, err = r.Set(ctx, []*model.IngressConfig{manyPathsIngress(2, nil)})
+	require.NoError(t, err)
+	gs.IdentityProviderSecret.Data[idpClientSecretKey] = []byte("secret-2")
+	changed, err = r.SetSettings(ctx, gs)
+	require.NoError(t, err)
+	assert.True(t, changed, "rotated client secret")
+	assert.Equal(t, "secret-2", db.settings(t, "ingress-controller-settings").GetIdpClientSecret())
+	assert.Empty(t, db.settings(t, "ingress-controller").GetIdpClientSecret())
+
+	cfg := new(pb.Config)
+	rec, err := db.Get(ctx, &databroker.GetRequest{Type: protoutil.NewAny(cfg).GetTypeUrl(), Id: "ingress-controller"})
+	require.NoError(t, err)
+	require.NoError(t, rec.GetRecord().GetData().UnmarshalTo(cfg))
+	assert.Len(t, cfg.Routes, 2)
+
+	changed, err = r.SetSettings(ctx, nil)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Nil(t, db.settings(t, "ingress-controller-settings"))
+
+	changed, err = r.SetSettings(ctx, nil)
+	require.NoError(t, err)
+	assert.False(t, changed, "already removed")
+}
+
+func TestSetSettingsInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	for name, mutate := range map[string]func(gs *model.GlobalSettings){
+		"missing idp secret": func(gs *model.GlobalSettings) {
+			gs.IdentityProviderSecret = nil
+		},
+		"missing client secret key": func(gs *model.GlobalSettings) {
+			delete(gs.IdentityProviderSecret.Data, idpClientSecretKey)
+		},
+		"certificate secret of wrong type": func(gs *model.GlobalSettings) {
+			gs.Certificates[0].Type = corev1.SecretTypeOpaque
+		},
+		"invalid authenticate url": func(gs *model.GlobalSettings) {
+			gs.Spec.AuthenticateURL = "authenticate"
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := newFakeDataBroker()
+			r := &ConfigReconciler{DataBrokerServiceClient: db, Cluster: "a"}
+			_, err := r.SetSettings(ctx, testGlobalSettings(t, "prev"))
+			require.NoError(t, err)
+
+			gs := testGlobalSettings(t, "next")
+			mutate(gs)
+			_, err = r.SetSettings(ctx, gs)
+			assert.True(t, model.IsPermanentError(err), "%v", err)
+			assert.Equal(t, "prev", db.settings(t, "ingress-controller-a-settings").GetIdpClientSecret(),
+				"the previously applied settings are kept")
+		})
+	}
+}
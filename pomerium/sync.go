@@ -3,10 +3,13 @@ package pomerium
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
+	"sync"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sergi/go-diff/diffmatchpatch"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -17,55 +20,157 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	pb "github.com/pomerium/pomerium/pkg/grpc/config"
 	"github.com/pomerium/pomerium/pkg/grpc/databroker"
 	"github.com/pomerium/pomerium/pkg/protoutil"
 
 	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/translate"
 )
 
 const (
 	configID = "ingress-controller"
+
+	deleteResultDeleted = "deleted"
+	deleteResultAbsent  = "absent"
 )
 
+var ingressDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "pomerium_ingress_deletions_total",
+	Help: "Number of ingress deletions from the pomerium config, by whether the ingress routes were deleted or already absent",
+}, []string{"result"})
+
+func init() {
+	// metrics are served by the controller manager
+	metrics.Registry.MustRegister(ingressDeletions)
+}
+
 // ConfigReconciler updates pomerium configuration
-// only one ConfigReconciler should be active
-// and its methods are not thread-safe
+// only one ConfigReconciler should be active.
+// its methods are safe for concurrent use, as they serialize the config record read-modify-write
 type ConfigReconciler struct {
+	// mu is held for the config record read-modify-write, so that the concurrent updates are not lost
+	mu sync.Mutex
+
 	databroker.DataBrokerServiceClient
 	DebugDumpConfigDiff bool
+	// StrictIngressValidation rejects the entire ingress if any of its routes is invalid,
+	// otherwise the valid routes are applied and the invalid ones are skipped
+	StrictIngressValidation bool
+	// Cluster if set, names the cluster that owns the config record,
+	// so that multiple clusters may publish their routes to the same databroker
+	Cluster string
+	// ClusterPriority determines which cluster owns the route if multiple clusters publish the same route
+	ClusterPriority int
+	// DeletionGuard if set, makes Set refuse to delete too many of the existing routes at once
+	DeletionGuard *DeletionGuard
+
+	// applied keeps the hashes of the ingress configs last applied by Upsert, guarded by mu
+	applied map[types.NamespacedName]appliedIngress
 }
 
-// Upsert should update or create the pomerium routes corresponding to this ingress
+// Upsert should update or create the pomerium routes corresponding to this ingress.
+// model.RouteErrors is returned if the ingress was only applied partially, as some of its routes were invalid.
 func (r *ConfigReconciler) Upsert(ctx context.Context, ic *model.IngressConfig) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := ic.GetIngressNamespacedName()
+	res, routeErrs, err := r.translate(ctx, ic)
+	if err != nil {
+		return false, err
+	}
+	hash, err := resultHash(res)
+	if err != nil {
+		return false, err
+	}
+	if r.skipUnchanged(ctx, ic, hash) {
+		if len(routeErrs) > 0 {
+			return false, routeErrs
+		}
+		return false, nil
+	}
+	delete(r.applied, name)
+
 	prev, err := r.getConfig(ctx)
 	if err != nil {
 		return false, fmt.Errorf("get config: %w", err)
 	}
 
 	next := proto.Clone(prev).(*pb.Config)
-	if err = upsert(ctx, next, ic); err != nil {
+	if err = applyResult(ctx, next, ic, res); err != nil {
+		return false, err
+	}
+
+	changed, err := r.saveConfig(ctx, prev, next, string(ic.Ingress.UID))
+	if err != nil {
 		return false, err
 	}
+	r.setApplied(name, hash, next)
+	if len(routeErrs) > 0 {
+		return changed, routeErrs
+	}
+	return changed, nil
+}
 
-	return r.saveConfig(ctx, prev, next, string(ic.Ingress.UID))
+// translate converts the ingress, and returns the routes that were skipped
+func (r *ConfigReconciler) translate(ctx context.Context, ic *model.IngressConfig) (*translate.Result, model.RouteErrors, error) {
+	var routeErrs model.RouteErrors
+	res, err := translateIngress(ctx, ic)
+	if !errors.As(err, &routeErrs) {
+		return res, nil, err
+	}
+	if r.StrictIngressValidation {
+		// not wrapped, as model.RouteErrors indicates the ingress was applied partially
+		return nil, nil, model.NewPermanentError(fmt.Errorf("strict ingress validation: %v", err))
+	}
+	return res, routeErrs, nil
 }
 
-// Set merges existing config with the one generated for ingress
+// upsert applies ingress to the config, and returns the routes that were skipped
+func (r *ConfigReconciler) upsert(ctx context.Context, cfg *pb.Config, ic *model.IngressConfig) (model.RouteErrors, error) {
+	res, routeErrs, err := r.translate(ctx, ic)
+	if err != nil {
+		return nil, err
+	}
+	if err = applyResult(ctx, cfg, ic, res); err != nil {
+		return nil, err
+	}
+	return routeErrs, nil
+}
+
+// Set replaces the routes this controller published with the ones generated for the ingresses,
+// so that the routes of the ingresses deleted meanwhile are dropped. the routes without the ownership marker,
+// that were added to the config record otherwise, are kept along with their certificates.
+// ErrMassRouteDeletion is returned if the DeletionGuard refuses to delete the existing routes
 func (r *ConfigReconciler) Set(ctx context.Context, ics []*model.IngressConfig) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	logger := log.FromContext(ctx)
+	r.applied = nil
 
 	prev, err := r.getConfig(ctx)
 	if err != nil {
 		return false, fmt.Errorf("get config: %w", err)
 	}
-	next := new(pb.Config)
+	_, other := splitOwned(prev.Routes)
+	next := &pb.Config{Routes: other}
+	if len(other) > 0 {
+		// the unused ones are removed once saved
+		next.Settings = &pb.Settings{Certificates: prev.GetSettings().GetCertificates()}
+	}
 
 	for _, ic := range ics {
 		cfg := proto.Clone(next).(*pb.Config)
+		routeErrs, err := r.upsert(ctx, cfg, ic)
+		if len(routeErrs) > 0 {
+			logger.Error(routeErrs, "skip invalid routes", "ingress", fmt.Sprintf("%s/%s", ic.Namespace, ic.Name))
+		}
 		if err := multierror.Append(
-			upsert(ctx, cfg, ic),
+			err,
 			validate(ctx, cfg, string(ic.Ingress.UID)),
 		).ErrorOrNil(); err != nil {
 			logger.Error(err, "skip ingress", "ingress", fmt.Sprintf("%s/%s", ic.Namespace, ic.Name))
@@ -74,11 +179,22 @@ func (r *ConfigReconciler) Set(ctx context.Context, ics []*model.IngressConfig)
 		next = cfg
 	}
 
+	if err := r.DeletionGuard.check(ctx, prev, next); err != nil {
+		return false, err
+	}
 	return r.saveConfig(ctx, prev, next, "config")
 }
 
-// Delete should delete pomerium routes corresponding to this ingress name
+// Delete should delete pomerium routes corresponding to this ingress name.
+// It is idempotent, so if the routes or the config record are already gone, i.e. after a manual cleanup
+// or a double delete during failover, nil is returned
 func (r *ConfigReconciler) Delete(ctx context.Context, namespacedName types.NamespacedName) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logger := log.FromContext(ctx).WithValues("ingress", namespacedName.String())
+	delete(r.applied, namespacedName)
+
 	prev, err := r.getConfig(ctx)
 	if err != nil {
 		return fmt.Errorf("get pomerium config: %w", err)
@@ -87,21 +203,98 @@ func (r *ConfigReconciler) Delete(ctx context.Context, namespacedName types.Name
 	if err := deleteRoutes(ctx, cfg, namespacedName); err != nil {
 		return fmt.Errorf("deleting pomerium config records %s: %w", namespacedName.String(), err)
 	}
-	if _, err := r.saveConfig(ctx, prev, cfg,
+	if len(cfg.Routes) == len(prev.Routes) {
+		logger.V(1).Info("ingress routes are already absent")
+		ingressDeletions.WithLabelValues(deleteResultAbsent).Inc()
+		return nil
+	}
+	_, err = r.saveConfig(ctx, prev, cfg,
 		fmt.Sprintf("%s-%s", namespacedName.Namespace, namespacedName.Name),
-	); err != nil {
+	)
+	if status.Code(err) == codes.NotFound {
+		logger.V(1).Info("pomerium config record is already absent")
+		ingressDeletions.WithLabelValues(deleteResultAbsent).Inc()
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("updating pomerium config: %w", err)
 	}
+	ingressDeletions.WithLabelValues(deleteResultDeleted).Inc()
 	return nil
 }
 
+// Batch applies the deletes and then the upserts of several ingresses in a single config update.
+// As with Set, the ingresses that could not be applied are skipped, and their errors are returned by name,
+// along with model.RouteErrors of the ingresses applied partially. err is returned if the config
+// could not be updated, in which case none of them were applied
+func (r *ConfigReconciler) Batch(
+	ctx context.Context,
+	upserts []*model.IngressConfig,
+	deletes []types.NamespacedName,
+) (changed bool, ingressErrs map[types.NamespacedName]error, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev, err := r.getConfig(ctx)
+	if err != nil {
+		return false, nil, fmt.Errorf("get config: %w", err)
+	}
+	next := proto.Clone(prev).(*pb.Config)
+	ingressErrs = make(map[types.NamespacedName]error)
+	for _, name := range deletes {
+		delete(r.applied, name)
+	}
+	for _, ic := range upserts {
+		delete(r.applied, ic.GetIngressNamespacedName())
+	}
+
+	var deleted, absent int
+	for _, name := range deletes {
+		routes := len(next.Routes)
+		if err := deleteRoutes(ctx, next, name); err != nil {
+			ingressErrs[name] = fmt.Errorf("deleting pomerium config records %s: %w", name.String(), err)
+		} else if len(next.Routes) == routes {
+			absent++
+		} else {
+			deleted++
+		}
+	}
+
+	for _, ic := range upserts {
+		name := ic.GetIngressNamespacedName()
+		cfg := proto.Clone(next).(*pb.Config)
+		routeErrs, err := r.upsert(ctx, cfg, ic)
+		if err == nil {
+			err = validate(ctx, cfg, string(ic.Ingress.UID))
+		}
+		if err != nil {
+			ingressErrs[name] = err
+			continue
+		}
+		if len(routeErrs) > 0 {
+			ingressErrs[name] = routeErrs
+		}
+		next = cfg
+	}
+
+	if changed, err = r.saveConfig(ctx, prev, next, "batch"); err != nil {
+		return false, nil, err
+	}
+	ingressDeletions.WithLabelValues(deleteResultDeleted).Add(float64(deleted))
+	ingressDeletions.WithLabelValues(deleteResultAbsent).Add(float64(absent))
+	return changed, ingressErrs, nil
+}
+
 // DeleteAll cleans pomerium configuration entirely
 func (r *ConfigReconciler) DeleteAll(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.applied = nil
 	any := protoutil.NewAny(&pb.Config{})
 	if _, err := r.Put(ctx, &databroker.PutRequest{
 		Record: &databroker.Record{
 			Type:      any.GetTypeUrl(),
-			Id:        configID,
+			Id:        r.recordID(),
 			Data:      any,
 			DeletedAt: timestamppb.Now(),
 		},
@@ -112,12 +305,17 @@ func (r *ConfigReconciler) DeleteAll(ctx context.Context) error {
 }
 
 func (r *ConfigReconciler) getConfig(ctx context.Context) (*pb.Config, error) {
+	return r.getConfigRecord(ctx, r.recordID())
+}
+
+// getConfigRecord returns the config record of the given id, or an empty config if it does not exist
+func (r *ConfigReconciler) getConfigRecord(ctx context.Context, id string) (*pb.Config, error) {
 	cfg := new(pb.Config)
 	any := protoutil.NewAny(cfg)
 	var hdr metadata.MD
 	resp, err := r.Get(ctx, &databroker.GetRequest{
 		Type: any.GetTypeUrl(),
-		Id:   configID,
+		Id:   id,
 	}, grpc.Header(&hdr))
 	if status.Code(err) == codes.NotFound {
 		return &pb.Config{}, nil
@@ -135,6 +333,13 @@ func (r *ConfigReconciler) getConfig(ctx context.Context) (*pb.Config, error) {
 func (r *ConfigReconciler) saveConfig(ctx context.Context, prev, next *pb.Config, id string) (bool, error) {
 	logger := log.FromContext(ctx)
 
+	if err := r.setOwner(next); err != nil {
+		return false, err
+	}
+	takeovers, err := r.resolveCompetingRoutes(ctx, next)
+	if err != nil {
+		return false, err
+	}
 	if err := removeUnusedCerts(next); err != nil {
 		return false, fmt.Errorf("removing unused certs: %w", err)
 	}
@@ -147,7 +352,7 @@ func (r *ConfigReconciler) saveConfig(ctx context.Context, prev, next *pb.Config
 	}
 
 	if err := validate(ctx, next, id); err != nil {
-		return false, fmt.Errorf("config validation: %w", err)
+		return false, fmt.Errorf("config validation: %w", model.NewPermanentError(err))
 	}
 
 	if proto.Equal(prev, next) {
@@ -155,16 +360,13 @@ func (r *ConfigReconciler) saveConfig(ctx context.Context, prev, next *pb.Config
 		return false, nil
 	}
 
-	any := protoutil.NewAny(next)
-	if _, err := r.Put(ctx, &databroker.PutRequest{
-		Record: &databroker.Record{
-			Type: any.GetTypeUrl(),
-			Id:   configID,
-			Data: any,
-		},
-	}); err != nil {
+	if err := r.putConfig(ctx, r.recordID(), next); err != nil {
 		return false, err
 	}
+	// the routes are only taken over once ours are published, as otherwise they would not be served by either cluster
+	if err := r.takeOver(ctx, takeovers); err != nil {
+		return true, err
+	}
 
 	logger.Info("new pomerium config applied")
 
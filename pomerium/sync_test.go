@@ -0,0 +1,900 @@
+package pomerium
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/protoutil"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// fakeDataBroker keeps the records in memory
+type fakeDataBroker struct {
+	databroker.DataBrokerServiceClient
+	sync.Mutex
+	records map[string]*databroker.Record
+	// err if set, is returned by all calls
+	err error
+	// putErr if set, is returned by Put
+	putErr error
+	// getDelay if set, delays Get, so that the concurrent read-modify-write cycles would overlap
+	getDelay time.Duration
+	// version is the last record version assigned by Put
+	version uint64
+}
+
+func newFakeDataBroker() *fakeDataBroker {
+	return &fakeDataBroker{records: make(map[string]*databroker.Record)}
+}
+
+func (f *fakeDataBroker) Get(_ context.Context, req *databroker.GetRequest, _ ...grpc.CallOption) (*databroker.GetResponse, error) {
+	time.Sleep(f.getDelay)
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	r, ok := f.records[req.GetType()+"/"+req.GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "record not found")
+	}
+	return &databroker.GetResponse{Record: proto.Clone(r).(*databroker.Record)}, nil
+}
+
+func (f *fakeDataBroker) Put(_ context.Context, req *databroker.PutRequest, _ ...grpc.CallOption) (*databroker.PutResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	r := proto.Clone(req.GetRecord()).(*databroker.Record)
+	f.version++
+	r.Version = f.version
+	key := r.GetType() + "/" + r.GetId()
+	if r.GetDeletedAt() != nil {
+		delete(f.records, key)
+	} else {
+		f.records[key] = proto.Clone(r).(*databroker.Record)
+	}
+	return &databroker.PutResponse{Record: r}, nil
+}
+
+func (f *fakeDataBroker) Query(_ context.Context, req *databroker.QueryRequest, _ ...grpc.CallOption) (*databroker.QueryResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	keys := make([]string, 0, len(f.records))
+	for key, r := range f.records {
+		if r.GetType() == req.GetType() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	resp := &databroker.QueryResponse{TotalCount: int64(len(keys))}
+	for i := req.GetOffset(); i < int64(len(keys)) && i < req.GetOffset()+req.GetLimit(); i++ {
+		resp.Records = append(resp.Records, proto.Clone(f.records[keys[i]]).(*databroker.Record))
+	}
+	return resp, nil
+}
+
+func (f *fakeDataBroker) config(t *testing.T) *pb.Config {
+	t.Helper()
+
+	f.Lock()
+	defer f.Unlock()
+
+	cfg := new(pb.Config)
+	for _, r := range f.records {
+		require.NoError(t, r.GetData().UnmarshalTo(cfg))
+	}
+	return cfg
+}
+
+func TestUpsertPartial(t *testing.T) {
+	ctx := context.Background()
+	typeImplSpecific := networkingv1.PathTypeImplementationSpecific
+	ic := manyPathsIngress(3, map[string]string{
+		"a/path_regex": "true",
+	})
+	paths := ic.Spec.Rules[0].HTTP.Paths
+	for i := range paths {
+		paths[i].PathType = &typeImplSpecific
+	}
+	validPath := paths[1].Path
+	paths[1].Path = "/api/(v1"
+
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+
+	changed, err := r.Upsert(ctx, ic)
+	var routeErrs model.RouteErrors
+	require.True(t, errors.As(err, &routeErrs), "expected route errors, got %v", err)
+	assert.True(t, changed)
+	if assert.Len(t, routeErrs, 1) {
+		assert.Equal(t, "/api/(v1", routeErrs[0].Path)
+		assert.Equal(t, "service.localhost.pomerium.io", routeErrs[0].Host)
+	}
+	assert.Len(t, db.config(t).Routes, 2, "valid routes should be applied")
+
+	// transition back to fully valid
+	paths[1].Path = validPath
+	changed, err = r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, db.config(t).Routes, 3)
+
+	// strict validation keeps the config intact
+	r.StrictIngressValidation = true
+	paths[1].Path = "/api/(v1"
+	changed, err = r.Upsert(ctx, ic)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &routeErrs), "strict validation should not report partial update")
+	assert.False(t, changed)
+	assert.Len(t, db.config(t).Routes, 3)
+
+	// Set would also skip invalid routes only
+	r.StrictIngressValidation = false
+	changed, err = r.Set(ctx, []*model.IngressConfig{ic})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, db.config(t).Routes, 2)
+}
+
+func TestUpsertErrorClassification(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("invalid annotation", func(t *testing.T) {
+		ic := manyPathsIngress(1, map[string]string{"a/unknown": "true"})
+		_, err := (&ConfigReconciler{DataBrokerServiceClient: newFakeDataBroker()}).Upsert(ctx, ic)
+		assert.Error(t, err)
+		assert.True(t, model.IsPermanentError(err), err)
+	})
+	t.Run("contradictory annotations", func(t *testing.T) {
+		ic := manyPathsIngress(1, map[string]string{
+			"a/allow_public_unauthenticated_access": "true",
+			"a/allow_any_authenticated_user":        "true",
+		})
+		_, err := (&ConfigReconciler{DataBrokerServiceClient: newFakeDataBroker()}).Upsert(ctx, ic)
+		assert.Error(t, err)
+		assert.True(t, model.IsPermanentError(err), err)
+	})
+	t.Run("databroker unavailable", func(t *testing.T) {
+		ic := manyPathsIngress(1, nil)
+		db := newFakeDataBroker()
+		db.err = status.Error(codes.Unavailable, "unavailable")
+		_, err := (&ConfigReconciler{DataBrokerServiceClient: db}).Upsert(ctx, ic)
+		assert.Error(t, err)
+		assert.False(t, model.IsPermanentError(err), err)
+	})
+}
+
+func TestSetSkipsInvalidIngress(t *testing.T) {
+	ctx := context.Background()
+	valid := manyPathsIngress(2, map[string]string{"a/allowed_idp_claims": "groups: admin"})
+	invalid := manyPathsIngress(1, map[string]string{"a/allowed_idp_claims": "groups: [admin"})
+	invalid.Ingress.Name = "invalid"
+	invalid.Spec.Rules[0].Host = "invalid.localhost.pomerium.io"
+
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+	changed, err := r.Set(ctx, []*model.IngressConfig{invalid, valid})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	routes := db.config(t).Routes
+	require.Len(t, routes, 2, "only the valid ingress should be applied")
+	for _, route := range routes {
+		assert.Equal(t, "https://service.localhost.pomerium.io", route.From)
+	}
+
+	_, err = r.Upsert(ctx, invalid)
+	assert.True(t, model.IsPermanentError(err), err)
+	assert.Len(t, db.config(t).Routes, 2)
+}
+
+// TestUpsertAllowAnyAuthenticatedUser checks toggling the annotation off removes the allow any clause from the routes
+func TestUpsertAllowAnyAuthenticatedUser(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+	allowAny := func() []bool {
+		var out []bool
+		for _, route := range db.config(t).Routes {
+			out = append(out, route.GetAllowAnyAuthenticatedUser())
+		}
+		return out
+	}
+
+	ic := manyPathsIngress(2, map[string]string{
+		"a/allow_any_authenticated_user": "true",
+		"a/pass_identity_headers":        "true",
+	})
+	_, err := r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true}, allowAny())
+
+	ic.Ingress.Annotations["a/allow_public_unauthenticated_access"] = "true"
+	_, err = r.Upsert(ctx, ic)
+	assert.Error(t, err)
+	assert.Equal(t, []bool{true, true}, allowAny(), "the config should be left as is")
+
+	ic.Ingress.Annotations = map[string]string{"a/pass_identity_headers": "true"}
+	_, err = r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, false}, allowAny())
+}
+
+// TestUpsertHealthChecks checks the health checks round-trip through the config record
+func TestUpsertCanary(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+
+	ic := manyPathsIngress(1, map[string]string{"a/canary": "{serviceName: service-canary, servicePort: http, weight: 25}"})
+	canary := types.NamespacedName{Name: "service-canary", Namespace: "default"}
+	primary := types.NamespacedName{Name: "service", Namespace: "default"}
+	ic.Services[canary] = ic.Services[primary].DeepCopy()
+	ic.Services[canary].Name = canary.Name
+	ic.Endpoints[canary] = &corev1.Endpoints{Subsets: []corev1.EndpointSubset{{
+		Addresses: []corev1.EndpointAddress{{IP: "10.0.1.1"}},
+		Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+	}}}
+	_, err := r.Upsert(ctx, ic)
+	require.NoError(t, err, "weighted destinations should pass the pomerium validation")
+
+	routes := db.config(t).Routes
+	require.Len(t, routes, 1)
+	assert.Equal(t, []string{
+		"http://10.0.0.1:8080,1", "http://10.0.0.2:8080,1", "http://10.0.0.3:8080,1", "http://10.0.1.1:8080,1",
+	}, routes[0].To)
+}
+
+func TestUpsertHealthChecks(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+
+	ic := manyPathsIngress(1, map[string]string{"a/health_checks": `
+- timeout: 1s
+  interval: 10s
+  healthy_threshold: 1
+  unhealthy_threshold: 3
+  http_health_check:
+    path: /healthz
+- timeout: 2s
+  interval: 30s
+  healthy_threshold: 2
+  unhealthy_threshold: 2
+  tcp_health_check: {}
+`})
+	_, err := r.Upsert(ctx, ic)
+	require.NoError(t, err)
+
+	routes := db.config(t).Routes
+	require.Len(t, routes, 1)
+	expect := []*envoy_config_core_v3.HealthCheck{{
+		Timeout:            durationpb.New(time.Second),
+		Interval:           durationpb.New(time.Second * 10),
+		HealthyThreshold:   wrapperspb.UInt32(1),
+		UnhealthyThreshold: wrapperspb.UInt32(3),
+		HealthChecker: &envoy_config_core_v3.HealthCheck_HttpHealthCheck_{
+			HttpHealthCheck: &envoy_config_core_v3.HealthCheck_HttpHealthCheck{Path: "/healthz"},
+		},
+	}, {
+		Timeout:            durationpb.New(time.Second * 2),
+		Interval:           durationpb.New(time.Second * 30),
+		HealthyThreshold:   wrapperspb.UInt32(2),
+		UnhealthyThreshold: wrapperspb.UInt32(2),
+		HealthChecker: &envoy_config_core_v3.HealthCheck_TcpHealthCheck_{
+			TcpHealthCheck: &envoy_config_core_v3.HealthCheck_TcpHealthCheck{},
+		},
+	}}
+	if assert.Len(t, routes[0].GetEnvoyOpts().GetHealthChecks(), len(expect)) {
+		for i, hc := range routes[0].GetEnvoyOpts().GetHealthChecks() {
+			assert.True(t, proto.Equal(expect[i], hc), "health check %d: %v", i, hc)
+		}
+	}
+}
+
+func TestDeleteIdempotent(t *testing.T) {
+	ctx := context.Background()
+	name := types.NamespacedName{Name: "ingress", Namespace: "default"}
+	deletions := func(result string) float64 {
+		return testutil.ToFloat64(ingressDeletions.WithLabelValues(result))
+	}
+	setup := func(t *testing.T) (*fakeDataBroker, *ConfigReconciler) {
+		t.Helper()
+		db := newFakeDataBroker()
+		r := &ConfigReconciler{DataBrokerServiceClient: db}
+		_, err := r.Upsert(ctx, manyPathsIngress(2, nil))
+		require.NoError(t, err)
+		require.Len(t, db.config(t).Routes, 2)
+		return db, r
+	}
+
+	t.Run("deleted", func(t *testing.T) {
+		db, r := setup(t)
+		deleted, absent := deletions(deleteResultDeleted), deletions(deleteResultAbsent)
+		require.NoError(t, r.Delete(ctx, name))
+		assert.Empty(t, db.config(t).Routes)
+		assert.Equal(t, deleted+1, deletions(deleteResultDeleted))
+
+		require.NoError(t, r.Delete(ctx, name), "double delete")
+		assert.Equal(t, absent+1, deletions(deleteResultAbsent))
+	})
+	t.Run("record not found", func(t *testing.T) {
+		r := &ConfigReconciler{DataBrokerServiceClient: newFakeDataBroker()}
+		absent := deletions(deleteResultAbsent)
+		require.NoError(t, r.Delete(ctx, name))
+		assert.Equal(t, absent+1, deletions(deleteResultAbsent))
+	})
+	t.Run("record removed while deleting", func(t *testing.T) {
+		db, r := setup(t)
+		absent := deletions(deleteResultAbsent)
+		db.putErr = status.Error(codes.NotFound, "record not found")
+		require.NoError(t, r.Delete(ctx, name))
+		assert.Equal(t, absent+1, deletions(deleteResultAbsent))
+	})
+	t.Run("databroker failure", func(t *testing.T) {
+		db, r := setup(t)
+		db.putErr = status.Error(codes.Unavailable, "unavailable")
+		assert.Error(t, r.Delete(ctx, name))
+		db.putErr = nil
+		db.err = status.Error(codes.Unavailable, "unavailable")
+		assert.Error(t, r.Delete(ctx, name))
+	})
+	t.Run("concurrent double delete", func(t *testing.T) {
+		db, _ := setup(t)
+		deleted, absent := deletions(deleteResultDeleted), deletions(deleteResultAbsent)
+		// i.e. the old and the new leader during failover
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = (&ConfigReconciler{DataBrokerServiceClient: db}).Delete(ctx, name)
+			}(i)
+		}
+		wg.Wait()
+		assert.NoError(t, errs[0])
+		assert.NoError(t, errs[1])
+		assert.Empty(t, db.config(t).Routes)
+		assert.Equal(t, float64(2), deletions(deleteResultDeleted)-deleted+deletions(deleteResultAbsent)-absent)
+	})
+}
+
+func TestSetDeletionGuard(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{
+		DataBrokerServiceClient: db,
+		DeletionGuard:           &DeletionGuard{MaxPercent: DefaultMaxRouteDeletionPercent},
+	}
+	_, err := r.Set(ctx, []*model.IngressConfig{manyPathsIngress(4, nil)})
+	require.NoError(t, err)
+	require.Len(t, db.config(t).Routes, 4)
+
+	// deleting up to the threshold is allowed
+	_, err = r.Set(ctx, []*model.IngressConfig{manyPathsIngress(2, nil)})
+	require.NoError(t, err)
+	require.Len(t, db.config(t).Routes, 2)
+
+	_, err = r.Set(ctx, nil)
+	assert.ErrorIs(t, err, ErrMassRouteDeletion)
+	assert.Len(t, db.config(t).Routes, 2, "routes should be kept")
+
+	r.DeletionGuard.ConfirmFile = filepath.Join(t.TempDir(), "confirm")
+	_, err = r.Set(ctx, nil)
+	assert.ErrorIs(t, err, ErrMassRouteDeletion, "confirmation file does not exist yet")
+	require.NoError(t, os.WriteFile(r.DeletionGuard.ConfirmFile, nil, 0o600))
+	_, err = r.Set(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, db.config(t).Routes)
+
+	_, err = r.Set(ctx, []*model.IngressConfig{manyPathsIngress(2, nil)})
+	require.NoError(t, err)
+	r.DeletionGuard = &DeletionGuard{MaxPercent: DefaultMaxRouteDeletionPercent, Allow: true}
+	_, err = r.Set(ctx, nil)
+	require.NoError(t, err, "override flag")
+	assert.Empty(t, db.config(t).Routes)
+}
+
+func TestSetKeepsUnownedRoutes(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+
+	staleID, err := (&routeID{Name: "stale", Namespace: "default", Host: "stale.localhost.pomerium.io", Path: "/"}).Marshal()
+	require.NoError(t, err)
+	manual := &pb.Route{Name: "manual", From: "https://manual.localhost.pomerium.io", To: []string{"http://manual.example.com"}}
+	require.NoError(t, r.putConfig(ctx, configID, &pb.Config{Routes: []*pb.Route{
+		{Id: staleID, From: "https://stale.localhost.pomerium.io", To: []string{"http://stale.default.svc.cluster.local"}, Prefix: "/"},
+		manual,
+	}}))
+
+	froms := func() []string {
+		var from []string
+		for _, route := range db.config(t).Routes {
+			from = append(from, route.From)
+		}
+		sort.Strings(from)
+		return from
+	}
+
+	// the ingress deleted while the controller was down is dropped by the startup sync
+	_, err = r.Set(ctx, []*model.IngressConfig{manyPathsIngress(1, nil)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://manual.localhost.pomerium.io", "https://service.localhost.pomerium.io"}, froms())
+
+	_, err = r.Upsert(ctx, manyPathsIngress(2, nil))
+	require.NoError(t, err)
+	assert.Len(t, db.config(t).Routes, 3)
+
+	require.NoError(t, r.Delete(ctx, types.NamespacedName{Name: "ingress", Namespace: "default"}))
+	if routes := db.config(t).Routes; assert.Len(t, routes, 1) {
+		assert.True(t, proto.Equal(manual, routes[0]), "manual route should be kept intact")
+	}
+}
+
+// clusterRoutes returns from URLs of the routes published by each cluster
+func (f *fakeDataBroker) clusterRoutes(t *testing.T) map[string][]string {
+	t.Helper()
+
+	f.Lock()
+	defer f.Unlock()
+
+	routes := make(map[string][]string)
+	for _, r := range f.records {
+		cfg := new(pb.Config)
+		require.NoError(t, r.GetData().UnmarshalTo(cfg))
+		for _, route := range cfg.Routes {
+			var id routeID
+			require.NoError(t, id.Unmarshal(route.Id))
+			routes[id.Cluster] = append(routes[id.Cluster], route.From)
+		}
+	}
+	return routes
+}
+
+func TestClusterPriority(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	primary := &ConfigReconciler{DataBrokerServiceClient: db, Cluster: "primary", ClusterPriority: 10}
+	standby := &ConfigReconciler{DataBrokerServiceClient: db, Cluster: "standby", ClusterPriority: 1}
+
+	ic := manyPathsIngress(1, nil)
+	from := "https://service.localhost.pomerium.io"
+
+	// standby publishes while primary is down
+	_, err := standby.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"standby": {from}}, db.clusterRoutes(t))
+
+	// primary comes up and takes over
+	_, err = primary.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"primary": {from}}, db.clusterRoutes(t))
+
+	// standby never clobbers primary's route
+	changed, err := standby.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, map[string][]string{"primary": {from}}, db.clusterRoutes(t))
+
+	// primary withdraws the route, standby publishes it on its next reconciliation
+	require.NoError(t, primary.Delete(ctx, types.NamespacedName{Namespace: ic.Namespace, Name: ic.Name}))
+	assert.Empty(t, db.clusterRoutes(t))
+	_, err = standby.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"standby": {from}}, db.clusterRoutes(t))
+
+	// failback: primary resync takes over again
+	_, err = primary.Set(ctx, []*model.IngressConfig{ic})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"primary": {from}}, db.clusterRoutes(t))
+
+	// clusters with the same priority both keep their routes
+	peer := &ConfigReconciler{DataBrokerServiceClient: db, Cluster: "peer", ClusterPriority: 10}
+	_, err = peer.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"primary": {from}, "peer": {from}}, db.clusterRoutes(t))
+}
+
+// recordRoutes returns from URLs of the routes in the config record
+func (f *fakeDataBroker) recordRoutes(t *testing.T, id string) []string {
+	t.Helper()
+
+	f.Lock()
+	defer f.Unlock()
+
+	r, ok := f.records[protoutil.NewAny(new(pb.Config)).GetTypeUrl()+"/"+id]
+	if !ok {
+		return nil
+	}
+	cfg := new(pb.Config)
+	require.NoError(t, r.GetData().UnmarshalTo(cfg))
+	var from []string
+	for _, route := range cfg.Routes {
+		from = append(from, route.From)
+	}
+	return from
+}
+
+// TestClusterTakeOver checks the routes are only removed from a lower priority cluster record
+// once our config is valid and actually changes, and that the concurrent updates of that record are kept
+func TestClusterTakeOver(t *testing.T) {
+	ctx := context.Background()
+	from := "https://service.localhost.pomerium.io"
+	setup := func(t *testing.T) (*fakeDataBroker, *ConfigReconciler, *ConfigReconciler) {
+		t.Helper()
+		db := newFakeDataBroker()
+		standby := &ConfigReconciler{DataBrokerServiceClient: db, Cluster: "standby", ClusterPriority: 1}
+		_, err := standby.Upsert(ctx, manyPathsIngress(1, nil))
+		require.NoError(t, err)
+		return db, standby, &ConfigReconciler{DataBrokerServiceClient: db, Cluster: "primary", ClusterPriority: 10}
+	}
+
+	t.Run("validation failure", func(t *testing.T) {
+		db, standby, primary := setup(t)
+		// the manually added route without a destination fails the validation of the primary config
+		require.NoError(t, primary.putConfig(ctx, primary.recordID(), &pb.Config{Routes: []*pb.Route{
+			{Name: "invalid", From: "https://invalid.localhost.pomerium.io"},
+		}}))
+		_, err := primary.Upsert(ctx, manyPathsIngress(1, nil))
+		assert.Error(t, err)
+		assert.Equal(t, []string{from}, db.recordRoutes(t, standby.recordID()), "other cluster record should be untouched")
+	})
+	t.Run("no changes", func(t *testing.T) {
+		db, standby, primary := setup(t)
+		standbyCfg, err := standby.getConfig(ctx)
+		require.NoError(t, err)
+		changed, err := primary.Upsert(ctx, manyPathsIngress(1, nil))
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Empty(t, db.recordRoutes(t, standby.recordID()), "route should be taken over")
+
+		// i.e. restored by an external tool, the standby would withdraw it on its next update
+		require.NoError(t, standby.putConfig(ctx, standby.recordID(), standbyCfg))
+		changed, err = primary.Upsert(ctx, manyPathsIngress(1, nil))
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, []string{from}, db.recordRoutes(t, standby.recordID()), "no-op save should not touch other cluster record")
+	})
+	t.Run("concurrent update", func(t *testing.T) {
+		db, standby, primary := setup(t)
+		cfg, err := primary.getConfig(ctx)
+		require.NoError(t, err)
+		_, err = primary.upsert(ctx, cfg, manyPathsIngress(1, nil))
+		require.NoError(t, err)
+		takeovers, err := primary.resolveCompetingRoutes(ctx, cfg)
+		require.NoError(t, err)
+		require.Len(t, takeovers, 1)
+
+		// the standby publishes another ingress after its record was read
+		other := manyPathsIngress(1, nil)
+		other.Name = "other"
+		other.Spec.Rules[0].Host = "other.localhost.pomerium.io"
+		_, err = standby.Upsert(ctx, other)
+		require.NoError(t, err)
+
+		require.NoError(t, primary.takeOver(ctx, takeovers))
+		assert.Equal(t, []string{"https://other.localhost.pomerium.io"}, db.recordRoutes(t, standby.recordID()),
+			"concurrent update of the other cluster should be kept")
+	})
+}
+
+// testCertSecret returns a self-signed certificate secret, for service.localhost.pomerium.io unless dnsNames are given
+func testCertSecret(t *testing.T, name string, notAfter time.Time, dnsNames ...string) *corev1.Secret {
+	t.Helper()
+
+	if len(dnsNames) == 0 {
+		dnsNames = []string{"service.localhost.pomerium.io"}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(notAfter.UnixNano()),
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		},
+	}
+}
+
+// TestUpsertDoublyReferencedSecret checks the secret used both as the ingress TLS certificate
+// and the upstream client certificate, is applied with the same data in both roles once updated
+func TestUpsertDoublyReferencedSecret(t *testing.T) {
+	ctx := context.Background()
+	ic := manyPathsIngress(1, map[string]string{"a/" + model.TLSClientSecret: "tls"})
+	ic.Ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{"service.localhost.pomerium.io"}, SecretName: "tls"}}
+	name := types.NamespacedName{Name: "tls", Namespace: "default"}
+
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+	for i, secret := range []*corev1.Secret{
+		testCertSecret(t, "tls", time.Now().Add(time.Hour)),
+		testCertSecret(t, "tls", time.Now().Add(time.Hour*2)),
+	} {
+		ic.Secrets = map[types.NamespacedName]*corev1.Secret{name: secret}
+		_, err := r.Upsert(ctx, ic)
+		require.NoError(t, err, "upsert %d", i)
+
+		cfg := db.config(t)
+		if assert.Len(t, cfg.GetSettings().GetCertificates(), 1, "stale certificate should be removed") {
+			assert.Equal(t, secret.Data[corev1.TLSCertKey], cfg.Settings.Certificates[0].CertBytes)
+		}
+		if assert.Len(t, cfg.Routes, 1) {
+			assert.Equal(t, base64.StdEncoding.EncodeToString(secret.Data[corev1.TLSCertKey]), cfg.Routes[0].TlsClientCert)
+			assert.Equal(t, base64.StdEncoding.EncodeToString(secret.Data[corev1.TLSPrivateKeyKey]), cfg.Routes[0].TlsClientKey)
+		}
+	}
+}
+
+// TestUpsertWildcardHost checks the certificate covering the wildcard host is kept, while the one naming a specific host is not
+func TestUpsertWildcardHost(t *testing.T) {
+	ctx := context.Background()
+	ic := manyPathsIngress(2, nil)
+	ic.AllowWildcardHosts = true
+	ic.Ingress.Spec.Rules[0].Host = "*.apps.example.com"
+	ic.Ingress.Spec.TLS = []networkingv1.IngressTLS{
+		{Hosts: []string{"*.apps.example.com"}, SecretName: "wildcard"},
+		{Hosts: []string{"service.localhost.pomerium.io"}, SecretName: "service"},
+	}
+	wildcard := testCertSecret(t, "wildcard", time.Now().Add(time.Hour), "*.apps.example.com")
+	ic.Secrets = map[types.NamespacedName]*corev1.Secret{
+		{Name: "wildcard", Namespace: "default"}: wildcard,
+		{Name: "service", Namespace: "default"}:  testCertSecret(t, "service", time.Now().Add(time.Hour)),
+	}
+
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+	_, err := r.Upsert(ctx, ic)
+	require.NoError(t, err)
+
+	cfg := db.config(t)
+	if assert.Len(t, cfg.GetSettings().GetCertificates(), 1) {
+		assert.Equal(t, wildcard.Data[corev1.TLSCertKey], cfg.Settings.Certificates[0].CertBytes)
+	}
+	if assert.Len(t, cfg.Routes, 2) {
+		for _, route := range cfg.Routes {
+			assert.Equal(t, "https://*.apps.example.com", route.From)
+		}
+	}
+}
+
+// TestUpsertEndpointsRollout checks only the ready endpoint addresses are used as upstreams,
+// unless the ingress opts in to the not ready ones, and the addresses becoming ready update the routes
+func TestUpsertEndpointsRollout(t *testing.T) {
+	ctx := context.Background()
+	ic := manyPathsIngress(1, nil)
+	endpoints := ic.Endpoints[types.NamespacedName{Name: "service", Namespace: "default"}]
+	ports := []corev1.EndpointPort{{Name: "http", Port: 8080}}
+
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+	upsert := func(subsets ...corev1.EndpointSubset) (bool, []string) {
+		t.Helper()
+		endpoints.Subsets = subsets
+		changed, err := r.Upsert(ctx, ic)
+		require.NoError(t, err)
+		cfg := db.config(t)
+		require.Len(t, cfg.Routes, 1)
+		return changed, cfg.Routes[0].To
+	}
+
+	// the new pod is not ready yet, hence the service DNS name is used
+	changed, to := upsert(corev1.EndpointSubset{NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}, Ports: ports})
+	assert.True(t, changed)
+	assert.Equal(t, []string{"http://service.default.svc.cluster.local:80"}, to)
+
+	changed, to = upsert(corev1.EndpointSubset{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}, Ports: ports})
+	assert.True(t, changed, "the first ready address should update the routes")
+	assert.Equal(t, []string{"http://10.0.0.1:8080"}, to)
+
+	// the next pod is rolled out while the old one is still ready
+	changed, to = upsert(corev1.EndpointSubset{
+		Addresses:         []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+		NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}},
+		Ports:             ports,
+	})
+	assert.False(t, changed)
+	assert.Equal(t, []string{"http://10.0.0.1:8080"}, to)
+
+	ic.Ingress.Annotations = map[string]string{"a/" + model.UseNotReadyEndpoints: "true"}
+	changed, to = upsert(corev1.EndpointSubset{
+		Addresses:         []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+		NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}},
+		Ports:             ports,
+	})
+	assert.True(t, changed)
+	assert.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}, to)
+}
+
+// TestConcurrentUpserts checks the ingresses upserted and deleted at the same time are all applied
+func TestConcurrentUpserts(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+
+	ingress := func(i int) *model.IngressConfig {
+		ic := manyPathsIngress(1, nil)
+		ic.Ingress.Name = fmt.Sprintf("ingress-%d", i)
+		ic.Ingress.UID = types.UID(ic.Ingress.Name)
+		ic.Spec.Rules[0].Host = fmt.Sprintf("service-%d.localhost.pomerium.io", i)
+		return ic
+	}
+	_, err := r.Upsert(ctx, ingress(0))
+	require.NoError(t, err)
+
+	db.getDelay = time.Millisecond * 10
+	var eg errgroup.Group
+	for i := 1; i <= 5; i++ {
+		ic := ingress(i)
+		eg.Go(func() error {
+			_, err := r.Upsert(ctx, ic)
+			return err
+		})
+	}
+	eg.Go(func() error {
+		return r.Delete(ctx, types.NamespacedName{Namespace: "default", Name: "ingress-0"})
+	})
+	require.NoError(t, eg.Wait())
+
+	var hosts []string
+	for _, route := range db.config(t).Routes {
+		hosts = append(hosts, route.From)
+	}
+	assert.ElementsMatch(t, []string{
+		"https://service-1.localhost.pomerium.io",
+		"https://service-2.localhost.pomerium.io",
+		"https://service-3.localhost.pomerium.io",
+		"https://service-4.localhost.pomerium.io",
+		"https://service-5.localhost.pomerium.io",
+	}, hosts, "no updates should be lost")
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+
+	ingress := func(name string, annotations map[string]string) *model.IngressConfig {
+		ic := manyPathsIngress(1, annotations)
+		ic.Ingress.Name = name
+		ic.Ingress.UID = types.UID(name)
+		ic.Spec.Rules[0].Host = name + ".localhost.pomerium.io"
+		return ic
+	}
+	_, err := r.Upsert(ctx, ingress("deleted", nil))
+	require.NoError(t, err)
+
+	invalid := ingress("invalid", map[string]string{"a/allowed_idp_claims": "groups: [admin"})
+	changed, ingressErrs, err := r.Batch(ctx,
+		[]*model.IngressConfig{ingress("a", nil), invalid, ingress("b", nil)},
+		[]types.NamespacedName{{Namespace: "default", Name: "deleted"}, {Namespace: "default", Name: "absent"}},
+	)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	if assert.Len(t, ingressErrs, 1) {
+		assert.True(t, model.IsPermanentError(ingressErrs[invalid.GetIngressNamespacedName()]))
+	}
+
+	var hosts []string
+	for _, route := range db.config(t).Routes {
+		hosts = append(hosts, route.From)
+	}
+	assert.ElementsMatch(t, []string{
+		"https://a.localhost.pomerium.io",
+		"https://b.localhost.pomerium.io",
+	}, hosts)
+
+	db.putErr = status.Error(codes.Unavailable, "unavailable")
+	_, _, err = r.Batch(ctx, nil, []types.NamespacedName{{Namespace: "default", Name: "a"}})
+	assert.Error(t, err)
+}
+
+func TestUpsertUnchanged(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDataBroker()
+	r := &ConfigReconciler{DataBrokerServiceClient: db}
+	ic := manyPathsIngress(2, nil)
+	skipped := func() float64 { return testutil.ToFloat64(unchangedUpsertsSkipped) }
+
+	changed, err := r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	// the databroker is not called if only the metadata changed
+	before := skipped()
+	db.err = status.Error(codes.Unavailable, "unavailable")
+	ic.Ingress.ResourceVersion = "2"
+	ic.Revision++
+	changed, err = r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, before+1, skipped())
+
+	ic.Spec.Rules[0].Host = "changed.localhost.pomerium.io"
+	_, err = r.Upsert(ctx, ic)
+	assert.Error(t, err, "changed config should be written")
+	db.err = nil
+	changed, err = r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	// the config removed in the databroker directly is only restored once the applied configs are forgotten
+	db.Lock()
+	db.records = make(map[string]*databroker.Record)
+	db.Unlock()
+	_, err = r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.Empty(t, db.config(t).Routes)
+	r.ForgetApplied()
+	changed, err = r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, db.config(t).Routes, 2)
+
+	// delete invalidates the applied config
+	require.NoError(t, r.Delete(ctx, ic.GetIngressNamespacedName()))
+	changed, err = r.Upsert(ctx, ic)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, db.config(t).Routes, 2)
+}
@@ -0,0 +1,83 @@
+package pomerium
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+	"github.com/pomerium/ingress-controller/translate"
+)
+
+var unchangedUpsertsSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "pomerium_ingress_unchanged_upserts_skipped_total",
+	Help: "Number of ingress upserts that were not written to the databroker, as the translated config did not change since applied",
+})
+
+func init() {
+	metrics.Registry.MustRegister(unchangedUpsertsSkipped)
+}
+
+// appliedIngress is the hash of the translated ingress config last applied,
+// along with the settings the ingress warnings depend on
+type appliedIngress struct {
+	hash          string
+	skipXffAppend bool
+}
+
+// resultHash returns a stable hash of the translated ingress config. it only depends on the translation output,
+// so that the metadata changes of the ingress and its dependencies, i.e. resourceVersion, do not affect it
+func resultHash(res *translate.Result) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(&pb.Config{
+		Routes:   res.Routes,
+		Settings: &pb.Settings{Certificates: res.Certificates},
+	})
+	if err != nil {
+		return "", fmt.Errorf("hashing ingress config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// skipUnchanged checks whether the ingress config is the same as last applied, in which case the update is skipped.
+// the ingress warnings that depend on the applied config are reported again. should be called with mu held.
+// it is never skipped if the cluster name is set, as the routes withheld in favor of a higher priority cluster,
+// or taken over by it from our config record, should be published once that cluster withdraws them
+func (r *ConfigReconciler) skipUnchanged(ctx context.Context, ic *model.IngressConfig, hash string) bool {
+	if r.Cluster != "" {
+		return false
+	}
+	applied, ok := r.applied[ic.GetIngressNamespacedName()]
+	if !ok || applied.hash != hash {
+		return false
+	}
+	warnUntrustedSourceAddress(ctx, &pb.Config{Settings: &pb.Settings{SkipXffAppend: proto.Bool(applied.skipXffAppend)}}, ic)
+	unchangedUpsertsSkipped.Inc()
+	log.FromContext(ctx).V(1).Info("ingress config did not change since applied")
+	return true
+}
+
+func (r *ConfigReconciler) setApplied(name types.NamespacedName, hash string, cfg *pb.Config) {
+	if r.applied == nil {
+		r.applied = make(map[types.NamespacedName]appliedIngress)
+	}
+	r.applied[name] = appliedIngress{hash: hash, skipXffAppend: cfg.GetSettings().GetSkipXffAppend()}
+}
+
+// ForgetApplied makes the subsequent upserts write the ingress configs regardless of whether they changed,
+// so that the config that was modified in the databroker directly is restored
+func (r *ConfigReconciler) ForgetApplied() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.applied = nil
+}
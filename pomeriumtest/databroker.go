@@ -0,0 +1,197 @@
+package pomeriumtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/protoutil"
+)
+
+// DataBroker is an in-memory databroker client, that keeps the records and the leases,
+// so that the pomerium configuration and the lease handling may be tested without a databroker
+type DataBroker struct {
+	databroker.DataBrokerServiceClient
+
+	mu      sync.Mutex
+	records map[string]*databroker.Record
+	leases  map[string]lease
+	nextID  int
+	// version is the last record version assigned by Put
+	version uint64
+}
+
+type lease struct {
+	id      string
+	expires time.Time
+}
+
+// NewDataBroker creates an empty in-memory databroker client
+func NewDataBroker() *DataBroker {
+	return &DataBroker{
+		records: make(map[string]*databroker.Record),
+		leases:  make(map[string]lease),
+	}
+}
+
+// Get implements databroker.DataBrokerServiceClient
+func (db *DataBroker) Get(_ context.Context, req *databroker.GetRequest, _ ...grpc.CallOption) (*databroker.GetResponse, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r, ok := db.records[req.GetType()+"/"+req.GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "record not found")
+	}
+	return &databroker.GetResponse{Record: proto.Clone(r).(*databroker.Record)}, nil
+}
+
+// Put implements databroker.DataBrokerServiceClient
+func (db *DataBroker) Put(_ context.Context, req *databroker.PutRequest, _ ...grpc.CallOption) (*databroker.PutResponse, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r := proto.Clone(req.GetRecord()).(*databroker.Record)
+	db.version++
+	r.Version = db.version
+	key := r.GetType() + "/" + r.GetId()
+	if r.GetDeletedAt() != nil {
+		delete(db.records, key)
+	} else {
+		db.records[key] = proto.Clone(r).(*databroker.Record)
+	}
+	return &databroker.PutResponse{Record: r}, nil
+}
+
+// Query implements databroker.DataBrokerServiceClient
+func (db *DataBroker) Query(_ context.Context, req *databroker.QueryRequest, _ ...grpc.CallOption) (*databroker.QueryResponse, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	keys := make([]string, 0, len(db.records))
+	for key, r := range db.records {
+		if r.GetType() == req.GetType() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	resp := &databroker.QueryResponse{TotalCount: int64(len(keys))}
+	for i := req.GetOffset(); i < int64(len(keys)) && i < req.GetOffset()+req.GetLimit(); i++ {
+		resp.Records = append(resp.Records, proto.Clone(db.records[keys[i]]).(*databroker.Record))
+	}
+	return resp, nil
+}
+
+// AcquireLease implements databroker.DataBrokerServiceClient
+func (db *DataBroker) AcquireLease(_ context.Context, req *databroker.AcquireLeaseRequest, _ ...grpc.CallOption) (*databroker.AcquireLeaseResponse, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if l, ok := db.leases[req.GetName()]; ok && time.Now().Before(l.expires) {
+		return nil, status.Error(codes.AlreadyExists, "lease is held")
+	}
+	db.nextID++
+	id := fmt.Sprint(db.nextID)
+	db.leases[req.GetName()] = lease{id: id, expires: time.Now().Add(req.GetDuration().AsDuration())}
+	return &databroker.AcquireLeaseResponse{Id: id}, nil
+}
+
+// RenewLease implements databroker.DataBrokerServiceClient
+func (db *DataBroker) RenewLease(_ context.Context, req *databroker.RenewLeaseRequest, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	l, ok := db.leases[req.GetName()]
+	if !ok || l.id != req.GetId() || time.Now().After(l.expires) {
+		return nil, status.Error(codes.AlreadyExists, "lease is lost")
+	}
+	l.expires = time.Now().Add(req.GetDuration().AsDuration())
+	db.leases[req.GetName()] = l
+	return new(emptypb.Empty), nil
+}
+
+// ReleaseLease implements databroker.DataBrokerServiceClient
+func (db *DataBroker) ReleaseLease(_ context.Context, req *databroker.ReleaseLeaseRequest, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if l, ok := db.leases[req.GetName()]; ok && l.id == req.GetId() {
+		delete(db.leases, req.GetName())
+	}
+	return new(emptypb.Empty), nil
+}
+
+// PutConfig stores the pomerium config record, i.e. to simulate the state left by a previous controller run
+func (db *DataBroker) PutConfig(id string, cfg *pb.Config) error {
+	data := protoutil.NewAny(cfg)
+	_, err := db.Put(context.Background(), &databroker.PutRequest{
+		Record: &databroker.Record{Type: data.GetTypeUrl(), Id: id, Data: data},
+	})
+	return err
+}
+
+// Routes returns the from URLs of the routes in all pomerium config records
+func (db *DataBroker) Routes() ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var from []string
+	for _, r := range db.records {
+		cfg := new(pb.Config)
+		if err := r.GetData().UnmarshalTo(cfg); err != nil {
+			return nil, err
+		}
+		for _, route := range cfg.GetRoutes() {
+			from = append(from, route.GetFrom())
+		}
+	}
+	sort.Strings(from)
+	return from, nil
+}
+
+// Destinations returns the to URLs of the routes in all pomerium config records
+func (db *DataBroker) Destinations() ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var to []string
+	for _, r := range db.records {
+		cfg := new(pb.Config)
+		if err := r.GetData().UnmarshalTo(cfg); err != nil {
+			return nil, err
+		}
+		for _, route := range cfg.GetRoutes() {
+			to = append(to, route.GetTo()...)
+		}
+	}
+	sort.Strings(to)
+	return to, nil
+}
+
+// RouteConfigs returns the routes in all pomerium config records, ordered by their from URLs
+func (db *DataBroker) RouteConfigs() ([]*pb.Route, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var routes []*pb.Route
+	for _, r := range db.records {
+		cfg := new(pb.Config)
+		if err := r.GetData().UnmarshalTo(cfg); err != nil {
+			return nil, err
+		}
+		routes = append(routes, cfg.GetRoutes()...)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].GetFrom() < routes[j].GetFrom() })
+	return routes, nil
+}
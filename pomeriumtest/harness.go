@@ -0,0 +1,143 @@
+// Package pomeriumtest provides helpers to test the ingress controller against a local kubernetes API server,
+// with a recording PomeriumReconciler or an in-memory databroker in place of the pomerium configuration
+package pomeriumtest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	goruntime "runtime"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/pomerium/ingress-controller/apis/v1alpha1"
+	"github.com/pomerium/ingress-controller/controllers"
+)
+
+// Harness is a kubernetes API server started via envtest, with a client to it.
+// The API server binaries are located via KUBEBUILDER_ASSETS environment variable.
+type Harness struct {
+	*envtest.Environment
+	client.Client
+}
+
+// StartHarness starts the test API server with the controller CRDs installed, that should be stopped with Stop
+func StartHarness() (*Harness, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	useExistingCluster := false
+	env := &envtest.Environment{
+		Scheme:                scheme,
+		UseExistingCluster:    &useExistingCluster,
+		CRDDirectoryPaths:     []string{crdDirectory()},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		return nil, fmt.Errorf("start test environment: %w", err)
+	}
+
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		_ = env.Stop()
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	return &Harness{Environment: env, Client: c}, nil
+}
+
+// crdDirectory returns the path of the CRD manifests, regardless of the package the tests are run from
+func crdDirectory() string {
+	_, file, _, _ := goruntime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "config", "crd", "bases")
+}
+
+// Stop stops the test API server
+func (h *Harness) Stop() error {
+	return h.Environment.Stop()
+}
+
+// Controller is an ingress controller connected to the harness API server,
+// that sends the resulting configuration to the recording Reconciler, unless created with another reconciler
+type Controller struct {
+	*Reconciler
+	// State provides access to the controller caches and managed ingresses
+	State *controllers.State
+
+	mgr    ctrl.Manager
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// NewController creates an ingress controller with the provided options, that should then be started with Start
+func (h *Harness) NewController(opts ...controllers.Option) (*Controller, error) {
+	r := new(Reconciler)
+	c, err := h.NewControllerWithReconciler(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.Reconciler = r
+	return c, nil
+}
+
+// NewControllerWithReconciler creates an ingress controller that applies the configuration via provided reconciler,
+// i.e. pomerium.ConfigReconciler, in which case the recording Reconciler methods are not available
+func (h *Harness) NewControllerWithReconciler(pcr controllers.PomeriumReconciler, opts ...controllers.Option) (*Controller, error) {
+	mgr, state, err := controllers.NewIngressControllerWithState(h.Environment.Config,
+		ctrl.Options{Scheme: h.Environment.Scheme},
+		pcr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Controller{State: state, mgr: mgr}, nil
+}
+
+// StartController creates and starts an ingress controller, that should be stopped with Controller.Stop
+func (h *Harness) StartController(opts ...controllers.Option) (*Controller, error) {
+	c, err := h.NewController(opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.Start()
+	return c, nil
+}
+
+// StartControllerWithReconciler creates and starts an ingress controller that applies the configuration
+// via provided reconciler, that should be stopped with Controller.Stop
+func (h *Harness) StartControllerWithReconciler(pcr controllers.PomeriumReconciler, opts ...controllers.Option) (*Controller, error) {
+	c, err := h.NewControllerWithReconciler(pcr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.Start()
+	return c, nil
+}
+
+// Start runs the controller manager in background
+func (c *Controller) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan error, 1)
+
+	go func() {
+		c.done <- c.mgr.Start(ctx)
+	}()
+}
+
+// Stop stops the controller manager and waits for it to complete
+func (c *Controller) Stop() error {
+	if c.cancel == nil {
+		return nil
+	}
+	c.cancel()
+	return <-c.done
+}
@@ -0,0 +1,116 @@
+package pomeriumtest
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Objects is a minimal set of resources for an ingress to be picked up by the controller
+type Objects struct {
+	*networkingv1.IngressClass
+	*networkingv1.Ingress
+	*corev1.Endpoints
+	*discoveryv1.EndpointSlice
+	*corev1.Service
+	*corev1.Secret
+}
+
+// InitialObjects returns an ingress class handled by controllerName,
+// and an ingress in the namespace with the TLS secret and backend service it references.
+// the service endpoints are provided both as the EndpointSlice and the legacy Endpoints,
+// as the test environment does not run the controllers that would mirror them
+func InitialObjects(namespace, controllerName string) *Objects {
+	typePrefix := networkingv1.PathTypePrefix
+	icsName := "pomerium"
+	return &Objects{
+		&networkingv1.IngressClass{
+			ObjectMeta: metav1.ObjectMeta{Name: icsName, Namespace: namespace},
+			Spec: networkingv1.IngressClassSpec{
+				Controller: controllerName,
+			},
+		},
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: namespace},
+			Spec: networkingv1.IngressSpec{
+				IngressClassName: &icsName,
+				TLS: []networkingv1.IngressTLS{{
+					Hosts:      []string{"service.localhost.pomerium.io"},
+					SecretName: "secret",
+				}},
+				Rules: []networkingv1.IngressRule{{
+					Host: "service.localhost.pomerium.io",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{{
+								Path:     "/",
+								PathType: &typePrefix,
+								Backend: networkingv1.IngressBackend{
+									Service: &networkingv1.IngressServiceBackend{
+										Name: "service",
+										Port: networkingv1.ServiceBackendPort{
+											Name: "http",
+										},
+									},
+								},
+							}},
+						},
+					},
+				}},
+			},
+		},
+		&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "service",
+				Namespace: namespace,
+			},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
+			}},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "service-1",
+				Namespace: namespace,
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "service"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"1.2.3.4"}}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "service",
+				Namespace: namespace,
+			},
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.IntOrString{IntVal: 80},
+				}},
+			},
+			Status: corev1.ServiceStatus{},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "secret",
+				Namespace: namespace,
+			},
+			Data: map[string][]byte{
+				corev1.TLSPrivateKeyKey: []byte("A"),
+				corev1.TLSCertKey:       []byte("A"),
+			},
+			Type: corev1.SecretTypeTLS,
+		},
+	}
+}
+
+// All returns the objects in the order they should be created in,
+// with the ingress last so that it is reconciled once all of its dependencies exist
+func (o *Objects) All() []client.Object {
+	return []client.Object{o.IngressClass, o.Endpoints, o.EndpointSlice, o.Service, o.Secret, o.Ingress}
+}
@@ -0,0 +1,191 @@
+package pomeriumtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/pomerium/ingress-controller/controllers"
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	upsertTimeout     = time.Second * 30
+	lastDeleteTimeout = time.Second
+	deleteTimeout     = time.Second * 10
+	neverTimeout      = time.Second
+	pollInterval      = time.Millisecond * 50
+)
+
+var (
+	_ controllers.PomeriumReconciler = &Reconciler{}
+	_ controllers.SettingsReconciler = &Reconciler{}
+)
+
+// Reconciler is a controllers.PomeriumReconciler that records the calls it receives,
+// so that tests may assert how the ingress controller reacts to the resource changes.
+// The zero value is ready to use.
+type Reconciler struct {
+	sync.RWMutex
+	lastUpsert *model.IngressConfig
+	lastDelete *types.NamespacedName
+	// deleted keeps all ingress names Delete was called for
+	deleted map[types.NamespacedName]bool
+	// revisionErr is set if upserted revisions were not increasing
+	revisionErr string
+	// lastSettings are the last applied global settings
+	lastSettings *model.GlobalSettings
+}
+
+// Upsert implements controllers.PomeriumReconciler
+func (r *Reconciler) Upsert(ctx context.Context, ic *model.IngressConfig) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.lastUpsert != nil && ic.Revision <= r.lastUpsert.Revision {
+		r.revisionErr = fmt.Sprintf("revision %d upserted after %d", ic.Revision, r.lastUpsert.Revision)
+	}
+	r.lastUpsert = ic.Clone()
+	r.lastDelete = nil
+	return true, nil
+}
+
+// Delete implements controllers.PomeriumReconciler
+func (r *Reconciler) Delete(ctx context.Context, name types.NamespacedName) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.lastDelete = &name
+	r.lastUpsert = nil
+	if r.deleted == nil {
+		r.deleted = make(map[types.NamespacedName]bool)
+	}
+	r.deleted[name] = true
+	return nil
+}
+
+// Set implements controllers.PomeriumReconciler.
+// The tests are expected to start with no ingresses, so it fails the initial sync otherwise.
+func (r *Reconciler) Set(ctx context.Context, ics []*model.IngressConfig) (bool, error) {
+	if len(ics) != 0 {
+		return false, errors.New("unexpected ingresses")
+	}
+	return false, nil
+}
+
+// SetSettings implements controllers.SettingsReconciler
+func (r *Reconciler) SetSettings(ctx context.Context, gs *model.GlobalSettings) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.lastSettings = nil
+	if gs != nil {
+		r.lastSettings = &model.GlobalSettings{
+			Pomerium:               gs.Pomerium.DeepCopy(),
+			IdentityProviderSecret: gs.IdentityProviderSecret.DeepCopy(),
+		}
+		for _, secret := range gs.Certificates {
+			r.lastSettings.Certificates = append(r.lastSettings.Certificates, secret.DeepCopy())
+		}
+	}
+	return true, nil
+}
+
+// LastSettings returns the last applied global settings, or nil if there were none or they were removed
+func (r *Reconciler) LastSettings() *model.GlobalSettings {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.lastSettings
+}
+
+// LastUpsert returns a copy of the last upserted ingress config,
+// or nil if there were no upserts since the last Delete
+func (r *Reconciler) LastUpsert() *model.IngressConfig {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.lastUpsert == nil {
+		return nil
+	}
+	return r.lastUpsert.Clone()
+}
+
+// Deleted checks whether Delete was ever called for the ingress
+func (r *Reconciler) Deleted(name types.NamespacedName) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.deleted[name]
+}
+
+// EventuallyUpsert waits until the last upserted ingress config satisfies diffFn,
+// that should return an empty string if the config matches expectations, or a diff otherwise
+func (r *Reconciler) EventuallyUpsert(t testing.TB, diffFn func(current *model.IngressConfig) string, msg string) {
+	t.Helper()
+	var diff string
+
+	if !assert.Eventually(t, r.diffFn(diffFn, &diff), upsertTimeout, pollInterval) {
+		t.Fatalf("condition %q never satisfied: %s", msg, diff)
+	}
+}
+
+// NeverUpsert asserts that the upserted ingress config does not satisfy diffFn for some time
+func (r *Reconciler) NeverUpsert(t testing.TB, diffFn func(current *model.IngressConfig) string) {
+	t.Helper()
+	var diff string
+	require.Never(t, r.diffFn(diffFn, &diff), neverTimeout, pollInterval)
+}
+
+// EventuallyDeleted waits until the last Delete call is for the provided ingress name
+func (r *Reconciler) EventuallyDeleted(t testing.TB, name types.NamespacedName) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		r.Lock()
+		defer r.Unlock()
+
+		if r.lastDelete == nil {
+			return false
+		}
+		val := *r.lastDelete == name
+		r.lastDelete = nil
+		return val
+	}, lastDeleteTimeout, pollInterval, "lastDeleted != %s", name)
+}
+
+// EventuallyAllDeleted waits until Delete was called for each of the provided ingress names, in any order
+func (r *Reconciler) EventuallyAllDeleted(t testing.TB, names ...types.NamespacedName) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		for _, name := range names {
+			if !r.Deleted(name) {
+				return false
+			}
+		}
+		return true
+	}, deleteTimeout, pollInterval, "expected all of %v to be deleted", names)
+}
+
+func (r *Reconciler) diffFn(diffFn func(current *model.IngressConfig) string, diff *string) func() bool {
+	return func() bool {
+		r.RLock()
+		defer r.RUnlock()
+
+		if r.lastUpsert == nil {
+			*diff = "lastUpsert == nil"
+			return false
+		}
+		if r.lastDelete != nil {
+			*diff = fmt.Sprintf("lastDelete = %s", *r.lastDelete)
+		}
+		*diff = diffFn(r.lastUpsert) + r.revisionErr
+		return *diff == ""
+	}
+}
@@ -0,0 +1,70 @@
+package translate
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// warningUnsupportedAppProtocol is reported if the backend service port declares an appProtocol
+// that Pomerium cannot talk to the upstream with, and HTTP/1.1 is used instead
+const warningUnsupportedAppProtocol = "UnsupportedAppProtocol"
+
+// appProtocolSecure checks whether the service port appProtocol declares a TLS upstream.
+// the plain HTTP, unrecognized and HTTP/2 cleartext protocols are not, as Pomerium v0.17.x
+// only negotiates HTTP/2 with the upstream via TLS ALPN
+func appProtocolSecure(appProtocol string) bool {
+	switch strings.ToLower(appProtocol) {
+	case "https", "kubernetes.io/wss":
+		return true
+	}
+	return false
+}
+
+// appProtocolUnsupported checks whether the service port appProtocol asks for HTTP/2 cleartext
+func appProtocolUnsupported(appProtocol string) bool {
+	switch strings.ToLower(appProtocol) {
+	case "h2c", "kubernetes.io/h2c", "grpc":
+		return true
+	}
+	return false
+}
+
+// getServicePort returns the service port the ingress backend refers to, or nil if the service does not define it,
+// i.e. the ExternalName service without ports
+func getServicePort(service *corev1.Service, port networkingv1.ServiceBackendPort) *corev1.ServicePort {
+	for i := range service.Spec.Ports {
+		sp := &service.Spec.Ports[i]
+		if (port.Name != "" && sp.Name == port.Name) || (port.Name == "" && sp.Port == port.Number) {
+			return sp
+		}
+	}
+	return nil
+}
+
+// isSecureUpstream checks whether the backend service port is served over TLS, as set by the secure_upstream annotation,
+// or otherwise declared by the service port appProtocol
+func isSecureUpstream(ic *model.IngressConfig, port *corev1.ServicePort) bool {
+	if txt, ok := ic.Ingress.Annotations[fmt.Sprintf("%s/%s", ic.AnnotationPrefix, model.SecureUpstream)]; ok {
+		return strings.EqualFold(txt, "true")
+	}
+	if port == nil || port.AppProtocol == nil {
+		return false
+	}
+	if appProtocolUnsupported(*port.AppProtocol) {
+		ic.Warn(warningUnsupportedAppProtocol, fmt.Sprintf("service port %s appProtocol %s: HTTP/2 cleartext upstreams are not supported, using HTTP/1.1",
+			servicePortName(port), *port.AppProtocol))
+	}
+	return appProtocolSecure(*port.AppProtocol)
+}
+
+func servicePortName(port *corev1.ServicePort) string {
+	if port.Name != "" {
+		return port.Name
+	}
+	return fmt.Sprint(port.Port)
+}
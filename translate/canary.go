@@ -0,0 +1,56 @@
+package translate
+
+import (
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// applyCanary splits the route traffic between its destinations and the canary service ones, if set.
+// pomerium takes the load balancing weight of each destination appended to its URL,
+// hence the weights are scaled so that each group receives its share regardless of the number of endpoints
+func applyCanary(r *pb.Route, ic *model.IngressConfig) error {
+	c, err := ic.GetCanary()
+	if err != nil || c == nil || c.Weight == 0 {
+		return err
+	}
+
+	// the upstream TLS server name of the route is kept, so that it is not derived from the canary service name
+	canary, err := getServiceURLs(&pb.Route{TlsServerName: r.TlsServerName}, networkingv1.HTTPIngressPath{
+		Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+			Name: c.ServiceName,
+			Port: c.ServicePort,
+		}},
+	}, ic)
+	if err != nil {
+		return fmt.Errorf("%s: %w", model.Canary, err)
+	}
+	if c.Weight == 100 {
+		r.To = canary
+		return nil
+	}
+
+	primaryWeight := uint64(100-c.Weight) * uint64(len(canary))
+	canaryWeight := uint64(c.Weight) * uint64(len(r.To))
+	d := gcd(primaryWeight, canaryWeight)
+	to := make([]string, 0, len(r.To)+len(canary))
+	for _, u := range r.To {
+		to = append(to, fmt.Sprintf("%s,%d", u, primaryWeight/d))
+	}
+	for _, u := range canary {
+		to = append(to, fmt.Sprintf("%s,%d", u, canaryWeight/d))
+	}
+	r.To = to
+	return nil
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
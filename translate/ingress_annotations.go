@@ -0,0 +1,814 @@
+package translate
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"unicode"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/open-policy-agent/opa/ast"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	pomerium "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const (
+	// CAKey is certificate authority secret key name
+	CAKey = "ca.crt"
+
+	// routeTimeout is the overall request timeout, and routeIdleTimeout is the timeout of a stream with no activity,
+	// that may need to be longer than the request timeout i.e. for the server-sent events
+	routeTimeout     = "timeout"
+	routeIdleTimeout = "idle_timeout"
+
+	// AllowedSourceRanges restricts the route to the client addresses within the listed CIDR ranges
+	AllowedSourceRanges = "allowed_source_ranges"
+	allowedMethods      = "allowed_methods"
+	// allowedIdpClaims is a map of the identity provider claim names to the lists of allowed values
+	allowedIdpClaims = "allowed_idp_claims"
+	// setResponseHeaders sets the response headers of the route, an empty value suppresses the controller default
+	setResponseHeaders = "set_response_headers"
+	// rewriteResponseHeaders is a list of the response header value prefix rewrites, applied in order
+	rewriteResponseHeaders = "rewrite_response_headers"
+	// healthChecks is a list of envoy active health checks of the route upstream endpoints
+	healthChecks = "health_checks"
+	// allowPublicUnauthenticatedAccess opens the route to anyone, while allowAnyAuthenticatedUser requires a login
+	allowPublicUnauthenticatedAccess = "allow_public_unauthenticated_access"
+	allowAnyAuthenticatedUser        = "allow_any_authenticated_user"
+	// hostRewrite sets the upstream Host header to a literal value, hostRewriteHeader copies it from a request header,
+	// and preserveHostHeader passes the original Host header, these are mutually exclusive
+	hostRewrite        = "host_rewrite"
+	hostRewriteHeader  = "host_rewrite_header"
+	preserveHostHeader = "preserve_host_header"
+	// tlsSkipVerify disables the upstream certificate verification of the route
+	tlsSkipVerify = "tls_skip_verify"
+	// sourceAddressHeader has the downstream peer address appended by envoy to any value sent by the client,
+	// as pomerium enables use_remote_address, unless skip_xff_append is set.
+	// the policy input of pomerium has no client address otherwise
+	sourceAddressHeader = "X-Forwarded-For"
+)
+
+var (
+	baseAnnotations = boolMap([]string{
+		"cors_allow_preflight",
+		allowPublicUnauthenticatedAccess,
+		allowAnyAuthenticatedUser,
+		routeTimeout,
+		routeIdleTimeout,
+		"allow_spdy",
+		"allow_websockets",
+		"set_request_headers",
+		"remove_request_headers",
+		setResponseHeaders,
+		rewriteResponseHeaders,
+		preserveHostHeader,
+		hostRewrite,
+		hostRewriteHeader,
+		"host_path_regex_rewrite_pattern",
+		"host_path_regex_rewrite_substitution",
+		"pass_identity_headers",
+		tlsSkipVerify,
+		model.TLSServerName,
+		"prefix_rewrite",
+		"regex_rewrite_pattern",
+		"regex_rewrite_substitution",
+		model.Redirect,
+	})
+	// subjectListAnnotations list the users, groups or domains allowed to access the route,
+	// either as a YAML list or a comma separated string
+	subjectListAnnotations = boolMap([]string{
+		"allowed_users",
+		"allowed_groups",
+		"allowed_domains",
+	})
+	policyAnnotations = boolMap([]string{
+		"allowed_users",
+		"allowed_groups",
+		"allowed_domains",
+		allowedIdpClaims,
+		pplAnnotation,
+		model.PolicyConfigMap,
+		AllowedSourceRanges,
+		allowedMethods,
+		allowedTimeWindows,
+	})
+	envoyAnnotations = boolMap([]string{
+		healthChecks,
+		"outlier_detection",
+		"lb_policy",
+		"least_request_lb_config",
+		"ring_hash_lb_config",
+		"maglev_lb_config",
+	})
+	tlsAnnotations = boolMap([]string{
+		model.TLSCustomCASecret,
+		model.TLSClientSecret,
+		model.TLSDownstreamClientCASecret,
+	})
+	secretAnnotations = boolMap([]string{
+		model.KubernetesServiceAccountTokenSecret,
+		model.SetRequestHeadersSecret,
+		model.SetResponseHeadersSecret,
+	})
+	handledElsewhere = boolMap([]string{
+		model.SecureUpstream,
+		model.PathRegex,
+		model.CaseInsensitivePaths,
+		model.CombinePaths,
+		model.UseServiceProxy,
+		model.UseNotReadyEndpoints,
+		model.TCPUpstream,
+		model.ListenerPort,
+		model.SyncStateAnnotation,
+		model.LastAppliedGeneration,
+		model.ReconcileError,
+		model.DisableDefaultHeaders,
+		model.LongLivedConnections,
+		model.RouteTTL,
+		model.StatusFromService,
+		model.Canary,
+	})
+)
+
+func boolMap(keys []string) map[string]bool {
+	out := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		out[k] = true
+	}
+	return out
+}
+
+type keys struct {
+	Base, Envoy, Policy, TLS, Etc, Secret map[string]string
+}
+
+func removeKeyPrefix(src map[string]string, prefix string) (*keys, error) {
+	prefix = fmt.Sprintf("%s/", prefix)
+	kv := keys{
+		Base:   make(map[string]string),
+		Envoy:  make(map[string]string),
+		Policy: make(map[string]string),
+		TLS:    make(map[string]string),
+		Etc:    make(map[string]string),
+		Secret: make(map[string]string),
+	}
+	for k, v := range src {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		k = strings.TrimPrefix(k, prefix)
+		known := false
+		for _, m := range []struct {
+			keys map[string]bool
+			dst  map[string]string
+		}{
+			{baseAnnotations, kv.Base},
+			{envoyAnnotations, kv.Envoy},
+			{policyAnnotations, kv.Policy},
+			{tlsAnnotations, kv.TLS},
+			{secretAnnotations, kv.Secret},
+			{handledElsewhere, kv.Etc},
+		} {
+			if m.keys[k] {
+				m.dst[k] = v
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown %s%s", prefix, k)
+		}
+	}
+	return &kv, nil
+}
+
+func toJSON(src map[string]string) ([]byte, error) {
+	dst := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		out := new(interface{})
+		if err := yaml.Unmarshal([]byte(v), out); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		dst[k] = *out
+	}
+
+	return json.Marshal(dst)
+}
+
+// applyAnnotations applies ingress annotations to a route
+func applyAnnotations(
+	r *pomerium.Route,
+	ic *model.IngressConfig,
+) error {
+	kv, err := removeKeyPrefix(ic.Ingress.Annotations, ic.AnnotationPrefix)
+	if err != nil {
+		return err
+	}
+
+	expandLongLivedConnections(kv.Base, ic)
+	applyClassDefaults(kv.Base, ic)
+	if err = unmarshallAnnotations(r, kv.Base); err != nil {
+		return err
+	}
+	if err = validateTimeouts(r); err != nil {
+		return err
+	}
+	if err = validateHealthChecks(kv.Envoy); err != nil {
+		return err
+	}
+	r.EnvoyOpts = new(envoy_config_cluster_v3.Cluster)
+	if err = unmarshallAnnotations(r.EnvoyOpts, kv.Envoy); err != nil {
+		return err
+	}
+	if err = applyTLSAnnotations(r, kv.TLS, ic.Secrets, ic.Ingress.Namespace); err != nil {
+		return err
+	}
+	if ic.SkipCertificates {
+		r.TlsDownstreamClientCa = ""
+	}
+	if err = applySecretAnnotations(r, kv.Secret, ic.Secrets, ic.Ingress.Namespace); err != nil {
+		return err
+	}
+	applyDefaultResponseHeaders(r, ic)
+	if err = validateResponseHeaders(r); err != nil {
+		return fmt.Errorf("%s: %w", setResponseHeaders, err)
+	}
+	if err = validateRewriteResponseHeaders(r); err != nil {
+		return fmt.Errorf("%s: %w", rewriteResponseHeaders, err)
+	}
+	if err = validateTLSServerName(r.TlsServerName); err != nil {
+		return fmt.Errorf("%s: %w", model.TLSServerName, err)
+	}
+	if err = validateHostRewrite(r, ic); err != nil {
+		return err
+	}
+	if err = applyRedirect(r, ic); err != nil {
+		return err
+	}
+	if r.AllowPublicUnauthenticatedAccess && r.AllowAnyAuthenticatedUser {
+		return fmt.Errorf("ingress %s: %s cannot be combined with %s",
+			ic.GetIngressNamespacedName(), allowAnyAuthenticatedUser, allowPublicUnauthenticatedAccess)
+	}
+	p := new(pomerium.Policy)
+	r.Policies = []*pomerium.Policy{p}
+	if err := unmarshallPolicyAnnotations(p, kv.Policy, ic, r.GetCorsAllowPreflight()); err != nil {
+		return fmt.Errorf("applying policy annotations: %w", err)
+	}
+	return nil
+}
+
+// applyClassDefaults sets the route annotations the IngressClass parameters set by default,
+// unless the ingress or its backend service sets them
+func applyClassDefaults(base map[string]string, ic *model.IngressConfig) {
+	for k, v := range ic.ClassDefaults {
+		if _, ok := base[k]; !ok {
+			base[k] = v
+		}
+	}
+}
+
+// validateTimeouts rejects the negative route timeouts, that are otherwise accepted as valid durations
+func validateTimeouts(r *pomerium.Route) error {
+	for _, t := range []struct {
+		name string
+		d    *durationpb.Duration
+	}{
+		{routeTimeout, r.Timeout},
+		{routeIdleTimeout, r.IdleTimeout},
+	} {
+		if t.d != nil && t.d.AsDuration() < 0 {
+			return fmt.Errorf("%s: negative duration %s is not allowed, use 0s to disable the timeout", t.name, t.d.AsDuration())
+		}
+	}
+	return nil
+}
+
+// applyDefaultResponseHeaders adds the controller default response headers to the route,
+// that take precedence over the defaults if set via annotations.
+// a header set to an empty string suppresses the default, and is not set on the route
+func applyDefaultResponseHeaders(r *pomerium.Route, ic *model.IngressConfig) {
+	defer removeEmptyResponseHeaders(r)
+	if len(ic.DefaultResponseHeaders) == 0 || ic.IsAnnotationSet(model.DisableDefaultHeaders) {
+		return
+	}
+	set := make(map[string]bool, len(r.SetResponseHeaders))
+	for k := range r.SetResponseHeaders {
+		set[http.CanonicalHeaderKey(k)] = true
+	}
+	for k, v := range ic.DefaultResponseHeaders {
+		if set[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		if r.SetResponseHeaders == nil {
+			r.SetResponseHeaders = make(map[string]string, len(ic.DefaultResponseHeaders))
+		}
+		r.SetResponseHeaders[k] = v
+	}
+}
+
+// validateResponseHeaders rejects the multi-line header values, i.e. from a literal YAML block,
+// that envoy would refuse. a folded >- block should be used for the long values instead
+func validateResponseHeaders(r *pomerium.Route) error {
+	for k, v := range r.SetResponseHeaders {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("%s: header value must not contain line breaks, use a folded >- YAML block for long values", k)
+		}
+	}
+	return nil
+}
+
+// validateRewriteResponseHeaders rejects the rules without a header name, that would not match any header
+func validateRewriteResponseHeaders(r *pomerium.Route) error {
+	for i, rw := range r.RewriteResponseHeaders {
+		if strings.TrimSpace(rw.GetHeader()) == "" {
+			return fmt.Errorf("[%d]: header name is required", i)
+		}
+	}
+	return nil
+}
+
+// validateTLSServerName checks the upstream SNI is a plausible hostname, as SNI may not hold an IP address
+func validateTLSServerName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if net.ParseIP(name) != nil {
+		return fmt.Errorf("%q is an IP address, expected a hostname", name)
+	}
+	if errs := validation.IsDNS1123Subdomain(strings.ToLower(name)); len(errs) > 0 {
+		return fmt.Errorf("%q is not a valid hostname: %s", name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validateHealthChecks parses the health checks on their own, so that the errors name the annotation,
+// and checks the fields envoy requires, i.e. the interval, are set
+func validateHealthChecks(kvs map[string]string) error {
+	txt, ok := kvs[healthChecks]
+	if !ok {
+		return nil
+	}
+	c := new(envoy_config_cluster_v3.Cluster)
+	if err := unmarshallAnnotations(c, map[string]string{healthChecks: txt}); err != nil {
+		return fmt.Errorf("%s: %w", healthChecks, err)
+	}
+	for i, hc := range c.HealthChecks {
+		if err := hc.Validate(); err != nil {
+			return fmt.Errorf("%s[%d]: %w", healthChecks, i, err)
+		}
+	}
+	return nil
+}
+
+// validateHostRewrite rejects the contradicting upstream Host header options, of which envoy would only apply one
+func validateHostRewrite(r *pomerium.Route, ic *model.IngressConfig) error {
+	var set []string
+	if r.HostRewrite != nil {
+		set = append(set, hostRewrite)
+	}
+	if r.HostRewriteHeader != nil {
+		set = append(set, hostRewriteHeader)
+	}
+	if r.PreserveHostHeader {
+		set = append(set, preserveHostHeader)
+	}
+	if len(set) > 1 {
+		return fmt.Errorf("ingress %s: %s cannot be combined with %s",
+			ic.GetIngressNamespacedName(), set[0], strings.Join(set[1:], ", "))
+	}
+	return nil
+}
+
+func removeEmptyResponseHeaders(r *pomerium.Route) {
+	for k, v := range r.SetResponseHeaders {
+		if v == "" {
+			delete(r.SetResponseHeaders, k)
+		}
+	}
+	if len(r.SetResponseHeaders) == 0 {
+		r.SetResponseHeaders = nil
+	}
+}
+
+func unmarshallPolicyAnnotations(p *pomerium.Policy, kvs map[string]string, ic *model.IngressConfig, corsAllowPreflight bool) error {
+	if err := validatePPLExclusive(kvs); err != nil {
+		return err
+	}
+	ppl, hasPPL, err := getPPL(kvs, ic)
+	if err != nil {
+		return err
+	}
+	sourceRanges, hasSourceRanges := kvs[AllowedSourceRanges]
+	if hasSourceRanges {
+		delete(kvs, AllowedSourceRanges)
+	}
+	methods, hasMethods := kvs[allowedMethods]
+	if hasMethods {
+		delete(kvs, allowedMethods)
+	}
+	timeWindows, hasTimeWindows := kvs[allowedTimeWindows]
+	if hasTimeWindows {
+		delete(kvs, allowedTimeWindows)
+	}
+	for k, v := range kvs {
+		if !subjectListAnnotations[k] {
+			continue
+		}
+		list, err := subjectList(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		kvs[k] = list
+	}
+	if claims, ok := kvs[allowedIdpClaims]; ok {
+		src, err := idpClaims(claims)
+		if err != nil {
+			return fmt.Errorf("%s: %w", allowedIdpClaims, err)
+		}
+		kvs[allowedIdpClaims] = src
+	}
+
+	if err := unmarshallAnnotations(p, kvs); err != nil {
+		return err
+	}
+
+	if hasPPL {
+		src, err := pplToRego(ppl)
+		if err != nil {
+			return fmt.Errorf("parsing policy: %w", err)
+		}
+		if err = addRego(p, src); err != nil {
+			return err
+		}
+	}
+
+	if hasSourceRanges {
+		src, err := sourceRangesRego(sourceRanges)
+		if err != nil {
+			return fmt.Errorf("%s: %w", AllowedSourceRanges, err)
+		}
+		if err = addRego(p, src); err != nil {
+			return err
+		}
+	}
+
+	if hasMethods {
+		src, err := allowedMethodsRego(methods, corsAllowPreflight)
+		if err != nil {
+			return fmt.Errorf("%s: %w", allowedMethods, err)
+		}
+		if err = addRego(p, src); err != nil {
+			return err
+		}
+	}
+
+	if hasTimeWindows {
+		src, err := timeWindowsRego(timeWindows)
+		if err != nil {
+			return fmt.Errorf("%s: %w", allowedTimeWindows, err)
+		}
+		if err = addRego(p, src); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// subjectList parses either a YAML list or a comma separated string into a JSON list,
+// rejecting empty lists, as an empty allowed list is most likely a mistake rather than an intent to deny everyone
+func subjectList(txt string) (string, error) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(txt), &v); err != nil {
+		return "", fmt.Errorf("expected a list: %w", err)
+	}
+
+	var items []string
+	switch v := v.(type) {
+	case nil:
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("expected a list of strings, got %v", item)
+			}
+			if str = strings.TrimSpace(str); str == "" {
+				return "", fmt.Errorf("empty values are not allowed")
+			}
+			items = append(items, str)
+		}
+	default:
+		return "", fmt.Errorf("expected a list or a comma separated string, got %v", v)
+	}
+	if len(items) == 0 {
+		return "", fmt.Errorf("at least one value is required")
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// idpClaims parses a YAML map of the claim names to the allowed values into JSON,
+// where a single value is normalized into a list, as a policy expects a list of values for each claim
+func idpClaims(txt string) (string, error) {
+	var claims map[string]interface{}
+	if err := yaml.Unmarshal([]byte(txt), &claims); err != nil {
+		return "", fmt.Errorf("expected a map of claim names to the allowed values: %w", err)
+	}
+	if len(claims) == 0 {
+		return "", fmt.Errorf("at least one claim is required")
+	}
+
+	for name, v := range claims {
+		if name == "" {
+			return "", fmt.Errorf("claim name is required")
+		}
+		switch v := v.(type) {
+		case nil:
+			return "", fmt.Errorf("%s: at least one value is required", name)
+		case []interface{}:
+			if len(v) == 0 {
+				return "", fmt.Errorf("%s: at least one value is required", name)
+			}
+		default:
+			claims[name] = []interface{}{v}
+		}
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func addRego(p *pomerium.Policy, src string) error {
+	_, err := ast.ParseModule("policy.rego", src)
+	if err != nil && strings.Contains(err.Error(), "package expected") {
+		_, err = ast.ParseModule("policy.rego", "package pomerium.policy\n\n"+src)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid custom rego: %w", err)
+	}
+
+	p.Rego = append(p.Rego, src)
+	return nil
+}
+
+// sourceRangesRego generates a rego that denies access to the route
+// unless the client address is within one of the provided CIDR ranges.
+// the client address is the last X-Forwarded-For entry, that envoy sets itself for every request,
+// the entries before it are sent by the client and are not trusted
+func sourceRangesRego(txt string) (string, error) {
+	var ranges []string
+	if err := yaml.Unmarshal([]byte(txt), &ranges); err != nil {
+		return "", fmt.Errorf("expected a list of CIDR ranges: %w", err)
+	}
+	if len(ranges) == 0 {
+		return "", fmt.Errorf("at least one CIDR range is required")
+	}
+
+	cidrs := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			return "", err
+		}
+		cidrs = append(cidrs, ipNet.String())
+	}
+	data, err := json.Marshal(cidrs)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`package pomerium.policy
+
+deny = [true, {"source-ip-unauthorized"}] {
+	not source_ip_allowed
+}
+
+source_ip := trim_space(addrs[count(addrs) - 1]) {
+	addrs := split(input.http.headers[%q], ",")
+}
+
+source_ip_allowed {
+	cidr := %s[_]
+	net.cidr_contains(cidr, source_ip)
+}
+`, sourceAddressHeader, data), nil
+}
+
+var standardMethods = boolMap([]string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+})
+
+// allowedMethodsRego generates a rego that denies access to the route
+// unless the request method is one of the provided HTTP methods.
+// if CORS preflight is allowed for the route, preflight requests are not denied
+// even if OPTIONS is not listed, as otherwise cors_allow_preflight would have no effect
+func allowedMethodsRego(txt string, corsAllowPreflight bool) (string, error) {
+	var methods []string
+	if err := yaml.Unmarshal([]byte(txt), &methods); err != nil {
+		return "", fmt.Errorf("expected a list of HTTP methods: %w", err)
+	}
+	if len(methods) == 0 {
+		return "", fmt.Errorf("at least one HTTP method is required")
+	}
+
+	for i, m := range methods {
+		m = strings.ToUpper(m)
+		if !standardMethods[m] {
+			return "", fmt.Errorf("unknown HTTP method %q", methods[i])
+		}
+		methods[i] = m
+	}
+	data, err := json.Marshal(methods)
+	if err != nil {
+		return "", err
+	}
+
+	preflight := ""
+	if corsAllowPreflight {
+		preflight = `
+	not cors_preflight`
+	}
+
+	return fmt.Sprintf(`package pomerium.policy
+
+deny = [true, {"method-not-allowed"}] {
+	not method_allowed%s
+}
+
+method_allowed {
+	input.http.method == %s[_]
+}
+
+cors_preflight {
+	input.http.method == "OPTIONS"
+	input.http.headers["Access-Control-Request-Method"]
+	input.http.headers["Origin"]
+}
+`, preflight, data), nil
+}
+
+func unmarshallAnnotations(m protoreflect.ProtoMessage, kvs map[string]string) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	data, err := toJSON(kvs)
+	if err != nil {
+		return err
+	}
+
+	return (&protojson.UnmarshalOptions{
+		DiscardUnknown: false,
+	}).Unmarshal(data, m)
+}
+
+func applyTLSAnnotations(
+	r *pomerium.Route,
+	kvs map[string]string,
+	secrets map[types.NamespacedName]*corev1.Secret,
+	namespace string,
+) error {
+	for k, name := range kvs {
+		secret := secrets[types.NamespacedName{Namespace: namespace, Name: name}]
+		if secret == nil {
+			return fmt.Errorf("annotation %s references secret %s, but the secret wasn't fetched. this is a bug", k, name)
+		}
+		var err error
+		switch k {
+		case model.TLSCustomCASecret:
+			if r.TlsCustomCa, err = b64(secret, k, CAKey); err != nil {
+				return err
+			}
+		case model.TLSClientSecret:
+			if r.TlsClientCert, err = b64(secret, k, corev1.TLSCertKey); err != nil {
+				return err
+			}
+			if r.TlsClientKey, err = b64(secret, k, corev1.TLSPrivateKeyKey); err != nil {
+				return err
+			}
+		case model.TLSDownstreamClientCASecret:
+			if r.TlsDownstreamClientCa, err = b64(secret, k, CAKey); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown annotation %s", k)
+		}
+	}
+	if r.TlsSkipVerify && r.TlsCustomCa != "" {
+		return fmt.Errorf("%s cannot be combined with %s, as the upstream certificate would not be verified against the custom CA",
+			tlsSkipVerify, model.TLSCustomCASecret)
+	}
+	return nil
+}
+
+func applySecretAnnotations(
+	r *pomerium.Route,
+	kvs map[string]string,
+	secrets map[types.NamespacedName]*corev1.Secret,
+	namespace string,
+) error {
+	for k, name := range kvs {
+		secret := secrets[types.NamespacedName{Namespace: namespace, Name: name}]
+		if secret == nil {
+			return fmt.Errorf("annotation %s references secret %s, but the secret wasn't fetched. this is a bug", k, name)
+		}
+		switch k {
+		case model.KubernetesServiceAccountTokenSecret:
+			token, ok := secret.Data[model.KubernetesServiceAccountTokenSecretKey]
+			if !ok {
+				return fmt.Errorf("annotation %s references secret %s that must have a %s key that is missing",
+					k, name, model.KubernetesServiceAccountTokenSecretKey)
+			}
+			txt, err := serviceAccountToken(token)
+			if err != nil {
+				return fmt.Errorf("annotation %s references secret %s, key %s: %w",
+					k, name, model.KubernetesServiceAccountTokenSecretKey, err)
+			}
+			r.KubernetesServiceAccountToken = txt
+		case model.SetRequestHeadersSecret:
+			dst, err := mergeMaps(r.SetRequestHeaders, secret.Data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", model.SetRequestHeadersSecret, err)
+			}
+			r.SetRequestHeaders = dst
+		case model.SetResponseHeadersSecret:
+			dst, err := mergeMaps(r.SetResponseHeaders, secret.Data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", model.SetResponseHeadersSecret, err)
+			}
+			r.SetResponseHeaders = dst
+		default:
+			return fmt.Errorf("unknown annotation %s", k)
+		}
+	}
+	return nil
+}
+
+// serviceAccountToken trims the trailing line break the token files often have,
+// and rejects the tokens that could not be sent as a bearer token
+func serviceAccountToken(data []byte) (string, error) {
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("token is empty")
+	}
+	if strings.IndexFunc(token, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0 {
+		return "", errors.New("token must not contain whitespace or non-printable characters")
+	}
+	return token, nil
+}
+
+func b64(secret *corev1.Secret, annotation, key string) (string, error) {
+	data := secret.Data[key]
+	if len(data) == 0 {
+		return "", fmt.Errorf("annotation %s references secret %s, key %s has no data",
+			annotation, secret.Name, CAKey)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func mergeMaps(dst map[string]string, src map[string][]byte) (map[string]string, error) {
+	if dst == nil {
+		dst = make(map[string]string)
+	}
+	for key, data := range src {
+		if _, there := dst[key]; there {
+			return nil, fmt.Errorf("secret contains key %s that was already specified by a non-secret rule", key)
+		}
+		dst[key] = string(data)
+	}
+	return dst, nil
+}
@@ -0,0 +1,1139 @@
+package translate
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+var (
+	testPPL = `{"allow":{"or":[{"domain":{"is":"pomerium.com"}}]}}`
+)
+
+func TestAnnotations(t *testing.T) {
+	strp := func(txt string) *string { return &txt }
+	r := &pb.Route{To: []string{"http://upstream.svc.cluster.local"}}
+	ic := &model.IngressConfig{
+		AnnotationPrefix: "a",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: v1.ObjectMeta{
+				Namespace: "test",
+				Annotations: map[string]string{
+					"a/allowed_users":                           `["a"]`,
+					"a/allowed_groups":                          `["a"]`,
+					"a/allowed_domains":                         `["a"]`,
+					"a/allowed_idp_claims":                      `{"key": ["val1", "val2"]}`,
+					"a/cors_allow_preflight":                    "true",
+					"a/allow_public_unauthenticated_access":     "false",
+					"a/allow_any_authenticated_user":            "false",
+					"a/timeout":                                 `10s`,
+					"a/idle_timeout":                            `60s`,
+					"a/allow_websockets":                        "true",
+					"a/allow_spdy":                              "true",
+					"a/set_request_headers":                     `{"a": "aaa"}`,
+					"a/set_request_headers_secret":              `request_headers`,
+					"a/remove_request_headers":                  `["a"]`,
+					"a/set_response_headers":                    `{"c": "ccc"}`,
+					"a/set_response_headers_secret":             `response_headers`,
+					"a/rewrite_response_headers":                `[{"header": "a", "prefix": "b", "value": "c"}]`,
+					"a/host_rewrite":                            "rewrite",
+					"a/host_path_regex_rewrite_pattern":         "rewrite-pattern",
+					"a/host_path_regex_rewrite_substitution":    "rewrite-sub",
+					"a/pass_identity_headers":                   "true",
+					"a/health_checks":                           `[{"timeout": "10s", "interval": "60s", "healthy_threshold": 1, "unhealthy_threshold": 2, "http_health_check": {"path": "/"}}]`,
+					"a/tls_server_name":                         "my.server.name",
+					"a/tls_custom_ca_secret":                    "my_custom_ca_secret",
+					"a/tls_client_secret":                       "my_client_secret",
+					"a/tls_downstream_client_ca_secret":         "my_downstream_client_ca_secret",
+					"a/secure_upstream":                         "true",
+					"a/lb_policy":                               "LEAST_REQUEST",
+					"a/least_request_lb_config":                 `{"choice_count":3,"active_request_bias":{"default_value":4,"runtime_key":"key"},"slow_start_config":{"slow_start_window":"3s","aggression":{"runtime_key":"key"}}}`,
+					"a/prefix_rewrite":                          "/",
+					"a/regex_rewrite_pattern":                   `^/service/([^/]+)(/.*)$`,
+					"a/regex_rewrite_substitution":              `\\2/instance/\\1`,
+					"a/kubernetes_service_account_token_secret": "k8s_token",
+				},
+			},
+		},
+		Secrets: map[types.NamespacedName]*corev1.Secret{
+			{Name: "my_custom_ca_secret", Namespace: "test"}: {
+				Data: map[string][]byte{
+					CAKey: []byte("my_custom_ca_secret+cert"),
+				},
+			},
+			{Name: "my_client_secret", Namespace: "test"}: {
+				Type: corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       []byte("my_client_secret+cert"),
+					corev1.TLSPrivateKeyKey: []byte("my_client_secret+key"),
+				},
+			},
+			{Name: "my_downstream_client_ca_secret", Namespace: "test"}: {
+				Data: map[string][]byte{
+					CAKey: []byte("my_downstream_client_ca_secret+cert"),
+				},
+			},
+			{Name: "k8s_token", Namespace: "test"}: {
+				Data: map[string][]byte{
+					model.KubernetesServiceAccountTokenSecretKey: []byte("k8s-token-data"),
+				},
+			},
+			{Name: "request_headers", Namespace: "test"}: {
+				Data: map[string][]byte{
+					"req_key_1": []byte("req_data1"),
+					"req_key_2": []byte("req_data2"),
+				},
+			},
+			{Name: "response_headers", Namespace: "test"}: {
+				Data: map[string][]byte{
+					"res_key_1": []byte("res_data1"),
+					"res_key_2": []byte("res_data2"),
+				},
+			},
+		},
+	}
+	require.NoError(t, applyAnnotations(r, ic))
+	require.Empty(t, cmp.Diff(r, &pb.Route{
+		TlsCustomCa:                      base64.StdEncoding.EncodeToString([]byte("my_custom_ca_secret+cert")),
+		TlsDownstreamClientCa:            base64.StdEncoding.EncodeToString([]byte("my_downstream_client_ca_secret+cert")),
+		TlsClientCert:                    base64.StdEncoding.EncodeToString([]byte("my_client_secret+cert")),
+		TlsClientKey:                     base64.StdEncoding.EncodeToString([]byte("my_client_secret+key")),
+		CorsAllowPreflight:               true,
+		AllowPublicUnauthenticatedAccess: false,
+		AllowAnyAuthenticatedUser:        false,
+		Timeout:                          durationpb.New(time.Second * 10),
+		IdleTimeout:                      durationpb.New(time.Minute),
+		AllowWebsockets:                  true,
+		AllowSpdy:                        true,
+		KubernetesServiceAccountToken:    "k8s-token-data",
+		SetRequestHeaders:                map[string]string{"a": "aaa", "req_key_1": "req_data1", "req_key_2": "req_data2"},
+		RemoveRequestHeaders:             []string{"a"},
+		SetResponseHeaders:               map[string]string{"c": "ccc", "res_key_1": "res_data1", "res_key_2": "res_data2"},
+		RewriteResponseHeaders: []*pb.RouteRewriteHeader{{
+			Header:  "a",
+			Matcher: &pb.RouteRewriteHeader_Prefix{Prefix: "b"},
+			Value:   "c",
+		}},
+		HostRewrite:                      strp("rewrite"),
+		HostPathRegexRewritePattern:      strp("rewrite-pattern"),
+		HostPathRegexRewriteSubstitution: strp("rewrite-sub"),
+		PassIdentityHeaders:              true,
+		PrefixRewrite:                    "/",
+		RegexRewritePattern:              `^/service/([^/]+)(/.*)$`,
+		RegexRewriteSubstitution:         `\\2/instance/\\1`,
+		EnvoyOpts: &envoy_config_cluster_v3.Cluster{
+			HealthChecks: []*envoy_config_core_v3.HealthCheck{{
+				Timeout:            durationpb.New(time.Second * 10),
+				Interval:           durationpb.New(time.Minute),
+				UnhealthyThreshold: wrapperspb.UInt32(2),
+				HealthyThreshold:   wrapperspb.UInt32(1),
+				HealthChecker: &envoy_config_core_v3.HealthCheck_HttpHealthCheck_{
+					HttpHealthCheck: &envoy_config_core_v3.HealthCheck_HttpHealthCheck{Path: "/"},
+				},
+			}},
+			LbPolicy: envoy_config_cluster_v3.Cluster_LEAST_REQUEST,
+			LbConfig: &envoy_config_cluster_v3.Cluster_LeastRequestLbConfig_{
+				LeastRequestLbConfig: &envoy_config_cluster_v3.Cluster_LeastRequestLbConfig{
+					ChoiceCount: &wrapperspb.UInt32Value{
+						Value: 3,
+					},
+					ActiveRequestBias: &envoy_config_core_v3.RuntimeDouble{
+						DefaultValue: 4,
+						RuntimeKey:   "key",
+					},
+					SlowStartConfig: &envoy_config_cluster_v3.Cluster_SlowStartConfig{
+						SlowStartWindow: &durationpb.Duration{
+							Seconds: 3,
+							Nanos:   0,
+						},
+						Aggression: &envoy_config_core_v3.RuntimeDouble{
+							DefaultValue: 0,
+							RuntimeKey:   "key",
+						},
+					},
+				},
+			},
+		},
+		Policies: []*pb.Policy{{
+			AllowedUsers:   []string{"a"},
+			AllowedGroups:  []string{"a"},
+			AllowedDomains: []string{"a"},
+			AllowedIdpClaims: map[string]*structpb.ListValue{
+				"key": {Values: []*structpb.Value{structpb.NewStringValue("val1"), structpb.NewStringValue("val2")}},
+			},
+		}},
+		TlsServerName: "my.server.name",
+	}, cmpopts.IgnoreUnexported(
+		pb.Route{},
+		pb.RouteRewriteHeader{},
+		pb.Policy{},
+		structpb.ListValue{},
+		structpb.Value{},
+		durationpb.Duration{},
+		envoy_config_cluster_v3.Cluster{},
+		envoy_config_core_v3.HealthCheck{},
+		envoy_config_core_v3.HealthCheck_HttpHealthCheck_{},
+		envoy_config_core_v3.HealthCheck_HttpHealthCheck{},
+		envoy_config_cluster_v3.Cluster_LeastRequestLbConfig{},
+		envoy_config_core_v3.RuntimeDouble{},
+		envoy_config_cluster_v3.Cluster_SlowStartConfig{},
+		wrapperspb.UInt32Value{},
+	),
+		cmpopts.IgnoreFields(pb.Policy{}, "Rego")))
+}
+
+func TestSourceRanges(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       string
+		expectError bool
+	}{
+		{"ipv4 and ipv6", `["10.0.0.0/8", "fd00::/8"]`, false},
+		{"single", `["192.168.1.1/32"]`, false},
+		{"empty", `[]`, true},
+		{"not a cidr", `["10.0.0.1"]`, true},
+		{"not a list", `10.0.0.0/8`, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{
+						Namespace: "test",
+						Annotations: map[string]string{
+							"a/allowed_source_ranges": tc.value,
+							"a/allowed_domains":       `["pomerium.com"]`,
+						},
+					},
+				},
+			}
+			err := applyAnnotations(r, ic)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, r.Policies, 1)
+			assert.Equal(t, []string{"pomerium.com"}, r.Policies[0].AllowedDomains)
+			require.Len(t, r.Policies[0].Rego, 1)
+		})
+	}
+}
+
+func TestSourceRangesRego(t *testing.T) {
+	src, err := sourceRangesRego(`["10.0.0.0/8", "fd00::/8"]`)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	query, err := rego.New(
+		rego.Query("data.pomerium.policy.deny"),
+		rego.Module("source_ranges.rego", src),
+	).PrepareForEval(ctx)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		allow   bool
+	}{
+		{"allowed client", map[string]string{"X-Forwarded-For": "10.1.2.3"}, true},
+		{"allowed ipv6 client", map[string]string{"X-Forwarded-For": "fd00::1"}, true},
+		{"allowed client behind a client supplied entry", map[string]string{"X-Forwarded-For": "203.0.113.5, 10.1.2.3"}, true},
+		{"denied client", map[string]string{"X-Forwarded-For": "203.0.113.5"}, false},
+		{"spoofed entry before the envoy one", map[string]string{"X-Forwarded-For": "10.1.2.3, 203.0.113.5"}, false},
+		{"no header", map[string]string{}, false},
+		{"not an address", map[string]string{"X-Forwarded-For": "unknown"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := query.Eval(ctx, rego.EvalInput(map[string]interface{}{
+				"http": map[string]interface{}{"headers": tc.headers},
+			}))
+			require.NoError(t, err)
+			assert.Equal(t, tc.allow, len(rs) == 0, "deny: %v", rs)
+		})
+	}
+}
+
+func TestAllowedSubjects(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       string
+		expect      []string
+		expectError bool
+	}{
+		{"yaml list", `["alice@example.com", "bob@example.com"]`, []string{"alice@example.com", "bob@example.com"}, false},
+		{"yaml block list", "- alice@example.com\n- bob@example.com", []string{"alice@example.com", "bob@example.com"}, false},
+		{"comma separated", `alice@example.com, bob@example.com`, []string{"alice@example.com", "bob@example.com"}, false},
+		{"single", `alice@example.com`, []string{"alice@example.com"}, false},
+		{"empty list", `[]`, nil, true},
+		{"empty string", ``, nil, true},
+		{"only commas", `, ,`, nil, true},
+		{"empty item", `["alice@example.com", ""]`, nil, true},
+		{"not a list of strings", `[{"a": "b"}]`, nil, true},
+	} {
+		for _, key := range []string{"allowed_users", "allowed_domains", "allowed_groups"} {
+			t.Run(tc.name+"/"+key, func(t *testing.T) {
+				r := new(pb.Route)
+				ic := &model.IngressConfig{
+					AnnotationPrefix: "a",
+					Ingress: &networkingv1.Ingress{
+						ObjectMeta: v1.ObjectMeta{
+							Namespace:   "test",
+							Annotations: map[string]string{"a/" + key: tc.value},
+						},
+					},
+				}
+				err := applyAnnotations(r, ic)
+				if tc.expectError {
+					assert.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				require.Len(t, r.Policies, 1)
+				got := map[string][]string{
+					"allowed_users":   r.Policies[0].AllowedUsers,
+					"allowed_domains": r.Policies[0].AllowedDomains,
+					"allowed_groups":  r.Policies[0].AllowedGroups,
+				}[key]
+				assert.Equal(t, tc.expect, got)
+			})
+		}
+	}
+}
+
+func TestAllowedIdpClaims(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       string
+		expect      string
+		expectError bool
+	}{
+		{"list", `groups: [admin, audit]`, `{"groups": ["admin", "audit"]}`, false},
+		{"scalar", `groups: admin`, `{"groups": ["admin"]}`, false},
+		{"scalar and list", "groups: admin\nroles:\n- reader\n- writer", `{"groups": ["admin"], "roles": ["reader", "writer"]}`, false},
+		{"number and bool", `{level: 5, verified: true}`, `{"level": [5], "verified": [true]}`, false},
+		{"nested", `address: {country: US, region: [east]}`, `{"address": [{"country": "US", "region": ["east"]}]}`, false},
+		{"nested list", `roles: [{name: admin, scopes: [read, write]}]`, `{"roles": [{"name": "admin", "scopes": ["read", "write"]}]}`, false},
+		{"json", `{"groups": ["admin"]}`, `{"groups": ["admin"]}`, false},
+		{"malformed yaml", `groups: [admin`, "", true},
+		{"not a map", `[admin, audit]`, "", true},
+		{"empty", `{}`, "", true},
+		{"empty list", `groups: []`, "", true},
+		{"null value", `groups:`, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{
+						Namespace:   "test",
+						Annotations: map[string]string{"a/allowed_idp_claims": tc.value},
+					},
+				},
+			}
+			err := applyAnnotations(r, ic)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, r.Policies, 1)
+			got := make(map[string]interface{})
+			for name, values := range r.Policies[0].AllowedIdpClaims {
+				got[name] = values.AsSlice()
+			}
+			data, err := json.Marshal(got)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expect, string(data))
+		})
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		annotations   map[string]string
+		expectError   bool
+		expectMethods string
+		expectCORS    bool
+	}{
+		{"single", map[string]string{"a/allowed_methods": `["POST"]`}, false, `["POST"]`, false},
+		{"lower case", map[string]string{"a/allowed_methods": `[get, head]`}, false, `["GET","HEAD"]`, false},
+		{"cors preflight", map[string]string{
+			"a/allowed_methods":      `["POST"]`,
+			"a/cors_allow_preflight": "true",
+		}, false, `["POST"]`, true},
+		{"empty", map[string]string{"a/allowed_methods": `[]`}, true, "", false},
+		{"unknown method", map[string]string{"a/allowed_methods": `["FETCH"]`}, true, "", false},
+		{"not a list", map[string]string{"a/allowed_methods": `POST`}, true, "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{
+						Namespace:   "test",
+						Annotations: tc.annotations,
+					},
+				},
+			}
+			err := applyAnnotations(r, ic)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, r.Policies, 1)
+			require.Len(t, r.Policies[0].Rego, 1)
+			rego := r.Policies[0].Rego[0]
+			assert.Contains(t, rego, tc.expectMethods)
+			assert.Equal(t, tc.expectCORS, strings.Contains(rego, "not cors_preflight"))
+		})
+	}
+}
+
+func TestDefaultResponseHeaders(t *testing.T) {
+	defaults := map[string]string{
+		"Strict-Transport-Security": "max-age=31536000",
+		"X-Frame-Options":           "SAMEORIGIN",
+	}
+	for _, tc := range []struct {
+		name        string
+		defaults    map[string]string
+		annotations map[string]string
+		expect      map[string]string
+	}{
+		{"defaults", defaults, nil, defaults},
+		{"annotation takes precedence", defaults, map[string]string{
+			"a/set_response_headers": `{"x-frame-options": "DENY", "Content-Security-Policy": "default-src 'self'"}`,
+		}, map[string]string{
+			"Strict-Transport-Security": "max-age=31536000",
+			"x-frame-options":           "DENY",
+			"Content-Security-Policy":   "default-src 'self'",
+		}},
+		{"opt out", defaults, map[string]string{
+			"a/disable_default_headers": "true",
+			"a/set_response_headers":    `{"X-Custom": "value"}`,
+		}, map[string]string{"X-Custom": "value"}},
+		{"controller defaults removed", nil, nil, nil},
+		{"empty value suppresses default", defaults, map[string]string{
+			"a/set_response_headers": `{"Strict-Transport-Security": ""}`,
+		}, map[string]string{"X-Frame-Options": "SAMEORIGIN"}},
+		{"empty value without defaults", nil, map[string]string{
+			"a/set_response_headers": `{"X-Frame-Options": ""}`,
+		}, nil},
+		{"multi-line yaml", defaults, map[string]string{
+			"a/set_response_headers": `
+Content-Security-Policy: >-
+  default-src 'self';
+  script-src 'self' https://cdn.example.com;
+  style-src 'self' 'unsafe-inline'
+Strict-Transport-Security: "max-age=63072000; includeSubDomains; preload"
+X-Frame-Options: ""
+`,
+		}, map[string]string{
+			"Content-Security-Policy":   "default-src 'self'; script-src 'self' https://cdn.example.com; style-src 'self' 'unsafe-inline'",
+			"Strict-Transport-Security": "max-age=63072000; includeSubDomains; preload",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix:       "a",
+				DefaultResponseHeaders: tc.defaults,
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{
+						Namespace:   "test",
+						Annotations: tc.annotations,
+					},
+				},
+			}
+			require.NoError(t, applyAnnotations(r, ic))
+			assert.Equal(t, tc.expect, r.SetResponseHeaders)
+		})
+	}
+	assert.Len(t, defaults, 2, "controller defaults should not be modified")
+
+	err := applyAnnotations(new(pb.Route), &model.IngressConfig{
+		AnnotationPrefix: "a",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: v1.ObjectMeta{
+				Namespace: "test",
+				Annotations: map[string]string{
+					"a/set_response_headers": "Content-Security-Policy: |-\n  default-src 'self';\n  script-src 'self'\n",
+				},
+			},
+		},
+	})
+	assert.ErrorContains(t, err, "Content-Security-Policy", "literal block scalar keeps the line breaks")
+}
+
+func TestTLSSkipVerify(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		expectSkip  bool
+		expectError string
+	}{
+		{"skip verify", map[string]string{"a/tls_skip_verify": "true"}, true, ""},
+		{"custom ca", map[string]string{"a/tls_custom_ca_secret": "ca"}, false, ""},
+		{"skip verify disabled with custom ca", map[string]string{"a/tls_skip_verify": "false", "a/tls_custom_ca_secret": "ca"}, false, ""},
+		{"contradicting", map[string]string{"a/tls_skip_verify": "true", "a/tls_custom_ca_secret": "ca"}, false,
+			"tls_skip_verify cannot be combined with tls_custom_ca_secret"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			err := applyAnnotations(r, &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{Namespace: "test", Annotations: tc.annotations},
+				},
+				Secrets: map[types.NamespacedName]*corev1.Secret{
+					{Name: "ca", Namespace: "test"}: {Data: map[string][]byte{CAKey: []byte("cert")}},
+				},
+			})
+			if tc.expectError != "" {
+				assert.ErrorContains(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectSkip, r.TlsSkipVerify)
+		})
+	}
+}
+
+func TestHostRewrite(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		expectError string
+	}{
+		{"literal", map[string]string{"a/host_rewrite": "upstream.example.com"}, ""},
+		{"header", map[string]string{"a/host_rewrite_header": "X-Upstream-Host"}, ""},
+		{"preserve disabled", map[string]string{"a/host_rewrite": "upstream.example.com", "a/preserve_host_header": "false"}, ""},
+		{"literal and header", map[string]string{"a/host_rewrite": "upstream.example.com", "a/host_rewrite_header": "X-Upstream-Host"},
+			"ingress test/ingress: host_rewrite cannot be combined with host_rewrite_header"},
+		{"header and preserve", map[string]string{"a/host_rewrite_header": "X-Upstream-Host", "a/preserve_host_header": "true"},
+			"ingress test/ingress: host_rewrite_header cannot be combined with preserve_host_header"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			err := applyAnnotations(r, &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "ingress", Annotations: tc.annotations},
+				},
+			})
+			if tc.expectError != "" {
+				assert.ErrorContains(t, err, tc.expectError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAllowAnyAuthenticatedUser(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		expectAny   bool
+		expectError string
+	}{
+		{"any authenticated user", map[string]string{"a/allow_any_authenticated_user": "true", "a/pass_identity_headers": "true"}, true, ""},
+		{"disabled", map[string]string{"a/allow_any_authenticated_user": "false", "a/allow_public_unauthenticated_access": "true"}, false, ""},
+		{"public", map[string]string{"a/allow_any_authenticated_user": "true", "a/allow_public_unauthenticated_access": "true"}, false,
+			"ingress test/ingress: allow_any_authenticated_user cannot be combined with allow_public_unauthenticated_access"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			err := applyAnnotations(r, &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "ingress", Annotations: tc.annotations},
+				},
+			})
+			if tc.expectError != "" {
+				assert.ErrorContains(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectAny, r.AllowAnyAuthenticatedUser)
+		})
+	}
+}
+
+// TestAllowSpdy checks SPDY upgrades, i.e. kubectl exec, are allowed independently of websockets
+func TestAllowSpdy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		expectSpdy  bool
+		expectWS    bool
+	}{
+		{"none", nil, false, false},
+		{"spdy", map[string]string{"a/allow_spdy": "true"}, true, false},
+		{"websockets", map[string]string{"a/allow_websockets": "true"}, false, true},
+		{"both", map[string]string{"a/allow_spdy": "true", "a/allow_websockets": "true"}, true, true},
+		{"spdy disabled", map[string]string{"a/allow_spdy": "false", "a/allow_websockets": "true"}, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			require.NoError(t, applyAnnotations(r, &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "ingress", Annotations: tc.annotations},
+				},
+			}))
+			assert.Equal(t, tc.expectSpdy, r.AllowSpdy, "allow_spdy")
+			assert.Equal(t, tc.expectWS, r.AllowWebsockets, "allow_websockets")
+		})
+	}
+
+	err := applyAnnotations(new(pb.Route), &model.IngressConfig{
+		AnnotationPrefix: "a",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "ingress", Annotations: map[string]string{"a/allow_spdy": "yes please"}},
+		},
+	})
+	assert.Error(t, err, "non-boolean values should be rejected")
+}
+
+func TestRewriteResponseHeaders(t *testing.T) {
+	apply := func(value string) (*pb.Route, error) {
+		r := new(pb.Route)
+		return r, applyAnnotations(r, &model.IngressConfig{
+			AnnotationPrefix: "a",
+			Ingress: &networkingv1.Ingress{
+				ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "ingress", Annotations: map[string]string{
+					"a/rewrite_response_headers": value,
+				}},
+			},
+		})
+	}
+
+	r, err := apply(`
+- header: Location
+  prefix: http://service.default.svc.cluster.local/
+  value: https://service.example.com/
+- header: Content-Location
+  prefix: http://service.default.svc.cluster.local/
+  value: https://service.example.com/
+- header: Location
+  prefix: http://localhost:8080/
+  value: https://service.example.com/
+`)
+	require.NoError(t, err)
+	var headers []string
+	for _, rw := range r.RewriteResponseHeaders {
+		headers = append(headers, rw.GetHeader())
+	}
+	assert.Equal(t, []string{"Location", "Content-Location", "Location"}, headers, "order should be preserved")
+	assert.Equal(t, "http://localhost:8080/", r.RewriteResponseHeaders[2].GetPrefix())
+
+	_, err = apply(`[{header: Location, prefix: "http://a/", value: "https://b/"}, {header: "", prefix: "http://a/", value: "https://b/"}]`)
+	assert.ErrorContains(t, err, "rewrite_response_headers: [1]: header name is required")
+	_, err = apply(`[{prefix: "http://a/", value: "https://b/"}]`)
+	assert.ErrorContains(t, err, "header name is required")
+}
+
+func TestHealthChecks(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       string
+		expectError string
+	}{
+		{"valid", `[{"timeout": "1s", "interval": "10s", "healthy_threshold": 1, "unhealthy_threshold": 2, "http_health_check": {"path": "/healthz"}}]`, ""},
+		{"missing interval", `[{"timeout": "1s", "healthy_threshold": 1, "unhealthy_threshold": 2, "http_health_check": {"path": "/healthz"}}]`,
+			"health_checks[0]: invalid HealthCheck.Interval: value is required"},
+		{"not a duration", `[{"timeout": "1s", "interval": "often", "healthy_threshold": 1, "unhealthy_threshold": 2, "http_health_check": {"path": "/"}}]`,
+			"health_checks: "},
+		{"missing threshold", `
+- timeout: 1s
+  interval: 10s
+  healthy_threshold: 1
+  http_health_check:
+    path: /healthz
+`, "health_checks[0]: invalid HealthCheck.UnhealthyThreshold: value is required"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			err := applyAnnotations(r, &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "ingress", Annotations: map[string]string{"a/health_checks": tc.value}},
+				},
+			})
+			if tc.expectError != "" {
+				assert.ErrorContains(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, r.EnvoyOpts.HealthChecks, 1)
+		})
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		expectError string
+	}{
+		{"hostname", map[string]string{"a/tls_server_name": "upstream.example.com"}, ""},
+		{"mixed case", map[string]string{"a/tls_server_name": "Upstream.Example.com"}, ""},
+		{"with custom ca", map[string]string{"a/tls_server_name": "upstream.example.com", "a/tls_custom_ca_secret": "ca"}, ""},
+		{"with skip verify", map[string]string{"a/tls_server_name": "upstream.example.com", "a/tls_skip_verify": "true"}, ""},
+		{"ip address", map[string]string{"a/tls_server_name": "10.0.0.1"}, "is an IP address"},
+		{"port", map[string]string{"a/tls_server_name": "upstream.example.com:443"}, "is not a valid hostname"},
+		{"url", map[string]string{"a/tls_server_name": "https://upstream.example.com"}, "is not a valid hostname"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			err := applyAnnotations(r, &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{Namespace: "test", Annotations: tc.annotations},
+				},
+				Secrets: map[types.NamespacedName]*corev1.Secret{
+					{Name: "ca", Namespace: "test"}: {Data: map[string][]byte{CAKey: []byte("cert")}},
+				},
+			})
+			if tc.expectError != "" {
+				assert.ErrorContains(t, err, "tls_server_name")
+				assert.ErrorContains(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.annotations["a/tls_server_name"], r.TlsServerName)
+		})
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		timeout     *durationpb.Duration
+		idleTimeout *durationpb.Duration
+		expectError string
+	}{
+		{"idle timeout only", map[string]string{"a/idle_timeout": "3600s"}, nil, durationpb.New(time.Hour), ""},
+		{"timeout only", map[string]string{"a/timeout": "30s"}, durationpb.New(time.Second * 30), nil, ""},
+		{"both", map[string]string{"a/timeout": "30s", "a/idle_timeout": "3600s"},
+			durationpb.New(time.Second * 30), durationpb.New(time.Hour), ""},
+		{"negative idle timeout", map[string]string{"a/idle_timeout": "-1s"}, nil, nil, "idle_timeout: negative duration"},
+		{"negative timeout", map[string]string{"a/timeout": "-1s"}, nil, nil, "timeout: negative duration"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{
+						Namespace:   "test",
+						Annotations: tc.annotations,
+					},
+				},
+			}
+			err := applyAnnotations(r, ic)
+			if tc.expectError != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectError)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Empty(t, cmp.Diff(tc.timeout, r.Timeout, protocmp.Transform()))
+			assert.Empty(t, cmp.Diff(tc.idleTimeout, r.IdleTimeout, protocmp.Transform()))
+		})
+	}
+}
+
+func TestLongLivedConnections(t *testing.T) {
+	for k := range longLivedConnections {
+		assert.True(t, baseAnnotations[k], "%s should be a route annotation", k)
+	}
+
+	for _, tc := range []struct {
+		name            string
+		annotations     map[string]string
+		timeout         *durationpb.Duration
+		idleTimeout     *durationpb.Duration
+		allowWebsockets bool
+	}{
+		{"not set", nil, nil, nil, false},
+		{"disabled", map[string]string{"a/long_lived_connections": "false"}, nil, nil, false},
+		{"expanded", map[string]string{"a/long_lived_connections": "true"},
+			durationpb.New(0), durationpb.New(time.Hour), true},
+		{"explicit annotations override", map[string]string{
+			"a/long_lived_connections": "true",
+			"a/idle_timeout":           "600s",
+			"a/allow_websockets":       "false",
+		}, durationpb.New(0), durationpb.New(10 * time.Minute), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{
+						Namespace:   "test",
+						Annotations: tc.annotations,
+					},
+				},
+			}
+			require.NoError(t, applyAnnotations(r, ic))
+			assert.Empty(t, cmp.Diff(tc.timeout, r.Timeout, protocmp.Transform()))
+			assert.Empty(t, cmp.Diff(tc.idleTimeout, r.IdleTimeout, protocmp.Transform()))
+			assert.Equal(t, tc.allowWebsockets, r.AllowWebsockets)
+		})
+	}
+}
+
+func TestClassDefaults(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		annotations         map[string]string
+		timeout             *durationpb.Duration
+		passIdentityHeaders bool
+	}{
+		{"defaults", nil, durationpb.New(time.Minute), true},
+		{"explicit annotations override", map[string]string{
+			"a/timeout":               "10s",
+			"a/pass_identity_headers": "false",
+		}, durationpb.New(10 * time.Second), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix: "a",
+				ClassDefaults:    map[string]string{"timeout": "60s", "pass_identity_headers": "true"},
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{
+						Namespace:   "test",
+						Annotations: tc.annotations,
+					},
+				},
+			}
+			require.NoError(t, applyAnnotations(r, ic))
+			assert.Empty(t, cmp.Diff(tc.timeout, r.Timeout, protocmp.Transform()))
+			assert.Equal(t, tc.passIdentityHeaders, r.GetPassIdentityHeaders())
+		})
+	}
+}
+
+func TestTimeWindows(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       string
+		expectError bool
+		expectRego  []string
+	}{
+		{"business hours", `[{days: [mon, tue, wed, thu, fri], from: "09:00", to: "17:00", timezone: America/New_York}]`, false,
+			[]string{`"days":["Monday","Tuesday","Wednesday","Thursday","Friday"]`, `"from":540`, `"to":1020`, `"timezone":"America/New_York"`}},
+		{"defaults", `[{from: "00:00", to: "24:00"}]`, false,
+			[]string{`"Sunday"`, `"Saturday"`, `"from":0`, `"to":1440`, `"timezone":"UTC"`}},
+		{"empty", `[]`, true, nil},
+		{"not a list", `{from: "09:00", to: "17:00"}`, true, nil},
+		{"bad timezone", `[{from: "09:00", to: "17:00", timezone: Mars/Olympus_Mons}]`, true, nil},
+		{"inverted range", `[{from: "17:00", to: "09:00"}]`, true, nil},
+		{"empty range", `[{from: "09:00", to: "09:00"}]`, true, nil},
+		{"bad time", `[{from: "9am", to: "17:00"}]`, true, nil},
+		{"bad day", `[{days: [someday], from: "09:00", to: "17:00"}]`, true, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{
+						Namespace: "test",
+						Annotations: map[string]string{
+							"a/allowed_time_windows": tc.value,
+						},
+					},
+				},
+			}
+			err := applyAnnotations(r, ic)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, r.Policies, 1)
+			require.Len(t, r.Policies[0].Rego, 1)
+			for _, txt := range tc.expectRego {
+				assert.Contains(t, r.Policies[0].Rego[0], txt)
+			}
+		})
+	}
+}
+
+func TestPPL(t *testing.T) {
+	multiDoc := `allow:
+  and:
+  - domain:
+      is: pomerium.com
+  not:
+  - user:
+      is: bob@pomerium.com
+---
+deny:
+  or:
+  - user:
+      is: mallory@pomerium.com
+`
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "policy", Namespace: "test"},
+		Data:       map[string]string{model.PolicyConfigMapKey: multiDoc},
+	}
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		expectError bool
+	}{
+		{"inline", map[string]string{"a/policy": multiDoc}, false},
+		{"config map", map[string]string{"a/policy_configmap": "policy"}, false},
+		{"both", map[string]string{"a/policy": multiDoc, "a/policy_configmap": "policy"}, true},
+		{"syntax error", map[string]string{"a/policy": "allow:\n  and: [\n"}, true},
+		{"unknown operator", map[string]string{"a/policy": "allow:\n  xor:\n  - domain:\n      is: pomerium.com\n"}, true},
+		{"unknown criterion", map[string]string{"a/policy": "allow:\n  and:\n  - planet:\n      is: earth\n"}, true},
+		{"no rules", map[string]string{"a/policy": "---\n---\n"}, true},
+		{"with allowed users", map[string]string{"a/policy": testPPL, "a/allowed_users": "a@pomerium.com"}, true},
+		{"config map with allowed idp claims", map[string]string{"a/policy_configmap": "policy", "a/allowed_idp_claims": "{group: [admin]}"}, true},
+		{"config map missing key", map[string]string{"a/policy_configmap": "empty"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			ic := &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{Namespace: "test", Annotations: tc.annotations},
+				},
+				ConfigMaps: map[types.NamespacedName]*corev1.ConfigMap{
+					{Namespace: "test", Name: "policy"}: configMap,
+					{Namespace: "test", Name: "empty"}:  {},
+				},
+			}
+			err := applyAnnotations(r, ic)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, r.Policies, 1)
+			require.Len(t, r.Policies[0].Rego, 1)
+			rego := r.Policies[0].Rego[0]
+			for _, txt := range []string{"pomerium.com", "bob@pomerium.com", "mallory@pomerium.com"} {
+				assert.Contains(t, rego, txt, "rules of all documents should be combined")
+			}
+		})
+	}
+}
+
+func TestPPLErrors(t *testing.T) {
+	apply := func(annotations map[string]string) (*pb.Route, error) {
+		r := new(pb.Route)
+		return r, applyAnnotations(r, &model.IngressConfig{
+			AnnotationPrefix: "a",
+			Ingress: &networkingv1.Ingress{
+				ObjectMeta: v1.ObjectMeta{Namespace: "test", Annotations: annotations},
+			},
+		})
+	}
+
+	_, err := apply(map[string]string{"a/policy": testPPL, "a/allowed_domains": "pomerium.com"})
+	assert.ErrorContains(t, err, "policy cannot be combined with allowed_domains")
+
+	r, err := apply(map[string]string{"a/policy": testPPL, "a/allowed_source_ranges": "[10.0.0.0/8]"})
+	require.NoError(t, err, "restrictions apply on top of the policy")
+	assert.Len(t, r.Policies[0].Rego, 2)
+
+	_, err = apply(map[string]string{"a/policy": "allow:\n  and:\n  - domain:\n      is: pomerium.com\n" +
+		"deny:\n  or:\n  - planet:\n      is: earth\n"})
+	assert.ErrorContains(t, err, "document 1: line 5:", "the failing rule should be located")
+
+	_, err = apply(map[string]string{"a/policy": "allow:\n  and: [\n"})
+	assert.ErrorContains(t, err, "line 2", "the YAML syntax error position should be reported")
+}
+
+func TestMissingTlsAnnotationsSecretData(t *testing.T) {
+	r := &pb.Route{To: []string{"http://upstream.svc.cluster.local"}}
+	ic := &model.IngressConfig{
+		AnnotationPrefix: "a",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: v1.ObjectMeta{
+				Namespace: "test",
+			},
+		},
+	}
+	require.NoError(t, applyAnnotations(r, ic))
+
+	for name, keys := range map[string][]string{
+		"tls_custom_ca_secret":            {CAKey},
+		"tls_downstream_client_ca_secret": {CAKey},
+		"tls_client_secret":               {corev1.TLSCertKey, corev1.TLSPrivateKeyKey},
+	} {
+		ic.Ingress.Annotations = map[string]string{
+			fmt.Sprintf("%s/%s", ic.AnnotationPrefix, name): name,
+		}
+		for _, testKey := range keys {
+			data := make(map[string][]byte)
+			ic.Secrets = map[types.NamespacedName]*corev1.Secret{
+				{Name: name, Namespace: "test"}: {Data: data},
+			}
+			for _, key := range keys {
+				if key == testKey {
+					continue
+				}
+				data[key] = []byte("data")
+			}
+			assert.Errorf(t, applyAnnotations(r, ic), "name=%s key=%s", name, testKey)
+		}
+	}
+}
+
+func TestKubernetesServiceAccountToken(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		data        map[string][]byte
+		expect      string
+		expectError string
+	}{
+		{"token", map[string][]byte{"token": []byte("eyJhbGciOiJSUzI1NiJ9.e30.c2ln")}, "eyJhbGciOiJSUzI1NiJ9.e30.c2ln", ""},
+		{"trailing line break", map[string][]byte{"token": []byte("eyJhbGciOiJSUzI1NiJ9.e30.c2ln\n")}, "eyJhbGciOiJSUzI1NiJ9.e30.c2ln", ""},
+		{"missing key", map[string][]byte{"ca.crt": []byte("ca")}, "", "must have a token key that is missing"},
+		{"empty", map[string][]byte{"token": nil}, "", "token is empty"},
+		{"whitespace", map[string][]byte{"token": []byte("a b")}, "", "must not contain whitespace"},
+		{"binary", map[string][]byte{"token": {0x00, 0x01}}, "", "must not contain whitespace or non-printable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(pb.Route)
+			err := applyAnnotations(r, &model.IngressConfig{
+				AnnotationPrefix: "a",
+				Ingress: &networkingv1.Ingress{
+					ObjectMeta: v1.ObjectMeta{Namespace: "test", Annotations: map[string]string{
+						"a/kubernetes_service_account_token_secret": "sa",
+					}},
+				},
+				Secrets: map[types.NamespacedName]*corev1.Secret{
+					{Name: "sa", Namespace: "test"}: {Type: corev1.SecretTypeServiceAccountToken, Data: tc.data},
+				},
+			})
+			if tc.expectError != "" {
+				assert.ErrorContains(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expect, r.KubernetesServiceAccountToken)
+		})
+	}
+}
+
+func TestAnnotationsConversion(t *testing.T) {
+	for i, tc := range []struct {
+		in     map[string]string
+		expect string
+	}{
+		{map[string]string{
+			"bool_param":         "true",
+			"num_param":          "10",
+			"txt_param":          "text",
+			"allowed_idp_claims": `groups: ["admin", "audit"]`,
+			"allowed_groups":     `["admin", "audit"]`,
+		}, `{
+				"bool_param": true,
+				"num_param": 10,
+				"txt_param": "text",
+				"allowed_groups": ["admin", "audit"],
+				"allowed_idp_claims": {"groups": ["admin", "audit"]}
+			}`},
+	} {
+		data, err := toJSON(tc.in)
+		if assert.NoError(t, err, i) {
+			assert.JSONEq(t, tc.expect, string(data), string(data))
+		}
+	}
+}
+
+func TestYaml(t *testing.T) {
+	for input, expect := range map[string]interface{}{
+		"10":                                10,
+		"0.5":                               0.5,
+		"true":                              true,
+		"text":                              "text",
+		"'text2'":                           "text2",
+		`{"bool_key":true}`:                 map[string]interface{}{"bool_key": true},
+		`{"groups":["admin", "superuser"]}`: map[string]interface{}{"groups": []interface{}{"admin", "superuser"}},
+		`groups: ["admin", "superuser"]`:    map[string]interface{}{"groups": []interface{}{"admin", "superuser"}},
+	} {
+		var out interface{}
+		if assert.NoError(t, yaml.Unmarshal([]byte(input), &out), input) {
+			assert.Equal(t, expect, out)
+		}
+	}
+}
+
+func TestMergeMap(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		src         map[string][]byte
+		dst         map[string]string
+		expect      map[string]string
+		expectError bool
+	}{
+		{name: "nothing", src: nil, dst: nil, expect: map[string]string{}, expectError: false},
+		{name: "key overlap", src: map[string][]byte{
+			"k1": []byte("v1"),
+		}, dst: map[string]string{
+			"k1": "v1.1",
+			"k2": "v2",
+		}, expect: nil, expectError: true},
+		{name: "no overlap", src: map[string][]byte{
+			"k1": []byte("v1"),
+		}, dst: map[string]string{
+			"k2": "v2",
+		}, expect: map[string]string{
+			"k1": "v1",
+			"k2": "v2",
+		}, expectError: false},
+	} {
+		got, err := mergeMaps(tc.dst, tc.src)
+		if tc.expectError {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		if assert.NoError(t, err, tc.name) {
+			assert.Equal(t, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,756 @@
+package translate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gosimple/slug"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// ingressToRoutes converts Ingress object into Pomerium Route.
+// if only some of the paths could not be converted, the valid routes are returned along with model.RouteErrors
+func ingressToRoutes(ctx context.Context, ic *model.IngressConfig) ([]*pb.Route, error) {
+	tmpl := &pb.Route{}
+
+	if model.IsHTTP01Solver(ic.Ingress) {
+		log.FromContext(ctx).Info("Ingress is HTTP-01 challenge solver, enabling public unauthenticated access")
+		tmpl.AllowPublicUnauthenticatedAccess = true
+		tmpl.PreserveHostHeader = true
+	} else if err := applyAnnotations(tmpl, ic); err != nil {
+		return nil, fmt.Errorf("annotations: %w", err)
+	}
+	if port, err := ic.GetListenerPort(); err != nil {
+		return nil, fmt.Errorf("annotations: %w", err)
+	} else if port != 0 && ic.IsTCPUpstream() {
+		return nil, model.NewPermanentError(fmt.Errorf("annotations: %s cannot be combined with %s", model.ListenerPort, model.TCPUpstream))
+	}
+	if _, err := ic.GetStatusFromService(); err != nil {
+		return nil, fmt.Errorf("annotations: %w", err)
+	}
+	if _, err := ic.GetCanary(); err != nil {
+		return nil, fmt.Errorf("annotations: %w", err)
+	}
+	tmpls := &routeTemplates{
+		base:      newRouteTemplate(tmpl, ic),
+		byService: make(map[string]*routeTemplate),
+	}
+
+	var routeErrs model.RouteErrors
+	routes := make([]*pb.Route, 0, len(ic.Ingress.Spec.Rules)+1)
+	if ic.Ingress.Spec.DefaultBackend != nil {
+		r, err := defaultBackend(ctx, tmpls, ic)
+		var errs model.RouteErrors
+		if errors.As(err, &errs) {
+			routeErrs = append(routeErrs, errs...)
+		} else if err != nil {
+			return nil, fmt.Errorf("defaultBackend: %w", err)
+		} else {
+			routes = append(routes, r)
+		}
+	}
+	for _, rule := range ic.Ingress.Spec.Rules {
+		r, err := ruleToRoute(ctx, rule, tmpls, ic)
+		var errs model.RouteErrors
+		if errors.As(err, &errs) {
+			routeErrs = append(routeErrs, errs...)
+		} else if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r...)
+	}
+
+	if len(routeErrs) > 0 {
+		return routes, routeErrs
+	}
+	return routes, nil
+}
+
+// routeTemplate is a route with all annotations applied,
+// along with the ingress config that has the effective annotations.
+// it also caches per path computations, as ingresses may have hundreds of paths sharing the same backend
+type routeTemplate struct {
+	*pb.Route
+	*model.IngressConfig
+
+	upstreams map[networkingv1.IngressServiceBackend]upstream
+	names     map[string]string
+}
+
+// upstream is the result of resolving the backend service into the route destination
+type upstream struct {
+	to            []string
+	tlsServerName string
+}
+
+func newRouteTemplate(r *pb.Route, ic *model.IngressConfig) *routeTemplate {
+	return &routeTemplate{
+		Route:         r,
+		IngressConfig: ic,
+		upstreams:     make(map[networkingv1.IngressServiceBackend]upstream),
+		names:         make(map[string]string),
+	}
+}
+
+// setServiceURLs sets route destination, reusing the result if the backend was already resolved
+// redirect routes have no upstream, and the backend is not resolved
+func (t *routeTemplate) setServiceURLs(r *pb.Route, p networkingv1.HTTPIngressPath) error {
+	if r.Redirect != nil {
+		return nil
+	}
+	if p.Backend.Service == nil {
+		return setServiceURLs(r, p, t.IngressConfig)
+	}
+
+	key := *p.Backend.Service
+	if u, ok := t.upstreams[key]; ok {
+		r.To = append(make([]string, 0, len(u.to)), u.to...)
+		r.TlsServerName = u.tlsServerName
+		return nil
+	}
+
+	if err := setServiceURLs(r, p, t.IngressConfig); err != nil {
+		return err
+	}
+	t.upstreams[key] = upstream{to: r.To, tlsServerName: r.TlsServerName}
+	return nil
+}
+
+// hostName returns the route name prefix for the host
+func (t *routeTemplate) hostName(host string) string {
+	name, ok := t.names[host]
+	if !ok {
+		name = slug.Make(fmt.Sprintf("%s %s %s", t.Ingress.Namespace, t.Ingress.Name, host))
+		t.names[host] = name
+	}
+	return name
+}
+
+// routeTemplates keeps route templates per backend service,
+// as backend service annotations may further customize the route
+type routeTemplates struct {
+	base      *routeTemplate
+	byService map[string]*routeTemplate
+}
+
+func (t *routeTemplates) get(backend networkingv1.IngressBackend) (*routeTemplate, error) {
+	ic := t.base.IngressConfig
+	if ic.ServiceAnnotationPrefix == "" || backend.Service == nil || model.IsHTTP01Solver(ic.Ingress) {
+		return t.base, nil
+	}
+
+	if tmpl, ok := t.byService[backend.Service.Name]; ok {
+		return tmpl, nil
+	}
+
+	svc, ok := ic.Services[ic.GetNamespacedName(backend.Service.Name)]
+	if !ok {
+		return t.base, nil
+	}
+	sic, err := withServiceAnnotations(ic, svc)
+	if err != nil {
+		return nil, fmt.Errorf("service %s annotations: %w", svc.Name, err)
+	}
+
+	tmpl := t.base
+	if sic != ic {
+		r := new(pb.Route)
+		if err := applyAnnotations(r, sic); err != nil {
+			return nil, fmt.Errorf("service %s annotations: %w", svc.Name, err)
+		}
+		tmpl = newRouteTemplate(r, sic)
+	}
+	t.byService[backend.Service.Name] = tmpl
+	return tmpl, nil
+}
+
+// withServiceAnnotations returns a shallow copy of ingress config with the service annotations
+// merged beneath the ingress annotations, so that the ingress annotations take precedence
+func withServiceAnnotations(ic *model.IngressConfig, svc *corev1.Service) (*model.IngressConfig, error) {
+	prefix := fmt.Sprintf("%s/", ic.ServiceAnnotationPrefix)
+	annotations := make(map[string]string)
+	for k, v := range svc.Annotations {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(k, prefix)
+		if tlsAnnotations[key] || secretAnnotations[key] {
+			return nil, fmt.Errorf("%s: referencing secrets is only supported in the ingress annotations", k)
+		}
+		if key == model.PolicyConfigMap {
+			return nil, fmt.Errorf("%s: referencing config maps is only supported in the ingress annotations", k)
+		}
+		if key == model.Canary {
+			return nil, fmt.Errorf("%s: canary service is only supported in the ingress annotations", k)
+		}
+		annotations[fmt.Sprintf("%s/%s", ic.AnnotationPrefix, key)] = v
+	}
+	if len(annotations) == 0 {
+		return ic, nil
+	}
+
+	for k, v := range ic.Ingress.Annotations {
+		annotations[k] = v
+	}
+	ingress := *ic.Ingress
+	ingress.Annotations = annotations
+	dst := *ic
+	dst.Ingress = &ingress
+	return &dst, nil
+}
+
+func deriveHostFromTLS(tls []networkingv1.IngressTLS) (string, error) {
+	if len(tls) != 1 {
+		return "", fmt.Errorf("expected one TLS spec, got %d", len(tls))
+	}
+	if len(tls[0].Hosts) != 1 {
+		return "", fmt.Errorf("expected exactly one Host in the TLS spec, got %d", len(tls[0].Hosts))
+	}
+	return tls[0].Hosts[0], nil
+}
+
+func defaultBackend(ctx context.Context, tmpls *routeTemplates, ic *model.IngressConfig) (*pb.Route, error) {
+	host, err := deriveHostFromTLS(ic.Spec.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("deriving host: %w", err)
+	}
+
+	typePrefix := networkingv1.PathTypePrefix
+	// errors are returned as is, as they may be model.RouteErrors
+	routes, err := ruleToRoute(ctx, networkingv1.IngressRule{
+		Host: host,
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Path:     "/",
+					PathType: &typePrefix,
+					Backend:  *ic.Spec.DefaultBackend,
+				}},
+			},
+		},
+	}, tmpls, ic)
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) != 1 {
+		return nil, fmt.Errorf("expected 1 route, got %d", len(routes))
+	}
+	return routes[0], nil
+}
+
+// ruleToRoute converts ingress rule into routes, paths that fail are skipped and reported as model.RouteErrors
+func ruleToRoute(ctx context.Context, rule networkingv1.IngressRule, tmpls *routeTemplates, ic *model.IngressConfig) ([]*pb.Route, error) {
+	if rule.Host == "" {
+		return nil, errors.New("host is required, rules matching all hosts are not supported")
+	}
+	if err := validateWildcardHost(rule.Host); err != nil {
+		return nil, err
+	}
+	if isWildcardHost(rule.Host) && !ic.AllowWildcardHosts {
+		return nil, fmt.Errorf("wildcard host %s is not supported, as Pomerium v0.17.x only authorizes the requests "+
+			"by the route whose host equals the request host; enable wildcard hosts for a Pomerium version that matches them", rule.Host)
+	}
+	if isWildcardHost(rule.Host) && ic.IsTCPUpstream() {
+		return nil, fmt.Errorf("wildcard host %s cannot be combined with %s", rule.Host, model.TCPUpstream)
+	}
+
+	var rulePaths []networkingv1.HTTPIngressPath
+	if ic.IsRedirect() {
+		rulePaths = redirectRulePaths(rule)
+	} else if rule.HTTP == nil {
+		return nil, errors.New("rules.http is required")
+	} else {
+		rulePaths = rule.HTTP.Paths
+	}
+
+	paths := make([]networkingv1.HTTPIngressPath, 0, len(rulePaths))
+	for _, p := range rulePaths {
+		if path := normalizePath(p.Path); path != p.Path {
+			ic.Warn(warningDuplicateSlashes, fmt.Sprintf("host %s path %s contains duplicate slashes, normalized to %s", rule.Host, p.Path, path))
+			p.Path = path
+		}
+		// tcp routes match the whole host:port, the root path is accepted as the ingress spec may require one,
+		// and is dropped so that the route id is the same as of the route without a path
+		if p.Path == "/" && ic.IsTCPUpstream() {
+			p.Path = ""
+		}
+		paths = append(paths, p)
+	}
+
+	if ic.IsCombinePaths() {
+		r, err := combinedPathsRoute(rule.Host, paths, tmpls, ic)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", model.CombinePaths, err)
+		}
+		return []*pb.Route{r}, nil
+	}
+
+	var routeErrs model.RouteErrors
+	routes := make([]*pb.Route, 0, len(paths))
+	for _, p := range paths {
+		tmpl, err := tmpls.get(p.Backend)
+		if err != nil {
+			routeErrs = append(routeErrs, &model.RouteError{Host: rule.Host, Path: p.Path, Err: err})
+			continue
+		}
+		r := proto.Clone(tmpl.Route).(*pb.Route)
+		if err := pathToRoute(r, rule.Host, p, tmpl); err != nil {
+			routeErrs = append(routeErrs, &model.RouteError{Host: rule.Host, Path: p.Path, Err: err})
+			continue
+		}
+		routes = append(routes, r)
+	}
+
+	if len(routeErrs) > 0 {
+		return routes, routeErrs
+	}
+	return routes, nil
+}
+
+func pathToRoute(r *pb.Route, host string, p networkingv1.HTTPIngressPath, tmpl *routeTemplate) error {
+	ic := tmpl.IngressConfig
+	if err := setRouteFrom(r, host, p, ic); err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+
+	if err := applyWildcardHost(r, host); err != nil {
+		return fmt.Errorf("host: %w", err)
+	}
+
+	if err := setRoutePath(r, p, ic); err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+	if ic.IsPathRegex() {
+		// envoy uses RE2 syntax that is also implemented by the go regexp package.
+		// the regexes generated from the other path types are always valid
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return fmt.Errorf("path: %w", err)
+		}
+	}
+
+	if err := setRouteNameID(r, tmpl.hostName(host), ic.GetNamespacedName(ic.Name), url.URL{Host: host, Path: p.Path}); err != nil {
+		return fmt.Errorf("name: %w", err)
+	}
+
+	if err := tmpl.setServiceURLs(r, p); err != nil {
+		return fmt.Errorf("backend: %w", err)
+	}
+
+	return nil
+}
+
+// combinedPathsRoute emits a single route matching all the rule paths with one regular expression,
+// that considerably reduces the config size for ingresses with many paths.
+// as the precedence of paths pointing to different backends could not be preserved in a single route,
+// all paths of the rule must share the same backend.
+func combinedPathsRoute(host string, paths []networkingv1.HTTPIngressPath, tmpls *routeTemplates, ic *model.IngressConfig) (*pb.Route, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("at least one path is required")
+	}
+	if ic.IsTCPUpstream() {
+		return nil, fmt.Errorf("cannot be combined with %s", model.TCPUpstream)
+	}
+	if ic.IsCaseInsensitivePaths() && ic.IsPathRegex() {
+		return nil, fmt.Errorf("%s cannot be combined with %s as it is ambiguous", model.CaseInsensitivePaths, model.PathRegex)
+	}
+
+	backend := paths[0].Backend
+	exprs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		// redirect routes have no backends to share
+		if !ic.IsRedirect() && !sameServiceBackend(backend, p.Backend) {
+			return nil, fmt.Errorf("path %s: all paths of a rule must share the same backend", p.Path)
+		}
+		expr, err := pathRegex(p, ic)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %w", p.Path, err)
+		}
+		exprs = append(exprs, expr)
+	}
+	regex := fmt.Sprintf("(?:%s)", strings.Join(exprs, "|"))
+	if ic.IsCaseInsensitivePaths() {
+		regex = "(?i)" + regex
+	}
+
+	tmpl, err := tmpls.get(backend)
+	if err != nil {
+		return nil, err
+	}
+	r := proto.Clone(tmpl.Route).(*pb.Route)
+	if r.PrefixRewrite != "" {
+		// the prefix to replace would depend on which of the paths matched, that envoy cannot tell
+		return nil, errors.New("cannot be combined with prefix_rewrite, use regex_rewrite_pattern instead")
+	}
+	if err := setRouteFrom(r, host, paths[0], tmpl.IngressConfig); err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	if err := applyWildcardHost(r, host); err != nil {
+		return nil, fmt.Errorf("host: %w", err)
+	}
+	r.Regex = regex
+	// route name would not include the path, and the regex makes a unique route id
+	if err := setRouteNameID(r, tmpl.hostName(host), ic.GetNamespacedName(ic.Name), url.URL{Host: host}); err != nil {
+		return nil, fmt.Errorf("name: %w", err)
+	}
+	if r.Id, err = (&RouteID{Name: ic.Name, Namespace: ic.Namespace, Host: host, Path: regex}).Marshal(); err != nil {
+		return nil, fmt.Errorf("id: %w", err)
+	}
+	if err := tmpl.setServiceURLs(r, paths[0]); err != nil {
+		return nil, fmt.Errorf("backend: %w", err)
+	}
+	return r, nil
+}
+
+func sameServiceBackend(a, b networkingv1.IngressBackend) bool {
+	if a.Service == nil || b.Service == nil {
+		return false
+	}
+	return *a.Service == *b.Service
+}
+
+func setRoutePath(r *pb.Route, p networkingv1.HTTPIngressPath, ic *model.IngressConfig) error {
+	// https://kubernetes.io/docs/concepts/services-networking/ingress/#path-types
+	// Paths that do not include an explicit pathType will fail validation.
+	if p.PathType == nil {
+		return fmt.Errorf("pathType is required")
+	}
+
+	if ic.IsTCPUpstream() {
+		if p.Path != "" {
+			return fmt.Errorf("tcp services must not specify path other than /, got %s", p.Path)
+		}
+		return nil
+	}
+
+	if ic.IsCaseInsensitivePaths() {
+		return setCaseInsensitiveRoutePath(r, p, ic)
+	}
+
+	switch *p.PathType {
+	case networkingv1.PathTypeImplementationSpecific:
+		if ic.IsPathRegex() {
+			r.Regex = p.Path
+		} else {
+			r.Prefix = p.Path
+		}
+	case networkingv1.PathTypeExact:
+		r.Path = p.Path
+	case networkingv1.PathTypePrefix:
+		setElementWisePrefix(r, p.Path)
+	default:
+		// shouldn't get there as apiserver should not allow this
+		return fmt.Errorf("unknown pathType %s", *p.PathType)
+	}
+
+	return nil
+}
+
+// setElementWisePrefix matches the path prefix element-wise, as required for the Prefix path type,
+// so that /foo matches /foo and /foo/bar, but not /foobar. the trailing slash of the path is ignored.
+// envoy string prefix matching is not element-wise, hence the regex. the prefix is kept as well,
+// so that the route is ordered along with the other prefix routes of the host
+func setElementWisePrefix(r *pb.Route, path string) {
+	prefix := strings.TrimSuffix(path, "/")
+	if prefix == "" {
+		// every path matches the root element-wise
+		r.Prefix = "/"
+		return
+	}
+	r.Prefix = prefix
+	r.Regex = prefixPathRegex(prefix)
+	setRegexPrefixRewrite(r, "^"+regexp.QuoteMeta(prefix))
+}
+
+// setRegexPrefixRewrite replaces the prefix_rewrite of a regex route with an equivalent regex rewrite
+// of the path prefix matched by the pattern, as envoy prefix_rewrite would replace the entire path matched by a regex
+func setRegexPrefixRewrite(r *pb.Route, pattern string) {
+	if r.PrefixRewrite == "" {
+		return
+	}
+	r.RegexRewritePattern = pattern
+	r.RegexRewriteSubstitution = strings.ReplaceAll(r.PrefixRewrite, `\`, `\\`)
+	r.PrefixRewrite = ""
+}
+
+// prefixPathRegex returns a regular expression matching the path prefix element-wise
+func prefixPathRegex(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return "/.*"
+	}
+	return regexp.QuoteMeta(prefix) + "(?:/.*)?"
+}
+
+// setCaseInsensitiveRoutePath translates exact and prefix paths into an equivalent case-insensitive regex,
+// as envoy route case sensitivity is not exposed via pomerium route options
+func setCaseInsensitiveRoutePath(r *pb.Route, p networkingv1.HTTPIngressPath, ic *model.IngressConfig) error {
+	if ic.IsPathRegex() {
+		return fmt.Errorf("%s cannot be combined with %s as it is ambiguous", model.CaseInsensitivePaths, model.PathRegex)
+	}
+
+	expr, err := pathRegex(p, ic)
+	if err != nil {
+		return err
+	}
+	r.Regex = "(?i)" + expr
+
+	// the prefix envoy would match and rewrite, had the path been case-sensitive
+	prefix := p.Path
+	if *p.PathType == networkingv1.PathTypePrefix {
+		if prefix = strings.TrimSuffix(prefix, "/"); prefix == "" {
+			prefix = "/"
+		}
+	}
+	setRegexPrefixRewrite(r, "(?i)^"+regexp.QuoteMeta(prefix))
+
+	return nil
+}
+
+// pathRegex returns a regular expression that is equivalent to the path match
+func pathRegex(p networkingv1.HTTPIngressPath, ic *model.IngressConfig) (string, error) {
+	if p.PathType == nil {
+		return "", fmt.Errorf("pathType is required")
+	}
+
+	switch *p.PathType {
+	case networkingv1.PathTypeImplementationSpecific:
+		if ic.IsPathRegex() {
+			return p.Path, nil
+		}
+		return regexp.QuoteMeta(p.Path) + ".*", nil
+	case networkingv1.PathTypePrefix:
+		return prefixPathRegex(p.Path), nil
+	case networkingv1.PathTypeExact:
+		return regexp.QuoteMeta(p.Path), nil
+	default:
+		return "", fmt.Errorf("unknown pathType %s", *p.PathType)
+	}
+}
+
+// warningDuplicateSlashes is reported if the ingress path contains repeated slashes, that are collapsed
+const warningDuplicateSlashes = "DuplicateSlashes"
+
+// normalizePath collapses repeated slashes in the path
+func normalizePath(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return path
+}
+
+func setRouteFrom(r *pb.Route, host string, p networkingv1.HTTPIngressPath, ic *model.IngressConfig) error {
+	u := url.URL{
+		Scheme: "https",
+		Host:   host,
+	}
+
+	listenerPort, err := ic.GetListenerPort()
+	if err != nil {
+		return err
+	}
+
+	if ic.IsTCPUpstream() {
+		_, _, port, err := getServiceFromPath(p, ic)
+		if err != nil {
+			return err
+		}
+		u.Host = net.JoinHostPort(u.Host, fmt.Sprint(port))
+		u.Scheme = "tcp+https"
+	} else if listenerPort != 0 {
+		u.Host = net.JoinHostPort(u.Host, fmt.Sprint(listenerPort))
+	}
+
+	r.From = u.String()
+	return nil
+}
+
+// setRouteNameID sets route id and name, where hostName is a slug of the ingress name and host
+func setRouteNameID(r *pb.Route, hostName string, name types.NamespacedName, u url.URL) error {
+	id, err := (&RouteID{Name: name.Name, Namespace: name.Namespace, Host: u.Host, Path: u.Path}).Marshal()
+	if err != nil {
+		return err
+	}
+	r.Id = id
+
+	r.Name = hostName
+	pathSlug := slug.Make(u.Path)
+	if pathSlug != "" {
+		r.Name = fmt.Sprintf("%s-%s", r.Name, pathSlug)
+	}
+
+	return nil
+}
+
+func getServiceFromPath(p networkingv1.HTTPIngressPath, ic *model.IngressConfig) (*networkingv1.IngressServiceBackend, *corev1.Service, int32, error) {
+	backend := p.Backend.Service
+	if backend == nil {
+		return nil, nil, -1, errors.New("service must be specified")
+	}
+
+	serviceName := ic.GetNamespacedName(backend.Name)
+	port := backend.Port.Number
+	if backend.Port.Name != "" {
+		var err error
+		port, err = ic.GetServicePortByName(serviceName, backend.Port.Name)
+		if err != nil {
+			return nil, nil, -1, err
+		}
+	}
+
+	service, ok := ic.Services[serviceName]
+	if !ok {
+		return nil, nil, -1, fmt.Errorf("service %s was not fetched, this is a bug", serviceName.String())
+	}
+
+	return backend, service, port, nil
+}
+
+// getPathServiceHosts returns the upstream hosts of the path backend, and whether they are served over TLS
+func getPathServiceHosts(r *pb.Route, p networkingv1.HTTPIngressPath, ic *model.IngressConfig) ([]string, bool, error) {
+	backend, service, port, err := getServiceFromPath(p, ic)
+	if err != nil {
+		return nil, false, fmt.Errorf("get service from path: %w", err)
+	}
+	secure := isSecureUpstream(ic, getServicePort(service, backend.Port))
+
+	var hosts []string
+	if service.Spec.Type == corev1.ServiceTypeExternalName {
+		hosts = append(hosts, net.JoinHostPort(service.Spec.ExternalName, strconv.Itoa(int(port))))
+	} else if ic.UseServiceProxy() {
+		hosts = append(hosts, fmt.Sprintf("%s.%s.svc.cluster.local:%d", backend.Name, ic.Namespace, port))
+	} else {
+		endpoints, ok := ic.Endpoints[ic.GetNamespacedName(backend.Name)]
+		if ok {
+			hosts = getEndpointsURLs(backend.Port, service.Spec.Ports, endpoints.Subsets, ic.UseNotReadyEndpoints())
+		}
+		// this can happen if no endpoints are ready, or none match, in which case we fallback to the Kubernetes DNS name
+		if len(hosts) == 0 {
+			hosts = append(hosts, fmt.Sprintf("%s.%s.svc.cluster.local:%d", backend.Name, ic.Namespace, port))
+		} else if secure && r.TlsServerName == "" {
+			r.TlsServerName = fmt.Sprintf("%s.%s.svc.cluster.local", backend.Name, ic.Namespace)
+		}
+	}
+
+	return hosts, secure, nil
+}
+
+func getUpstreamScheme(ic *model.IngressConfig, secure bool) string {
+	if ic.IsTCPUpstream() {
+		return "tcp"
+	} else if secure {
+		return "https"
+	}
+	return "http"
+}
+
+func setServiceURLs(r *pb.Route, p networkingv1.HTTPIngressPath, ic *model.IngressConfig) error {
+	urls, err := getServiceURLs(r, p, ic)
+	if err != nil {
+		return err
+	}
+	r.To = urls
+	return applyCanary(r, ic)
+}
+
+func getServiceURLs(r *pb.Route, p networkingv1.HTTPIngressPath, ic *model.IngressConfig) ([]string, error) {
+	hosts, secure, err := getPathServiceHosts(r, p, ic)
+	if err != nil {
+		return nil, fmt.Errorf("get service hosts: %w", err)
+	}
+
+	var urls []string
+	scheme := getUpstreamScheme(ic, secure)
+	for _, host := range hosts {
+		urls = append(urls, (&url.URL{
+			Scheme: scheme,
+			Host:   host,
+		}).String())
+	}
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// getEndpointsURLs returns the ready endpoint addresses matching the ingress backend service port,
+// along with the ones that are not ready if notReady is set
+func getEndpointsURLs(
+	ingressServicePort networkingv1.ServiceBackendPort,
+	servicePorts []corev1.ServicePort,
+	endpointSubsets []corev1.EndpointSubset,
+	notReady bool,
+) []string {
+	portMatch := getEndpointPortMatcher(ingressServicePort, servicePorts)
+	if portMatch == nil {
+		return nil
+	}
+	var hosts []string
+	for _, subset := range endpointSubsets {
+		addresses := subset.Addresses
+		if notReady {
+			addresses = append(append([]corev1.EndpointAddress(nil), addresses...), subset.NotReadyAddresses...)
+		}
+		for _, endpointAddress := range addresses {
+			for _, endpointPort := range subset.Ports {
+				if portMatch(endpointPort) {
+					hosts = append(hosts, net.JoinHostPort(endpointAddress.IP, strconv.Itoa(int(endpointPort.Port))))
+				}
+			}
+		}
+	}
+	return hosts
+}
+
+// getEndpointPortMatcher returns a function that matches the endpoint ports of the ingress backend service port.
+// if the service target port refers to a named container port, i.e. "https", its number is only known
+// from the endpoints, that are matched by the service port name then
+func getEndpointPortMatcher(ingressServicePort networkingv1.ServiceBackendPort, servicePorts []corev1.ServicePort) func(port corev1.EndpointPort) bool {
+	if ingressServicePort.Name != "" {
+		ports := make(map[int32]bool)
+		namedTarget := false
+		for _, sp := range servicePorts {
+			if sp.Name != ingressServicePort.Name {
+				continue
+			}
+			if sp.TargetPort.Type == intstr.String {
+				namedTarget = true
+			} else {
+				ports[sp.TargetPort.IntVal] = true
+			}
+		}
+		return func(port corev1.EndpointPort) bool {
+			return port.Name == ingressServicePort.Name && (namedTarget || ports[port.Port])
+		}
+	}
+
+	// match by port number
+	for _, sp := range servicePorts {
+		if sp.Port != ingressServicePort.Number {
+			continue
+		}
+		if sp.TargetPort.Type == intstr.String {
+			return func(port corev1.EndpointPort) bool {
+				return sp.Name == port.Name
+			}
+		}
+		return func(port corev1.EndpointPort) bool {
+			return sp.TargetPort.IntVal == port.Port
+		}
+	}
+
+	return nil
+}
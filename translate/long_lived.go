@@ -0,0 +1,28 @@
+package translate
+
+import (
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// longLivedConnections are the route options the long_lived_connections annotation expands into,
+// so that long-polling and websocket clients are not disconnected by the route timeouts
+var longLivedConnections = map[string]string{
+	// disables the route timeout, that would otherwise terminate a response still being streamed
+	routeTimeout: "0s",
+	// keeps the connection open while the upstream holds the poll without sending data
+	routeIdleTimeout:   "3600s",
+	"allow_websockets": "true",
+}
+
+// expandLongLivedConnections adds the longLivedConnections route options to the base annotations,
+// unless they were explicitly set for the ingress
+func expandLongLivedConnections(base map[string]string, ic *model.IngressConfig) {
+	if !ic.IsAnnotationSet(model.LongLivedConnections) {
+		return
+	}
+	for k, v := range longLivedConnections {
+		if _, ok := base[k]; !ok {
+			base[k] = v
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package translate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/pomerium/pomerium/pkg/policy"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+const pplAnnotation = "policy"
+
+// getPPL returns the Pomerium Policy Language policy source, that is either set inline,
+// or is referenced from a config map
+func getPPL(kvs map[string]string, ic *model.IngressConfig) (string, bool, error) {
+	inline, hasInline := kvs[pplAnnotation]
+	cmName, hasConfigMap := kvs[model.PolicyConfigMap]
+	delete(kvs, pplAnnotation)
+	delete(kvs, model.PolicyConfigMap)
+
+	switch {
+	case hasInline && hasConfigMap:
+		return "", false, fmt.Errorf("only one of %s or %s may be set", pplAnnotation, model.PolicyConfigMap)
+	case hasInline:
+		return inline, true, nil
+	case !hasConfigMap:
+		return "", false, nil
+	}
+
+	name := types.NamespacedName{Namespace: ic.Ingress.Namespace, Name: cmName}
+	cm, ok := ic.ConfigMaps[name]
+	if !ok {
+		return "", false, fmt.Errorf("config map %s was not pre-fetched, this is a bug", name.String())
+	}
+	src, ok := cm.Data[model.PolicyConfigMapKey]
+	if !ok {
+		return "", false, fmt.Errorf("config map %s should have %s key", name.String(), model.PolicyConfigMapKey)
+	}
+	return src, true, nil
+}
+
+// validatePPLExclusive rejects the policy combined with the allowed subjects annotations,
+// as these would be merged as alternatives, silently widening the access the policy grants.
+// the restrictions, i.e. allowed_source_ranges, still apply on top of the policy
+func validatePPLExclusive(kvs map[string]string) error {
+	for _, ppl := range []string{pplAnnotation, model.PolicyConfigMap} {
+		if _, ok := kvs[ppl]; !ok {
+			continue
+		}
+		for _, k := range []string{"allowed_users", "allowed_groups", "allowed_domains", allowedIdpClaims} {
+			if _, ok := kvs[k]; ok {
+				return fmt.Errorf("%s cannot be combined with %s, express the subjects in the policy instead", ppl, k)
+			}
+		}
+	}
+	return nil
+}
+
+// pplToRego parses Pomerium Policy Language policy and generates rego.
+// the YAML source may contain multiple documents, the rules of which are combined.
+func pplToRego(src string) (string, error) {
+	var rules []parser.Rule
+	dec := yaml.NewDecoder(strings.NewReader(src))
+	for i := 1; ; i++ {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		// YAML errors carry the line already
+		if err != nil {
+			return "", fmt.Errorf("document %d: %w", i, err)
+		}
+		var doc interface{}
+		if err = node.Decode(&doc); err != nil {
+			return "", fmt.Errorf("document %d: %w", i, err)
+		}
+		if doc == nil {
+			continue
+		}
+
+		p, err := parsePPL(doc)
+		if err != nil {
+			return "", fmt.Errorf("document %d: line %d: %w", i, pplErrorLine(&node), err)
+		}
+		rules = append(rules, p.Rules...)
+	}
+	if len(rules) == 0 {
+		return "", errors.New("policy has no rules")
+	}
+
+	return policy.GenerateRegoFromPolicy(&parser.Policy{Rules: rules})
+}
+
+// parsePPL parses the policy document, and checks the rego may be generated from it,
+// as the unknown criteria are only reported then
+func parsePPL(doc interface{}) (*parser.Policy, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parser.ParseJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = policy.GenerateRegoFromPolicy(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// pplErrorLine returns the line of the first rule of the document that fails to parse,
+// as the policy parser errors do not carry the position
+func pplErrorLine(doc *yaml.Node) int {
+	if len(doc.Content) != 1 {
+		return doc.Line
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return root.Line
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		var rule interface{}
+		if err := root.Content[i+1].Decode(&rule); err != nil {
+			return root.Content[i].Line
+		}
+		if _, err := parsePPL(map[string]interface{}{root.Content[i].Value: rule}); err != nil {
+			return root.Content[i].Line
+		}
+	}
+	return root.Line
+}
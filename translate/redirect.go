@@ -0,0 +1,59 @@
+package translate
+
+import (
+	"fmt"
+	"net/http"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	networkingv1 "k8s.io/api/networking/v1"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// redirectResponseCodes maps the HTTP status codes to the envoy redirect response codes,
+// as pomerium passes the route response code to envoy as is
+var redirectResponseCodes = map[int32]envoy_config_route_v3.RedirectAction_RedirectResponseCode{
+	http.StatusMovedPermanently:  envoy_config_route_v3.RedirectAction_MOVED_PERMANENTLY,
+	http.StatusFound:             envoy_config_route_v3.RedirectAction_FOUND,
+	http.StatusSeeOther:          envoy_config_route_v3.RedirectAction_SEE_OTHER,
+	http.StatusTemporaryRedirect: envoy_config_route_v3.RedirectAction_TEMPORARY_REDIRECT,
+	http.StatusPermanentRedirect: envoy_config_route_v3.RedirectAction_PERMANENT_REDIRECT,
+}
+
+// applyRedirect validates the redirect set via annotation, and converts its HTTP response code
+func applyRedirect(r *pb.Route, ic *model.IngressConfig) error {
+	if r.Redirect == nil {
+		return nil
+	}
+	if ic.IsTCPUpstream() {
+		return fmt.Errorf("%s cannot be combined with %s", model.Redirect, model.TCPUpstream)
+	}
+	if _, ok := ic.Ingress.Annotations[ic.AnnotationPrefix+"/"+model.Canary]; ok {
+		return fmt.Errorf("%s cannot be combined with %s", model.Redirect, model.Canary)
+	}
+	if r.Redirect.PathRedirect != nil && r.Redirect.PrefixRewrite != nil {
+		return fmt.Errorf("%s: path_redirect cannot be combined with prefix_rewrite", model.Redirect)
+	}
+	if r.Redirect.ResponseCode == nil {
+		return nil
+	}
+	code, ok := redirectResponseCodes[r.Redirect.GetResponseCode()]
+	if !ok {
+		return fmt.Errorf("%s: response_code %d is not supported, expected one of 301, 302, 303, 307 or 308",
+			model.Redirect, r.Redirect.GetResponseCode())
+	}
+	r.Redirect.ResponseCode = (*int32)(&code)
+	return nil
+}
+
+// redirectRulePaths returns the paths of a redirect ingress rule,
+// that may omit the paths altogether as there are no backends to refer to, in which case all paths are redirected
+func redirectRulePaths(rule networkingv1.IngressRule) []networkingv1.HTTPIngressPath {
+	if rule.HTTP != nil {
+		return rule.HTTP.Paths
+	}
+	prefix := networkingv1.PathTypePrefix
+	return []networkingv1.HTTPIngressPath{{Path: "/", PathType: &prefix}}
+}
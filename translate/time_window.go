@@ -0,0 +1,141 @@
+package translate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const allowedTimeWindows = "allowed_time_windows"
+
+// timeWindow is a single allowed access window, i.e.
+//
+//   - days: [mon, tue, wed, thu, fri]
+//     from: "09:00"
+//     to: "17:00"
+//     timezone: Europe/Berlin
+type timeWindow struct {
+	// Days of week the window applies to, every day if omitted
+	Days []string `yaml:"days"`
+	// From is the start of the window, in HH:MM format
+	From string `yaml:"from"`
+	// To is the end of the window, in HH:MM format, exclusive. 24:00 may be used for the end of day
+	To string `yaml:"to"`
+	// Timezone is IANA time zone name the window is in, UTC if omitted
+	Timezone string `yaml:"timezone"`
+}
+
+// regoTimeWindow is a time window as evaluated by the generated rego
+type regoTimeWindow struct {
+	Days     []string `json:"days"`
+	From     int      `json:"from"`
+	To       int      `json:"to"`
+	Timezone string   `json:"timezone"`
+}
+
+var weekdays = func() map[string]string {
+	out := make(map[string]string, 14)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := d.String()
+		out[strings.ToLower(name)] = name
+		out[strings.ToLower(name[:3])] = name
+	}
+	return out
+}()
+
+// timeWindowsRego generates a rego that denies access to the route
+// unless the current time is within one of the provided time windows.
+// the time is evaluated by pomerium at request time, so the controller does not need to reconcile on a timer
+func timeWindowsRego(txt string) (string, error) {
+	var windows []timeWindow
+	if err := yaml.Unmarshal([]byte(txt), &windows); err != nil {
+		return "", fmt.Errorf("expected a list of time windows: %w", err)
+	}
+	if len(windows) == 0 {
+		return "", errors.New("at least one time window is required")
+	}
+
+	out := make([]regoTimeWindow, 0, len(windows))
+	for i, w := range windows {
+		rw, err := w.toRego()
+		if err != nil {
+			return "", fmt.Errorf("window %d: %w", i+1, err)
+		}
+		out = append(out, *rw)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`package pomerium.policy
+
+deny = [true, {"outside-allowed-time-window"}] {
+	not time_window_allowed
+}
+
+time_window_allowed {
+	now := time.now_ns()
+	w := %s[_]
+	time.weekday([now, w.timezone]) == w.days[_]
+	clock := time.clock([now, w.timezone])
+	minutes := clock[0] * 60 + clock[1]
+	minutes >= w.from
+	minutes < w.to
+}
+`, data), nil
+}
+
+func (w *timeWindow) toRego() (*regoTimeWindow, error) {
+	tz := w.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+
+	from, err := parseClock(w.From)
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	to, err := parseClock(w.To)
+	if err != nil {
+		return nil, fmt.Errorf("to: %w", err)
+	}
+	if from >= to {
+		return nil, fmt.Errorf("from %s must be before to %s", w.From, w.To)
+	}
+
+	days := make([]string, 0, len(w.Days))
+	for _, d := range w.Days {
+		name, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day of week %q", d)
+		}
+		days = append(days, name)
+	}
+	if len(days) == 0 {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			days = append(days, d.String())
+		}
+	}
+
+	return &regoTimeWindow{Days: days, From: from, To: to, Timezone: tz}, nil
+}
+
+// parseClock parses HH:MM time of day and returns minutes since midnight
+func parseClock(txt string) (int, error) {
+	if txt == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", txt)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM time of day, got %q", txt)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,97 @@
+// Package translate converts the Kubernetes Ingress objects into the Pomerium configuration.
+//
+// The translation is a pure function of the ingress and its resolved dependencies (model.IngressConfig),
+// it performs no Kubernetes API or databroker calls, so that it may be used without running a controller.
+package translate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+// Result is the Pomerium configuration generated for an ingress
+type Result struct {
+	// Routes are in the order of the ingress rules and paths
+	Routes []*pb.Route
+	// Certificates are the TLS certificates of the ingress hosts
+	Certificates []*pb.Settings_Certificate
+}
+
+// Ingress converts the ingress into the Pomerium routes and certificates.
+// if only some of the paths could not be converted, the valid routes are returned along with model.RouteErrors,
+// any other error means the ingress as a whole could not be converted
+func Ingress(ctx context.Context, ic *model.IngressConfig) (*Result, error) {
+	var routeErrs model.RouteErrors
+	routes, err := Routes(ctx, ic)
+	if err != nil && !errors.As(err, &routeErrs) {
+		return nil, fmt.Errorf("routes: %w", err)
+	}
+
+	certs, err := Certificates(ctx, ic)
+	if err != nil {
+		return nil, fmt.Errorf("certs: %w", err)
+	}
+
+	res := &Result{Routes: routes, Certificates: certs}
+	if len(routeErrs) > 0 {
+		return res, routeErrs
+	}
+	return res, nil
+}
+
+// Routes converts the ingress into the Pomerium routes.
+// if only some of the paths could not be converted, the valid routes are returned along with model.RouteErrors
+func Routes(ctx context.Context, ic *model.IngressConfig) ([]*pb.Route, error) {
+	return ingressToRoutes(ctx, ic)
+}
+
+// Certificates returns the TLS certificates referenced by the ingress tls spec, none if ic.SkipCertificates is set
+func Certificates(ctx context.Context, ic *model.IngressConfig) ([]*pb.Settings_Certificate, error) {
+	if ic.SkipCertificates {
+		return nil, nil
+	}
+	certs, err := ic.ParseTLSCerts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*pb.Settings_Certificate, 0, len(certs))
+	for _, cert := range certs {
+		out = append(out, &pb.Settings_Certificate{
+			CertBytes: cert.Cert,
+			KeyBytes:  cert.Key,
+		})
+	}
+	return out, nil
+}
+
+// RouteID identifies the ingress, host and path a route was generated for, and is stored as the route ID
+type RouteID struct {
+	Name      string `json:"n"`
+	Namespace string `json:"ns"`
+	Host      string `json:"h"`
+	Path      string `json:"p"`
+	// Cluster and Priority identify the cluster that published the route
+	Cluster  string `json:"c,omitempty"`
+	Priority int    `json:"pr,omitempty"`
+}
+
+// Marshal encodes the route ID
+func (r *RouteID) Marshal() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Unmarshal decodes the route ID
+func (r *RouteID) Unmarshal(txt string) error {
+	return json.Unmarshal([]byte(txt), r)
+}
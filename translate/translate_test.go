@@ -0,0 +1,496 @@
+package translate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+
+	"github.com/pomerium/ingress-controller/model"
+)
+
+func testIngressConfig(annotations map[string]string, paths ...string) *model.IngressConfig {
+	pathType := networkingv1.PathTypePrefix
+	var httpPaths []networkingv1.HTTPIngressPath
+	for _, p := range paths {
+		httpPaths = append(httpPaths, networkingv1.HTTPIngressPath{
+			Path:     p,
+			PathType: &pathType,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: "service",
+					Port: networkingv1.ServiceBackendPort{Name: "http"},
+				},
+			},
+		})
+	}
+	return &model.IngressConfig{
+		AnnotationPrefix: "a",
+		Ingress: &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", Annotations: annotations},
+			Spec: networkingv1.IngressSpec{
+				TLS: []networkingv1.IngressTLS{{Hosts: []string{"service.localhost.pomerium.io"}, SecretName: "tls"}},
+				Rules: []networkingv1.IngressRule{{
+					Host: "service.localhost.pomerium.io",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{Paths: httpPaths},
+					},
+				}},
+			},
+		},
+		Services: map[types.NamespacedName]*corev1.Service{
+			{Name: "service", Namespace: "default"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					Ports: []corev1.ServicePort{{Name: "http", Port: 80}},
+				},
+			},
+		},
+		Secrets: map[types.NamespacedName]*corev1.Secret{
+			{Name: "tls", Namespace: "default"}: {
+				ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "default"},
+				Type:       corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       []byte("cert"),
+					corev1.TLSPrivateKeyKey: []byte("key"),
+				},
+			},
+		},
+		Warnings: new(model.Warnings),
+	}
+}
+
+func TestIngress(t *testing.T) {
+	type route struct {
+		from, prefix string
+		to           []string
+	}
+	for _, tc := range []struct {
+		name  string
+		ic    *model.IngressConfig
+		want  []route
+		certs int
+		// routeErrs is the number of the skipped paths, or -1 if the whole ingress is expected to fail
+		routeErrs int
+	}{
+		{
+			name: "host and paths",
+			ic:   testIngressConfig(nil, "/a", "/b"),
+			want: []route{
+				{"https://service.localhost.pomerium.io", "/a", []string{"http://service.default.svc.cluster.local:80"}},
+				{"https://service.localhost.pomerium.io", "/b", []string{"http://service.default.svc.cluster.local:80"}},
+			},
+			certs: 1,
+		},
+		{
+			name: "missing certificate secret",
+			ic: func() *model.IngressConfig {
+				ic := testIngressConfig(nil, "/")
+				ic.Secrets = nil
+				return ic
+			}(),
+			want: []route{
+				{"https://service.localhost.pomerium.io", "/", []string{"http://service.default.svc.cluster.local:80"}},
+			},
+		},
+		{
+			name: "missing service",
+			ic: func() *model.IngressConfig {
+				ic := testIngressConfig(nil, "/a", "/b")
+				ic.Ingress.Spec.Rules[0].HTTP.Paths[1].Backend.Service.Name = "missing"
+				return ic
+			}(),
+			want: []route{
+				{"https://service.localhost.pomerium.io", "/a", []string{"http://service.default.svc.cluster.local:80"}},
+			},
+			certs:     1,
+			routeErrs: 1,
+		},
+		{
+			name:      "unknown annotation",
+			ic:        testIngressConfig(map[string]string{"a/unknown": "true"}, "/"),
+			routeErrs: -1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := Ingress(context.Background(), tc.ic)
+			var routeErrs model.RouteErrors
+			switch {
+			case tc.routeErrs < 0:
+				require.Error(t, err)
+				assert.False(t, errors.As(err, &routeErrs), err)
+				assert.Nil(t, res)
+				return
+			case tc.routeErrs > 0:
+				require.True(t, errors.As(err, &routeErrs), err)
+				assert.Len(t, routeErrs, tc.routeErrs)
+			default:
+				require.NoError(t, err)
+			}
+
+			var got []route
+			for _, r := range res.Routes {
+				got = append(got, route{r.From, r.Prefix, r.To})
+
+				var id RouteID
+				require.NoError(t, id.Unmarshal(r.Id))
+				assert.Equal(t, RouteID{Name: "ingress", Namespace: "default", Host: "service.localhost.pomerium.io", Path: r.Prefix}, id)
+			}
+			assert.Equal(t, tc.want, got)
+			assert.Len(t, res.Certificates, tc.certs)
+		})
+	}
+}
+
+func TestIngressPolicy(t *testing.T) {
+	ic := testIngressConfig(map[string]string{"a/allowed_users": "alice@example.com,bob@example.com"}, "/a", "/b")
+	ic.Ingress.Spec.DefaultBackend = &networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{Name: "service", Port: networkingv1.ServiceBackendPort{Name: "http"}},
+	}
+	ic.Ingress.Spec.Rules = append(ic.Ingress.Spec.Rules, networkingv1.IngressRule{
+		Host:             "other.localhost.pomerium.io",
+		IngressRuleValue: ic.Ingress.Spec.Rules[0].IngressRuleValue,
+	})
+
+	res, err := Ingress(context.Background(), ic)
+	require.NoError(t, err)
+	require.Len(t, res.Routes, 5)
+	for _, r := range res.Routes {
+		require.Len(t, r.Policies, 1, r.From)
+		assert.Equal(t, []string{"alice@example.com", "bob@example.com"}, r.Policies[0].AllowedUsers, r.From)
+	}
+}
+
+func TestPrefixRewrite(t *testing.T) {
+	res, err := Ingress(context.Background(), testIngressConfig(map[string]string{"a/prefix_rewrite": "/v2"}, "/", "/api/"))
+	require.NoError(t, err)
+	require.Len(t, res.Routes, 2)
+	for _, r := range res.Routes {
+		switch r.Prefix {
+		case "/":
+			assert.Empty(t, r.Regex, "root prefix matches all paths element-wise")
+			assert.Equal(t, "/v2", r.PrefixRewrite)
+		case "/api":
+			assert.Equal(t, `/api(?:/.*)?`, r.Regex)
+			assert.Empty(t, r.PrefixRewrite, "envoy would rewrite the entire path matched by the regex")
+			assert.Equal(t, "^/api", r.RegexRewritePattern)
+			assert.Equal(t, "/v2", r.RegexRewriteSubstitution)
+		default:
+			t.Errorf("unexpected route prefix %q", r.Prefix)
+		}
+	}
+
+	ic := testIngressConfig(map[string]string{"a/prefix_rewrite": "/v2", "a/case_insensitive_paths": "true"}, "/", "/api/")
+	exact := networkingv1.PathTypeExact
+	ic.Ingress.Spec.Rules[0].HTTP.Paths[1].PathType = &exact
+	res, err = Ingress(context.Background(), ic)
+	require.NoError(t, err)
+	require.Len(t, res.Routes, 2)
+	rewrite := func(r *pb.Route, path string) string {
+		return regexp.MustCompile(r.RegexRewritePattern).ReplaceAllLiteralString(path, r.RegexRewriteSubstitution)
+	}
+	for _, r := range res.Routes {
+		assert.Empty(t, r.PrefixRewrite, "envoy would rewrite the entire path matched by the regex")
+		switch r.Regex {
+		case "(?i)/.*":
+			assert.Equal(t, "/v2Api/users", rewrite(r, "/Api/users"))
+		case `(?i)/api/`:
+			assert.Equal(t, "/v2", rewrite(r, "/API/"), "the entire exact path is the prefix")
+		default:
+			t.Errorf("unexpected route regex %q", r.Regex)
+		}
+	}
+}
+
+func TestSkipCertificates(t *testing.T) {
+	ic := testIngressConfig(map[string]string{"a/tls_downstream_client_ca_secret": "ca"}, "/")
+	ic.Secrets[types.NamespacedName{Name: "ca", Namespace: "default"}] = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "default"},
+		Data:       map[string][]byte{"ca.crt": []byte("ca")},
+	}
+
+	res, err := Ingress(context.Background(), ic)
+	require.NoError(t, err)
+	assert.Len(t, res.Certificates, 1)
+	require.Len(t, res.Routes, 1)
+	assert.NotEmpty(t, res.Routes[0].TlsDownstreamClientCa)
+
+	ic.SkipCertificates = true
+	res, err = Ingress(context.Background(), ic)
+	require.NoError(t, err)
+	assert.Empty(t, res.Certificates)
+	require.Len(t, res.Routes, 1)
+	assert.Empty(t, res.Routes[0].TlsDownstreamClientCa)
+}
+
+func TestSecureUpstream(t *testing.T) {
+	ic := testIngressConfig(nil, "/")
+	ic.Ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port = networkingv1.ServiceBackendPort{Name: "https"}
+	svcName := types.NamespacedName{Name: "service", Namespace: "default"}
+	ic.Services[svcName].Spec.Ports = []corev1.ServicePort{{Name: "https", Port: 443, TargetPort: intstr.FromString("https")}}
+	ic.Endpoints = map[types.NamespacedName]*corev1.Endpoints{svcName: {
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
+			Ports:     []corev1.EndpointPort{{Name: "https", Port: 8443}},
+		}},
+	}}
+	ic.Secrets[types.NamespacedName{Name: "ca", Namespace: "default"}] = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "default"},
+		Data:       map[string][]byte{CAKey: []byte("ca")},
+	}
+
+	for _, tc := range []struct {
+		name             string
+		annotations      map[string]string
+		expectTo         string
+		expectServerName string
+	}{
+		{"plain", nil, "http://1.2.3.4:8443", ""},
+		{"secure", map[string]string{"a/secure_upstream": "true"}, "https://1.2.3.4:8443", "service.default.svc.cluster.local"},
+		{"secure with server name and custom ca", map[string]string{
+			"a/secure_upstream":      "true",
+			"a/tls_server_name":      "upstream.example.com",
+			"a/tls_custom_ca_secret": "ca",
+		}, "https://1.2.3.4:8443", "upstream.example.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ic.Ingress.Annotations = tc.annotations
+			routes, err := Routes(context.Background(), ic)
+			require.NoError(t, err)
+			require.Len(t, routes, 1)
+			assert.Equal(t, []string{tc.expectTo}, routes[0].To, "named target port should be resolved from the endpoints")
+			assert.Equal(t, tc.expectServerName, routes[0].TlsServerName)
+		})
+	}
+}
+
+// TestAppProtocol checks the upstream scheme is inferred from the backend service port appProtocol,
+// unless set by the secure_upstream annotation
+func TestAppProtocol(t *testing.T) {
+	svcName := types.NamespacedName{Name: "service", Namespace: "default"}
+
+	for _, tc := range []struct {
+		appProtocol   string
+		annotations   map[string]string
+		expectTo      string
+		expectWarning bool
+	}{
+		{"", nil, "http://1.2.3.4:8443", false},
+		{"http", nil, "http://1.2.3.4:8443", false},
+		{"https", nil, "https://1.2.3.4:8443", false},
+		{"HTTPS", nil, "https://1.2.3.4:8443", false},
+		{"kubernetes.io/wss", nil, "https://1.2.3.4:8443", false},
+		{"kubernetes.io/ws", nil, "http://1.2.3.4:8443", false},
+		{"kubernetes.io/h2c", nil, "http://1.2.3.4:8443", true},
+		{"h2c", nil, "http://1.2.3.4:8443", true},
+		{"grpc", nil, "http://1.2.3.4:8443", true},
+		{"example.com/custom", nil, "http://1.2.3.4:8443", false},
+		{"https", map[string]string{"a/secure_upstream": "false"}, "http://1.2.3.4:8443", false},
+		{"http", map[string]string{"a/secure_upstream": "true"}, "https://1.2.3.4:8443", false},
+	} {
+		t.Run(fmt.Sprintf("%s %v", tc.appProtocol, tc.annotations), func(t *testing.T) {
+			ic := testIngressConfig(tc.annotations, "/")
+			port := corev1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromString("http")}
+			if tc.appProtocol != "" {
+				port.AppProtocol = &tc.appProtocol
+			}
+			ic.Services[svcName].Spec.Ports = []corev1.ServicePort{port}
+			ic.Endpoints = map[types.NamespacedName]*corev1.Endpoints{svcName: {
+				Subsets: []corev1.EndpointSubset{{
+					Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}},
+					Ports:     []corev1.EndpointPort{{Name: "http", Port: 8443}},
+				}},
+			}}
+
+			routes, err := Routes(context.Background(), ic)
+			require.NoError(t, err)
+			require.Len(t, routes, 1)
+			assert.Equal(t, []string{tc.expectTo}, routes[0].To)
+			if tc.expectWarning {
+				if assert.Len(t, ic.Warnings.List(), 1) {
+					assert.Equal(t, warningUnsupportedAppProtocol, ic.Warnings.List()[0].Reason)
+				}
+			} else {
+				assert.Empty(t, ic.Warnings.List())
+			}
+		})
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	ctx := context.Background()
+	redirect := map[string]string{"a/redirect": "{host_redirect: example.com, prefix_rewrite: /new, response_code: 308}"}
+
+	ic := testIngressConfig(redirect, "/")
+	ic.Services = nil
+	routes, err := Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Empty(t, routes[0].To, "redirect routes have no upstream")
+	if assert.NotNil(t, routes[0].Redirect) {
+		assert.Equal(t, "example.com", routes[0].Redirect.GetHostRedirect())
+		assert.Equal(t, "/new", routes[0].Redirect.GetPrefixRewrite())
+		assert.Equal(t, int32(envoy_config_route_v3.RedirectAction_PERMANENT_REDIRECT), routes[0].Redirect.GetResponseCode())
+	}
+
+	ic.Ingress.Spec.Rules[0].HTTP = nil
+	routes, err = Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 1, "a rule without paths redirects all paths")
+	assert.Equal(t, "https://service.localhost.pomerium.io", routes[0].From)
+	assert.Equal(t, "/", routes[0].Prefix)
+
+	ic.Ingress.Spec.Rules[0].Host = ""
+	_, err = Routes(ctx, ic)
+	assert.ErrorContains(t, err, "host is required")
+
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		expectErr   string
+	}{
+		{"unsupported response code", map[string]string{"a/redirect": "{host_redirect: example.com, response_code: 200}"},
+			"response_code 200 is not supported"},
+		{"path and prefix", map[string]string{"a/redirect": "{path_redirect: /a, prefix_rewrite: /b}"},
+			"path_redirect cannot be combined with prefix_rewrite"},
+		{"tcp", map[string]string{"a/redirect": "{host_redirect: example.com}", "a/tcp_upstream": "true"},
+			"redirect cannot be combined with tcp_upstream"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Routes(ctx, testIngressConfig(tc.annotations, "/"))
+			assert.ErrorContains(t, err, tc.expectErr)
+		})
+	}
+
+	ic = testIngressConfig(nil, "/")
+	ic.Ingress.Spec.Rules[0].HTTP = nil
+	_, err = Routes(ctx, ic)
+	assert.ErrorContains(t, err, "rules.http is required", "without the redirect, the backend is required")
+}
+
+func TestCanary(t *testing.T) {
+	ctx := context.Background()
+	endpoints := func(ips ...string) *corev1.Endpoints {
+		e := &corev1.Endpoints{Subsets: []corev1.EndpointSubset{{Ports: []corev1.EndpointPort{{Name: "http", Port: 8080}}}}}
+		for _, ip := range ips {
+			e.Subsets[0].Addresses = append(e.Subsets[0].Addresses, corev1.EndpointAddress{IP: ip})
+		}
+		return e
+	}
+	canaryName := types.NamespacedName{Name: "app-canary", Namespace: "default"}
+	withCanary := func(weight string) *model.IngressConfig {
+		ic := testIngressConfig(map[string]string{
+			"a/canary": "{serviceName: app-canary, servicePort: http, weight: " + weight + "}",
+		}, "/")
+		ic.Services[canaryName] = &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: canaryName.Name, Namespace: canaryName.Namespace},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)}}},
+		}
+		ic.Services[types.NamespacedName{Name: "service", Namespace: "default"}].Spec.Ports[0].TargetPort = intstr.FromInt(8080)
+		ic.Endpoints = map[types.NamespacedName]*corev1.Endpoints{
+			{Name: "service", Namespace: "default"}: endpoints("10.0.0.1", "10.0.0.2"),
+			canaryName:                              endpoints("10.0.1.1"),
+		}
+		return ic
+	}
+
+	for _, tc := range []struct {
+		weight   string
+		expectTo []string
+	}{
+		{"10", []string{"http://10.0.0.1:8080,9", "http://10.0.0.2:8080,9", "http://10.0.1.1:8080,2"}},
+		{"50", []string{"http://10.0.0.1:8080,1", "http://10.0.0.2:8080,1", "http://10.0.1.1:8080,2"}},
+		{"0", []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}},
+		{"100", []string{"http://10.0.1.1:8080"}},
+	} {
+		t.Run(tc.weight, func(t *testing.T) {
+			routes, err := Routes(ctx, withCanary(tc.weight))
+			require.NoError(t, err)
+			require.Len(t, routes, 1)
+			assert.Equal(t, tc.expectTo, routes[0].To)
+		})
+	}
+
+	ic := withCanary("10")
+	delete(ic.Services, canaryName)
+	_, err := Routes(ctx, ic)
+	assert.Error(t, err, "canary service should be fetched")
+
+	ic = withCanary("10")
+	ic.Ingress.Annotations["a/canary"] = "{serviceName: app-canary}"
+	_, err = Routes(ctx, ic)
+	assert.ErrorContains(t, err, "servicePort is required")
+
+	ic = withCanary("10")
+	delete(ic.Ingress.Annotations, "a/canary")
+	routes, err := Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}, routes[0].To,
+		"without the annotation, the primary service is the only destination")
+}
+
+func TestWildcardHost(t *testing.T) {
+	ctx := context.Background()
+	ic := testIngressConfig(nil, "/a", "/b")
+	ic.Ingress.Spec.Rules[0].Host = "*.apps.example.com"
+	_, err := Routes(ctx, ic)
+	assert.ErrorContains(t, err, "wildcard host *.apps.example.com is not supported", "wildcard hosts are not enabled")
+
+	ic.AllowWildcardHosts = true
+	routes, err := Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	for _, r := range routes {
+		assert.Equal(t, "https://*.apps.example.com", r.From)
+		if assert.Len(t, r.Policies, 1) {
+			assert.Equal(t, []string{wildcardHostRego("*.apps.example.com")}, r.Policies[0].Rego)
+		}
+	}
+
+	ic.Ingress.Annotations = map[string]string{"a/allowed_users": `["a@example.com"]`}
+	routes, err = Routes(ctx, ic)
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	if assert.Len(t, routes[0].Policies, 1) {
+		assert.Equal(t, []string{"a@example.com"}, routes[0].Policies[0].AllowedUsers)
+		assert.Len(t, routes[0].Policies[0].Rego, 1, "the host restriction is added to the route policy")
+	}
+
+	expr := regexp.MustCompile(wildcardHostRegex("*.apps.example.com"))
+	for host, match := range map[string]bool{
+		"a.apps.example.com":      true,
+		"a.apps.example.com:8443": true,
+		"apps.example.com":        false,
+		"a.b.apps.example.com":    false,
+		"a.appsxexample.com":      false,
+	} {
+		assert.Equal(t, match, expr.MatchString(host), host)
+	}
+
+	for _, host := range []string{"*", "a.*.example.com", "*.*.example.com", "*a.example.com", "*.Example.com"} {
+		ic := testIngressConfig(nil, "/")
+		ic.Ingress.Spec.Rules[0].Host = host
+		ic.AllowWildcardHosts = true
+		_, err := Routes(ctx, ic)
+		assert.Error(t, err, host)
+	}
+
+	ic = testIngressConfig(map[string]string{"a/tcp_upstream": "true"}, "/")
+	ic.Ingress.Spec.Rules[0].Host = "*.apps.example.com"
+	ic.AllowWildcardHosts = true
+	_, err = Routes(ctx, ic)
+	assert.ErrorContains(t, err, "cannot be combined with tcp_upstream")
+}
@@ -0,0 +1,65 @@
+package translate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	pb "github.com/pomerium/pomerium/pkg/grpc/config"
+)
+
+// wildcardPrefix is the only wildcard form the ingress spec allows in the rule host, i.e. *.apps.example.com
+const wildcardPrefix = "*."
+
+// isWildcardHost returns true if the rule host matches a subdomain
+func isWildcardHost(host string) bool {
+	return strings.HasPrefix(host, wildcardPrefix)
+}
+
+// validateWildcardHost checks the wildcard is the whole leftmost label, as envoy would not match the other forms
+func validateWildcardHost(host string) error {
+	if !strings.Contains(host, "*") {
+		return nil
+	}
+	if !isWildcardHost(host) || strings.Contains(strings.TrimPrefix(host, wildcardPrefix), "*") {
+		return fmt.Errorf("wildcard host %q may only have * as its leftmost label", host)
+	}
+	if errs := validation.IsWildcardDNS1123Subdomain(host); len(errs) > 0 {
+		return fmt.Errorf("wildcard host %q: %s", host, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// applyWildcardHost restricts the route of a wildcard host to the single label subdomains, as the ingress spec requires.
+// envoy matches the wildcard domain as a suffix, so that a.b.apps.example.com would match *.apps.example.com otherwise
+func applyWildcardHost(r *pb.Route, host string) error {
+	if !isWildcardHost(host) {
+		return nil
+	}
+	if len(r.Policies) == 0 {
+		r.Policies = []*pb.Policy{new(pb.Policy)}
+	}
+	return addRego(r.Policies[0], wildcardHostRego(host))
+}
+
+// wildcardHostRegex returns a regular expression matching the single label subdomains of the wildcard host, with an optional port
+func wildcardHostRegex(host string) string {
+	return `^[^.]+` + regexp.QuoteMeta(strings.TrimPrefix(host, "*")) + `(?::[0-9]+)?$`
+}
+
+// wildcardHostRego generates a rego that denies access unless the request host is a single label subdomain of the wildcard
+func wildcardHostRego(host string) string {
+	return fmt.Sprintf(`package pomerium.policy
+
+deny = [true, {"host-not-matched"}] {
+	not wildcard_host_matched
+}
+
+wildcard_host_matched {
+	host := lower(split(input.http.url, "/")[2])
+	regex.match(%q, host)
+}
+`, wildcardHostRegex(host))
+}